@@ -2,18 +2,38 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"time"
 
 	"github.com/EFG/api"
+	"github.com/EFG/internal/certificate"
 	"github.com/EFG/internal/logger"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 )
 
 func main() {
-	conn, err := grpc.NewClient("localhost:9000", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	addr := flag.String("addr", "localhost:9000", "address of the gRPC server")
+	useTLS := flag.Bool("tls", false, "connect over TLS")
+	caFile := flag.String("ca-file", "", "PEM bundle the server certificate is verified against, the system roots when empty")
+	serverName := flag.String("server-name", "localhost", "name expected on the server certificate")
+	certFile := flag.String("cert-file", "", "client certificate presented to servers verifying their clients")
+	keyFile := flag.String("key-file", "", "key of the client certificate")
+	flag.Parse()
+
+	transportCredentials := insecure.NewCredentials()
+	if *useTLS {
+		tlsConfig, err := certificate.ClientConfig(*serverName, *caFile, *certFile, *keyFile)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to set up tls: %w", err))
+		}
+		transportCredentials = credentials.NewTLS(tlsConfig)
+	}
+
+	conn, err := grpc.NewClient(*addr, grpc.WithTransportCredentials(transportCredentials))
 	if err != nil {
 		logger.Fatal(fmt.Errorf("failed to connect: %w", err))
 	}
@@ -39,6 +59,14 @@ func main() {
 
 	slog.Info("Created user", "id", createResp.Id)
 
+	// get the user back by email
+	getUserResp, err := client.GetUser(ctx, &api.GetUserRequest{Email: "john.doe@example.com"})
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to get user: %w", err))
+	}
+
+	slog.Info("User", "id", getUserResp.User.Id, "first_name", getUserResp.User.FirstName, "email", getUserResp.User.Email)
+
 	// // Modify the user
 	// modifyResp, err := client.ModifyUser(ctx, &api.ModifyUserRequest{
 	// 	Id:        createResp.Id,
@@ -2,22 +2,35 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/aws"
+	"github.com/EFG/internal/certificate"
 	"github.com/EFG/internal/datasource/database/postgres"
 	"github.com/EFG/internal/env"
+	"github.com/EFG/internal/gateway"
 	"github.com/EFG/internal/logger"
+	"github.com/EFG/internal/metrics"
 	"github.com/EFG/internal/notifier"
+	"github.com/EFG/internal/ratelimit"
 	"github.com/EFG/internal/server"
 	"github.com/EFG/internal/service"
+	"github.com/EFG/internal/token"
+	"github.com/EFG/internal/tracing"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 )
@@ -33,8 +46,6 @@ func main() {
 		logger.Fatal(fmt.Errorf("failed to listen on port 9000: %w", err))
 	}
 
-	grpcServer := grpc.NewServer()
-
 	postgresConfig, err := env.LoadDatabaseConfig()
 	if err != nil {
 		logger.Fatal(fmt.Errorf("failed to load database config: %w", err))
@@ -46,6 +57,134 @@ func main() {
 	}
 	defer postgresDataSource.Close()
 
+	if err := metrics.RegisterDatabase(postgresDataSource.DB, "postgres"); err != nil {
+		logger.Fatal(fmt.Errorf("failed to export database metrics: %w", err))
+	}
+
+	tracingConfig, err := env.LoadTracingConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load tracing config: %w", err))
+	}
+
+	// without an exporter spans aren't recorded, but the trace context of incoming calls is still
+	// passed on to SNS messages
+	if tracingConfig.IsEnabled() {
+		tracerProvider, err := newTracerProvider(tracingConfig)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to set up tracing: %w", err))
+		}
+		defer tracerProvider.Shutdown(context.Background())
+		slog.Info("Tracing is enabled", "exporter", tracingConfig.Exporter)
+	}
+
+	idempotencyConfig, err := env.LoadIdempotencyConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load idempotency config: %w", err))
+	}
+
+	tokenConfig, err := env.LoadTokenConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load token config: %w", err))
+	}
+
+	var tokenManager *token.Manager
+	var tokenIssuer service.TokenIssuer
+	if tokenConfig.IsEnabled() {
+		tokenManager, err = newTokenManager(tokenConfig)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to set up access tokens: %w", err))
+		}
+		tokenIssuer = tokenManager
+		slog.Info("Access tokens are enabled", "signingKeyID", tokenConfig.SigningKeyID)
+	}
+
+	authConfig, err := env.LoadAuthConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load auth config: %w", err))
+	}
+
+	// every call is counted and timed, then attached to the caller presenting an API key or access token, then tagged with a
+	// request ID for the audit log and scoped to its tenant before being checked against the caller's
+	// permissions. Retried mutating calls carrying an idempotency-key get the original response
+	// instead of running again
+	metricsUnary, metricsStream := server.NewMetricsInterceptors(time.Now)
+	unaryInterceptors := []grpc.UnaryServerInterceptor{metricsUnary}
+	streamInterceptors := []grpc.StreamServerInterceptor{metricsStream}
+
+	rateLimitConfig, err := env.LoadRateLimitConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load rate limit config: %w", err))
+	}
+
+	// authenticating a caller can mean looking up their API key, so addresses over their limit are
+	// turned away before that and each caller is held to their own limit once they are known
+	var rateLimitUnary grpc.UnaryServerInterceptor
+	var rateLimitStream grpc.StreamServerInterceptor
+	if rateLimitConfig.IsEnabled() {
+		addressUnary, addressStream := server.NewAddressRateLimitInterceptors(ratelimit.Limit{Rate: rateLimitConfig.AddressRate, Burst: rateLimitConfig.AddressBurst}, time.Now)
+		unaryInterceptors = append(unaryInterceptors, addressUnary)
+		streamInterceptors = append(streamInterceptors, addressStream)
+
+		rateLimitUnary, rateLimitStream, err = newRateLimitInterceptors(rateLimitConfig)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to set up rate limits: %w", err))
+		}
+	}
+
+	authenticationUnary, authenticationStream := server.NewAuthenticationInterceptors(tokenIssuer, postgresDataSource, authConfig.Required, time.Now)
+	unaryInterceptors = append(unaryInterceptors, authenticationUnary)
+	streamInterceptors = append(streamInterceptors, authenticationStream)
+
+	if rateLimitUnary != nil {
+		unaryInterceptors = append(unaryInterceptors, rateLimitUnary)
+		streamInterceptors = append(streamInterceptors, rateLimitStream)
+	}
+
+	auditUnary, auditStream := server.NewAuditInterceptors()
+	tenantUnary, tenantStream := server.NewTenantInterceptors()
+	unaryInterceptors = append(unaryInterceptors, auditUnary, tenantUnary)
+	streamInterceptors = append(streamInterceptors, auditStream, tenantStream)
+
+	// callers presenting an API key or access token are always held to its scopes or their roles.
+	// Anonymous callers are only let through while nothing asks callers to identify themselves, and
+	// never to hard deletes, purges or managing roles and keys
+	allowAnonymous := !authConfig.Required && tokenIssuer == nil
+	authorizationUnary, authorizationStream := server.NewAuthorizationInterceptors(postgresDataSource, allowAnonymous)
+	unaryInterceptors = append(unaryInterceptors, authorizationUnary)
+	streamInterceptors = append(streamInterceptors, authorizationStream)
+
+	unaryInterceptors = append(unaryInterceptors, server.NewIdempotencyInterceptor(postgresDataSource, idempotencyConfig.TTL, time.Now))
+	grpcServerOpts := []grpc.ServerOption{
+		// every RPC gets a span, continuing the trace of the traceparent metadata sent by the caller
+		grpc.StatsHandler(otelgrpc.NewServerHandler(otelgrpc.WithPropagators(tracing.Propagator))),
+		grpc.ChainUnaryInterceptor(unaryInterceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	tlsConfig, err := env.LoadTLSConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load tls config: %w", err))
+	}
+
+	// the gateway dials the server like any other client, so it needs credentials to match
+	gatewayCredentials := insecure.NewCredentials()
+	if tlsConfig.IsEnabled() {
+		reloader, err := certificate.NewReloader(tlsConfig.CertFile, tlsConfig.KeyFile, tlsConfig.ClientCAFile)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to load certificates: %w", err))
+		}
+		go reloader.Watch(context.Background(), tlsConfig.ReloadInterval)
+		grpcServerOpts = append(grpcServerOpts, grpc.Creds(credentials.NewTLS(reloader.ServerConfig())))
+
+		gatewayCredentials, err = newGatewayCredentials(tlsConfig, reloader)
+		if err != nil {
+			logger.Fatal(fmt.Errorf("failed to set up gateway credentials: %w", err))
+		}
+		slog.Info("TLS is enabled", "mutual", tlsConfig.IsMutual())
+	}
+
+	grpcServer := grpc.NewServer(grpcServerOpts...)
+
 	awsConfig, err := env.LoadAWSConfig()
 	if err != nil {
 		logger.Fatal(fmt.Errorf("failed to load AWS config: %w", err))
@@ -53,20 +192,46 @@ func main() {
 
 	ctx := context.Background()
 
+	// background jobs stop and the servers shut down gracefully on an interrupt or SIGTERM
+	shutdown, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	// Always default to NoOpNotifier
-	var notifierService service.Notifier = notifier.NewNoOpNotifier()
+	var notifierService service.Notifier = notifier.NewInstrumentedNotifier(notifier.NewNoOpNotifier(), "noop", time.Now)
 
 	if awsConfig.IsValid() {
 		snsClient, err := aws.NewSNSClient(ctx, awsConfig)
 		if err != nil {
 			slog.Info("Failed to create SNS client, using NoOpNotifier", "error", err)
 		} else {
-			notifierService = notifier.NewSNSNotifier(snsClient)
+			notifierService = notifier.NewInstrumentedNotifier(notifier.NewSNSNotifier(snsClient), "sns", time.Now)
 			slog.Info("SNS notifier service is enabled")
 		}
 	}
 
-	userServer := server.NewServer(postgresDataSource, notifierService, time.Now)
+	// Fan out every change to in-process watchers before it reaches the notifier
+	broadcastNotifier := notifier.NewBroadcastNotifier(notifierService, 1000)
+
+	purgeConfig, err := env.LoadPurgeConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load purge config: %w", err))
+	}
+
+	// No mail provider is wired up yet so reset and verification requests are only logged
+	serverOpts := []server.Option{
+		server.WithWatcher(broadcastNotifier),
+		server.WithPurgeRetention(purgeConfig.Retention),
+		server.WithPasswordResetSender(notifier.NewNoOpPasswordResetSender()),
+		server.WithEmailVerificationSender(notifier.NewNoOpEmailVerificationSender()),
+	}
+	var gatewayOpts []gateway.Option
+
+	if tokenIssuer != nil {
+		serverOpts = append(serverOpts, server.WithTokenIssuer(tokenIssuer))
+		gatewayOpts = append(gatewayOpts, gateway.WithJWKS(tokenManager))
+	}
+
+	userServer := server.NewServer(postgresDataSource, broadcastNotifier, time.Now, serverOpts...)
 
 	api.RegisterUserServiceServer(grpcServer, userServer)
 
@@ -85,18 +250,161 @@ func main() {
 				slog.Info("Database is healthy")
 				healthServer.SetServingStatus("api.UserService", grpc_health_v1.HealthCheckResponse_SERVING)
 			}
+			metrics.SetHealth("api.UserService", err == nil)
 			time.Sleep(10 * time.Second)
 		}
 	}()
 
-	slog.Info("gRPC server is listening on port 9000")
-	if err := grpcServer.Serve(lis); err != nil {
-		logger.Fatal(fmt.Errorf("failed to serve: %w", err))
+	// permanently remove users once they have been soft deleted for longer than the retention window,
+	// expired idempotency keys are cleared out on the same schedule
+	go func() {
+		ticker := time.NewTicker(purgeConfig.Interval)
+		defer ticker.Stop()
+
+		for {
+			deletedBefore := time.Now().Add(-purgeConfig.Retention)
+			purged, err := service.PurgeExpiredUsers(shutdown, postgresDataSource, broadcastNotifier, deletedBefore, purgeConfig.BatchSize, time.Now)
+			if err != nil {
+				slog.Warn("Purge of deleted users failed", "error", err)
+			} else {
+				slog.Info("Purge of deleted users complete", "purged", purged, "deletedBefore", deletedBefore)
+			}
+
+			if deleted, err := service.DeleteExpiredIdempotencyKeys(shutdown, postgresDataSource, time.Now()); err != nil {
+				slog.Warn("Removing expired idempotency keys failed", "error", err)
+			} else {
+				slog.Info("Removed expired idempotency keys", "deleted", deleted)
+			}
+
+			select {
+			case <-shutdown.Done():
+				slog.Info("Purge of deleted users stopped")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	gatewayConfig, err := env.LoadGatewayConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load gateway config: %w", err))
 	}
 
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+	// The gateway dials the gRPC server like any other client so REST calls take the same path
+	gatewayConn, err := grpc.NewClient("localhost:9000", grpc.WithTransportCredentials(gatewayCredentials))
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to create gateway client: %w", err))
+	}
+	defer gatewayConn.Close()
+
+	gatewayServer := &http.Server{
+		Addr:              ":" + gatewayConfig.Port,
+		Handler:           gateway.NewHandler(api.NewUserServiceClient(gatewayConn), gatewayOpts...),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("REST gateway is listening", "port", gatewayConfig.Port)
+		if err := gatewayServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(fmt.Errorf("failed to serve gateway: %w", err))
+		}
+	}()
+
+	metricsConfig, err := env.LoadMetricsConfig()
+	if err != nil {
+		logger.Fatal(fmt.Errorf("failed to load metrics config: %w", err))
+	}
+
+	// metrics are served on a port of their own so they aren't exposed alongside the REST API
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", metrics.Handler())
+	metricsServer := &http.Server{
+		Addr:              ":" + metricsConfig.Port,
+		Handler:           metricsMux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Metrics are served", "port", metricsConfig.Port)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Fatal(fmt.Errorf("failed to serve metrics: %w", err))
+		}
+	}()
+
+	go func() {
+		slog.Info("gRPC server is listening on port 9000")
+		if err := grpcServer.Serve(lis); err != nil {
+			logger.Fatal(fmt.Errorf("failed to serve: %w", err))
+		}
+	}()
+
+	<-shutdown.Done()
+	gatewayServer.Shutdown(ctx)
+	metricsServer.Shutdown(ctx)
 	grpcServer.GracefulStop()
 	slog.Info("gRPC server is shutting down")
 }
+
+// newGatewayCredentials verifies the server certificate against the configured CA bundle. With
+// mutual TLS the gateway presents the server's own certificate, which is reloaded along with it, so
+// that certificate has to be issued by a client CA and allow client authentication.
+func newGatewayCredentials(config env.TLSConfig, reloader *certificate.Reloader) (credentials.TransportCredentials, error) {
+	clientConfig, err := certificate.ClientConfig(config.ServerName, config.CAFile, "", "")
+	if err != nil {
+		return nil, err
+	}
+	if config.IsMutual() {
+		clientConfig.GetClientCertificate = reloader.GetClientCertificate
+	}
+
+	return credentials.NewTLS(clientConfig), nil
+}
+
+// newRateLimitInterceptors holds callers to the default limit and to the limits of the RPCs that have one.
+func newRateLimitInterceptors(config env.RateLimitConfig) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor, error) {
+	methodLimits := make(map[string]ratelimit.Limit, len(config.MethodLimits))
+	for method, l := range config.MethodLimits {
+		methodLimits[method] = ratelimit.Limit{Rate: l.Rate, Burst: l.Burst}
+	}
+
+	return server.NewRateLimitInterceptors(ratelimit.Limit{Rate: config.Rate, Burst: config.Burst}, methodLimits, time.Now)
+}
+
+// newTracerProvider exports spans to the configured collector, or as JSON lines on stderr so they
+// aren't mixed in with the logs on stdout.
+func newTracerProvider(config env.TracingConfig) (*sdktrace.TracerProvider, error) {
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch config.Exporter {
+	case env.TracingExporterOTLP:
+		exporter, err = tracing.NewOTLPExporter(context.Background(), config.Endpoint, config.Insecure)
+	case env.TracingExporterStderr:
+		exporter, err = tracing.NewWriterExporter(os.Stderr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tracing.NewProvider(exporter, config.ServiceName, config.SampleRatio)
+}
+
+// newTokenManager loads every configured key, the signing key and any keys kept for validating
+// tokens issued before a rotation.
+func newTokenManager(config env.TokenConfig) (*token.Manager, error) {
+	keys := make([]token.Key, 0, len(config.KeyFiles))
+	for _, f := range config.KeyFiles {
+		key, err := token.LoadKeyFile(f.ID, f.Path)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return token.NewManager(keys, token.ManagerOpts{
+		SigningKeyID: config.SigningKeyID,
+		Issuer:       config.Issuer,
+		Audience:     config.Audience,
+		TTL:          config.TTL,
+		TimeNow:      time.Now,
+	})
+}
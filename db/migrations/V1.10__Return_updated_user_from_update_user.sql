@@ -0,0 +1,56 @@
+DROP PROCEDURE IF EXISTS update_user;
+
+CREATE FUNCTION update_user(
+    p_id UUID,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_password TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_country TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate required inputs
+    IF p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id is required.';
+    END IF;
+
+    -- NULL leaves a field untouched, any other value (including an empty string) is written
+    RETURN QUERY
+    UPDATE users
+    SET
+        first_name = COALESCE(p_first_name, first_name),
+        last_name = COALESCE(p_last_name, last_name),
+        nick_name = COALESCE(p_nick_name, nick_name),
+        password = COALESCE(p_password, password),
+        email = COALESCE(p_email, email),
+        country = COALESCE(p_country, country)
+    WHERE users.id = p_id
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+END;
+$$;
@@ -0,0 +1,102 @@
+DROP FUNCTION IF EXISTS get_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, INT, INT, TIMESTAMP, UUID);
+
+CREATE FUNCTION get_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_page INT DEFAULT 1,
+    p_page_size INT DEFAULT 10,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_order_fields TEXT[] DEFAULT NULL,
+    p_order_directions TEXT[] DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_order_by TEXT := '';
+    v_direction TEXT;
+BEGIN
+    -- Validate pagination inputs
+    IF p_page < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page must be >= 1.';
+    END IF;
+
+    IF p_page_size < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page_size must be >= 1.';
+    END IF;
+
+    IF p_page IS NOT NULL AND p_after_created_at IS NOT NULL THEN
+        RAISE EXCEPTION 'Invalid input: page and cursor cannot be combined.';
+    END IF;
+
+    -- Validate ordering inputs, only allow-listed columns and directions ever reach the query text
+    IF cardinality(p_order_fields) > 0 THEN
+        IF p_after_created_at IS NOT NULL THEN
+            RAISE EXCEPTION 'Invalid input: order by and cursor cannot be combined.';
+        END IF;
+
+        IF cardinality(p_order_fields) IS DISTINCT FROM cardinality(p_order_directions) THEN
+            RAISE EXCEPTION 'Invalid input: every order by field needs a direction.';
+        END IF;
+
+        FOR i IN 1 .. cardinality(p_order_fields) LOOP
+            IF p_order_fields[i] NOT IN ('first_name', 'last_name', 'email', 'country', 'created_at', 'updated_at') THEN
+                RAISE EXCEPTION 'Invalid input: cannot order by %.', p_order_fields[i];
+            END IF;
+
+            v_direction := upper(p_order_directions[i]);
+            IF v_direction NOT IN ('ASC', 'DESC') THEN
+                RAISE EXCEPTION 'Invalid input: unknown order direction %.', p_order_directions[i];
+            END IF;
+
+            v_order_by := v_order_by || format('users.%I %s, ', p_order_fields[i], v_direction);
+        END LOOP;
+
+        -- id breaks ties so pages are stable
+        v_order_by := v_order_by || 'users.id ASC';
+    ELSE
+        v_order_by := 'users.created_at DESC, users.id DESC';
+    END IF;
+
+    -- Return with dynamic filtering - partial matching can be applied
+    -- a cursor continues strictly after the last (created_at, id) of the previous page
+    RETURN QUERY EXECUTE format($query$
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP
+    FROM users
+    WHERE
+        ($1 IS NULL OR users.id = $1) AND
+        ($2 IS NULL OR users.country = $2) AND
+        ($3 IS NULL OR users.email = $3) AND
+        ($4 IS NULL OR users.first_name ILIKE '%%' || $4 || '%%') AND
+        ($5 IS NULL OR users.last_name ILIKE '%%' || $5 || '%%') AND
+        ($6 IS NULL OR users.nick_name ILIKE '%%' || $6 || '%%') AND
+        ($9 IS NULL OR (users.created_at, users.id) < ($9, $10))
+    ORDER BY %s
+    LIMIT $8
+    OFFSET ($7 - 1) * $8
+    $query$, v_order_by)
+    USING p_id, p_country, p_email, p_first_name, p_last_name, p_nick_name, p_page, p_page_size, p_after_created_at, p_after_id;
+END;
+$$;
@@ -0,0 +1,39 @@
+CREATE OR REPLACE FUNCTION get_user_credentials(
+    p_email TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    password TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: email is required.';
+    END IF;
+
+    -- The password hash is only ever read here so credentials are verified in one place
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.password::TEXT
+    FROM users
+    WHERE users.email = p_email
+    LIMIT 1;
+END;
+$$;
@@ -0,0 +1,85 @@
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    -- Only a SHA-256 of the token is stored so a leaked table can't be used to reset passwords
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS password_reset_tokens_user_id_idx ON password_reset_tokens (user_id);
+
+CREATE OR REPLACE FUNCTION create_password_reset_token(
+    p_email TEXT,
+    p_token_hash TEXT,
+    p_expires_at TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+BEGIN
+    -- Validate required inputs
+    IF p_email IS NULL OR p_token_hash IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: email, token hash and expiry are required.';
+    END IF;
+
+    SELECT users.id INTO v_user_id
+    FROM users
+    WHERE users.email = p_email;
+
+    IF v_user_id IS NULL THEN
+        RAISE EXCEPTION 'User with email % not found.', p_email;
+    END IF;
+
+    INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+    VALUES (p_token_hash, v_user_id, p_expires_at);
+
+    RETURN v_user_id;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION complete_password_reset(
+    p_token_hash TEXT,
+    p_password TEXT,
+    p_now TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+BEGIN
+    -- Validate required inputs
+    IF p_token_hash IS NULL OR p_password IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: token hash, password and current time are required.';
+    END IF;
+
+    -- Spending the token in the same statement that checks it means concurrent attempts
+    -- with one token can't both succeed
+    UPDATE password_reset_tokens
+    SET used_at = p_now
+    WHERE token_hash = p_token_hash
+      AND used_at IS NULL
+      AND expires_at > p_now
+    RETURNING user_id INTO v_user_id;
+
+    -- NULL tells the caller the token is unknown, used or expired without saying which
+    IF v_user_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    UPDATE users
+    SET password = p_password
+    WHERE id = v_user_id;
+
+    -- Any other outstanding tokens for the user are spent too
+    UPDATE password_reset_tokens
+    SET used_at = p_now
+    WHERE user_id = v_user_id
+      AND used_at IS NULL;
+
+    RETURN v_user_id;
+END;
+$$;
@@ -0,0 +1,434 @@
+-- A changed email is held in pending_email until the new address is confirmed
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP,
+    ADD COLUMN IF NOT EXISTS pending_email VARCHAR(320);
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    -- Only a SHA-256 of the token is stored, as with password_reset_tokens
+    token_hash VARCHAR(64) PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- The address the token proves ownership of, a token for a replaced address can't confirm the new one
+    email VARCHAR(320) NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS email_verification_tokens_user_id_idx ON email_verification_tokens (user_id);
+
+CREATE OR REPLACE FUNCTION create_email_verification_token(
+    p_id UUID,
+    p_token_hash TEXT,
+    p_expires_at TIMESTAMPTZ
+)
+RETURNS TEXT
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_email TEXT;
+    v_pending_email TEXT;
+    v_email_verified_at TIMESTAMP;
+BEGIN
+    -- Validate required inputs
+    IF p_id IS NULL OR p_token_hash IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id, token hash and expiry are required.';
+    END IF;
+
+    SELECT users.email, users.pending_email, users.email_verified_at
+    INTO v_email, v_pending_email, v_email_verified_at
+    FROM users
+    WHERE users.id = p_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+
+    -- A pending address is always the one being verified, otherwise the current one if it isn't yet
+    IF v_pending_email IS NOT NULL THEN
+        v_email := v_pending_email;
+    ELSIF v_email_verified_at IS NOT NULL THEN
+        -- NULL tells the caller there is nothing left to verify
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at)
+    VALUES (p_token_hash, p_id, v_email, p_expires_at);
+
+    RETURN v_email;
+END;
+$$;
+
+CREATE OR REPLACE FUNCTION confirm_email(
+    p_token_hash TEXT,
+    p_now TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+    v_email TEXT;
+BEGIN
+    -- Validate required inputs
+    IF p_token_hash IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: token hash and current time are required.';
+    END IF;
+
+    UPDATE email_verification_tokens
+    SET used_at = p_now
+    WHERE token_hash = p_token_hash
+      AND used_at IS NULL
+      AND expires_at > p_now
+    RETURNING user_id, email INTO v_user_id, v_email;
+
+    IF v_user_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    -- Promote the pending address or mark the current one verified, whichever the token was sent to.
+    -- Taking a pending address can still hit user_email_unique if someone else registered it meanwhile
+    UPDATE users
+    SET
+        email = v_email,
+        pending_email = NULL,
+        email_verified_at = p_now
+    WHERE users.id = v_user_id
+      AND (users.pending_email = v_email OR (users.pending_email IS NULL AND users.email = v_email));
+
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+
+    -- Tokens for the same user are spent once any of them confirms an address
+    UPDATE email_verification_tokens
+    SET used_at = p_now
+    WHERE user_id = v_user_id
+      AND used_at IS NULL;
+
+    RETURN v_user_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS update_user(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+
+CREATE FUNCTION update_user(
+    p_id UUID,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_password TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_country TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate required inputs
+    IF p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id is required.';
+    END IF;
+
+    -- Fail fast rather than leave a pending address that can never be confirmed
+    IF p_email IS NOT NULL AND EXISTS (SELECT 1 FROM users WHERE users.email = p_email AND users.id <> p_id) THEN
+        RAISE EXCEPTION USING
+            ERRCODE = 'unique_violation',
+            CONSTRAINT = 'user_email_unique',
+            MESSAGE = format('Email %s already exists.', p_email);
+    END IF;
+
+    -- NULL leaves a field untouched, any other value (including an empty string) is written.
+    -- A new email only becomes pending, supplying the current email again cancels a pending change
+    RETURN QUERY
+    UPDATE users
+    SET
+        first_name = COALESCE(p_first_name, first_name),
+        last_name = COALESCE(p_last_name, last_name),
+        nick_name = COALESCE(p_nick_name, nick_name),
+        password = COALESCE(p_password, password),
+        pending_email = CASE
+            WHEN p_email IS NULL THEN pending_email
+            WHEN p_email = email THEN NULL
+            ELSE p_email
+        END,
+        country = COALESCE(p_country, country)
+    WHERE users.id = p_id
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_user(UUID, TEXT);
+
+CREATE FUNCTION get_user(
+    p_id UUID DEFAULT NULL,
+    p_email TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_id IS NULL AND p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id or email is required.';
+    END IF;
+
+    -- Exact matching only, a single user is expected
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id) AND
+        (p_email IS NULL OR users.email = p_email)
+    LIMIT 1;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, INT, INT, TIMESTAMP, UUID, TEXT[], TEXT[]);
+
+CREATE FUNCTION get_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_page INT DEFAULT 1,
+    p_page_size INT DEFAULT 10,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_order_fields TEXT[] DEFAULT NULL,
+    p_order_directions TEXT[] DEFAULT NULL,
+    p_email_verified BOOLEAN DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_order_by TEXT := '';
+    v_direction TEXT;
+BEGIN
+    -- Validate pagination inputs
+    IF p_page < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page must be >= 1.';
+    END IF;
+
+    IF p_page_size < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page_size must be >= 1.';
+    END IF;
+
+    IF p_page IS NOT NULL AND p_after_created_at IS NOT NULL THEN
+        RAISE EXCEPTION 'Invalid input: page and cursor cannot be combined.';
+    END IF;
+
+    -- Validate ordering inputs, only allow-listed columns and directions ever reach the query text
+    IF cardinality(p_order_fields) > 0 THEN
+        IF p_after_created_at IS NOT NULL THEN
+            RAISE EXCEPTION 'Invalid input: order by and cursor cannot be combined.';
+        END IF;
+
+        IF cardinality(p_order_fields) IS DISTINCT FROM cardinality(p_order_directions) THEN
+            RAISE EXCEPTION 'Invalid input: every order by field needs a direction.';
+        END IF;
+
+        FOR i IN 1 .. cardinality(p_order_fields) LOOP
+            IF p_order_fields[i] NOT IN ('first_name', 'last_name', 'email', 'country', 'created_at', 'updated_at') THEN
+                RAISE EXCEPTION 'Invalid input: cannot order by %.', p_order_fields[i];
+            END IF;
+
+            v_direction := upper(p_order_directions[i]);
+            IF v_direction NOT IN ('ASC', 'DESC') THEN
+                RAISE EXCEPTION 'Invalid input: unknown order direction %.', p_order_directions[i];
+            END IF;
+
+            v_order_by := v_order_by || format('users.%I %s, ', p_order_fields[i], v_direction);
+        END LOOP;
+
+        -- id breaks ties so pages are stable
+        v_order_by := v_order_by || 'users.id ASC';
+    ELSE
+        v_order_by := 'users.created_at DESC, users.id DESC';
+    END IF;
+
+    -- Return with dynamic filtering - partial matching can be applied
+    -- a cursor continues strictly after the last (created_at, id) of the previous page
+    RETURN QUERY EXECUTE format($query$
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT
+    FROM users
+    WHERE
+        ($1 IS NULL OR users.id = $1) AND
+        ($2 IS NULL OR users.country = $2) AND
+        ($3 IS NULL OR users.email = $3) AND
+        ($4 IS NULL OR users.first_name ILIKE '%%' || $4 || '%%') AND
+        ($5 IS NULL OR users.last_name ILIKE '%%' || $5 || '%%') AND
+        ($6 IS NULL OR users.nick_name ILIKE '%%' || $6 || '%%') AND
+        ($9 IS NULL OR (users.created_at, users.id) < ($9, $10)) AND
+        ($11 IS NULL OR (users.email_verified_at IS NOT NULL) = $11)
+    ORDER BY %s
+    LIMIT $8
+    OFFSET ($7 - 1) * $8
+    $query$, v_order_by)
+    USING p_id, p_country, p_email, p_first_name, p_last_name, p_nick_name, p_page, p_page_size, p_after_created_at, p_after_id, p_email_verified;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS count_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT);
+
+CREATE FUNCTION count_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_email_verified BOOLEAN DEFAULT NULL
+)
+RETURNS BIGINT
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    total BIGINT;
+BEGIN
+    -- Same filtering as get_users without pagination so callers know the full size of the result
+    SELECT COUNT(*) INTO total
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_email_verified IS NULL OR (users.email_verified_at IS NOT NULL) = p_email_verified);
+
+    RETURN total;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS export_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TIMESTAMP, UUID, INT);
+
+CREATE FUNCTION export_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_limit INT DEFAULT 1000
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate batch size input
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Same filtering as get_users, but walks the table in (created_at, id) order
+    -- so callers can page through it with a keyset instead of an offset
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_after_created_at IS NULL OR (users.created_at, users.id) > (p_after_created_at, p_after_id))
+    ORDER BY users.created_at ASC, users.id ASC
+    LIMIT p_limit;
+END;
+$$;
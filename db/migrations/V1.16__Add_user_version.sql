@@ -0,0 +1,434 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+-- Every write to a user moves the version on, whichever function made it, so a version read
+-- earlier reliably tells a caller whether the row has changed since
+CREATE OR REPLACE FUNCTION increment_version_column()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER set_version
+BEFORE UPDATE ON users
+FOR EACH ROW
+EXECUTE FUNCTION increment_version_column();
+
+DROP PROCEDURE IF EXISTS delete_user(UUID);
+
+CREATE PROCEDURE delete_user(
+    p_id UUID,
+    p_expected_version BIGINT DEFAULT NULL
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate input
+    IF p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id is required.';
+    END IF;
+
+    -- The version is checked in the same statement as the write so nothing can slip in between
+    UPDATE users
+    SET deleted_at = CURRENT_TIMESTAMP
+    WHERE id = p_id
+      AND deleted_at IS NULL
+      AND (p_expected_version IS NULL OR version = p_expected_version);
+
+    IF NOT FOUND THEN
+        IF p_expected_version IS NOT NULL AND EXISTS (
+            SELECT 1 FROM users WHERE users.id = p_id AND users.deleted_at IS NULL
+        ) THEN
+            RAISE EXCEPTION 'Version mismatch: user with id % has been changed since version %.', p_id, p_expected_version;
+        END IF;
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+
+    -- Outstanding tokens mustn't outlive the deletion, a restored user requests new ones
+    UPDATE password_reset_tokens
+    SET used_at = CURRENT_TIMESTAMP
+    WHERE user_id = p_id
+      AND used_at IS NULL;
+
+    UPDATE email_verification_tokens
+    SET used_at = CURRENT_TIMESTAMP
+    WHERE user_id = p_id
+      AND used_at IS NULL;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS restore_user(UUID);
+
+CREATE FUNCTION restore_user(p_id UUID)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate input
+    IF p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id is required.';
+    END IF;
+
+    -- Restoring can hit user_email_unique if the email was registered again after the deletion
+    RETURN QUERY
+    UPDATE users
+    SET deleted_at = NULL
+    WHERE users.id = p_id
+      AND users.deleted_at IS NOT NULL
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Deleted user with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS update_user(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+
+CREATE FUNCTION update_user(
+    p_id UUID,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_password TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_expected_version BIGINT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate required inputs
+    IF p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id is required.';
+    END IF;
+
+    -- Fail fast rather than leave a pending address that can never be confirmed
+    IF p_email IS NOT NULL AND EXISTS (
+        SELECT 1 FROM users WHERE users.email = p_email AND users.id <> p_id AND users.deleted_at IS NULL
+    ) THEN
+        RAISE EXCEPTION USING
+            ERRCODE = 'unique_violation',
+            CONSTRAINT = 'user_email_unique',
+            MESSAGE = format('Email %s already exists.', p_email);
+    END IF;
+
+    -- NULL leaves a field untouched, any other value (including an empty string) is written.
+    -- A new email only becomes pending, supplying the current email again cancels a pending change.
+    -- Deleted users have to be restored before they can be modified, and when an expected version
+    -- is supplied the write only lands if nobody else has changed the user since it was read
+    RETURN QUERY
+    UPDATE users
+    SET
+        first_name = COALESCE(p_first_name, first_name),
+        last_name = COALESCE(p_last_name, last_name),
+        nick_name = COALESCE(p_nick_name, nick_name),
+        password = COALESCE(p_password, password),
+        pending_email = CASE
+            WHEN p_email IS NULL THEN pending_email
+            WHEN p_email = email THEN NULL
+            ELSE p_email
+        END,
+        country = COALESCE(p_country, country)
+    WHERE users.id = p_id
+      AND users.deleted_at IS NULL
+      AND (p_expected_version IS NULL OR users.version = p_expected_version)
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT;
+
+    IF NOT FOUND THEN
+        IF p_expected_version IS NOT NULL AND EXISTS (
+            SELECT 1 FROM users WHERE users.id = p_id AND users.deleted_at IS NULL
+        ) THEN
+            RAISE EXCEPTION 'Version mismatch: user with id % has been changed since version %.', p_id, p_expected_version;
+        END IF;
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_user(UUID, TEXT);
+
+CREATE FUNCTION get_user(
+    p_id UUID DEFAULT NULL,
+    p_email TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_id IS NULL AND p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id or email is required.';
+    END IF;
+
+    -- Exact matching only, a single live user is expected
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        users.deleted_at IS NULL
+    LIMIT 1;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, INT, INT, TIMESTAMP, UUID, TEXT[], TEXT[], BOOLEAN, BOOLEAN);
+
+CREATE FUNCTION get_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_page INT DEFAULT 1,
+    p_page_size INT DEFAULT 10,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_order_fields TEXT[] DEFAULT NULL,
+    p_order_directions TEXT[] DEFAULT NULL,
+    p_email_verified BOOLEAN DEFAULT NULL,
+    p_include_deleted BOOLEAN DEFAULT FALSE
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_order_by TEXT := '';
+    v_direction TEXT;
+BEGIN
+    -- Validate pagination inputs
+    IF p_page < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page must be >= 1.';
+    END IF;
+
+    IF p_page_size < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page_size must be >= 1.';
+    END IF;
+
+    IF p_page IS NOT NULL AND p_after_created_at IS NOT NULL THEN
+        RAISE EXCEPTION 'Invalid input: page and cursor cannot be combined.';
+    END IF;
+
+    -- Validate ordering inputs, only allow-listed columns and directions ever reach the query text
+    IF cardinality(p_order_fields) > 0 THEN
+        IF p_after_created_at IS NOT NULL THEN
+            RAISE EXCEPTION 'Invalid input: order by and cursor cannot be combined.';
+        END IF;
+
+        IF cardinality(p_order_fields) IS DISTINCT FROM cardinality(p_order_directions) THEN
+            RAISE EXCEPTION 'Invalid input: every order by field needs a direction.';
+        END IF;
+
+        FOR i IN 1 .. cardinality(p_order_fields) LOOP
+            IF p_order_fields[i] NOT IN ('first_name', 'last_name', 'email', 'country', 'created_at', 'updated_at') THEN
+                RAISE EXCEPTION 'Invalid input: cannot order by %.', p_order_fields[i];
+            END IF;
+
+            v_direction := upper(p_order_directions[i]);
+            IF v_direction NOT IN ('ASC', 'DESC') THEN
+                RAISE EXCEPTION 'Invalid input: unknown order direction %.', p_order_directions[i];
+            END IF;
+
+            v_order_by := v_order_by || format('users.%I %s, ', p_order_fields[i], v_direction);
+        END LOOP;
+
+        -- id breaks ties so pages are stable
+        v_order_by := v_order_by || 'users.id ASC';
+    ELSE
+        v_order_by := 'users.created_at DESC, users.id DESC';
+    END IF;
+
+    -- Return with dynamic filtering - partial matching can be applied
+    -- a cursor continues strictly after the last (created_at, id) of the previous page
+    RETURN QUERY EXECUTE format($query$
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        ($1 IS NULL OR users.id = $1) AND
+        ($2 IS NULL OR users.country = $2) AND
+        ($3 IS NULL OR users.email = $3) AND
+        ($4 IS NULL OR users.first_name ILIKE '%%' || $4 || '%%') AND
+        ($5 IS NULL OR users.last_name ILIKE '%%' || $5 || '%%') AND
+        ($6 IS NULL OR users.nick_name ILIKE '%%' || $6 || '%%') AND
+        ($9 IS NULL OR (users.created_at, users.id) < ($9, $10)) AND
+        ($11 IS NULL OR (users.email_verified_at IS NOT NULL) = $11) AND
+        ($12 OR users.deleted_at IS NULL)
+    ORDER BY %s
+    LIMIT $8
+    OFFSET ($7 - 1) * $8
+    $query$, v_order_by)
+    USING p_id, p_country, p_email, p_first_name, p_last_name, p_nick_name, p_page, p_page_size, p_after_created_at, p_after_id, p_email_verified, COALESCE(p_include_deleted, FALSE);
+END;
+$$;
+
+DROP FUNCTION IF EXISTS export_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TIMESTAMP, UUID, INT);
+
+CREATE FUNCTION export_users(
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_limit INT DEFAULT 1000
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate batch size input
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Same filtering as get_users, but walks the table in (created_at, id) order
+    -- so callers can page through it with a keyset instead of an offset. Deleted users are never exported
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_after_created_at IS NULL OR (users.created_at, users.id) > (p_after_created_at, p_after_id)) AND
+        users.deleted_at IS NULL
+    ORDER BY users.created_at ASC, users.id ASC
+    LIMIT p_limit;
+END;
+$$;
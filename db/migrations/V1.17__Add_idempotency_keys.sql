@@ -0,0 +1,129 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key TEXT NOT NULL,
+    -- Keys are scoped to the RPC so the same key sent to two methods never replays the wrong response
+    method TEXT NOT NULL,
+    -- A SHA-256 of the request lets a key reused for a different request be rejected
+    request_hash VARCHAR(64) NOT NULL,
+    -- NULL while the first request is still in progress
+    response BYTEA,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (idempotency_key, method)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expires_at_idx ON idempotency_keys (expires_at);
+
+CREATE FUNCTION reserve_idempotency_key(
+    p_key TEXT,
+    p_method TEXT,
+    p_request_hash TEXT,
+    p_expires_at TIMESTAMPTZ,
+    p_now TIMESTAMPTZ
+)
+RETURNS TABLE (
+    reserved BOOLEAN,
+    request_hash TEXT,
+    response BYTEA
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_key IS NULL OR p_method IS NULL OR p_request_hash IS NULL OR p_expires_at IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: key, method, request hash, expiry and current time are required.';
+    END IF;
+
+    -- An expired key is free to be used again, this includes reservations abandoned by a
+    -- request that never finished
+    DELETE FROM idempotency_keys
+    WHERE idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method
+      AND idempotency_keys.expires_at <= p_now;
+
+    -- A concurrent reservation of the same key waits here until the first one commits
+    INSERT INTO idempotency_keys (idempotency_key, method, request_hash, expires_at)
+    VALUES (p_key, p_method, p_request_hash, p_expires_at)
+    ON CONFLICT (idempotency_key, method) DO NOTHING;
+
+    IF FOUND THEN
+        RETURN QUERY SELECT TRUE, p_request_hash, NULL::BYTEA;
+        RETURN;
+    END IF;
+
+    -- The key is already held, hand back what it holds so the caller can replay or reject
+    RETURN QUERY
+    SELECT
+        FALSE,
+        idempotency_keys.request_hash::TEXT,
+        idempotency_keys.response
+    FROM idempotency_keys
+    WHERE idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method;
+END;
+$$;
+
+CREATE PROCEDURE complete_idempotency_key(
+    p_key TEXT,
+    p_method TEXT,
+    p_response BYTEA,
+    p_expires_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_key IS NULL OR p_method IS NULL OR p_response IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: key, method, response and expiry are required.';
+    END IF;
+
+    UPDATE idempotency_keys
+    SET response = p_response,
+        expires_at = p_expires_at
+    WHERE idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
+
+CREATE PROCEDURE release_idempotency_key(
+    p_key TEXT,
+    p_method TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_key IS NULL OR p_method IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: key and method are required.';
+    END IF;
+
+    -- Only an unfinished reservation is released, a stored response is kept until it expires
+    DELETE FROM idempotency_keys
+    WHERE idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
+
+CREATE FUNCTION delete_expired_idempotency_keys(
+    p_now TIMESTAMPTZ
+)
+RETURNS BIGINT
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    deleted BIGINT;
+BEGIN
+    -- Validate required inputs
+    IF p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: current time is required.';
+    END IF;
+
+    DELETE FROM idempotency_keys
+    WHERE expires_at <= p_now;
+
+    GET DIAGNOSTICS deleted = ROW_COUNT;
+    RETURN deleted;
+END;
+$$;
@@ -0,0 +1,151 @@
+CREATE TABLE IF NOT EXISTS user_audit (
+    id BIGSERIAL PRIMARY KEY,
+    -- No foreign key, the history of a user outlives them being purged
+    user_id UUID NOT NULL,
+    action TEXT NOT NULL,
+    -- Every changed field as {"field": {"old": ..., "new": ...}}, passwords are only ever recorded as redacted
+    changes JSONB NOT NULL,
+    actor TEXT,
+    request_id TEXT,
+    occurred_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (user_id, id DESC);
+
+CREATE OR REPLACE FUNCTION prevent_user_audit_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    RAISE EXCEPTION 'user_audit is append-only.';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit_append_only
+BEFORE UPDATE OR DELETE ON user_audit
+FOR EACH ROW
+EXECUTE FUNCTION prevent_user_audit_change();
+
+-- The audited fields of a user under their API names, nulls are left out so a field going
+-- from nothing to nothing isn't a change
+CREATE OR REPLACE FUNCTION user_audit_fields(u users)
+RETURNS JSONB
+LANGUAGE SQL
+IMMUTABLE
+AS $$
+    SELECT jsonb_strip_nulls(jsonb_build_object(
+        'first_name', u.first_name,
+        'last_name', u.last_name,
+        'nickname', u.nick_name,
+        'email', u.email,
+        'password', u.password,
+        'country', u.country,
+        'email_verified_at', u.email_verified_at,
+        'pending_email', u.pending_email,
+        'deleted_at', u.deleted_at
+    ));
+$$;
+
+-- Records every write to users in the transaction making it, so no change can be made without
+-- its audit entry. The caller and request are read from the transaction local settings
+-- user_audit.actor and user_audit.request_id, which writers set before changing a user
+CREATE OR REPLACE FUNCTION record_user_audit()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_old JSONB;
+    v_new JSONB;
+    v_changes JSONB := '{}'::JSONB;
+    v_field TEXT;
+    v_action TEXT;
+    v_user_id UUID;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := user_audit_fields(OLD);
+        v_user_id := OLD.id;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := user_audit_fields(NEW);
+        v_user_id := NEW.id;
+    END IF;
+
+    v_action := CASE
+        WHEN TG_OP = 'INSERT' THEN 'create'
+        WHEN TG_OP = 'DELETE' THEN 'purge'
+        WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'delete'
+        WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restore'
+        ELSE 'modify'
+    END;
+
+    FOR v_field IN SELECT jsonb_object_keys(COALESCE(v_old, '{}'::JSONB) || COALESCE(v_new, '{}'::JSONB)) LOOP
+        IF (v_old -> v_field) IS DISTINCT FROM (v_new -> v_field) THEN
+            v_changes := v_changes || jsonb_build_object(v_field, jsonb_build_object(
+                'old', CASE WHEN v_field = 'password' AND v_old ? v_field THEN to_jsonb('[REDACTED]'::TEXT) ELSE v_old -> v_field END,
+                'new', CASE WHEN v_field = 'password' AND v_new ? v_field THEN to_jsonb('[REDACTED]'::TEXT) ELSE v_new -> v_field END
+            ));
+        END IF;
+    END LOOP;
+
+    -- A modification that leaves every audited field as it was isn't worth an entry
+    IF v_action = 'modify' AND v_changes = '{}'::JSONB THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO user_audit (user_id, action, changes, actor, request_id)
+    VALUES (
+        v_user_id,
+        v_action,
+        v_changes,
+        NULLIF(current_setting('user_audit.actor', TRUE), ''),
+        NULLIF(current_setting('user_audit.request_id', TRUE), '')
+    );
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER record_user_audit
+AFTER INSERT OR UPDATE OR DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION record_user_audit();
+
+CREATE FUNCTION get_user_history(
+    p_user_id UUID,
+    p_before_id BIGINT DEFAULT NULL,
+    p_limit INT DEFAULT 20
+)
+RETURNS TABLE (
+    id BIGINT,
+    user_id UUID,
+    action TEXT,
+    changes JSONB,
+    actor TEXT,
+    request_id TEXT,
+    occurred_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_user_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: user id is required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Newest first, a cursor continues strictly before the last entry of the previous page
+    RETURN QUERY
+    SELECT
+        user_audit.id,
+        user_audit.user_id,
+        user_audit.action,
+        user_audit.changes,
+        user_audit.actor,
+        user_audit.request_id,
+        user_audit.occurred_at
+    FROM user_audit
+    WHERE user_audit.user_id = p_user_id
+      AND (p_before_id IS NULL OR user_audit.id < p_before_id)
+    ORDER BY user_audit.id DESC
+    LIMIT p_limit;
+END;
+$$;
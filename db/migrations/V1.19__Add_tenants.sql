@@ -0,0 +1,1126 @@
+-- Users belong to a tenant, every user written before tenancy belongs to the default tenant.
+-- The column default is dropped once existing rows are filled in so every write has to name a tenant
+ALTER TABLE users ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE users ALTER COLUMN tenant_id DROP DEFAULT;
+
+-- An email only has to be unique among the live users of its tenant, the index keeps the
+-- constraint's name for error classification
+DROP INDEX IF EXISTS user_email_unique;
+CREATE UNIQUE INDEX IF NOT EXISTS user_email_unique ON users (tenant_id, email) WHERE deleted_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_tenant_id_idx ON users (tenant_id, created_at, id);
+
+ALTER TABLE user_audit ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE user_audit ALTER COLUMN tenant_id DROP DEFAULT;
+
+DROP INDEX IF EXISTS user_audit_user_id_idx;
+CREATE INDEX IF NOT EXISTS user_audit_user_id_idx ON user_audit (tenant_id, user_id, id DESC);
+
+-- Idempotency keys are chosen by clients, so two tenants can pick the same one
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS tenant_id TEXT NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys ALTER COLUMN tenant_id DROP DEFAULT;
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, idempotency_key, method);
+
+CREATE OR REPLACE FUNCTION record_user_audit()
+RETURNS TRIGGER AS $$
+DECLARE
+    v_old JSONB;
+    v_new JSONB;
+    v_changes JSONB := '{}'::JSONB;
+    v_field TEXT;
+    v_action TEXT;
+    v_user_id UUID;
+    v_tenant_id TEXT;
+BEGIN
+    IF TG_OP <> 'INSERT' THEN
+        v_old := user_audit_fields(OLD);
+        v_user_id := OLD.id;
+        v_tenant_id := OLD.tenant_id;
+    END IF;
+    IF TG_OP <> 'DELETE' THEN
+        v_new := user_audit_fields(NEW);
+        v_user_id := NEW.id;
+        v_tenant_id := NEW.tenant_id;
+    END IF;
+
+    v_action := CASE
+        WHEN TG_OP = 'INSERT' THEN 'create'
+        WHEN TG_OP = 'DELETE' THEN 'purge'
+        WHEN OLD.deleted_at IS NULL AND NEW.deleted_at IS NOT NULL THEN 'delete'
+        WHEN OLD.deleted_at IS NOT NULL AND NEW.deleted_at IS NULL THEN 'restore'
+        ELSE 'modify'
+    END;
+
+    FOR v_field IN SELECT jsonb_object_keys(COALESCE(v_old, '{}'::JSONB) || COALESCE(v_new, '{}'::JSONB)) LOOP
+        IF (v_old -> v_field) IS DISTINCT FROM (v_new -> v_field) THEN
+            v_changes := v_changes || jsonb_build_object(v_field, jsonb_build_object(
+                'old', CASE WHEN v_field = 'password' AND v_old ? v_field THEN to_jsonb('[REDACTED]'::TEXT) ELSE v_old -> v_field END,
+                'new', CASE WHEN v_field = 'password' AND v_new ? v_field THEN to_jsonb('[REDACTED]'::TEXT) ELSE v_new -> v_field END
+            ));
+        END IF;
+    END LOOP;
+
+    -- A modification that leaves every audited field as it was isn't worth an entry
+    IF v_action = 'modify' AND v_changes = '{}'::JSONB THEN
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO user_audit (tenant_id, user_id, action, changes, actor, request_id)
+    VALUES (
+        v_tenant_id,
+        v_user_id,
+        v_action,
+        v_changes,
+        NULLIF(current_setting('user_audit.actor', TRUE), ''),
+        NULLIF(current_setting('user_audit.request_id', TRUE), '')
+    );
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP FUNCTION IF EXISTS create_user(TEXT, TEXT, TEXT, TEXT, TEXT, TEXT);
+
+CREATE FUNCTION create_user(
+    p_tenant_id TEXT,
+    p_first_name TEXT,
+    p_last_name TEXT,
+    p_nick_name TEXT,
+    p_password TEXT,
+    p_email TEXT,
+    p_country TEXT
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    new_user_id UUID;
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    IF p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: email is required.';
+    END IF;
+
+    INSERT INTO users (
+        tenant_id,
+        first_name,
+        last_name,
+        nick_name,
+        password,
+        email,
+        country
+    )
+    VALUES (
+        p_tenant_id,
+        p_first_name,
+        p_last_name,
+        p_nick_name,
+        p_password,
+        p_email,
+        p_country
+    )
+    RETURNING id INTO new_user_id;
+
+    RETURN new_user_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS create_users(TEXT[], TEXT[], TEXT[], TEXT[], TEXT[], TEXT[]);
+
+CREATE FUNCTION create_users(
+    p_tenant_id TEXT,
+    p_first_names TEXT[],
+    p_last_names TEXT[],
+    p_nick_names TEXT[],
+    p_passwords TEXT[],
+    p_emails TEXT[],
+    p_countries TEXT[]
+)
+RETURNS TABLE (
+    id UUID,
+    email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    -- Validate every column has been supplied for every user
+    IF cardinality(p_first_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_last_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_nick_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_passwords) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_countries) IS DISTINCT FROM cardinality(p_emails) THEN
+        RAISE EXCEPTION 'Invalid input: all user fields must have the same number of entries.';
+    END IF;
+
+    -- Insert the whole batch at once, rows with an email that already exists in the tenant are
+    -- skipped rather than failing the batch and are missing from the returned set
+    RETURN QUERY
+    INSERT INTO users (
+        tenant_id,
+        first_name,
+        last_name,
+        nick_name,
+        password,
+        email,
+        country
+    )
+    SELECT p_tenant_id, * FROM unnest(
+        p_first_names,
+        p_last_names,
+        p_nick_names,
+        p_passwords,
+        p_emails,
+        p_countries
+    )
+    ON CONFLICT (tenant_id, email) WHERE deleted_at IS NULL DO NOTHING
+    RETURNING users.id::UUID, users.email::TEXT;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS update_user(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TEXT, BIGINT);
+
+CREATE FUNCTION update_user(
+    p_tenant_id TEXT,
+    p_id UUID,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_password TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_expected_version BIGINT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and id are required.';
+    END IF;
+
+    -- Fail fast rather than leave a pending address that can never be confirmed
+    IF p_email IS NOT NULL AND EXISTS (
+        SELECT 1 FROM users
+        WHERE users.tenant_id = p_tenant_id AND users.email = p_email AND users.id <> p_id AND users.deleted_at IS NULL
+    ) THEN
+        RAISE EXCEPTION USING
+            ERRCODE = 'unique_violation',
+            CONSTRAINT = 'user_email_unique',
+            MESSAGE = format('Email %s already exists.', p_email);
+    END IF;
+
+    -- NULL leaves a field untouched, any other value (including an empty string) is written.
+    -- A new email only becomes pending, supplying the current email again cancels a pending change.
+    -- Deleted users have to be restored before they can be modified, and when an expected version
+    -- is supplied the write only lands if nobody else has changed the user since it was read
+    RETURN QUERY
+    UPDATE users
+    SET
+        first_name = COALESCE(p_first_name, first_name),
+        last_name = COALESCE(p_last_name, last_name),
+        nick_name = COALESCE(p_nick_name, nick_name),
+        password = COALESCE(p_password, password),
+        pending_email = CASE
+            WHEN p_email IS NULL THEN pending_email
+            WHEN p_email = email THEN NULL
+            ELSE p_email
+        END,
+        country = COALESCE(p_country, country)
+    WHERE users.tenant_id = p_tenant_id
+      AND users.id = p_id
+      AND users.deleted_at IS NULL
+      AND (p_expected_version IS NULL OR users.version = p_expected_version)
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT;
+
+    IF NOT FOUND THEN
+        IF p_expected_version IS NOT NULL AND EXISTS (
+            SELECT 1 FROM users WHERE users.tenant_id = p_tenant_id AND users.id = p_id AND users.deleted_at IS NULL
+        ) THEN
+            RAISE EXCEPTION 'Version mismatch: user with id % has been changed since version %.', p_id, p_expected_version;
+        END IF;
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS delete_user(UUID, BIGINT);
+
+CREATE PROCEDURE delete_user(
+    p_tenant_id TEXT,
+    p_id UUID,
+    p_expected_version BIGINT DEFAULT NULL
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate input
+    IF p_tenant_id IS NULL OR p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and id are required.';
+    END IF;
+
+    -- The version is checked in the same statement as the write so nothing can slip in between
+    UPDATE users
+    SET deleted_at = CURRENT_TIMESTAMP
+    WHERE tenant_id = p_tenant_id
+      AND id = p_id
+      AND deleted_at IS NULL
+      AND (p_expected_version IS NULL OR version = p_expected_version);
+
+    IF NOT FOUND THEN
+        IF p_expected_version IS NOT NULL AND EXISTS (
+            SELECT 1 FROM users WHERE users.tenant_id = p_tenant_id AND users.id = p_id AND users.deleted_at IS NULL
+        ) THEN
+            RAISE EXCEPTION 'Version mismatch: user with id % has been changed since version %.', p_id, p_expected_version;
+        END IF;
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+
+    -- Outstanding tokens mustn't outlive the deletion, a restored user requests new ones
+    UPDATE password_reset_tokens
+    SET used_at = CURRENT_TIMESTAMP
+    WHERE user_id = p_id
+      AND used_at IS NULL;
+
+    UPDATE email_verification_tokens
+    SET used_at = CURRENT_TIMESTAMP
+    WHERE user_id = p_id
+      AND used_at IS NULL;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS restore_user(UUID);
+
+CREATE FUNCTION restore_user(
+    p_tenant_id TEXT,
+    p_id UUID
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate input
+    IF p_tenant_id IS NULL OR p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and id are required.';
+    END IF;
+
+    -- Restoring can hit user_email_unique if the email was registered again after the deletion
+    RETURN QUERY
+    UPDATE users
+    SET deleted_at = NULL
+    WHERE users.tenant_id = p_tenant_id
+      AND users.id = p_id
+      AND users.deleted_at IS NOT NULL
+    RETURNING
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'Deleted user with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS purge_user(UUID);
+
+CREATE PROCEDURE purge_user(
+    p_tenant_id TEXT,
+    p_id UUID
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate input
+    IF p_tenant_id IS NULL OR p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and id are required.';
+    END IF;
+
+    -- Removes the row whether or not it was soft deleted first, tokens go with it by cascade
+    DELETE FROM users
+    WHERE tenant_id = p_tenant_id
+      AND id = p_id;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS purge_deleted_users(TIMESTAMP, INT);
+
+CREATE FUNCTION purge_deleted_users(
+    p_deleted_before TIMESTAMP,
+    p_limit INT DEFAULT 100
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_deleted_before IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: deleted before is required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- The retention window applies to every tenant, so this is the one write that isn't scoped to one.
+    -- Purges at most p_limit users so a large backlog is worked through in short transactions,
+    -- SKIP LOCKED lets several instances purge at once without waiting on each other
+    RETURN QUERY
+    DELETE FROM users
+    WHERE users.id IN (
+        SELECT expired.id
+        FROM users AS expired
+        WHERE expired.deleted_at < p_deleted_before
+        ORDER BY expired.deleted_at
+        LIMIT p_limit
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING users.id::UUID, users.tenant_id::TEXT;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_user(UUID, TEXT);
+
+CREATE FUNCTION get_user(
+    p_tenant_id TEXT,
+    p_id UUID DEFAULT NULL,
+    p_email TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    IF p_id IS NULL AND p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id or email is required.';
+    END IF;
+
+    -- Exact matching only, a single live user is expected
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        users.tenant_id = p_tenant_id AND
+        (p_id IS NULL OR users.id = p_id) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        users.deleted_at IS NULL
+    LIMIT 1;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, INT, INT, TIMESTAMP, UUID, TEXT[], TEXT[], BOOLEAN, BOOLEAN);
+
+CREATE FUNCTION get_users(
+    p_tenant_id TEXT,
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_page INT DEFAULT 1,
+    p_page_size INT DEFAULT 10,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_order_fields TEXT[] DEFAULT NULL,
+    p_order_directions TEXT[] DEFAULT NULL,
+    p_email_verified BOOLEAN DEFAULT NULL,
+    p_include_deleted BOOLEAN DEFAULT FALSE
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_order_by TEXT := '';
+    v_direction TEXT;
+BEGIN
+    -- Validate tenant input
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    -- Validate pagination inputs
+    IF p_page < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page must be >= 1.';
+    END IF;
+
+    IF p_page_size < 1 THEN
+        RAISE EXCEPTION 'Invalid input: page_size must be >= 1.';
+    END IF;
+
+    IF p_page IS NOT NULL AND p_after_created_at IS NOT NULL THEN
+        RAISE EXCEPTION 'Invalid input: page and cursor cannot be combined.';
+    END IF;
+
+    -- Validate ordering inputs, only allow-listed columns and directions ever reach the query text
+    IF cardinality(p_order_fields) > 0 THEN
+        IF p_after_created_at IS NOT NULL THEN
+            RAISE EXCEPTION 'Invalid input: order by and cursor cannot be combined.';
+        END IF;
+
+        IF cardinality(p_order_fields) IS DISTINCT FROM cardinality(p_order_directions) THEN
+            RAISE EXCEPTION 'Invalid input: every order by field needs a direction.';
+        END IF;
+
+        FOR i IN 1 .. cardinality(p_order_fields) LOOP
+            IF p_order_fields[i] NOT IN ('first_name', 'last_name', 'email', 'country', 'created_at', 'updated_at') THEN
+                RAISE EXCEPTION 'Invalid input: cannot order by %.', p_order_fields[i];
+            END IF;
+
+            v_direction := upper(p_order_directions[i]);
+            IF v_direction NOT IN ('ASC', 'DESC') THEN
+                RAISE EXCEPTION 'Invalid input: unknown order direction %.', p_order_directions[i];
+            END IF;
+
+            v_order_by := v_order_by || format('users.%I %s, ', p_order_fields[i], v_direction);
+        END LOOP;
+
+        -- id breaks ties so pages are stable
+        v_order_by := v_order_by || 'users.id ASC';
+    ELSE
+        v_order_by := 'users.created_at DESC, users.id DESC';
+    END IF;
+
+    -- Return with dynamic filtering - partial matching can be applied
+    -- a cursor continues strictly after the last (created_at, id) of the previous page
+    RETURN QUERY EXECUTE format($query$
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        users.tenant_id = $13 AND
+        ($1 IS NULL OR users.id = $1) AND
+        ($2 IS NULL OR users.country = $2) AND
+        ($3 IS NULL OR users.email = $3) AND
+        ($4 IS NULL OR users.first_name ILIKE '%%' || $4 || '%%') AND
+        ($5 IS NULL OR users.last_name ILIKE '%%' || $5 || '%%') AND
+        ($6 IS NULL OR users.nick_name ILIKE '%%' || $6 || '%%') AND
+        ($9 IS NULL OR (users.created_at, users.id) < ($9, $10)) AND
+        ($11 IS NULL OR (users.email_verified_at IS NOT NULL) = $11) AND
+        ($12 OR users.deleted_at IS NULL)
+    ORDER BY %s
+    LIMIT $8
+    OFFSET ($7 - 1) * $8
+    $query$, v_order_by)
+    USING p_id, p_country, p_email, p_first_name, p_last_name, p_nick_name, p_page, p_page_size, p_after_created_at, p_after_id, p_email_verified, COALESCE(p_include_deleted, FALSE), p_tenant_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS count_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, BOOLEAN, BOOLEAN);
+
+CREATE FUNCTION count_users(
+    p_tenant_id TEXT,
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_email_verified BOOLEAN DEFAULT NULL,
+    p_include_deleted BOOLEAN DEFAULT FALSE
+)
+RETURNS BIGINT
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    total BIGINT;
+BEGIN
+    -- Validate tenant input
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    -- Same filtering as get_users without pagination so callers know the full size of the result
+    SELECT COUNT(*) INTO total
+    FROM users
+    WHERE
+        users.tenant_id = p_tenant_id AND
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_email_verified IS NULL OR (users.email_verified_at IS NOT NULL) = p_email_verified) AND
+        (COALESCE(p_include_deleted, FALSE) OR users.deleted_at IS NULL);
+
+    RETURN total;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS export_users(UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TIMESTAMP, UUID, INT);
+
+CREATE FUNCTION export_users(
+    p_tenant_id TEXT,
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_limit INT DEFAULT 1000
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Same filtering as get_users, but walks the table in (created_at, id) order
+    -- so callers can page through it with a keyset instead of an offset. Deleted users are never exported
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        users.tenant_id = p_tenant_id AND
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_after_created_at IS NULL OR (users.created_at, users.id) > (p_after_created_at, p_after_id)) AND
+        users.deleted_at IS NULL
+    ORDER BY users.created_at ASC, users.id ASC
+    LIMIT p_limit;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_user_credentials(TEXT);
+
+CREATE FUNCTION get_user_credentials(
+    p_tenant_id TEXT,
+    p_email TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    password TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_tenant_id IS NULL OR p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and email are required.';
+    END IF;
+
+    -- The password hash is only ever read here so credentials are verified in one place,
+    -- deleted users can't authenticate
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.password::TEXT
+    FROM users
+    WHERE users.tenant_id = p_tenant_id
+      AND users.email = p_email
+      AND users.deleted_at IS NULL
+    LIMIT 1;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS create_password_reset_token(TEXT, TEXT, TIMESTAMPTZ);
+
+CREATE FUNCTION create_password_reset_token(
+    p_tenant_id TEXT,
+    p_email TEXT,
+    p_token_hash TEXT,
+    p_expires_at TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_email IS NULL OR p_token_hash IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, email, token hash and expiry are required.';
+    END IF;
+
+    SELECT users.id INTO v_user_id
+    FROM users
+    WHERE users.tenant_id = p_tenant_id
+      AND users.email = p_email
+      AND users.deleted_at IS NULL;
+
+    IF v_user_id IS NULL THEN
+        RAISE EXCEPTION 'User with email % not found.', p_email;
+    END IF;
+
+    INSERT INTO password_reset_tokens (token_hash, user_id, expires_at)
+    VALUES (p_token_hash, v_user_id, p_expires_at);
+
+    RETURN v_user_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS complete_password_reset(TEXT, TEXT, TIMESTAMPTZ);
+
+CREATE FUNCTION complete_password_reset(
+    p_tenant_id TEXT,
+    p_token_hash TEXT,
+    p_password TEXT,
+    p_now TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_token_hash IS NULL OR p_password IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, token hash, password and current time are required.';
+    END IF;
+
+    -- Spending the token in the same statement that checks it means concurrent attempts
+    -- with one token can't both succeed. A token only works in the tenant of its user
+    UPDATE password_reset_tokens
+    SET used_at = p_now
+    WHERE token_hash = p_token_hash
+      AND used_at IS NULL
+      AND expires_at > p_now
+      AND user_id IN (SELECT users.id FROM users WHERE users.tenant_id = p_tenant_id)
+    RETURNING user_id INTO v_user_id;
+
+    -- NULL tells the caller the token is unknown, used or expired without saying which
+    IF v_user_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    UPDATE users
+    SET password = p_password
+    WHERE id = v_user_id;
+
+    -- Any other outstanding tokens for the user are spent too
+    UPDATE password_reset_tokens
+    SET used_at = p_now
+    WHERE user_id = v_user_id
+      AND used_at IS NULL;
+
+    RETURN v_user_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS create_email_verification_token(UUID, TEXT, TIMESTAMPTZ);
+
+CREATE FUNCTION create_email_verification_token(
+    p_tenant_id TEXT,
+    p_id UUID,
+    p_token_hash TEXT,
+    p_expires_at TIMESTAMPTZ
+)
+RETURNS TEXT
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_email TEXT;
+    v_pending_email TEXT;
+    v_email_verified_at TIMESTAMP;
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_id IS NULL OR p_token_hash IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, id, token hash and expiry are required.';
+    END IF;
+
+    SELECT users.email, users.pending_email, users.email_verified_at
+    INTO v_email, v_pending_email, v_email_verified_at
+    FROM users
+    WHERE users.tenant_id = p_tenant_id
+      AND users.id = p_id
+      AND users.deleted_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_id;
+    END IF;
+
+    -- A pending address is always the one being verified, otherwise the current one if it isn't yet
+    IF v_pending_email IS NOT NULL THEN
+        v_email := v_pending_email;
+    ELSIF v_email_verified_at IS NOT NULL THEN
+        -- NULL tells the caller there is nothing left to verify
+        RETURN NULL;
+    END IF;
+
+    INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at)
+    VALUES (p_token_hash, p_id, v_email, p_expires_at);
+
+    RETURN v_email;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS confirm_email(TEXT, TIMESTAMPTZ);
+
+CREATE FUNCTION confirm_email(
+    p_tenant_id TEXT,
+    p_token_hash TEXT,
+    p_now TIMESTAMPTZ
+)
+RETURNS UUID
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+    v_user_id UUID;
+    v_email TEXT;
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_token_hash IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, token hash and current time are required.';
+    END IF;
+
+    UPDATE email_verification_tokens
+    SET used_at = p_now
+    WHERE token_hash = p_token_hash
+      AND used_at IS NULL
+      AND expires_at > p_now
+      AND user_id IN (SELECT users.id FROM users WHERE users.tenant_id = p_tenant_id)
+    RETURNING user_id, email INTO v_user_id, v_email;
+
+    IF v_user_id IS NULL THEN
+        RETURN NULL;
+    END IF;
+
+    -- Promote the pending address or mark the current one verified, whichever the token was sent to.
+    -- Taking a pending address can still hit user_email_unique if someone else registered it meanwhile
+    UPDATE users
+    SET
+        email = v_email,
+        pending_email = NULL,
+        email_verified_at = p_now
+    WHERE users.id = v_user_id
+      AND (users.pending_email = v_email OR (users.pending_email IS NULL AND users.email = v_email));
+
+    IF NOT FOUND THEN
+        RETURN NULL;
+    END IF;
+
+    -- Tokens for the same user are spent once any of them confirms an address
+    UPDATE email_verification_tokens
+    SET used_at = p_now
+    WHERE user_id = v_user_id
+      AND used_at IS NULL;
+
+    RETURN v_user_id;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS get_user_history(UUID, BIGINT, INT);
+
+CREATE FUNCTION get_user_history(
+    p_tenant_id TEXT,
+    p_user_id UUID,
+    p_before_id BIGINT DEFAULT NULL,
+    p_limit INT DEFAULT 20
+)
+RETURNS TABLE (
+    id BIGINT,
+    user_id UUID,
+    action TEXT,
+    changes JSONB,
+    actor TEXT,
+    request_id TEXT,
+    occurred_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_user_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and user id are required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Newest first, a cursor continues strictly before the last entry of the previous page
+    RETURN QUERY
+    SELECT
+        user_audit.id,
+        user_audit.user_id,
+        user_audit.action,
+        user_audit.changes,
+        user_audit.actor,
+        user_audit.request_id,
+        user_audit.occurred_at
+    FROM user_audit
+    WHERE user_audit.tenant_id = p_tenant_id
+      AND user_audit.user_id = p_user_id
+      AND (p_before_id IS NULL OR user_audit.id < p_before_id)
+    ORDER BY user_audit.id DESC
+    LIMIT p_limit;
+END;
+$$;
+
+DROP FUNCTION IF EXISTS reserve_idempotency_key(TEXT, TEXT, TEXT, TIMESTAMPTZ, TIMESTAMPTZ);
+
+CREATE FUNCTION reserve_idempotency_key(
+    p_tenant_id TEXT,
+    p_key TEXT,
+    p_method TEXT,
+    p_request_hash TEXT,
+    p_expires_at TIMESTAMPTZ,
+    p_now TIMESTAMPTZ
+)
+RETURNS TABLE (
+    reserved BOOLEAN,
+    request_hash TEXT,
+    response BYTEA
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_key IS NULL OR p_method IS NULL OR p_request_hash IS NULL OR p_expires_at IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, key, method, request hash, expiry and current time are required.';
+    END IF;
+
+    -- An expired key is free to be used again, this includes reservations abandoned by a
+    -- request that never finished
+    DELETE FROM idempotency_keys
+    WHERE idempotency_keys.tenant_id = p_tenant_id
+      AND idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method
+      AND idempotency_keys.expires_at <= p_now;
+
+    -- A concurrent reservation of the same key waits here until the first one commits
+    INSERT INTO idempotency_keys (tenant_id, idempotency_key, method, request_hash, expires_at)
+    VALUES (p_tenant_id, p_key, p_method, p_request_hash, p_expires_at)
+    ON CONFLICT (tenant_id, idempotency_key, method) DO NOTHING;
+
+    IF FOUND THEN
+        RETURN QUERY SELECT TRUE, p_request_hash, NULL::BYTEA;
+        RETURN;
+    END IF;
+
+    -- The key is already held, hand back what it holds so the caller can replay or reject
+    RETURN QUERY
+    SELECT
+        FALSE,
+        idempotency_keys.request_hash::TEXT,
+        idempotency_keys.response
+    FROM idempotency_keys
+    WHERE idempotency_keys.tenant_id = p_tenant_id
+      AND idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS complete_idempotency_key(TEXT, TEXT, BYTEA, TIMESTAMPTZ);
+
+CREATE PROCEDURE complete_idempotency_key(
+    p_tenant_id TEXT,
+    p_key TEXT,
+    p_method TEXT,
+    p_response BYTEA,
+    p_expires_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_key IS NULL OR p_method IS NULL OR p_response IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, key, method, response and expiry are required.';
+    END IF;
+
+    UPDATE idempotency_keys
+    SET response = p_response,
+        expires_at = p_expires_at
+    WHERE tenant_id = p_tenant_id
+      AND idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS release_idempotency_key(TEXT, TEXT);
+
+CREATE PROCEDURE release_idempotency_key(
+    p_tenant_id TEXT,
+    p_key TEXT,
+    p_method TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_key IS NULL OR p_method IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, key and method are required.';
+    END IF;
+
+    -- Only an unfinished reservation is released, a stored response is kept until it expires
+    DELETE FROM idempotency_keys
+    WHERE tenant_id = p_tenant_id
+      AND idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
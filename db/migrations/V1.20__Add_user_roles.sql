@@ -0,0 +1,100 @@
+CREATE TABLE IF NOT EXISTS user_roles (
+    tenant_id TEXT NOT NULL,
+    -- Assignments go with the user when they are purged
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    role TEXT NOT NULL,
+    -- The user who assigned the role, NULL when it was assigned outside of an authenticated call
+    granted_by TEXT,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+
+    PRIMARY KEY (tenant_id, user_id, role)
+);
+
+CREATE FUNCTION get_user_roles(
+    p_tenant_id TEXT,
+    p_user_id UUID
+)
+RETURNS TABLE (
+    role TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_user_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and user id are required.';
+    END IF;
+
+    -- A deleted user keeps their assignments for a restore but holds no roles meanwhile
+    RETURN QUERY
+    SELECT user_roles.role
+    FROM user_roles
+    JOIN users ON users.id = user_roles.user_id
+    WHERE user_roles.tenant_id = p_tenant_id
+      AND user_roles.user_id = p_user_id
+      AND users.deleted_at IS NULL
+    ORDER BY user_roles.role;
+END;
+$$;
+
+CREATE PROCEDURE assign_role(
+    p_tenant_id TEXT,
+    p_user_id UUID,
+    p_role TEXT,
+    p_granted_by TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_user_id IS NULL OR p_role IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, user id and role are required.';
+    END IF;
+
+    PERFORM 1
+    FROM users
+    WHERE tenant_id = p_tenant_id
+      AND id = p_user_id
+      AND deleted_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_user_id;
+    END IF;
+
+    -- Assigning a role the user already holds changes nothing
+    INSERT INTO user_roles (tenant_id, user_id, role, granted_by)
+    VALUES (p_tenant_id, p_user_id, p_role, NULLIF(p_granted_by, ''))
+    ON CONFLICT (tenant_id, user_id, role) DO NOTHING;
+END;
+$$;
+
+CREATE PROCEDURE revoke_role(
+    p_tenant_id TEXT,
+    p_user_id UUID,
+    p_role TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_user_id IS NULL OR p_role IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, user id and role are required.';
+    END IF;
+
+    PERFORM 1
+    FROM users
+    WHERE tenant_id = p_tenant_id
+      AND id = p_user_id
+      AND deleted_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'User with id % not found.', p_user_id;
+    END IF;
+
+    -- Revoking a role the user doesn't hold changes nothing
+    DELETE FROM user_roles
+    WHERE tenant_id = p_tenant_id
+      AND user_id = p_user_id
+      AND role = p_role;
+END;
+$$;
@@ -0,0 +1,216 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    id UUID DEFAULT gen_random_uuid() PRIMARY KEY,
+    tenant_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    -- A SHA-256 of the key, the key itself is only ever shown to whoever created or rotated it
+    key_hash VARCHAR(64) NOT NULL,
+    -- The permissions granted to callers using the key, such as users.read
+    scopes TEXT[] NOT NULL,
+    -- NULL for keys that never expire
+    expires_at TIMESTAMPTZ,
+    -- The caller who created the key, NULL when it was created outside of an authenticated call
+    created_by TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+
+    CONSTRAINT api_key_hash_unique UNIQUE (key_hash)
+);
+
+-- Names only have to be unique among a tenant's keys still in use
+CREATE UNIQUE INDEX IF NOT EXISTS api_key_name_unique ON api_keys (tenant_id, name) WHERE revoked_at IS NULL;
+
+CREATE FUNCTION create_api_key(
+    p_tenant_id TEXT,
+    p_name TEXT,
+    p_key_hash TEXT,
+    p_scopes TEXT[],
+    p_expires_at TIMESTAMPTZ,
+    p_created_by TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT,
+    name TEXT,
+    scopes TEXT[],
+    expires_at TIMESTAMPTZ,
+    created_by TEXT,
+    created_at TIMESTAMPTZ,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_name IS NULL OR p_key_hash IS NULL OR p_scopes IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, name, key hash and scopes are required.';
+    END IF;
+
+    RETURN QUERY
+    INSERT INTO api_keys (tenant_id, name, key_hash, scopes, expires_at, created_by)
+    VALUES (p_tenant_id, p_name, p_key_hash, p_scopes, p_expires_at, NULLIF(p_created_by, ''))
+    RETURNING
+        api_keys.id,
+        api_keys.tenant_id,
+        api_keys.name,
+        api_keys.scopes,
+        api_keys.expires_at,
+        api_keys.created_by,
+        api_keys.created_at,
+        api_keys.rotated_at,
+        api_keys.revoked_at;
+END;
+$$;
+
+CREATE FUNCTION rotate_api_key(
+    p_tenant_id TEXT,
+    p_id UUID,
+    p_key_hash TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT,
+    name TEXT,
+    scopes TEXT[],
+    expires_at TIMESTAMPTZ,
+    created_by TEXT,
+    created_at TIMESTAMPTZ,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_id IS NULL OR p_key_hash IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, id and key hash are required.';
+    END IF;
+
+    -- The old key stops working as soon as the new one is stored
+    RETURN QUERY
+    UPDATE api_keys
+    SET key_hash = p_key_hash,
+        rotated_at = CURRENT_TIMESTAMP
+    WHERE api_keys.tenant_id = p_tenant_id
+      AND api_keys.id = p_id
+      AND api_keys.revoked_at IS NULL
+    RETURNING
+        api_keys.id,
+        api_keys.tenant_id,
+        api_keys.name,
+        api_keys.scopes,
+        api_keys.expires_at,
+        api_keys.created_by,
+        api_keys.created_at,
+        api_keys.rotated_at,
+        api_keys.revoked_at;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'API key with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+CREATE PROCEDURE revoke_api_key(
+    p_tenant_id TEXT,
+    p_id UUID
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL OR p_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant and id are required.';
+    END IF;
+
+    -- Revoked keys are kept so their use can still be traced in the audit log
+    UPDATE api_keys
+    SET revoked_at = CURRENT_TIMESTAMP
+    WHERE tenant_id = p_tenant_id
+      AND id = p_id
+      AND revoked_at IS NULL;
+
+    IF NOT FOUND THEN
+        RAISE EXCEPTION 'API key with id % not found.', p_id;
+    END IF;
+END;
+$$;
+
+CREATE FUNCTION list_api_keys(
+    p_tenant_id TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT,
+    name TEXT,
+    scopes TEXT[],
+    expires_at TIMESTAMPTZ,
+    created_by TEXT,
+    created_at TIMESTAMPTZ,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate input
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    RETURN QUERY
+    SELECT
+        api_keys.id,
+        api_keys.tenant_id,
+        api_keys.name,
+        api_keys.scopes,
+        api_keys.expires_at,
+        api_keys.created_by,
+        api_keys.created_at,
+        api_keys.rotated_at,
+        api_keys.revoked_at
+    FROM api_keys
+    WHERE api_keys.tenant_id = p_tenant_id
+    ORDER BY api_keys.created_at, api_keys.id;
+END;
+$$;
+
+-- Looks a key up across every tenant, a key decides the tenant of the calls made with it
+CREATE FUNCTION get_api_key(
+    p_key_hash TEXT
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT,
+    name TEXT,
+    scopes TEXT[],
+    expires_at TIMESTAMPTZ,
+    created_by TEXT,
+    created_at TIMESTAMPTZ,
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate input
+    IF p_key_hash IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: key hash is required.';
+    END IF;
+
+    RETURN QUERY
+    SELECT
+        api_keys.id,
+        api_keys.tenant_id,
+        api_keys.name,
+        api_keys.scopes,
+        api_keys.expires_at,
+        api_keys.created_by,
+        api_keys.created_at,
+        api_keys.rotated_at,
+        api_keys.revoked_at
+    FROM api_keys
+    WHERE api_keys.key_hash = p_key_hash;
+END;
+$$;
@@ -0,0 +1,68 @@
+-- The fields of a user that identify them, their values are redacted from the audit log once the
+-- user is purged so the history keeps what changed and when but no longer who it was about
+CREATE OR REPLACE FUNCTION redact_user_audit_value(p_value JSONB)
+RETURNS JSONB
+LANGUAGE SQL
+IMMUTABLE
+AS $$
+    SELECT CASE WHEN p_value IS NULL OR p_value = 'null'::JSONB THEN p_value ELSE to_jsonb('[REDACTED]'::TEXT) END;
+$$;
+
+CREATE OR REPLACE FUNCTION redact_user_audit_changes(p_changes JSONB)
+RETURNS JSONB
+LANGUAGE SQL
+IMMUTABLE
+AS $$
+    SELECT COALESCE(jsonb_object_agg(
+        change.key,
+        CASE WHEN change.key IN ('first_name', 'last_name', 'nickname', 'email', 'pending_email') THEN
+            jsonb_build_object(
+                'old', redact_user_audit_value(change.value -> 'old'),
+                'new', redact_user_audit_value(change.value -> 'new')
+            )
+        ELSE change.value END
+    ), '{}'::JSONB)
+    FROM jsonb_each(p_changes) AS change;
+$$;
+
+-- The audit log stays append-only, the one rewrite allowed is redacting an entry, which can only
+-- ever remove information
+CREATE OR REPLACE FUNCTION prevent_user_audit_change()
+RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'UPDATE'
+        AND to_jsonb(NEW) - 'changes' = to_jsonb(OLD) - 'changes'
+        AND NEW.changes = redact_user_audit_changes(OLD.changes) THEN
+        RETURN NEW;
+    END IF;
+
+    RAISE EXCEPTION 'user_audit is append-only.';
+END;
+$$ LANGUAGE plpgsql;
+
+-- Runs after record_user_audit, triggers firing on the same event run in name order, so the purge
+-- entry itself is redacted along with the rest of the user's history. Covers PurgeUser, hard
+-- deletes and the scheduled purge alike since they all delete from users
+CREATE OR REPLACE FUNCTION redact_purged_user_audit()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE user_audit
+    SET changes = redact_user_audit_changes(changes)
+    WHERE tenant_id = OLD.tenant_id
+      AND user_id = OLD.id;
+
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER redact_purged_user_audit
+AFTER DELETE ON users
+FOR EACH ROW
+EXECUTE FUNCTION redact_purged_user_audit();
+
+-- Users purged before this migration ran
+UPDATE user_audit
+SET changes = redact_user_audit_changes(changes)
+WHERE NOT EXISTS (
+    SELECT 1 FROM users WHERE users.tenant_id = user_audit.tenant_id AND users.id = user_audit.user_id
+);
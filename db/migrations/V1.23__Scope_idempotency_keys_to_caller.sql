@@ -0,0 +1,117 @@
+-- Idempotency keys are chosen by clients, so two callers of a tenant can pick the same one and
+-- neither may be handed the other's response. Anonymous callers are recorded as ''
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS caller TEXT NOT NULL DEFAULT '';
+ALTER TABLE idempotency_keys DROP CONSTRAINT IF EXISTS idempotency_keys_pkey;
+ALTER TABLE idempotency_keys ADD PRIMARY KEY (tenant_id, caller, idempotency_key, method);
+
+DROP FUNCTION IF EXISTS reserve_idempotency_key(TEXT, TEXT, TEXT, TEXT, TIMESTAMPTZ, TIMESTAMPTZ);
+
+CREATE FUNCTION reserve_idempotency_key(
+    p_tenant_id TEXT,
+    p_caller TEXT,
+    p_key TEXT,
+    p_method TEXT,
+    p_request_hash TEXT,
+    p_expires_at TIMESTAMPTZ,
+    p_now TIMESTAMPTZ
+)
+RETURNS TABLE (
+    reserved BOOLEAN,
+    request_hash TEXT,
+    response BYTEA
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_caller IS NULL OR p_key IS NULL OR p_method IS NULL OR p_request_hash IS NULL OR p_expires_at IS NULL OR p_now IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, caller, key, method, request hash, expiry and current time are required.';
+    END IF;
+
+    -- An expired key is free to be used again, this includes reservations abandoned by a
+    -- request that never finished
+    DELETE FROM idempotency_keys
+    WHERE idempotency_keys.tenant_id = p_tenant_id
+      AND idempotency_keys.caller = p_caller
+      AND idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method
+      AND idempotency_keys.expires_at <= p_now;
+
+    -- A concurrent reservation of the same key waits here until the first one commits
+    INSERT INTO idempotency_keys (tenant_id, caller, idempotency_key, method, request_hash, expires_at)
+    VALUES (p_tenant_id, p_caller, p_key, p_method, p_request_hash, p_expires_at)
+    ON CONFLICT (tenant_id, caller, idempotency_key, method) DO NOTHING;
+
+    IF FOUND THEN
+        RETURN QUERY SELECT TRUE, p_request_hash, NULL::BYTEA;
+        RETURN;
+    END IF;
+
+    -- The key is already held, hand back what it holds so the caller can replay or reject
+    RETURN QUERY
+    SELECT
+        FALSE,
+        idempotency_keys.request_hash::TEXT,
+        idempotency_keys.response
+    FROM idempotency_keys
+    WHERE idempotency_keys.tenant_id = p_tenant_id
+      AND idempotency_keys.caller = p_caller
+      AND idempotency_keys.idempotency_key = p_key
+      AND idempotency_keys.method = p_method;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS complete_idempotency_key(TEXT, TEXT, TEXT, BYTEA, TIMESTAMPTZ);
+
+CREATE PROCEDURE complete_idempotency_key(
+    p_tenant_id TEXT,
+    p_caller TEXT,
+    p_key TEXT,
+    p_method TEXT,
+    p_response BYTEA,
+    p_expires_at TIMESTAMPTZ
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_caller IS NULL OR p_key IS NULL OR p_method IS NULL OR p_response IS NULL OR p_expires_at IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, caller, key, method, response and expiry are required.';
+    END IF;
+
+    UPDATE idempotency_keys
+    SET response = p_response,
+        expires_at = p_expires_at
+    WHERE tenant_id = p_tenant_id
+      AND caller = p_caller
+      AND idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
+
+DROP PROCEDURE IF EXISTS release_idempotency_key(TEXT, TEXT, TEXT);
+
+CREATE PROCEDURE release_idempotency_key(
+    p_tenant_id TEXT,
+    p_caller TEXT,
+    p_key TEXT,
+    p_method TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate required inputs
+    IF p_tenant_id IS NULL OR p_caller IS NULL OR p_key IS NULL OR p_method IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant, caller, key and method are required.';
+    END IF;
+
+    -- Only an unfinished reservation is released, a stored response is kept until it expires
+    DELETE FROM idempotency_keys
+    WHERE tenant_id = p_tenant_id
+      AND caller = p_caller
+      AND idempotency_key = p_key
+      AND method = p_method
+      AND response IS NULL;
+END;
+$$;
@@ -0,0 +1,73 @@
+DROP FUNCTION IF EXISTS export_users(TEXT, UUID, TEXT, TEXT, TEXT, TEXT, TEXT, TIMESTAMP, UUID, INT);
+
+CREATE FUNCTION export_users(
+    p_tenant_id TEXT,
+    p_id UUID DEFAULT NULL,
+    p_country TEXT DEFAULT NULL,
+    p_email TEXT DEFAULT NULL,
+    p_first_name TEXT DEFAULT NULL,
+    p_last_name TEXT DEFAULT NULL,
+    p_nick_name TEXT DEFAULT NULL,
+    p_after_created_at TIMESTAMP DEFAULT NULL,
+    p_after_id UUID DEFAULT NULL,
+    p_limit INT DEFAULT 1000,
+    p_email_verified BOOLEAN DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP,
+    email_verified_at TIMESTAMP,
+    pending_email TEXT,
+    deleted_at TIMESTAMP,
+    version BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_tenant_id IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: tenant is required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- Same filtering as get_users, but walks the table in (created_at, id) order
+    -- so callers can page through it with a keyset instead of an offset. Deleted users are never exported
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP,
+        users.email_verified_at::TIMESTAMP,
+        users.pending_email::TEXT,
+        users.deleted_at::TIMESTAMP,
+        users.version::BIGINT
+    FROM users
+    WHERE
+        users.tenant_id = p_tenant_id AND
+        (p_id IS NULL OR users.id = p_id)  AND
+        (p_country IS NULL OR users.country = p_country) AND
+        (p_email IS NULL OR users.email = p_email) AND
+        (p_first_name IS NULL OR users.first_name ILIKE '%' || p_first_name || '%') AND
+        (p_last_name IS NULL OR users.last_name ILIKE '%' || p_last_name || '%') AND
+        (p_nick_name IS NULL OR users.nick_name ILIKE '%' || p_nick_name || '%') AND
+        (p_after_created_at IS NULL OR (users.created_at, users.id) > (p_after_created_at, p_after_id)) AND
+        (p_email_verified IS NULL OR (users.email_verified_at IS NOT NULL) = p_email_verified) AND
+        users.deleted_at IS NULL
+    ORDER BY users.created_at ASC, users.id ASC
+    LIMIT p_limit;
+END;
+$$;
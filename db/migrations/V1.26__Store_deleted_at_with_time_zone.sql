@@ -0,0 +1,44 @@
+-- deleted_at was written as CURRENT_TIMESTAMP in the session's time zone while the purge cutoff is
+-- the service's clock, comparing the two without a zone purged users early or late whenever the
+-- database and the service disagreed on it. Existing values are read in the session's zone, the
+-- one they were written in
+ALTER TABLE users ALTER COLUMN deleted_at TYPE TIMESTAMPTZ USING deleted_at::TIMESTAMPTZ;
+
+DROP FUNCTION IF EXISTS purge_deleted_users(TIMESTAMP, INT);
+
+CREATE FUNCTION purge_deleted_users(
+    p_deleted_before TIMESTAMPTZ,
+    p_limit INT DEFAULT 100
+)
+RETURNS TABLE (
+    id UUID,
+    tenant_id TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate inputs
+    IF p_deleted_before IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: deleted before is required.';
+    END IF;
+
+    IF p_limit < 1 THEN
+        RAISE EXCEPTION 'Invalid input: limit must be >= 1.';
+    END IF;
+
+    -- The retention window applies to every tenant, so this is the one write that isn't scoped to one.
+    -- Purges at most p_limit users so a large backlog is worked through in short transactions,
+    -- SKIP LOCKED lets several instances purge at once without waiting on each other
+    RETURN QUERY
+    DELETE FROM users
+    WHERE users.id IN (
+        SELECT expired.id
+        FROM users AS expired
+        WHERE expired.deleted_at < p_deleted_before
+        ORDER BY expired.deleted_at
+        LIMIT p_limit
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING users.id::UUID, users.tenant_id::TEXT;
+END;
+$$;
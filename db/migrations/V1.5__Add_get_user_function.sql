@@ -0,0 +1,40 @@
+CREATE OR REPLACE FUNCTION get_user(
+    p_id UUID DEFAULT NULL,
+    p_email TEXT DEFAULT NULL
+)
+RETURNS TABLE (
+    id UUID,
+    first_name TEXT,
+    last_name TEXT,
+    nick_name TEXT,
+    email TEXT,
+    country TEXT,
+    created_at TIMESTAMP,
+    updated_at TIMESTAMP
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+    -- Validate lookup inputs
+    IF p_id IS NULL AND p_email IS NULL THEN
+        RAISE EXCEPTION 'Invalid input: id or email is required.';
+    END IF;
+
+    -- Exact matching only, a single user is expected
+    RETURN QUERY
+    SELECT
+        users.id::UUID,
+        users.first_name::TEXT,
+        users.last_name::TEXT,
+        users.nick_name::TEXT,
+        users.email::TEXT,
+        users.country::TEXT,
+        users.created_at::TIMESTAMP,
+        users.updated_at::TIMESTAMP
+    FROM users
+    WHERE
+        (p_id IS NULL OR users.id = p_id) AND
+        (p_email IS NULL OR users.email = p_email)
+    LIMIT 1;
+END;
+$$;
@@ -0,0 +1,50 @@
+DROP FUNCTION IF EXISTS create_users;
+
+CREATE FUNCTION create_users(
+    p_first_names TEXT[],
+    p_last_names TEXT[],
+    p_nick_names TEXT[],
+    p_passwords TEXT[],
+    p_emails TEXT[],
+    p_countries TEXT[]
+)
+RETURNS TABLE (
+    id UUID,
+    email TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+#variable_conflict use_column
+BEGIN
+    -- Validate every column has been supplied for every user
+    IF cardinality(p_first_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_last_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_nick_names) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_passwords) IS DISTINCT FROM cardinality(p_emails) OR
+       cardinality(p_countries) IS DISTINCT FROM cardinality(p_emails) THEN
+        RAISE EXCEPTION 'Invalid input: all user fields must have the same number of entries.';
+    END IF;
+
+    -- Insert the whole batch at once, rows with an email that already exists are
+    -- skipped rather than failing the batch and are missing from the returned set
+    RETURN QUERY
+    INSERT INTO users (
+        first_name,
+        last_name,
+        nick_name,
+        password,
+        email,
+        country
+    )
+    SELECT * FROM unnest(
+        p_first_names,
+        p_last_names,
+        p_nick_names,
+        p_passwords,
+        p_emails,
+        p_countries
+    )
+    ON CONFLICT ON CONSTRAINT user_email_unique DO NOTHING
+    RETURNING users.id::UUID, users.email::TEXT;
+END;
+$$;
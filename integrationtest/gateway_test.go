@@ -0,0 +1,85 @@
+package integrationtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const gatewayURL = "http://localhost:8080"
+
+func TestGatewayIntegration_CreateModifyAndDeleteUser(t *testing.T) {
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := http.Post(gatewayURL+"/v1/users", "application/json", strings.NewReader(`{
+		"firstName": "Jane",
+		"lastName": "Doe",
+		"nickname": "janedoe",
+		"email": "jane.doe@example.com",
+		"password": "password123",
+		"country": "US"
+	}`))
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var created struct {
+		ID string `json:"id"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	assert.NotEmpty(t, created.ID)
+
+	// A duplicate email is a conflict
+	resp2, err := http.Post(gatewayURL+"/v1/users", "application/json", strings.NewReader(`{
+		"firstName": "Jane",
+		"lastName": "Doe",
+		"nickname": "janedoe",
+		"email": "jane.doe@example.com",
+		"password": "password123",
+		"country": "US"
+	}`))
+	assert.NoError(t, err)
+	resp2.Body.Close()
+	assert.Equal(t, http.StatusConflict, resp2.StatusCode)
+
+	req, err := http.NewRequest(http.MethodPatch, gatewayURL+"/v1/users/"+created.ID, strings.NewReader(`{"country": "UK"}`))
+	assert.NoError(t, err)
+	resp3, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp3.Body.Close()
+	assert.Equal(t, http.StatusOK, resp3.StatusCode)
+
+	resp4, err := http.Get(gatewayURL + "/v1/users?page=1&page_size=10&filter_country=UK")
+	assert.NoError(t, err)
+	defer resp4.Body.Close()
+	assert.Equal(t, http.StatusOK, resp4.StatusCode)
+
+	var list struct {
+		Users []struct {
+			ID string `json:"id"`
+		} `json:"users"`
+		TotalCount int `json:"totalCount"`
+	}
+	assert.NoError(t, json.NewDecoder(resp4.Body).Decode(&list))
+	assert.Equal(t, 1, list.TotalCount)
+	assert.Equal(t, created.ID, list.Users[0].ID)
+
+	req, err = http.NewRequest(http.MethodDelete, gatewayURL+"/v1/users/"+created.ID, nil)
+	assert.NoError(t, err)
+	resp5, err := http.DefaultClient.Do(req)
+	assert.NoError(t, err)
+	resp5.Body.Close()
+	assert.Equal(t, http.StatusOK, resp5.StatusCode)
+
+	count, err := d.GetUserCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
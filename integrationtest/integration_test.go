@@ -2,14 +2,35 @@ package integrationtest
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"log"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/EFG/api"
+	"github.com/EFG/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
+// adminContext calls as an admin API key of the tenant, anonymous callers can't hard delete, purge or
+// manage roles and keys.
+func adminContext(t *testing.T, d Datasource, tenantID string) context.Context {
+	key := "umsk_integration-admin-" + tenantID
+	err := d.EnsureAPIKey(tenantID, "integration-admin", key, []string{"users.read", "users.write", "users.delete", "users.admin"})
+	assert.NoError(t, err)
+
+	return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+}
+
 func TestCreateUserIntegration_HappyPath(t *testing.T) {
 	client, conn, err := setupGRPCClient("localhost:9000")
 	assert.NoError(t, err, "failed to set up gRPC client")
@@ -89,6 +110,7 @@ func TestCreateUserIntegration_ErrorCreatingUserForExistingEmail(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
 	assert.Contains(t, err.Error(), "email already exists: jane.doe@example.com")
 }
 
@@ -140,7 +162,9 @@ func TestModifyUserIntegration_HappyPath(t *testing.T) {
 
 	assert.Equal(t, req2.FirstName, userAfterChange.FirstName.String)
 	assert.Equal(t, req2.LastName, userAfterChange.LastName.String)
-	assert.Equal(t, req2.Email, userAfterChange.Email.String)
+	// a changed email waits to be confirmed before replacing the current one
+	assert.Equal(t, req.Email, userAfterChange.Email.String)
+	assert.Equal(t, req2.Email, resp2.User.PendingEmail)
 	assert.Equal(t, req2.Country, userAfterChange.Country.String)
 	assert.Equal(t, req2.Nickname, userAfterChange.Nickname.String)
 	assert.Equal(t, userBeforeChange.Password.String, userAfterChange.Password.String)
@@ -174,6 +198,7 @@ func TestModifyUserIntegration_ErrorModifyingUserThatDoeNotExist(t *testing.T) {
 	assert.Nil(t, resp2)
 
 	expectedError := fmt.Sprintf("User with id %s not found.", fakeId)
+	assert.Equal(t, codes.NotFound, status.Code(err))
 	assert.Contains(t, err.Error(), expectedError)
 }
 
@@ -248,6 +273,7 @@ func TestDeleteUserIntegration_ErrorDeletingUserThatDoeNotExist(t *testing.T) {
 	assert.Nil(t, resp2)
 
 	expectedError := fmt.Sprintf("User with id %s not found.", fakeId)
+	assert.Equal(t, codes.NotFound, status.Code(err))
 	assert.Contains(t, err.Error(), expectedError)
 }
 
@@ -334,6 +360,8 @@ func TestGetUsersIntegration_HappyPath(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, resp5.Users, 1)
 	assert.Equal(t, resp5.Users[0].FirstName, "John")
+	// Total count covers every matching user rather than just the page
+	assert.Equal(t, int32(3), resp5.TotalCount)
 
 	req6 := &api.GetUsersRequest{
 		Page:     3,
@@ -371,6 +399,7 @@ func TestGetUsersIntegration_ErrorGettingUsersWithInvalidPage(t *testing.T) {
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	assert.Contains(t, err.Error(), "page must be >= 1")
 }
 
@@ -388,10 +417,11 @@ func TestGetUsersIntegration_ErrorGettingUsersWithInvalidPageSize(t *testing.T)
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
 	assert.Contains(t, err.Error(), "page_size must be >= 1")
 }
 
-func TestGetUsersIntegration_ErrorGettingUsersWithNoMatchingFilter(t *testing.T) {
+func TestGetUsersIntegration_EmptyResultForNoMatchingFilter(t *testing.T) {
 	client, conn, err := setupGRPCClient("localhost:9000")
 	assert.NoError(t, err, "failed to set up gRPC client")
 	defer conn.Close()
@@ -408,8 +438,886 @@ func TestGetUsersIntegration_ErrorGettingUsersWithNoMatchingFilter(t *testing.T)
 	}
 
 	resp, err := client.GetUsers(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 0)
+	assert.Equal(t, int32(0), resp.TotalCount)
+}
+
+func TestGetUserIntegration_HappyPath(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	req := &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	}
+
+	resp, err := client.CreateUser(context.Background(), req)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, resp.Id, "response ID should not be empty")
+
+	resp2, err := client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Id, resp2.User.Id)
+	assert.Equal(t, req.Email, resp2.User.Email)
+
+	resp3, err := client.GetUser(context.Background(), &api.GetUserRequest{Email: req.Email})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Id, resp3.User.Id)
+	assert.Equal(t, req.FirstName, resp3.User.FirstName)
+}
+
+func TestGetUserIntegration_ErrorGettingUserThatDoesNotExist(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.GetUser(context.Background(), &api.GetUserRequest{Id: "00000000-0000-0000-0000-000000000000"})
 	assert.Error(t, err)
 	assert.Nil(t, resp)
 
-	assert.Contains(t, err.Error(), "no users found for supplied filters")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestImportUsersIntegration_HappyPath(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	stream, err := client.ImportUsers(context.Background())
+	assert.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		err = stream.Send(&api.CreateUserRequest{
+			FirstName: "Import",
+			LastName:  "User",
+			Email:     fmt.Sprintf("importuser%d@example.com", i),
+			Password:  "password123",
+			Country:   "US",
+			Nickname:  "importuser",
+		})
+		assert.NoError(t, err)
+	}
+
+	// duplicate email and missing fields should fail without aborting the import
+	err = stream.Send(&api.CreateUserRequest{
+		FirstName: "Import",
+		LastName:  "User",
+		Email:     "importuser0@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "importuser",
+	})
+	assert.NoError(t, err)
+
+	err = stream.Send(&api.CreateUserRequest{Email: "incomplete@example.com"})
+	assert.NoError(t, err)
+
+	resp, err := stream.CloseAndRecv()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(10), resp.CreatedCount)
+	assert.Equal(t, int32(2), resp.FailedCount)
+	assert.Contains(t, resp.Results[10].Error, "email already exists: importuser0@example.com")
+
+	count, err := d.GetUserCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 10, count)
+}
+
+func TestExportUsersIntegration_HappyPath(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		country := "US"
+		if i%2 == 0 {
+			country = "UK"
+		}
+		_, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+			FirstName: "Export",
+			LastName:  "User",
+			Email:     fmt.Sprintf("exportuser%d@example.com", i),
+			Password:  "password123",
+			Country:   country,
+			Nickname:  "exportuser",
+		})
+		assert.NoError(t, err)
+	}
+
+	stream, err := client.ExportUsers(context.Background(), &api.ExportUsersRequest{FilterCountry: "UK"})
+	assert.NoError(t, err)
+
+	var exported []*api.User
+	for {
+		user, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		exported = append(exported, user)
+	}
+
+	// Order for export is done by created_at asc
+	assert.Len(t, exported, 3)
+	assert.Equal(t, "exportuser0@example.com", exported[0].Email)
+	assert.Equal(t, "exportuser4@example.com", exported[2].Email)
+}
+
+func TestGetUsersIntegration_KeysetPagination(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		_, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+			FirstName: "Page",
+			LastName:  "User",
+			Email:     fmt.Sprintf("pageuser%d@example.com", i),
+			Password:  "password123",
+			Country:   "US",
+			Nickname:  "pageuser",
+		})
+		assert.NoError(t, err)
+	}
+
+	seen := map[string]bool{}
+	pageToken := ""
+	pages := 0
+	for {
+		resp, err := client.GetUsers(context.Background(), &api.GetUsersRequest{
+			PageSize:  2,
+			PageToken: pageToken,
+		})
+		assert.NoError(t, err)
+		pages++
+
+		for _, u := range resp.Users {
+			assert.False(t, seen[u.Id], "user should only be returned once")
+			seen[u.Id] = true
+		}
+
+		if resp.NextPageToken == "" {
+			break
+		}
+		pageToken = resp.NextPageToken
+	}
+
+	assert.Len(t, seen, 5)
+	assert.Equal(t, 3, pages)
+}
+
+func TestModifyUserIntegration_UpdateMaskClearsField(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	resp2, err := client.ModifyUser(context.Background(), &api.ModifyUserRequest{
+		Id:         resp.Id,
+		Nickname:   "",
+		Country:    "UK",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname", "country"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "", resp2.User.Nickname)
+	assert.Equal(t, "UK", resp2.User.Country)
+	assert.Equal(t, "Jane", resp2.User.FirstName)
+
+	userAfterChange, err := d.GetUserById(resp.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", userAfterChange.Nickname.String)
+	assert.Equal(t, "UK", userAfterChange.Country.String)
+	assert.Equal(t, "jane.doe@example.com", userAfterChange.Email.String)
+}
+
+func TestGetUsersIntegration_OrderBy(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	for _, u := range []struct{ first, last, country string }{
+		{"Ann", "Baker", "US"},
+		{"Cid", "Adams", "UK"},
+		{"Bea", "Adams", "US"},
+	} {
+		_, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+			FirstName: u.first,
+			LastName:  u.last,
+			Email:     strings.ToLower(u.first) + "@example.com",
+			Password:  "password123",
+			Country:   u.country,
+			Nickname:  u.first,
+		})
+		assert.NoError(t, err)
+	}
+
+	resp, err := client.GetUsers(context.Background(), &api.GetUsersRequest{
+		Page:     1,
+		PageSize: 10,
+		OrderBy:  "last_name asc, first_name desc",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 3)
+	assert.Equal(t, "Cid", resp.Users[0].FirstName)
+	assert.Equal(t, "Bea", resp.Users[1].FirstName)
+	assert.Equal(t, "Ann", resp.Users[2].FirstName)
+
+	_, err = client.GetUsers(context.Background(), &api.GetUsersRequest{Page: 1, PageSize: 10, OrderBy: "password"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestAuthenticateUserIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	authResp, err := client.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{
+		Email:    "jane.doe@example.com",
+		Password: "password123",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Id, authResp.User.Id)
+
+	_, err = client.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{
+		Email:    "jane.doe@example.com",
+		Password: "wrong-password",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	_, err = client.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{
+		Email:    "nobody@example.com",
+		Password: "password123",
+	})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestPasswordResetIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	_, err = client.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "jane.doe@example.com"})
+	assert.NoError(t, err)
+	_, err = client.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "nobody@example.com"})
+	assert.NoError(t, err)
+
+	count, err := d.GetPasswordResetTokenCount(resp.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	hashToken := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	assert.NoError(t, d.InsertPasswordResetToken(resp.Id, hashToken("valid-token"), time.Now().Add(time.Hour)))
+	assert.NoError(t, d.InsertPasswordResetToken(resp.Id, hashToken("expired-token"), time.Now().Add(-time.Minute)))
+
+	_, err = client.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: "expired-token", NewPassword: "newpassword456"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: "valid-token", NewPassword: "newpassword456"})
+	assert.NoError(t, err)
+
+	_, err = client.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: "valid-token", NewPassword: "anotherpassword"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = client.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "jane.doe@example.com", Password: "newpassword456"})
+	assert.NoError(t, err)
+	_, err = client.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "jane.doe@example.com", Password: "password123"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
+
+func TestEmailVerificationIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	user, err := client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.False(t, user.User.EmailVerified)
+
+	_, err = client.SendEmailVerification(context.Background(), &api.SendEmailVerificationRequest{Id: resp.Id})
+	assert.NoError(t, err)
+
+	hashToken := func(token string) string {
+		sum := sha256.Sum256([]byte(token))
+		return hex.EncodeToString(sum[:])
+	}
+	assert.NoError(t, d.InsertEmailVerificationToken(resp.Id, "jane.doe@example.com", hashToken("current-email-token"), time.Now().Add(time.Hour)))
+
+	_, err = client.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: "current-email-token"})
+	assert.NoError(t, err)
+
+	user, err = client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.True(t, user.User.EmailVerified)
+
+	_, err = client.SendEmailVerification(context.Background(), &api.SendEmailVerificationRequest{Id: resp.Id})
+	assert.Equal(t, codes.FailedPrecondition, status.Code(err))
+
+	verified, err := client.GetUsers(context.Background(), &api.GetUsersRequest{FilterEmailVerified: utils.Ptr(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), verified.TotalCount)
+	unverified, err := client.GetUsers(context.Background(), &api.GetUsersRequest{FilterEmailVerified: utils.Ptr(false)})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), unverified.TotalCount)
+
+	// changing the email leaves the verified one in place until the new one is confirmed
+	modified, err := client.ModifyUser(context.Background(), &api.ModifyUserRequest{Id: resp.Id, Email: "jane@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, "jane.doe@example.com", modified.User.Email)
+	assert.Equal(t, "jane@example.com", modified.User.PendingEmail)
+	assert.True(t, modified.User.EmailVerified)
+
+	assert.NoError(t, d.InsertEmailVerificationToken(resp.Id, "jane@example.com", hashToken("pending-email-token"), time.Now().Add(time.Hour)))
+
+	_, err = client.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: "pending-email-token"})
+	assert.NoError(t, err)
+
+	user, err = client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, "jane@example.com", user.User.Email)
+	assert.Empty(t, user.User.PendingEmail)
+	assert.True(t, user.User.EmailVerified)
+
+	_, err = client.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: "pending-email-token"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestSoftDeleteIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	_, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+
+	deletedCount, err := d.GetDeletedUserCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, deletedCount)
+
+	_, err = client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	users, err := client.GetUsers(context.Background(), &api.GetUsersRequest{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(0), users.TotalCount)
+
+	users, err = client.GetUsers(context.Background(), &api.GetUsersRequest{IncludeDeleted: true})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), users.TotalCount)
+	assert.NotEmpty(t, users.Users[0].DeletedAt)
+
+	restored, err := client.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Id, restored.User.Id)
+	assert.Empty(t, restored.User.DeletedAt)
+
+	_, err = client.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: resp.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// the email is free again once the user is deleted, which then blocks restoring them
+	_, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	_, err = client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+	_, err = client.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: resp.Id})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	_, err = client.PurgeUser(context.Background(), &api.PurgeUserRequest{Id: resp.Id})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.PurgeUser(adminContext(t, d, "default"), &api.PurgeUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+
+	deletedCount, err = d.GetDeletedUserCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, deletedCount)
+}
+
+func TestETagIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	user, err := client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	readETag := user.User.Etag
+	assert.NotEmpty(t, readETag)
+
+	// the first admin's write lands and moves the etag on
+	modified, err := client.ModifyUser(context.Background(), &api.ModifyUserRequest{Id: resp.Id, Nickname: "jane", Etag: readETag})
+	assert.NoError(t, err)
+	assert.NotEqual(t, readETag, modified.User.Etag)
+
+	// the second admin read the same version, so their writes are rejected rather than overwriting it
+	_, err = client.ModifyUser(context.Background(), &api.ModifyUserRequest{Id: resp.Id, Nickname: "jd", Etag: readETag})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	_, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: resp.Id, Etag: readETag})
+	assert.Equal(t, codes.Aborted, status.Code(err))
+
+	user, err = client.GetUser(context.Background(), &api.GetUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Equal(t, "jane", user.User.Nickname)
+
+	_, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: resp.Id, Etag: user.User.Etag})
+	assert.NoError(t, err)
+}
+
+func TestIdempotencyKeyIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	req := &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	}
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "idempotency-key", "create-jane")
+
+	resp, err := client.CreateUser(ctx, req)
+	assert.NoError(t, err)
+
+	// the retry gets the original response rather than an email already exists error
+	retry, err := client.CreateUser(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, resp.Id, retry.Id)
+
+	count, err := d.GetUserCount()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	// without the key the same request is run again
+	_, err = client.CreateUser(context.Background(), req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	req.Email = "jane@example.com"
+	_, err = client.CreateUser(ctx, req)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUserHistoryIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-request-id", "history-create")
+	var header metadata.MD
+	resp, err := client.CreateUser(ctx, &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	}, grpc.Header(&header))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"history-create"}, header.Get("x-request-id"))
+
+	_, err = client.ModifyUser(context.Background(), &api.ModifyUserRequest{Id: resp.Id, Nickname: "jane", Password: "newpassword123"})
+	assert.NoError(t, err)
+
+	// a modification that changes nothing isn't recorded
+	_, err = client.ModifyUser(context.Background(), &api.ModifyUserRequest{Id: resp.Id, Nickname: "jane"})
+	assert.NoError(t, err)
+
+	_, err = client.DeleteUser(adminContext(t, d, "default"), &api.DeleteUserRequest{Id: resp.Id, HardDelete: true})
+	assert.NoError(t, err)
+
+	// the history outlives the user being purged, but no longer says who it was about
+	history, err := client.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: resp.Id, PageSize: 2})
+	assert.NoError(t, err)
+	assert.Len(t, history.Entries, 2)
+	assert.Equal(t, "purge", history.Entries[0].Action)
+	for _, change := range history.Entries[0].Changes {
+		switch change.Field {
+		case "email", "nickname":
+			assert.Equal(t, "[REDACTED]", change.OldValue)
+		case "country":
+			assert.Equal(t, "US", change.OldValue)
+		}
+	}
+	assert.Equal(t, "modify", history.Entries[1].Action)
+	assert.NotEmpty(t, history.Entries[1].RequestId)
+	for _, change := range history.Entries[1].Changes {
+		switch change.Field {
+		case "nickname", "password":
+			assert.Equal(t, "[REDACTED]", change.OldValue)
+			assert.Equal(t, "[REDACTED]", change.NewValue)
+		}
+	}
+	assert.NotEmpty(t, history.NextPageToken)
+
+	history, err = client.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: resp.Id, PageSize: 2, PageToken: history.NextPageToken})
+	assert.NoError(t, err)
+	assert.Len(t, history.Entries, 1)
+	assert.Equal(t, "create", history.Entries[0].Action)
+	assert.Equal(t, "history-create", history.Entries[0].RequestId)
+	assert.Empty(t, history.NextPageToken)
+
+	// the audit log can't be rewritten
+	err = d.DeleteUserAudit(resp.Id)
+	assert.ErrorContains(t, err, "user_audit is append-only")
+}
+
+func TestTenantIsolationIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	brandA := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "brand-a")
+	brandB := metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "brand-b")
+
+	req := &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	}
+
+	// the same email can register once in every tenant
+	respA, err := client.CreateUser(brandA, req)
+	assert.NoError(t, err)
+	respB, err := client.CreateUser(brandB, req)
+	assert.NoError(t, err)
+	assert.NotEqual(t, respA.Id, respB.Id)
+
+	_, err = client.CreateUser(brandA, req)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	// neither tenant can see or change the other's user
+	_, err = client.GetUser(brandB, &api.GetUserRequest{Id: respA.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.DeleteUser(brandB, &api.DeleteUserRequest{Id: respA.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	users, err := client.GetUsers(brandA, &api.GetUsersRequest{Page: 1, PageSize: 10})
+	assert.NoError(t, err)
+	assert.Len(t, users.Users, 1)
+	assert.Equal(t, respA.Id, users.Users[0].Id)
+
+	// calls naming no tenant belong to the default tenant, which has no users
+	users, err = client.GetUsers(context.Background(), &api.GetUsersRequest{Page: 1, PageSize: 10})
+	assert.NoError(t, err)
+	assert.Empty(t, users.Users)
+
+	_, err = client.GetUser(metadata.AppendToOutgoingContext(context.Background(), "x-tenant-id", "Brand A"), &api.GetUserRequest{Id: respA.Id})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestRolesIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetUserStore()
+	assert.NoError(t, err)
+
+	resp, err := client.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "Jane",
+		LastName:  "Doe",
+		Email:     "jane.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "janedoe",
+	})
+	assert.NoError(t, err)
+
+	admin := adminContext(t, d, "default")
+
+	_, err = client.AssignRole(context.Background(), &api.AssignRoleRequest{Id: resp.Id, Role: "admin"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	assigned, err := client.AssignRole(admin, &api.AssignRoleRequest{Id: resp.Id, Role: "editor"})
+	assert.NoError(t, err)
+	assert.Len(t, assigned.Roles, 1)
+
+	// assigning a role twice changes nothing
+	_, err = client.AssignRole(admin, &api.AssignRoleRequest{Id: resp.Id, Role: "editor"})
+	assert.NoError(t, err)
+	_, err = client.AssignRole(admin, &api.AssignRoleRequest{Id: resp.Id, Role: "admin"})
+	assert.NoError(t, err)
+
+	listed, err := client.ListRoles(admin, &api.ListRolesRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Len(t, listed.Roles, 2)
+	assert.Equal(t, "admin", listed.Roles[0].Name)
+	assert.Equal(t, "editor", listed.Roles[1].Name)
+
+	revoked, err := client.RevokeRole(admin, &api.RevokeRoleRequest{Id: resp.Id, Role: "admin"})
+	assert.NoError(t, err)
+	assert.Len(t, revoked.Roles, 1)
+	assert.Equal(t, "editor", revoked.Roles[0].Name)
+
+	_, err = client.AssignRole(admin, &api.AssignRoleRequest{Id: "123e4567-e89b-12d3-a456-426614174999", Role: "editor"})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// roles are held per tenant
+	listed, err = client.ListRoles(adminContext(t, d, "brand-a"), &api.ListRolesRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Empty(t, listed.Roles)
+
+	// a deleted user holds no roles until restored
+	_, err = client.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	listed, err = client.ListRoles(admin, &api.ListRolesRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Empty(t, listed.Roles)
+
+	_, err = client.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	listed, err = client.ListRoles(admin, &api.ListRolesRequest{Id: resp.Id})
+	assert.NoError(t, err)
+	assert.Len(t, listed.Roles, 1)
+}
+
+func TestApiKeysIntegration(t *testing.T) {
+	client, conn, err := setupGRPCClient("localhost:9000")
+	assert.NoError(t, err, "failed to set up gRPC client")
+	defer conn.Close()
+
+	d, err := setupPostgresDatasource()
+	assert.NoError(t, err, "failed to connect to datasource")
+	defer d.Disconnect()
+
+	err = d.ResetAPIKeys()
+	assert.NoError(t, err)
+	admin := adminContext(t, d, "default")
+
+	_, err = client.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read"}})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	created, err := client.CreateApiKey(admin, &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read", "users.write"}})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, created.Key)
+	assert.Equal(t, []string{"users.read", "users.write"}, created.ApiKey.Scopes)
+
+	_, err = client.CreateApiKey(admin, &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read"}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	withKey := func(key string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "x-api-key", key)
+	}
+
+	_, err = client.GetUsers(withKey(created.Key), &api.GetUsersRequest{})
+	assert.NoError(t, err)
+	// the key is held to its scopes
+	_, err = client.ListApiKeys(withKey(created.Key), &api.ListApiKeysRequest{})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	listed, err := client.ListApiKeys(admin, &api.ListApiKeysRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, listed.ApiKeys, 2)
+
+	// the old key stops working as soon as it is rotated
+	rotated, err := client.RotateApiKey(admin, &api.RotateApiKeyRequest{Id: created.ApiKey.Id})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, rotated.ApiKey.RotatedAt)
+	_, err = client.GetUsers(withKey(created.Key), &api.GetUsersRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.GetUsers(withKey(rotated.Key), &api.GetUsersRequest{})
+	assert.NoError(t, err)
+
+	_, err = client.RevokeApiKey(admin, &api.RevokeApiKeyRequest{Id: created.ApiKey.Id})
+	assert.NoError(t, err)
+	_, err = client.GetUsers(withKey(rotated.Key), &api.GetUsersRequest{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the name of a revoked key can be used again
+	_, err = client.CreateApiKey(admin, &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read"}})
+	assert.NoError(t, err)
+
+	// keys are held per tenant
+	listed, err = client.ListApiKeys(adminContext(t, d, "brand-a"), &api.ListApiKeysRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, listed.ApiKeys, 1)
+	assert.Equal(t, "integration-admin", listed.ApiKeys[0].Name)
 }
@@ -1,11 +1,14 @@
 package integrationtest
 
 import (
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
+	"time"
 
 	"github.com/EFG/internal/datasource/dto"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 type PostgresClient struct {
@@ -32,8 +35,9 @@ func (p *PostgresClient) Disconnect() error {
 	return p.DB.Close()
 }
 
+// GetUserCount counts the live users, soft deleted users are left out.
 func (p *PostgresClient) GetUserCount() (int, error) {
-	query := "SELECT COUNT(*) FROM users"
+	query := "SELECT COUNT(*) FROM users WHERE deleted_at IS NULL"
 	row := p.DB.QueryRow(query)
 
 	var count int
@@ -45,6 +49,18 @@ func (p *PostgresClient) GetUserCount() (int, error) {
 	return count, nil
 }
 
+func (p *PostgresClient) GetDeletedUserCount() (int, error) {
+	row := p.DB.QueryRow("SELECT COUNT(*) FROM users WHERE deleted_at IS NOT NULL")
+
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted users: %w", err)
+	}
+
+	return count, nil
+}
+
 func (p *PostgresClient) GetUserById(id string) (dto.UserDTO, error) {
 	query := `SELECT id, first_name, last_name, email, password, country, nick_name, created_at, updated_at
               FROM users WHERE id = $1`
@@ -73,10 +89,77 @@ func (p *PostgresClient) GetUserById(id string) (dto.UserDTO, error) {
 }
 
 func (p *PostgresClient) ResetUserStore() error {
-	_, err := p.DB.Exec("TRUNCATE TABLE users")
+	// CASCADE also clears the tables referencing users such as password_reset_tokens
+	_, err := p.DB.Exec("TRUNCATE TABLE users CASCADE")
 	if err != nil {
 		return fmt.Errorf("failed to truncate users table: %w", err)
 	}
 
 	return nil
 }
+
+// ResetAPIKeys removes every API key, they don't belong to a user so ResetUserStore leaves them be.
+func (p *PostgresClient) ResetAPIKeys() error {
+	_, err := p.DB.Exec("TRUNCATE TABLE api_keys")
+	if err != nil {
+		return fmt.Errorf("failed to truncate api_keys table: %w", err)
+	}
+
+	return nil
+}
+
+// EnsureAPIKey stores the key for the tenant unless a key of that name is already active, it stands
+// in for the admin key a deployment stores directly in the database.
+func (p *PostgresClient) EnsureAPIKey(tenantID, name, key string, scopes []string) error {
+	sum := sha256.Sum256([]byte(key))
+	_, err := p.DB.Exec("INSERT INTO api_keys (tenant_id, name, key_hash, scopes) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING",
+		tenantID, name, hex.EncodeToString(sum[:]), pq.Array(scopes))
+	if err != nil {
+		return fmt.Errorf("failed to insert api key: %w", err)
+	}
+
+	return nil
+}
+
+func (p *PostgresClient) GetPasswordResetTokenCount(userID string) (int, error) {
+	row := p.DB.QueryRow("SELECT COUNT(*) FROM password_reset_tokens WHERE user_id = $1", userID)
+
+	var count int
+	err := row.Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count password reset tokens: %w", err)
+	}
+
+	return count, nil
+}
+
+// InsertPasswordResetToken stores a token hash directly, the tokens the service generates are only
+// ever sent to the user so tests can't otherwise know one.
+func (p *PostgresClient) InsertPasswordResetToken(userID, tokenHash string, expiresAt time.Time) error {
+	_, err := p.DB.Exec("INSERT INTO password_reset_tokens (token_hash, user_id, expires_at) VALUES ($1, $2, $3)", tokenHash, userID, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert password reset token: %w", err)
+	}
+
+	return nil
+}
+
+// InsertEmailVerificationToken stores a token hash for the email directly, as with InsertPasswordResetToken.
+func (p *PostgresClient) InsertEmailVerificationToken(userID, email, tokenHash string, expiresAt time.Time) error {
+	_, err := p.DB.Exec("INSERT INTO email_verification_tokens (token_hash, user_id, email, expires_at) VALUES ($1, $2, $3, $4)", tokenHash, userID, email, expiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert email verification token: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteUserAudit tries to remove the audit history of a user, which the append-only trigger refuses.
+func (p *PostgresClient) DeleteUserAudit(userID string) error {
+	_, err := p.DB.Exec("DELETE FROM user_audit WHERE user_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to delete user audit: %w", err)
+	}
+
+	return nil
+}
@@ -2,6 +2,7 @@ package integrationtest
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/datasource/dto"
@@ -12,7 +13,14 @@ import (
 type Datasource interface {
 	GetUserById(string) (dto.UserDTO, error)
 	GetUserCount() (int, error)
+	GetDeletedUserCount() (int, error)
 	ResetUserStore() error
+	ResetAPIKeys() error
+	EnsureAPIKey(string, string, string, []string) error
+	GetPasswordResetTokenCount(string) (int, error)
+	InsertPasswordResetToken(string, string, time.Time) error
+	InsertEmailVerificationToken(string, string, string, time.Time) error
+	DeleteUserAudit(string) error
 	Disconnect() error
 }
 
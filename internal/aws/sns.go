@@ -5,9 +5,13 @@ import (
 	"fmt"
 
 	"github.com/EFG/internal/env"
+	"github.com/EFG/internal/tracing"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	snspkg "github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/sns/types"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type SNS struct {
@@ -41,10 +45,25 @@ func NewSNSClient(ctx context.Context, cfg env.AWSConfig) (sns SNS, err error) {
 	return sns, nil
 }
 
-func (s *SNS) PublishMessage(message []byte, topicARN string) (string, error) {
-	output, err := s.client.Publish(s.ctx, &snspkg.PublishInput{
-		TopicArn: aws.String(topicARN),
-		Message:  aws.String(string(message)),
+// PublishMessage publishes message to the topic. The trace context of ctx is sent along as message
+// attributes, traceparent and tracestate, so consumers can continue the trace.
+func (s *SNS) PublishMessage(ctx context.Context, message []byte, topicARN string) (_ string, err error) {
+	ctx, end := tracing.Start(ctx, "aws.SNS.PublishMessage",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKey.String("aws_sns"),
+			semconv.MessagingOperationTypePublish,
+			semconv.MessagingDestinationName(topicARN),
+		))
+	defer end(&err)
+
+	attributes := messageAttributeCarrier{}
+	tracing.Propagator.Inject(ctx, attributes)
+
+	output, err := s.client.Publish(ctx, &snspkg.PublishInput{
+		TopicArn:          aws.String(topicARN),
+		Message:           aws.String(string(message)),
+		MessageAttributes: attributes,
 	})
 	if err != nil {
 		return "", fmt.Errorf("error publishing message to SNS: %w", err)
@@ -65,3 +84,28 @@ func (s *SNS) SubscribeToTopic(topicARN, protocol, endpoint string) (string, err
 
 	return *output.SubscriptionArn, nil
 }
+
+// messageAttributeCarrier lets trace context be written to and read from SNS message attributes.
+type messageAttributeCarrier map[string]types.MessageAttributeValue
+
+func (c messageAttributeCarrier) Get(key string) string {
+	if v, ok := c[key]; ok && v.StringValue != nil {
+		return *v.StringValue
+	}
+	return ""
+}
+
+func (c messageAttributeCarrier) Set(key, value string) {
+	c[key] = types.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(value),
+	}
+}
+
+func (c messageAttributeCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
@@ -0,0 +1,29 @@
+package aws
+
+import (
+	"context"
+	"testing"
+
+	"github.com/EFG/internal/tracing"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMessageAttributeCarrier(t *testing.T) {
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{0x4b, 0xf9, 0x2f, 0x35, 0x77, 0xb3, 0x4d, 0xa6, 0xa3, 0xce, 0x92, 0x9d, 0x0e, 0x0e, 0x47, 0x36},
+		SpanID:     trace.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7},
+		TraceFlags: trace.FlagsSampled,
+	})
+
+	attributes := messageAttributeCarrier{}
+	tracing.Propagator.Inject(trace.ContextWithSpanContext(context.Background(), spanContext), attributes)
+
+	assert.Equal(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", attributes.Get("traceparent"))
+	assert.Equal(t, "String", *attributes["traceparent"].DataType)
+
+	extracted := trace.SpanContextFromContext(tracing.Propagator.Extract(context.Background(), attributes))
+	assert.Equal(t, spanContext.TraceID(), extracted.TraceID())
+	assert.Equal(t, spanContext.SpanID(), extracted.SpanID())
+	assert.True(t, extracted.IsSampled())
+}
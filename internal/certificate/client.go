@@ -0,0 +1,34 @@
+package certificate
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// ClientConfig returns a TLS config for dialing serverName, whose certificate has to be issued by a
+// CA in caFile or by one of the system roots when caFile is empty. The certificate in certFile is
+// presented to servers that verify their clients, it is left out when certFile is empty.
+func ClientConfig(serverName, caFile, certFile, keyFile string) (*tls.Config, error) {
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: serverName,
+	}
+
+	if caFile != "" {
+		pool, err := LoadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+
+	if certFile != "" {
+		certificate, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", certFile, err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
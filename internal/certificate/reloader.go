@@ -0,0 +1,176 @@
+package certificate
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Reloader serves a certificate and key read from disk, along with the CA bundle client
+// certificates are verified against, and picks up rotated files without a restart.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	mu          sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	// loadedAt is the latest modification time of the files when they were last loaded
+	loadedAt time.Time
+}
+
+// NewReloader loads the certificate and key, and the client CA bundle when caFile is set.
+func NewReloader(certFile, keyFile, caFile string) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// Reload reads the files again, the previous certificate is kept if any of them fail to load.
+func (r *Reloader) Reload() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+
+	var clientCAs *x509.CertPool
+	if r.caFile != "" {
+		if clientCAs, err = LoadCertPool(r.caFile); err != nil {
+			return err
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.loadedAt = modTime
+
+	return nil
+}
+
+// Watch reloads the files whenever any of them has changed, checking every interval until ctx is
+// done. Certificate managers replace the files in place, so a reload that fails because only some
+// of them have been written yet is tried again on the next check.
+func (r *Reloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		modTime, err := r.latestModTime()
+		if err != nil {
+			slog.Warn("Failed to check certificates for changes", "error", err)
+			continue
+		}
+
+		r.mu.RLock()
+		changed := modTime.After(r.loadedAt)
+		r.mu.RUnlock()
+		if !changed {
+			continue
+		}
+
+		if err := r.Reload(); err != nil {
+			slog.Warn("Failed to reload certificates, keeping the previous ones", "error", err)
+			continue
+		}
+		slog.Info("Reloaded certificates", "certFile", r.certFile, "expiresAt", r.expiresAt())
+	}
+}
+
+// ServerConfig returns a TLS config serving the current certificate. When a client CA bundle is
+// loaded clients have to present a certificate issued by one of its CAs.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			r.mu.RLock()
+			defer r.mu.RUnlock()
+
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*r.certificate},
+				// the config returned here replaces the one gRPC set up, which negotiates HTTP/2
+				NextProtos: []string{"h2"},
+			}
+			if r.clientCAs != nil {
+				config.ClientCAs = r.clientCAs
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return config, nil
+		},
+	}
+}
+
+// GetClientCertificate presents the current certificate when dialing a server that asks for one.
+func (r *Reloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.certificate, nil
+}
+
+func (r *Reloader) expiresAt() time.Time {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.certificate.Leaf == nil {
+		return time.Time{}
+	}
+	return r.certificate.Leaf.NotAfter
+}
+
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, f := range []string{r.certFile, r.keyFile, r.caFile} {
+		if f == "" {
+			continue
+		}
+		info, err := os.Stat(f)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("failed to stat %s: %w", f, err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+
+	return latest, nil
+}
+
+// LoadCertPool reads a PEM bundle of CA certificates.
+func LoadCertPool(path string) (*x509.CertPool, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ca bundle %s: %w", path, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(raw) {
+		return nil, fmt.Errorf("ca bundle %s holds no PEM certificates", path)
+	}
+
+	return pool, nil
+}
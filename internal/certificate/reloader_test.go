@@ -0,0 +1,231 @@
+package certificate
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// authority is a CA generated for a test, which issues certificates written to the test's directory.
+type authority struct {
+	t       *testing.T
+	dir     string
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	serial  int64
+}
+
+func newAuthority(t *testing.T, name string) *authority {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	a := &authority{t: t, dir: t.TempDir(), cert: cert, key: key, certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), serial: 1}
+	require.NoError(t, os.WriteFile(a.caFile(), a.certPEM, 0o600))
+	return a
+}
+
+func (a *authority) caFile() string {
+	return filepath.Join(a.dir, a.cert.Subject.CommonName+"-ca.pem")
+}
+
+// issue writes a certificate for name usable by both servers and clients, returning its files.
+func (a *authority) issue(name string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(a.t, err)
+
+	a.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(a.serial),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, &key.PublicKey, a.key)
+	require.NoError(a.t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(a.t, err)
+
+	certFile = filepath.Join(a.dir, name+".pem")
+	keyFile = filepath.Join(a.dir, name+"-key.pem")
+	require.NoError(a.t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	require.NoError(a.t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return certFile, keyFile
+}
+
+// serve starts a gRPC server with only a health service over the reloader's TLS config.
+func serve(t *testing.T, r *Reloader) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	s := grpc.NewServer(grpc.Creds(credentials.NewTLS(r.ServerConfig())))
+	grpc_health_v1.RegisterHealthServer(s, health.NewServer())
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return lis.Addr().String()
+}
+
+// check calls the health service and returns the certificate the server presented.
+func check(t *testing.T, addr string, config *tls.Config) (*x509.Certificate, error) {
+	var presented *x509.Certificate
+	config.VerifyConnection = func(cs tls.ConnectionState) error {
+		presented = cs.PeerCertificates[0]
+		return nil
+	}
+
+	conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	return presented, err
+}
+
+func TestReloader_TLS(t *testing.T) {
+	ca := newAuthority(t, "servers")
+	certFile, keyFile := ca.issue("localhost")
+
+	r, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	addr := serve(t, r)
+
+	config, err := ClientConfig("localhost", ca.caFile(), "", "")
+	require.NoError(t, err)
+	presented, err := check(t, addr, config)
+	assert.NoError(t, err)
+	assert.Equal(t, "localhost", presented.Subject.CommonName)
+
+	// a client that doesn't trust the issuing CA refuses the server
+	config, err = ClientConfig("localhost", newAuthority(t, "other").caFile(), "", "")
+	require.NoError(t, err)
+	_, err = check(t, addr, config)
+	assert.Error(t, err)
+}
+
+func TestReloader_MutualTLS(t *testing.T) {
+	ca := newAuthority(t, "servers")
+	clients := newAuthority(t, "clients")
+	certFile, keyFile := ca.issue("localhost")
+
+	r, err := NewReloader(certFile, keyFile, clients.caFile())
+	require.NoError(t, err)
+	addr := serve(t, r)
+
+	clientCertFile, clientKeyFile := clients.issue("billing")
+	config, err := ClientConfig("localhost", ca.caFile(), clientCertFile, clientKeyFile)
+	require.NoError(t, err)
+	_, err = check(t, addr, config)
+	assert.NoError(t, err)
+
+	// without a certificate, or with one from another CA, the handshake fails
+	config, err = ClientConfig("localhost", ca.caFile(), "", "")
+	require.NoError(t, err)
+	_, err = check(t, addr, config)
+	assert.Error(t, err)
+
+	otherCertFile, otherKeyFile := newAuthority(t, "other").issue("billing")
+	config, err = ClientConfig("localhost", ca.caFile(), otherCertFile, otherKeyFile)
+	require.NoError(t, err)
+	_, err = check(t, addr, config)
+	assert.Error(t, err)
+}
+
+func TestReloader_Reload(t *testing.T) {
+	ca := newAuthority(t, "servers")
+	certFile, keyFile := ca.issue("localhost")
+
+	r, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+	addr := serve(t, r)
+
+	config, err := ClientConfig("localhost", ca.caFile(), "", "")
+	require.NoError(t, err)
+	before, err := check(t, addr, config)
+	require.NoError(t, err)
+
+	// issuing again overwrites the files, as a certificate manager rotating them would
+	ca.issue("localhost")
+	require.NoError(t, r.Reload())
+
+	after, err := check(t, addr, config)
+	require.NoError(t, err)
+	assert.NotEqual(t, before.SerialNumber, after.SerialNumber)
+
+	// a broken file leaves the previous certificate in place
+	require.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0o600))
+	assert.Error(t, r.Reload())
+	kept, err := check(t, addr, config)
+	require.NoError(t, err)
+	assert.Equal(t, after.SerialNumber, kept.SerialNumber)
+}
+
+func TestReloader_Watch(t *testing.T) {
+	ca := newAuthority(t, "servers")
+	certFile, keyFile := ca.issue("localhost")
+
+	r, err := NewReloader(certFile, keyFile, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.Watch(ctx, 10*time.Millisecond)
+
+	first, err := r.GetClientCertificate(nil)
+	require.NoError(t, err)
+
+	ca.issue("localhost")
+	// file systems with coarse timestamps could otherwise leave the rotation looking unchanged
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certFile, future, future))
+
+	assert.Eventually(t, func() bool {
+		current, _ := r.GetClientCertificate(nil)
+		return current.Leaf.SerialNumber.Cmp(first.Leaf.SerialNumber) != 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestLoadCertPool_NoCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(path, []byte("nothing here"), 0o600))
+
+	_, err := LoadCertPool(path)
+	assert.ErrorContains(t, err, "holds no PEM certificates")
+}
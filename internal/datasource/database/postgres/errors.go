@@ -0,0 +1,74 @@
+package postgres
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/EFG/internal/service"
+	"github.com/lib/pq"
+)
+
+const (
+	// emailUniqueConstraint is the unique constraint on users.email.
+	emailUniqueConstraint = "user_email_unique"
+
+	// apiKeyNameUniqueConstraint is the unique index on the names of a tenant's keys in use.
+	apiKeyNameUniqueConstraint = "api_key_name_unique"
+
+	// invalidInputPrefix starts the message of every exception our functions raise for bad arguments.
+	invalidInputPrefix = "Invalid input"
+
+	// versionMismatchPrefix starts the message raised when a write's expected version is stale.
+	versionMismatchPrefix = "Version mismatch"
+)
+
+// classifyError converts driver errors into the service's typed errors based on the pq error
+// code so callers can branch on the kind of failure, anything unrecognised is returned as is.
+func classifyError(err error) error {
+	if errors.Is(err, driver.ErrBadConn) {
+		return &service.UnavailableError{Err: err}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return &service.UnavailableError{Err: err}
+	}
+
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return err
+	}
+
+	switch {
+	case pqErr.Code.Name() == "unique_violation":
+		switch pqErr.Constraint {
+		case emailUniqueConstraint:
+			return service.ErrEmailAlreadyExists
+		case apiKeyNameUniqueConstraint:
+			return service.ErrAPIKeyNameAlreadyExists
+		}
+		return &service.AlreadyExistsError{Msg: pqErr.Message}
+	case pqErr.Code.Name() == "raise_exception":
+		// our functions raise with the default code, they either reject the input, report a
+		// stale version or report a missing user
+		if strings.HasPrefix(pqErr.Message, invalidInputPrefix) {
+			return service.NewInvalidArgumentError("", "%s", pqErr.Message)
+		}
+		if strings.HasPrefix(pqErr.Message, versionMismatchPrefix) {
+			return &service.AbortedError{Msg: pqErr.Message}
+		}
+		if strings.HasSuffix(pqErr.Message, "not found.") {
+			return &service.NotFoundError{Msg: pqErr.Message}
+		}
+	case pqErr.Code.Class() == "22", pqErr.Code.Class() == "23":
+		// data exceptions such as a malformed uuid and the remaining integrity constraint violations
+		return service.NewInvalidArgumentError(pqErr.Column, "%s", pqErr.Message)
+	case pqErr.Code.Class() == "08", pqErr.Code.Class() == "53", pqErr.Code.Class() == "57":
+		// connection exceptions, insufficient resources and operator intervention such as a shutdown
+		return &service.UnavailableError{Err: err}
+	}
+
+	return err
+}
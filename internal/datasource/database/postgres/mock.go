@@ -4,11 +4,13 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/EFG/internal/datasource/database"
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/service"
 	"github.com/EFG/internal/utils"
 )
 
@@ -17,37 +19,274 @@ type MockClient struct {
 	UUID                    string
 	WriteUserRowCallHistory dto.UsersDTO
 	TestRequiresError       bool
+	// TotalUsers overrides the total count returned by GetUsers when set
+	TotalUsers int
+	// GetUsersCallHistory records the args of every GetUsers call
+	GetUsersCallHistory []dto.GetUsersArgs
+	// ExportUsersCallHistory records the args of every ExportUsers call
+	ExportUsersCallHistory []dto.ExportUsersArgs
+	// PasswordResetTokens records every password reset token created
+	PasswordResetTokens []MockPasswordResetToken
+	// EmailVerificationTokens records every email verification token created
+	EmailVerificationTokens []MockEmailVerificationToken
+	// EmailAlreadyVerified makes CreateEmailVerificationToken report there is nothing left to verify
+	EmailAlreadyVerified bool
+	// PurgeUserCallHistory records the ID of every user purged by PurgeUser
+	PurgeUserCallHistory []string
+	// ExpiredUsers are handed out by PurgeDeletedUsers, at most limit per call
+	ExpiredUsers dto.UsersDTO
+	// PurgeDeletedUsersCallHistory records the cutoff of every PurgeDeletedUsers call
+	PurgeDeletedUsersCallHistory []time.Time
+	// Version is the version of the mock user, conditional writes expecting another version are aborted
+	Version int64
+	// IdempotencyKeys holds every reserved idempotency key by tenant, caller, method and key
+	IdempotencyKeys map[string]*MockIdempotencyKey
+	// UserHistory are the audit entries handed out by GetUserHistory, newest first
+	UserHistory []dto.UserAuditDTO
+	// GetUserHistoryCallHistory records the args of every GetUserHistory call
+	GetUserHistoryCallHistory []dto.GetUserHistoryArgs
+	// Roles holds the roles assigned to each user by user ID
+	Roles map[string][]string
+	// APIKeys holds every API key created, in the order they were created
+	APIKeys []dto.APIKeyDTO
+	// RejectedEmails fail any CreateUsers call writing one of them, as a constraint violation would
+	RejectedEmails []string
+	// CreateUsersError fails every CreateUsers call when set
+	CreateUsersError error
+	// CreateUsersCalls counts the CreateUsers calls made
+	CreateUsersCalls int
+}
+
+type MockPasswordResetToken struct {
+	TokenHash string
+	Email     string
+	ExpiresAt time.Time
+	Used      bool
 }
 
 func (m *MockClient) CreateUser(ctx context.Context, user dto.UserDTO) (string, error) {
 	if m.TestRequiresError {
 		return "", fmt.Errorf("mock db error for create user")
 	}
+	// the tenant is recorded so tests can check which tenant a user was created in
+	user.TenantID = utils.ToNullString(service.TenantFromContext(ctx))
 	m.UserWritten(user)
 	return m.UUID, nil
 }
 
-func (m *MockClient) ModifyUser(ctx context.Context, user dto.UserDTO) error {
+func (m *MockClient) CreateUsers(ctx context.Context, users dto.UsersDTO) (dto.UsersDTO, error) {
+	m.CreateUsersCalls++
+	if m.TestRequiresError {
+		return nil, fmt.Errorf("mock db error for create users")
+	}
+	if m.CreateUsersError != nil {
+		return nil, m.CreateUsersError
+	}
+
+	for _, user := range users {
+		if slices.Contains(m.RejectedEmails, user.Email.String) {
+			return nil, service.NewInvalidArgumentError("", "value too long for type character varying(100)")
+		}
+	}
+
+	var created dto.UsersDTO
+	for _, user := range users {
+		if m.emailWritten(user.Email.String) {
+			continue
+		}
+		m.UserWritten(user)
+		created = append(created, dto.UserDTO{
+			ID:    utils.ToNullString(fmt.Sprintf("%s-%d", m.UUID, len(m.WriteUserRowCallHistory))),
+			Email: user.Email,
+		})
+	}
+
+	return created, nil
+}
+
+func (m *MockClient) ModifyUser(ctx context.Context, user dto.UserDTO) (dto.UserDTO, error) {
 	if m.TestRequiresError {
-		return fmt.Errorf("mock db error for modify user")
+		return dto.UserDTO{}, fmt.Errorf("mock db error for modify user")
+	}
+	if err := m.checkVersion(user.ID.String, user.Version); err != nil {
+		return dto.UserDTO{}, err
 	}
 	m.UserWritten(user)
 
-	return nil
+	m.Version++
+	user.Version = sql.NullInt64{Int64: m.Version, Valid: true}
+
+	return user, nil
 }
 
-func (m *MockClient) DeleteUser(ctx context.Context, id string) error {
+func (m *MockClient) DeleteUser(ctx context.Context, id string, expectedVersion sql.NullInt64) error {
 	if m.TestRequiresError {
 		return fmt.Errorf("mock db error for delete user")
 	}
-	m.UserWritten(dto.UserDTO{ID: utils.ToNullString(id)})
+	if err := m.checkVersion(id, expectedVersion); err != nil {
+		return err
+	}
+	m.UserWritten(dto.UserDTO{ID: utils.ToNullString(id), Version: expectedVersion})
+	return nil
+}
+
+func (m *MockClient) checkVersion(id string, expectedVersion sql.NullInt64) error {
+	if expectedVersion.Valid && expectedVersion.Int64 != m.Version {
+		return &service.AbortedError{Msg: fmt.Sprintf("Version mismatch: user with id %s has been changed since version %d.", id, expectedVersion.Int64)}
+	}
+	return nil
+}
+
+type MockEmailVerificationToken struct {
+	TokenHash string
+	UserID    string
+	Email     string
+	ExpiresAt time.Time
+	Used      bool
+}
+
+// CreateEmailVerificationToken sends the token to the most recently written email of the user with the mock UUID.
+func (m *MockClient) CreateEmailVerificationToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (string, error) {
+	if m.TestRequiresError {
+		return "", fmt.Errorf("mock db error for create email verification token")
+	}
+	if userID != m.UUID {
+		return "", &service.NotFoundError{Msg: fmt.Sprintf("User with id %s not found.", userID)}
+	}
+	if m.EmailAlreadyVerified {
+		return "", service.ErrEmailAlreadyVerified
+	}
+
+	var email string
+	for _, u := range m.WriteUserRowCallHistory {
+		if u.Email.Valid {
+			email = u.Email.String
+		}
+	}
+
+	m.EmailVerificationTokens = append(m.EmailVerificationTokens, MockEmailVerificationToken{
+		TokenHash: tokenHash,
+		UserID:    userID,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	})
+	return email, nil
+}
+
+// ConfirmEmail writes the email the token was sent to as verified and spends every outstanding token for the user.
+func (m *MockClient) ConfirmEmail(ctx context.Context, tokenHash string, now time.Time) (string, error) {
+	if m.TestRequiresError {
+		return "", fmt.Errorf("mock db error for confirm email")
+	}
+
+	for _, t := range m.EmailVerificationTokens {
+		if t.TokenHash != tokenHash || t.Used || !t.ExpiresAt.After(now) {
+			continue
+		}
+		for i := range m.EmailVerificationTokens {
+			if m.EmailVerificationTokens[i].UserID == t.UserID {
+				m.EmailVerificationTokens[i].Used = true
+			}
+		}
+		m.UserWritten(dto.UserDTO{
+			ID:              utils.ToNullString(t.UserID),
+			Email:           utils.ToNullString(t.Email),
+			EmailVerifiedAt: utils.ToNullTime(now),
+		})
+		return t.UserID, nil
+	}
+
+	return "", service.ErrInvalidEmailVerificationToken
+}
+
+func (m *MockClient) CreatePasswordResetToken(ctx context.Context, email, tokenHash string, expiresAt time.Time) (string, error) {
+	if m.TestRequiresError {
+		return "", fmt.Errorf("mock db error for create password reset token")
+	}
+	if !m.emailWritten(email) {
+		return "", fmt.Errorf("%w for supplied email", service.ErrUserNotFound)
+	}
+
+	m.PasswordResetTokens = append(m.PasswordResetTokens, MockPasswordResetToken{
+		TokenHash: tokenHash,
+		Email:     email,
+		ExpiresAt: expiresAt,
+	})
+	return m.UUID, nil
+}
+
+// CompletePasswordReset writes the password against the email the token was created for and spends
+// every outstanding token for that email.
+func (m *MockClient) CompletePasswordReset(ctx context.Context, tokenHash, passwordHash string, now time.Time) (string, error) {
+	if m.TestRequiresError {
+		return "", fmt.Errorf("mock db error for complete password reset")
+	}
+
+	for _, t := range m.PasswordResetTokens {
+		if t.TokenHash != tokenHash || t.Used || !t.ExpiresAt.After(now) {
+			continue
+		}
+		for i := range m.PasswordResetTokens {
+			if m.PasswordResetTokens[i].Email == t.Email {
+				m.PasswordResetTokens[i].Used = true
+			}
+		}
+		m.UserWritten(dto.UserDTO{
+			ID:       utils.ToNullString(m.UUID),
+			Email:    utils.ToNullString(t.Email),
+			Password: utils.ToNullString(passwordHash),
+		})
+		return m.UUID, nil
+	}
+
+	return "", service.ErrInvalidPasswordResetToken
+}
+
+func (m *MockClient) RestoreUser(ctx context.Context, id string) (dto.UserDTO, error) {
+	if m.TestRequiresError {
+		return dto.UserDTO{}, fmt.Errorf("mock db error for restore user")
+	}
+	restored := dto.UserDTO{ID: utils.ToNullString(id)}
+	m.UserWritten(restored)
+
+	return restored, nil
+}
+
+func (m *MockClient) PurgeUser(ctx context.Context, id string) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for purge user")
+	}
+	m.PurgeUserCallHistory = append(m.PurgeUserCallHistory, id)
+
 	return nil
 }
 
+func (m *MockClient) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (dto.UsersDTO, error) {
+	if m.TestRequiresError {
+		return nil, fmt.Errorf("mock db error for purge deleted users")
+	}
+	m.PurgeDeletedUsersCallHistory = append(m.PurgeDeletedUsersCallHistory, deletedBefore)
+
+	n := min(limit, len(m.ExpiredUsers))
+	purged := m.ExpiredUsers[:n]
+	m.ExpiredUsers = m.ExpiredUsers[n:]
+
+	return purged, nil
+}
+
 func (d *MockClient) UserWritten(user dto.UserDTO) {
 	d.WriteUserRowCallHistory = append(d.WriteUserRowCallHistory, user)
 }
 
+func (d *MockClient) emailWritten(email string) bool {
+	for _, u := range d.WriteUserRowCallHistory {
+		if u.Email.String == email {
+			return true
+		}
+	}
+	return false
+}
+
 func (d *MockClient) Reset() {
 	d.WriteUserRowCallHistory = dto.UsersDTO{}
 }
@@ -58,9 +297,9 @@ func (d *MockClient) GetCallHistory() dto.UsersDTO {
 
 func mockSQLRowsGetUsersFromDataSource() *sql.Rows {
 	timestamp := time.Now()
-	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at"}).
-		AddRow("1", "John", "Doe", "johndoe", "john.doe@example.com", "US", timestamp, timestamp).
-		AddRow("2", "Jane", "Doe", "janedoe", "jane.doe@example.com", "US", timestamp, timestamp)
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at", "email_verified_at", "pending_email", "deleted_at", "version"}).
+		AddRow("1", "John", "Doe", "johndoe", "john.doe@example.com", "US", timestamp, timestamp, timestamp, nil, nil, 1).
+		AddRow("2", "Jane", "Doe", "janedoe", "jane.doe@example.com", "US", timestamp, timestamp, nil, "jane@example.com", nil, 3)
 	return database.MockRowsToSQLRows(rows)
 }
 
@@ -68,6 +307,7 @@ func (m *MockClient) GetUsers(ctx context.Context, user dto.GetUsersArgs) (dto.U
 	if m.TestRequiresError {
 		return nil, 0, fmt.Errorf("mock db error for get users")
 	}
+	m.GetUsersCallHistory = append(m.GetUsersCallHistory, user)
 	rows := mockSQLRowsGetUsersFromDataSource()
 
 	users, err := scanUsers(rows)
@@ -75,5 +315,255 @@ func (m *MockClient) GetUsers(ctx context.Context, user dto.GetUsersArgs) (dto.U
 		return nil, 0, fmt.Errorf("failed to scan users: %w", err)
 	}
 
+	if m.TotalUsers != 0 {
+		return users, m.TotalUsers, nil
+	}
+
 	return users, len(users), nil
 }
+
+func (m *MockClient) GetUser(ctx context.Context, args dto.GetUserArgs) (dto.UserDTO, error) {
+	if m.TestRequiresError {
+		return dto.UserDTO{}, fmt.Errorf("mock db error for get user")
+	}
+	rows := mockSQLRowsGetUsersFromDataSource()
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return dto.UserDTO{}, fmt.Errorf("failed to scan users: %w", err)
+	}
+
+	for _, u := range users {
+		if (!args.ID.Valid || u.ID.String == args.ID.String) && (!args.Email.Valid || u.Email.String == args.Email.String) {
+			return u, nil
+		}
+	}
+
+	return dto.UserDTO{}, fmt.Errorf("%w for supplied id or email", service.ErrUserNotFound)
+}
+
+// GetUserCredentials returns the most recent user written with the email, including the hashed password.
+func (m *MockClient) GetUserCredentials(ctx context.Context, email string) (dto.UserDTO, error) {
+	if m.TestRequiresError {
+		return dto.UserDTO{}, fmt.Errorf("mock db error for get user credentials")
+	}
+
+	for i := len(m.WriteUserRowCallHistory) - 1; i >= 0; i-- {
+		u := m.WriteUserRowCallHistory[i]
+		if u.Email.String == email {
+			u.ID = utils.ToNullString(m.UUID)
+			return u, nil
+		}
+	}
+
+	return dto.UserDTO{}, fmt.Errorf("%w for supplied email", service.ErrUserNotFound)
+}
+
+func (m *MockClient) ExportUsers(ctx context.Context, args dto.ExportUsersArgs, send func(dto.UserDTO) error) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for export users")
+	}
+	m.ExportUsersCallHistory = append(m.ExportUsersCallHistory, args)
+	rows := mockSQLRowsGetUsersFromDataSource()
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		return fmt.Errorf("failed to scan users: %w", err)
+	}
+
+	for _, u := range users {
+		if args.FilterCountry.Valid && u.Country.String != args.FilterCountry.String {
+			continue
+		}
+		if args.FilterEmailVerified.Valid && u.EmailVerifiedAt.Valid != args.FilterEmailVerified.Bool {
+			continue
+		}
+		if !args.IncludeDeleted && u.DeletedAt.Valid {
+			continue
+		}
+		if err := send(u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetUserHistory pages through UserHistory, skipping entries at or after the BeforeID cursor.
+func (m *MockClient) GetUserHistory(ctx context.Context, args dto.GetUserHistoryArgs) ([]dto.UserAuditDTO, error) {
+	m.GetUserHistoryCallHistory = append(m.GetUserHistoryCallHistory, args)
+	if m.TestRequiresError {
+		return nil, fmt.Errorf("mock db error for get user history")
+	}
+
+	var entries []dto.UserAuditDTO
+	for _, e := range m.UserHistory {
+		if e.UserID != args.UserID.String || (args.BeforeID.Valid && e.ID >= args.BeforeID.Int64) {
+			continue
+		}
+		if len(entries) == int(args.Limit) {
+			break
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+type MockIdempotencyKey struct {
+	RequestHash string
+	Response    []byte
+	ExpiresAt   time.Time
+}
+
+func mockIdempotencyKeyID(ctx context.Context, key, method string) string {
+	return service.TenantFromContext(ctx) + " " + service.CallerFromContext(ctx).Actor() + " " + method + " " + key
+}
+
+func (m *MockClient) ReserveIdempotencyKey(ctx context.Context, key, method, requestHash string, expiresAt, now time.Time) (dto.IdempotencyKeyDTO, error) {
+	if m.TestRequiresError {
+		return dto.IdempotencyKeyDTO{}, fmt.Errorf("mock db error for reserve idempotency key")
+	}
+	if m.IdempotencyKeys == nil {
+		m.IdempotencyKeys = make(map[string]*MockIdempotencyKey)
+	}
+
+	id := mockIdempotencyKeyID(ctx, key, method)
+	if stored, ok := m.IdempotencyKeys[id]; ok && stored.ExpiresAt.After(now) {
+		return dto.IdempotencyKeyDTO{RequestHash: stored.RequestHash, Response: stored.Response}, nil
+	}
+
+	m.IdempotencyKeys[id] = &MockIdempotencyKey{RequestHash: requestHash, ExpiresAt: expiresAt}
+	return dto.IdempotencyKeyDTO{Reserved: true, RequestHash: requestHash}, nil
+}
+
+func (m *MockClient) CompleteIdempotencyKey(ctx context.Context, key, method string, response []byte, expiresAt time.Time) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for complete idempotency key")
+	}
+	if stored, ok := m.IdempotencyKeys[mockIdempotencyKeyID(ctx, key, method)]; ok && stored.Response == nil {
+		stored.Response = response
+		stored.ExpiresAt = expiresAt
+	}
+	return nil
+}
+
+func (m *MockClient) ReleaseIdempotencyKey(ctx context.Context, key, method string) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for release idempotency key")
+	}
+	id := mockIdempotencyKeyID(ctx, key, method)
+	if stored, ok := m.IdempotencyKeys[id]; ok && stored.Response == nil {
+		delete(m.IdempotencyKeys, id)
+	}
+	return nil
+}
+
+func (m *MockClient) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error) {
+	if m.TestRequiresError {
+		return 0, fmt.Errorf("mock db error for delete expired idempotency keys")
+	}
+	deleted := 0
+	for id, stored := range m.IdempotencyKeys {
+		if !stored.ExpiresAt.After(now) {
+			delete(m.IdempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+func (m *MockClient) GetUserRoles(ctx context.Context, id string) ([]string, error) {
+	if m.TestRequiresError {
+		return nil, fmt.Errorf("mock db error for get user roles")
+	}
+	roles := slices.Clone(m.Roles[id])
+	slices.Sort(roles)
+	return roles, nil
+}
+
+func (m *MockClient) AssignRole(ctx context.Context, id, role string) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for assign role")
+	}
+	if m.Roles == nil {
+		m.Roles = make(map[string][]string)
+	}
+	if !slices.Contains(m.Roles[id], role) {
+		m.Roles[id] = append(m.Roles[id], role)
+	}
+	return nil
+}
+
+func (m *MockClient) RevokeRole(ctx context.Context, id, role string) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for revoke role")
+	}
+	m.Roles[id] = slices.DeleteFunc(m.Roles[id], func(r string) bool { return r == role })
+	return nil
+}
+
+func (m *MockClient) CreateAPIKey(ctx context.Context, key dto.APIKeyDTO) (dto.APIKeyDTO, error) {
+	if m.TestRequiresError {
+		return dto.APIKeyDTO{}, fmt.Errorf("mock db error for create api key")
+	}
+	for _, k := range m.APIKeys {
+		if k.Name == key.Name && !k.RevokedAt.Valid {
+			return dto.APIKeyDTO{}, service.ErrAPIKeyNameAlreadyExists
+		}
+	}
+
+	key.ID = fmt.Sprintf("%s-key-%d", m.UUID, len(m.APIKeys)+1)
+	key.TenantID = service.TenantFromContext(ctx)
+	key.CreatedBy = utils.ToNullString(service.AuditInfoFromContext(ctx).Actor)
+	key.CreatedAt = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.APIKeys = append(m.APIKeys, key)
+
+	return key, nil
+}
+
+func (m *MockClient) RotateAPIKey(ctx context.Context, id, keyHash string) (dto.APIKeyDTO, error) {
+	if m.TestRequiresError {
+		return dto.APIKeyDTO{}, fmt.Errorf("mock db error for rotate api key")
+	}
+	for i, k := range m.APIKeys {
+		if k.ID == id && !k.RevokedAt.Valid {
+			m.APIKeys[i].KeyHash = keyHash
+			m.APIKeys[i].RotatedAt = sql.NullTime{Time: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC), Valid: true}
+			return m.APIKeys[i], nil
+		}
+	}
+	return dto.APIKeyDTO{}, &service.NotFoundError{Msg: fmt.Sprintf("API key with id %s not found.", id)}
+}
+
+func (m *MockClient) RevokeAPIKey(ctx context.Context, id string) error {
+	if m.TestRequiresError {
+		return fmt.Errorf("mock db error for revoke api key")
+	}
+	for i, k := range m.APIKeys {
+		if k.ID == id && !k.RevokedAt.Valid {
+			m.APIKeys[i].RevokedAt = sql.NullTime{Time: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC), Valid: true}
+			return nil
+		}
+	}
+	return &service.NotFoundError{Msg: fmt.Sprintf("API key with id %s not found.", id)}
+}
+
+func (m *MockClient) ListAPIKeys(ctx context.Context) ([]dto.APIKeyDTO, error) {
+	if m.TestRequiresError {
+		return nil, fmt.Errorf("mock db error for list api keys")
+	}
+	return slices.Clone(m.APIKeys), nil
+}
+
+func (m *MockClient) GetAPIKeyByHash(ctx context.Context, keyHash string) (dto.APIKeyDTO, error) {
+	if m.TestRequiresError {
+		return dto.APIKeyDTO{}, fmt.Errorf("mock db error for get api key")
+	}
+	for _, k := range m.APIKeys {
+		if k.KeyHash == keyHash {
+			return k, nil
+		}
+	}
+	return dto.APIKeyDTO{}, service.ErrAPIKeyNotFound
+}
@@ -3,10 +3,13 @@ package postgres
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
 
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/service"
+	"github.com/lib/pq"
 
 	_ "embed"
 )
@@ -14,8 +17,24 @@ import (
 //go:embed scripts/postgres_get_users_function_call.sql
 var getUsersFunctionCall string
 
-func (d *Client) GetUsers(ctx context.Context, user dto.GetUsersArgs) (dto.UsersDTO, int, error) {
-	rows, err := d.DB.QueryContext(ctx, getUsersFunctionCall,
+//go:embed scripts/postgres_count_users_function_call.sql
+var countUsersFunctionCall string
+
+// GetUsers returns a page of users along with the total number of users matching the filters,
+// both are read in the same repeatable read transaction so the count agrees with the page.
+func (d *Client) GetUsers(ctx context.Context, user dto.GetUsersArgs) (_ dto.UsersDTO, _ int, err error) {
+	ctx, end := startSpan(ctx, "GetUsers")
+	defer end(&err)
+
+	tx, err := d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		slog.Error("failed to begin get users transaction", "error", err)
+		return nil, 0, fmt.Errorf("failed to begin get users transaction: %w", classifyError(err))
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, getUsersFunctionCall,
+		service.TenantFromContext(ctx),
 		user.FilterID,
 		user.FilterCountry,
 		user.FilterEmail,
@@ -24,24 +43,315 @@ func (d *Client) GetUsers(ctx context.Context, user dto.GetUsersArgs) (dto.Users
 		user.FilterNickname,
 		user.Page,
 		user.PageSize,
+		user.AfterCreatedAt,
+		user.AfterID,
+		pq.Array(user.OrderByFields),
+		pq.Array(user.OrderByDirections),
+		user.FilterEmailVerified,
+		user.IncludeDeleted,
 	)
 	if err != nil {
 		slog.Error("failed to call get_users function", "error", err)
-		return nil, 0, fmt.Errorf("failed to call get_users function: %w", err)
+		return nil, 0, fmt.Errorf("failed to call get_users function: %w", classifyError(err))
 	}
-	defer rows.Close()
 
 	users, err := scanUsers(rows)
+	rows.Close()
 	if err != nil {
 		slog.Error("failed to scan users", "error", err)
 		return nil, 0, fmt.Errorf("failed to scan users: %w", err)
 	}
 
+	var total int
+	err = tx.QueryRowContext(ctx, countUsersFunctionCall,
+		service.TenantFromContext(ctx),
+		user.FilterID,
+		user.FilterCountry,
+		user.FilterEmail,
+		user.FilterFirstName,
+		user.FilterLastName,
+		user.FilterNickname,
+		user.FilterEmailVerified,
+		user.IncludeDeleted,
+	).Scan(&total)
+	if err != nil {
+		slog.Error("failed to call count_users function", "error", err)
+		return nil, 0, fmt.Errorf("failed to call count_users function: %w", classifyError(err))
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, 0, fmt.Errorf("failed to commit get users transaction: %w", classifyError(err))
+	}
+
+	return users, total, nil
+}
+
+//go:embed scripts/postgres_get_user_function_call.sql
+var getUserFunctionCall string
+
+func (d *Client) GetUser(ctx context.Context, args dto.GetUserArgs) (_ dto.UserDTO, err error) {
+	ctx, end := startSpan(ctx, "GetUser")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, getUserFunctionCall,
+		service.TenantFromContext(ctx),
+		args.ID,
+		args.Email,
+	)
+	if err != nil {
+		slog.Error("failed to call get_user function", "error", err)
+		return dto.UserDTO{}, fmt.Errorf("failed to call get_user function: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	users, err := scanUsers(rows)
+	if err != nil {
+		slog.Error("failed to scan user", "error", err)
+		return dto.UserDTO{}, fmt.Errorf("failed to scan user: %w", err)
+	}
+
 	if len(users) == 0 {
-		return nil, 0, fmt.Errorf("no users found for supplied filters")
+		return dto.UserDTO{}, fmt.Errorf("%w for supplied id or email", service.ErrUserNotFound)
 	}
 
-	return users, len(users), nil
+	return users[0], nil
+}
+
+//go:embed scripts/postgres_get_user_credentials_function_call.sql
+var getUserCredentialsFunctionCall string
+
+// GetUserCredentials returns the user with the given email along with their password hash.
+func (d *Client) GetUserCredentials(ctx context.Context, email string) (_ dto.UserDTO, err error) {
+	ctx, end := startSpan(ctx, "GetUserCredentials")
+	defer end(&err)
+
+	var u dto.UserDTO
+	err = d.DB.QueryRowContext(ctx, getUserCredentialsFunctionCall, service.TenantFromContext(ctx), email).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Nickname,
+		&u.Email,
+		&u.Country,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+		&u.Password,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return dto.UserDTO{}, fmt.Errorf("%w for supplied email", service.ErrUserNotFound)
+	}
+	if err != nil {
+		slog.Error("failed to call get_user_credentials function", "error", err)
+		return dto.UserDTO{}, fmt.Errorf("failed to call get_user_credentials function: %w", classifyError(err))
+	}
+
+	return u, nil
+}
+
+//go:embed scripts/postgres_export_users_function_call.sql
+var exportUsersFunctionCall string
+
+// exportBatchSize is the number of users read per round trip while exporting.
+const exportBatchSize = 1000
+
+// ExportUsers walks every user matching the filters in (created_at, id) order, all batches are
+// read inside a single repeatable read transaction so the export sees one consistent snapshot.
+func (d *Client) ExportUsers(ctx context.Context, args dto.ExportUsersArgs, send func(dto.UserDTO) error) (err error) {
+	ctx, end := startSpan(ctx, "ExportUsers")
+	defer end(&err)
+
+	tx, err := d.DB.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		slog.Error("failed to begin export transaction", "error", err)
+		return fmt.Errorf("failed to begin export transaction: %w", classifyError(err))
+	}
+	defer tx.Rollback()
+
+	var afterCreatedAt sql.NullTime
+	var afterID sql.NullString
+	for {
+		rows, err := tx.QueryContext(ctx, exportUsersFunctionCall,
+			service.TenantFromContext(ctx),
+			args.FilterID,
+			args.FilterCountry,
+			args.FilterEmail,
+			args.FilterFirstName,
+			args.FilterLastName,
+			args.FilterNickname,
+			afterCreatedAt,
+			afterID,
+			exportBatchSize,
+			args.FilterEmailVerified,
+			args.IncludeDeleted,
+		)
+		if err != nil {
+			slog.Error("failed to call export_users function", "error", err)
+			return fmt.Errorf("failed to call export_users function: %w", classifyError(err))
+		}
+
+		users, err := scanUsers(rows)
+		rows.Close()
+		if err != nil {
+			slog.Error("failed to scan users", "error", err)
+			return fmt.Errorf("failed to scan users: %w", err)
+		}
+
+		for _, u := range users {
+			if err := send(u); err != nil {
+				return err
+			}
+		}
+
+		if len(users) < exportBatchSize {
+			break
+		}
+
+		last := users[len(users)-1]
+		afterCreatedAt = last.CreatedAt
+		afterID = last.ID
+	}
+
+	return tx.Commit()
+}
+
+//go:embed scripts/postgres_get_user_history_function_call.sql
+var getUserHistoryFunctionCall string
+
+// GetUserHistory returns a page of the user's audit entries, newest first.
+func (d *Client) GetUserHistory(ctx context.Context, args dto.GetUserHistoryArgs) (_ []dto.UserAuditDTO, err error) {
+	ctx, end := startSpan(ctx, "GetUserHistory")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, getUserHistoryFunctionCall, service.TenantFromContext(ctx), args.UserID, args.BeforeID, args.Limit)
+	if err != nil {
+		slog.Error("failed to call get_user_history function", "error", err)
+		return nil, fmt.Errorf("failed to call get_user_history function: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	var entries []dto.UserAuditDTO
+	for rows.Next() {
+		var e dto.UserAuditDTO
+		if err := rows.Scan(
+			&e.ID,
+			&e.UserID,
+			&e.Action,
+			&e.Changes,
+			&e.Actor,
+			&e.RequestID,
+			&e.OccurredAt,
+		); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit entries: %w", classifyError(err))
+	}
+
+	return entries, nil
+}
+
+//go:embed scripts/postgres_get_user_roles_function_call.sql
+var getUserRolesFunctionCall string
+
+// GetUserRoles returns the roles held by the user in name order, a deleted or unknown user holds none.
+func (d *Client) GetUserRoles(ctx context.Context, userUUID string) (_ []string, err error) {
+	ctx, end := startSpan(ctx, "GetUserRoles")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, getUserRolesFunctionCall, service.TenantFromContext(ctx), userUUID)
+	if err != nil {
+		slog.Error("failed to call get_user_roles function", "error", err)
+		return nil, fmt.Errorf("failed to call get_user_roles function: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	var roles []string
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, fmt.Errorf("failed to scan role: %w", err)
+		}
+		roles = append(roles, role)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read roles: %w", classifyError(err))
+	}
+
+	return roles, nil
+}
+
+//go:embed scripts/postgres_list_api_keys_function_call.sql
+var listAPIKeysFunctionCall string
+
+// ListAPIKeys returns every key of the tenant in the order they were created, revoked keys included.
+func (d *Client) ListAPIKeys(ctx context.Context) (_ []dto.APIKeyDTO, err error) {
+	ctx, end := startSpan(ctx, "ListAPIKeys")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, listAPIKeysFunctionCall, service.TenantFromContext(ctx))
+	if err != nil {
+		slog.Error("failed to call list_api_keys function", "error", err)
+		return nil, fmt.Errorf("failed to call list_api_keys function: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan api keys: %w", classifyError(err))
+	}
+
+	return keys, nil
+}
+
+//go:embed scripts/postgres_get_api_key_function_call.sql
+var getAPIKeyFunctionCall string
+
+// GetAPIKeyByHash returns the key with the hash whatever its tenant, expired and revoked keys included.
+func (d *Client) GetAPIKeyByHash(ctx context.Context, keyHash string) (_ dto.APIKeyDTO, err error) {
+	ctx, end := startSpan(ctx, "GetAPIKeyByHash")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, getAPIKeyFunctionCall, keyHash)
+	if err != nil {
+		slog.Error("failed to call get_api_key function", "error", err)
+		return dto.APIKeyDTO{}, fmt.Errorf("failed to call get_api_key function: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return dto.APIKeyDTO{}, fmt.Errorf("failed to scan api key: %w", classifyError(err))
+	}
+
+	if len(keys) == 0 {
+		return dto.APIKeyDTO{}, service.ErrAPIKeyNotFound
+	}
+
+	return keys[0], nil
+}
+
+func scanAPIKeys(rows *sql.Rows) ([]dto.APIKeyDTO, error) {
+	var keys []dto.APIKeyDTO
+	for rows.Next() {
+		var k dto.APIKeyDTO
+		if err := rows.Scan(
+			&k.ID,
+			&k.TenantID,
+			&k.Name,
+			pq.Array(&k.Scopes),
+			&k.ExpiresAt,
+			&k.CreatedBy,
+			&k.CreatedAt,
+			&k.RotatedAt,
+			&k.RevokedAt,
+		); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
 }
 
 func scanUsers(rows *sql.Rows) (dto.UsersDTO, error) {
@@ -57,10 +367,14 @@ func scanUsers(rows *sql.Rows) (dto.UsersDTO, error) {
 			&u.Country,
 			&u.CreatedAt,
 			&u.UpdatedAt,
+			&u.EmailVerifiedAt,
+			&u.PendingEmail,
+			&u.DeletedAt,
+			&u.Version,
 		); err != nil {
 			return nil, err
 		}
 		users = append(users, u)
 	}
-	return users, nil
+	return users, rows.Err()
 }
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/EFG/internal/datasource/database"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportUsers_ReportsAnErrorWhileReadingRows(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	assert.NoError(t, err)
+	defer db.Close()
+
+	timestamp := time.Now()
+	rows := sqlmock.NewRows([]string{"id", "first_name", "last_name", "nickname", "email", "country", "created_at", "updated_at", "email_verified_at", "pending_email", "deleted_at", "version"}).
+		AddRow("1", "John", "Doe", "johndoe", "john.doe@example.com", "US", timestamp, timestamp, nil, nil, nil, 1).
+		AddRow("2", "Jane", "Doe", "janedoe", "jane.doe@example.com", "US", timestamp, timestamp, nil, nil, nil, 1).
+		RowError(1, errors.New("connection reset by peer"))
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("export_users").WillReturnRows(rows)
+	mock.ExpectRollback()
+
+	client := &Client{BaseClient: &database.BaseClient{DB: db}}
+
+	// a broken stream must not pass for the end of the export
+	var sent []string
+	err = client.ExportUsers(context.Background(), dto.ExportUsersArgs{}, func(u dto.UserDTO) error {
+		sent = append(sent, u.ID.String)
+		return nil
+	})
+	assert.ErrorContains(t, err, "connection reset by peer")
+	assert.Empty(t, sent)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1 @@
+CALL assign_role($1, $2, $3, $4)
\ No newline at end of file
@@ -0,0 +1 @@
+CALL complete_idempotency_key($1, $2, $3, $4, $5, $6)
\ No newline at end of file
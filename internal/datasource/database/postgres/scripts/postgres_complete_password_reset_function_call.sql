@@ -0,0 +1 @@
+SELECT complete_password_reset($1, $2, $3, $4)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT confirm_email($1, $2, $3)
\ No newline at end of file
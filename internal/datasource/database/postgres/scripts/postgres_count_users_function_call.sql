@@ -0,0 +1 @@
+SELECT count_users($1, $2, $3, $4, $5, $6, $7, $8, $9)
\ No newline at end of file
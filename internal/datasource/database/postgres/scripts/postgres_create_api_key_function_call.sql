@@ -0,0 +1 @@
+SELECT * FROM create_api_key($1, $2, $3, $4, $5, $6)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT create_email_verification_token($1, $2, $3, $4)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT create_password_reset_token($1, $2, $3, $4)
\ No newline at end of file
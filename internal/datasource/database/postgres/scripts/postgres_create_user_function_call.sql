@@ -1 +1 @@
-SELECT create_user($1, $2, $3, $4, $5, $6)
\ No newline at end of file
+SELECT create_user($1, $2, $3, $4, $5, $6, $7)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT delete_expired_idempotency_keys($1)
\ No newline at end of file
@@ -1 +1 @@
-CALL delete_user($1)
+CALL delete_user($1, $2, $3)
\ No newline at end of file
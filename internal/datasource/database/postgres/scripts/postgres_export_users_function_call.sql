@@ -0,0 +1 @@
+SELECT * FROM export_users($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
\ No newline at end of file
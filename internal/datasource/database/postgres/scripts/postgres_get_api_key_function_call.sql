@@ -0,0 +1 @@
+SELECT * FROM get_api_key($1)
\ No newline at end of file
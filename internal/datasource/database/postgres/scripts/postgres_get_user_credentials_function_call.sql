@@ -0,0 +1 @@
+SELECT * FROM get_user_credentials($1, $2)
\ No newline at end of file
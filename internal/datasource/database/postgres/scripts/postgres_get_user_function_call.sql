@@ -0,0 +1 @@
+SELECT * FROM get_user($1, $2, $3)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT * FROM get_user_history($1, $2, $3, $4)
\ No newline at end of file
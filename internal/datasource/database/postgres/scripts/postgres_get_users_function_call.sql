@@ -1 +1 @@
-SELECT * FROM get_users($1, $2, $3, $4, $5, $6, $7, $8)
\ No newline at end of file
+SELECT * FROM get_users($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
\ No newline at end of file
@@ -0,0 +1 @@
+SELECT * FROM list_api_keys($1)
\ No newline at end of file
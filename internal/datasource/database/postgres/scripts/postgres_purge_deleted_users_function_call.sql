@@ -0,0 +1 @@
+SELECT * FROM purge_deleted_users($1, $2)
\ No newline at end of file
@@ -0,0 +1 @@
+CALL purge_user($1, $2)
\ No newline at end of file
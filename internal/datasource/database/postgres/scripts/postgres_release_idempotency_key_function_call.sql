@@ -0,0 +1 @@
+CALL release_idempotency_key($1, $2, $3, $4)
\ No newline at end of file
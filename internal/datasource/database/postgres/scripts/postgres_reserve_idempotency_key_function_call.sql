@@ -0,0 +1 @@
+SELECT * FROM reserve_idempotency_key($1, $2, $3, $4, $5, $6, $7)
\ No newline at end of file
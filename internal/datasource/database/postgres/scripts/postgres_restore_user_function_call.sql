@@ -0,0 +1 @@
+SELECT * FROM restore_user($1, $2)
\ No newline at end of file
@@ -0,0 +1 @@
+CALL revoke_api_key($1, $2)
\ No newline at end of file
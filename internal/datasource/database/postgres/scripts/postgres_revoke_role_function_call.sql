@@ -0,0 +1 @@
+CALL revoke_role($1, $2, $3)
\ No newline at end of file
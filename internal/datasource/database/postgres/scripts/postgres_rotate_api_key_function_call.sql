@@ -0,0 +1 @@
+SELECT * FROM rotate_api_key($1, $2, $3)
\ No newline at end of file
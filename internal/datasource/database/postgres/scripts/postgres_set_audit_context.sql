@@ -0,0 +1 @@
+SELECT set_config('user_audit.actor', $1, true), set_config('user_audit.request_id', $2, true)
\ No newline at end of file
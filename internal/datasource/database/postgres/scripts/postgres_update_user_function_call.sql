@@ -1 +1 @@
-CALL update_user($1, $2, $3, $4, $5, $6, $7)
\ No newline at end of file
+SELECT * FROM update_user($1, $2, $3, $4, $5, $6, $7, $8, $9)
\ No newline at end of file
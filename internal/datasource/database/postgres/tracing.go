@@ -0,0 +1,17 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/EFG/internal/tracing"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startSpan starts the span of a Client method, named after it so slow queries can be told apart
+// by the function they call.
+func startSpan(ctx context.Context, method string) (context.Context, func(err *error)) {
+	return tracing.Start(ctx, "postgres."+method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL))
+}
@@ -2,32 +2,68 @@ package postgres
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"log/slog"
-	"strings"
+	"time"
 
 	_ "embed"
 
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/service"
+	"github.com/lib/pq"
 )
 
+//go:embed scripts/postgres_set_audit_context.sql
+var setAuditContext string
+
+// audited runs fn in a transaction tagged with the actor and request ID carried by ctx, the
+// user_audit trigger records them against every change fn makes to users. Errors are returned
+// unclassified so callers handle them like any other database error.
+func (d *Client) audited(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := d.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	info := service.AuditInfoFromContext(ctx)
+	if _, err := tx.ExecContext(ctx, setAuditContext, info.Actor, info.RequestID); err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
 //go:embed scripts/postgres_create_user_function_call.sql
 var createUserFunctionCall string
 
-func (d *Client) CreateUser(ctx context.Context, user dto.UserDTO) (string, error) {
+func (d *Client) CreateUser(ctx context.Context, user dto.UserDTO) (_ string, err error) {
+	ctx, end := startSpan(ctx, "CreateUser")
+	defer end(&err)
+
 	var id string
 
-	err := d.DB.QueryRowContext(ctx, createUserFunctionCall,
-		user.FirstName,
-		user.LastName,
-		user.Nickname,
-		user.Password,
-		user.Email,
-		user.Country,
-	).Scan(&id)
-	if err != nil {
-		if strings.Contains(err.Error(), "user_email_unique") {
-			return "", fmt.Errorf("email already exists: %s", user.Email.String)
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, createUserFunctionCall,
+			service.TenantFromContext(ctx),
+			user.FirstName,
+			user.LastName,
+			user.Nickname,
+			user.Password,
+			user.Email,
+			user.Country,
+		).Scan(&id)
+	})
+	if err != nil {
+		err = classifyError(err)
+		if errors.Is(err, service.ErrEmailAlreadyExists) {
+			return "", fmt.Errorf("%w: %s", err, user.Email.String)
 		}
 		return "", fmt.Errorf("database error: %w", err)
 	}
@@ -35,35 +71,477 @@ func (d *Client) CreateUser(ctx context.Context, user dto.UserDTO) (string, erro
 	return id, nil
 }
 
+//go:embed scripts/postgres_create_users_function_call.sql
+var createUsersFunctionCall string
+
+// CreateUsers inserts a batch of users in a single round trip, users whose email
+// already exists are skipped and left out of the returned set.
+func (d *Client) CreateUsers(ctx context.Context, users dto.UsersDTO) (_ dto.UsersDTO, err error) {
+	ctx, end := startSpan(ctx, "CreateUsers")
+	defer end(&err)
+
+	firstNames := make([]string, len(users))
+	lastNames := make([]string, len(users))
+	nicknames := make([]string, len(users))
+	passwords := make([]string, len(users))
+	emails := make([]string, len(users))
+	countries := make([]string, len(users))
+	for i, u := range users {
+		firstNames[i] = u.FirstName.String
+		lastNames[i] = u.LastName.String
+		nicknames[i] = u.Nickname.String
+		passwords[i] = u.Password.String
+		emails[i] = u.Email.String
+		countries[i] = u.Country.String
+	}
+
+	var created dto.UsersDTO
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, createUsersFunctionCall,
+			service.TenantFromContext(ctx),
+			pq.Array(firstNames),
+			pq.Array(lastNames),
+			pq.Array(nicknames),
+			pq.Array(passwords),
+			pq.Array(emails),
+			pq.Array(countries),
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u dto.UserDTO
+			if err := rows.Scan(&u.ID, &u.Email); err != nil {
+				return fmt.Errorf("failed to scan created user: %w", err)
+			}
+			created = append(created, u)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return created, nil
+}
+
 //go:embed scripts/postgres_update_user_function_call.sql
 var updateUserFunctionCall string
 
-func (d *Client) ModifyUser(ctx context.Context, user dto.UserDTO) error {
+// ModifyUser writes every valid field of the user and returns the user as it is after the update.
+func (d *Client) ModifyUser(ctx context.Context, user dto.UserDTO) (_ dto.UserDTO, err error) {
+	ctx, end := startSpan(ctx, "ModifyUser")
+	defer end(&err)
+
 	slog.Info("Modifying user", "id", user.ID)
-	_, err := d.DB.ExecContext(ctx, updateUserFunctionCall,
-		user.ID,
-		user.FirstName,
-		user.LastName,
-		user.Nickname,
-		user.Password,
-		user.Email,
-		user.Country,
-	)
+	var users dto.UsersDTO
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, updateUserFunctionCall,
+			service.TenantFromContext(ctx),
+			user.ID,
+			user.FirstName,
+			user.LastName,
+			user.Nickname,
+			user.Password,
+			user.Email,
+			user.Country,
+			user.Version,
+		)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users, err = scanUsers(rows)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("database error: %w", err)
+		err = classifyError(err)
+		if errors.Is(err, service.ErrEmailAlreadyExists) {
+			return dto.UserDTO{}, fmt.Errorf("%w: %s", err, user.Email.String)
+		}
+		return dto.UserDTO{}, fmt.Errorf("database error: %w", err)
 	}
 
-	return nil
+	if len(users) == 0 {
+		return dto.UserDTO{}, fmt.Errorf("%w with id %s", service.ErrUserNotFound, user.ID.String)
+	}
+
+	return users[0], nil
 }
 
 //go:embed scripts/postgres_delete_user_function_call.sql
 var deleteUserFunctionCall string
 
-func (d *Client) DeleteUser(ctx context.Context, userUUID string) error {
-	_, err := d.DB.ExecContext(ctx, deleteUserFunctionCall, userUUID)
+// DeleteUser soft deletes the user, they can be restored until purged. When the expected version
+// is valid the user is only deleted if it hasn't been written since.
+func (d *Client) DeleteUser(ctx context.Context, userUUID string, expectedVersion sql.NullInt64) (err error) {
+	ctx, end := startSpan(ctx, "DeleteUser")
+	defer end(&err)
+
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, deleteUserFunctionCall, service.TenantFromContext(ctx), userUUID, expectedVersion)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("database error: %w", err)
+		return fmt.Errorf("database error: %w", classifyError(err))
 	}
 
 	return nil
 }
+
+//go:embed scripts/postgres_restore_user_function_call.sql
+var restoreUserFunctionCall string
+
+// RestoreUser clears the deletion of a soft deleted user and returns them.
+func (d *Client) RestoreUser(ctx context.Context, userUUID string) (_ dto.UserDTO, err error) {
+	ctx, end := startSpan(ctx, "RestoreUser")
+	defer end(&err)
+
+	var users dto.UsersDTO
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, restoreUserFunctionCall, service.TenantFromContext(ctx), userUUID)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		users, err = scanUsers(rows)
+		return err
+	})
+	if err != nil {
+		err = classifyError(err)
+		if errors.Is(err, service.ErrEmailAlreadyExists) {
+			return dto.UserDTO{}, fmt.Errorf("%w: the email has been registered again since the user was deleted", err)
+		}
+		return dto.UserDTO{}, fmt.Errorf("database error: %w", err)
+	}
+
+	if len(users) == 0 {
+		return dto.UserDTO{}, fmt.Errorf("%w with id %s", service.ErrUserNotFound, userUUID)
+	}
+
+	return users[0], nil
+}
+
+//go:embed scripts/postgres_purge_user_function_call.sql
+var purgeUserFunctionCall string
+
+// PurgeUser permanently removes the user, deleted or not.
+func (d *Client) PurgeUser(ctx context.Context, userUUID string) (err error) {
+	ctx, end := startSpan(ctx, "PurgeUser")
+	defer end(&err)
+
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, purgeUserFunctionCall, service.TenantFromContext(ctx), userUUID)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+//go:embed scripts/postgres_purge_deleted_users_function_call.sql
+var purgeDeletedUsersFunctionCall string
+
+// PurgeDeletedUsers permanently removes up to limit users of any tenant soft deleted before deletedBefore
+// and returns their IDs and tenants.
+func (d *Client) PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (_ dto.UsersDTO, err error) {
+	ctx, end := startSpan(ctx, "PurgeDeletedUsers")
+	defer end(&err)
+
+	var purged dto.UsersDTO
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, purgeDeletedUsersFunctionCall, deletedBefore, limit)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var u dto.UserDTO
+			if err := rows.Scan(&u.ID, &u.TenantID); err != nil {
+				return fmt.Errorf("failed to scan purged user: %w", err)
+			}
+			purged = append(purged, u)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return purged, nil
+}
+
+//go:embed scripts/postgres_create_password_reset_token_function_call.sql
+var createPasswordResetTokenFunctionCall string
+
+// CreatePasswordResetToken stores the hashed token against the user with the email and returns their ID.
+func (d *Client) CreatePasswordResetToken(ctx context.Context, email, tokenHash string, expiresAt time.Time) (_ string, err error) {
+	ctx, end := startSpan(ctx, "CreatePasswordResetToken")
+	defer end(&err)
+
+	var userID string
+
+	err = d.DB.QueryRowContext(ctx, createPasswordResetTokenFunctionCall, service.TenantFromContext(ctx), email, tokenHash, expiresAt).Scan(&userID)
+	if err != nil {
+		return "", fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return userID, nil
+}
+
+//go:embed scripts/postgres_complete_password_reset_function_call.sql
+var completePasswordResetFunctionCall string
+
+// CompletePasswordReset spends the token and sets the hashed password of its user, returning their ID.
+func (d *Client) CompletePasswordReset(ctx context.Context, tokenHash, passwordHash string, now time.Time) (_ string, err error) {
+	ctx, end := startSpan(ctx, "CompletePasswordReset")
+	defer end(&err)
+
+	var userID sql.NullString
+
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, completePasswordResetFunctionCall, service.TenantFromContext(ctx), tokenHash, passwordHash, now).Scan(&userID)
+	})
+	if err != nil {
+		return "", fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	if !userID.Valid {
+		return "", service.ErrInvalidPasswordResetToken
+	}
+
+	return userID.String, nil
+}
+
+//go:embed scripts/postgres_create_email_verification_token_function_call.sql
+var createEmailVerificationTokenFunctionCall string
+
+// CreateEmailVerificationToken stores the hashed token against the address the user has to verify and
+// returns that address.
+func (d *Client) CreateEmailVerificationToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (_ string, err error) {
+	ctx, end := startSpan(ctx, "CreateEmailVerificationToken")
+	defer end(&err)
+
+	var email sql.NullString
+
+	err = d.DB.QueryRowContext(ctx, createEmailVerificationTokenFunctionCall, service.TenantFromContext(ctx), userID, tokenHash, expiresAt).Scan(&email)
+	if err != nil {
+		return "", fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	if !email.Valid {
+		return "", service.ErrEmailAlreadyVerified
+	}
+
+	return email.String, nil
+}
+
+//go:embed scripts/postgres_confirm_email_function_call.sql
+var confirmEmailFunctionCall string
+
+// ConfirmEmail spends the token and verifies the address it was sent to, returning the user's ID.
+func (d *Client) ConfirmEmail(ctx context.Context, tokenHash string, now time.Time) (_ string, err error) {
+	ctx, end := startSpan(ctx, "ConfirmEmail")
+	defer end(&err)
+
+	var userID sql.NullString
+
+	err = d.audited(ctx, func(tx *sql.Tx) error {
+		return tx.QueryRowContext(ctx, confirmEmailFunctionCall, service.TenantFromContext(ctx), tokenHash, now).Scan(&userID)
+	})
+	if err != nil {
+		err = classifyError(err)
+		if errors.Is(err, service.ErrEmailAlreadyExists) {
+			return "", fmt.Errorf("%w: the pending email has since been taken", err)
+		}
+		return "", fmt.Errorf("database error: %w", err)
+	}
+
+	if !userID.Valid {
+		return "", service.ErrInvalidEmailVerificationToken
+	}
+
+	return userID.String, nil
+}
+
+//go:embed scripts/postgres_reserve_idempotency_key_function_call.sql
+var reserveIdempotencyKeyFunctionCall string
+
+// ReserveIdempotencyKey claims the key of the caller for a request until expiresAt, or reports what the key already
+// holds when it is in use.
+func (d *Client) ReserveIdempotencyKey(ctx context.Context, key, method, requestHash string, expiresAt, now time.Time) (_ dto.IdempotencyKeyDTO, err error) {
+	ctx, end := startSpan(ctx, "ReserveIdempotencyKey")
+	defer end(&err)
+
+	var stored dto.IdempotencyKeyDTO
+
+	err = d.DB.QueryRowContext(ctx, reserveIdempotencyKeyFunctionCall, service.TenantFromContext(ctx), service.CallerFromContext(ctx).Actor(), key, method, requestHash, expiresAt, now).Scan(
+		&stored.Reserved,
+		&stored.RequestHash,
+		&stored.Response,
+	)
+	if err != nil {
+		return dto.IdempotencyKeyDTO{}, fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return stored, nil
+}
+
+//go:embed scripts/postgres_complete_idempotency_key_function_call.sql
+var completeIdempotencyKeyFunctionCall string
+
+// CompleteIdempotencyKey stores the response of the request holding the key, it is replayed until expiresAt.
+func (d *Client) CompleteIdempotencyKey(ctx context.Context, key, method string, response []byte, expiresAt time.Time) (err error) {
+	ctx, end := startSpan(ctx, "CompleteIdempotencyKey")
+	defer end(&err)
+
+	_, err = d.DB.ExecContext(ctx, completeIdempotencyKeyFunctionCall, service.TenantFromContext(ctx), service.CallerFromContext(ctx).Actor(), key, method, response, expiresAt)
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+//go:embed scripts/postgres_release_idempotency_key_function_call.sql
+var releaseIdempotencyKeyFunctionCall string
+
+// ReleaseIdempotencyKey frees a key whose request failed so a retry runs it again.
+func (d *Client) ReleaseIdempotencyKey(ctx context.Context, key, method string) (err error) {
+	ctx, end := startSpan(ctx, "ReleaseIdempotencyKey")
+	defer end(&err)
+
+	_, err = d.DB.ExecContext(ctx, releaseIdempotencyKeyFunctionCall, service.TenantFromContext(ctx), service.CallerFromContext(ctx).Actor(), key, method)
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+//go:embed scripts/postgres_delete_expired_idempotency_keys_function_call.sql
+var deleteExpiredIdempotencyKeysFunctionCall string
+
+// DeleteExpiredIdempotencyKeys removes every key that expired before now, returning how many were removed.
+func (d *Client) DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (_ int, err error) {
+	ctx, end := startSpan(ctx, "DeleteExpiredIdempotencyKeys")
+	defer end(&err)
+
+	var deleted int
+
+	err = d.DB.QueryRowContext(ctx, deleteExpiredIdempotencyKeysFunctionCall, now).Scan(&deleted)
+	if err != nil {
+		return 0, fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return deleted, nil
+}
+
+//go:embed scripts/postgres_assign_role_function_call.sql
+var assignRoleFunctionCall string
+
+// AssignRole gives the user the role, recording the caller as the one who granted it.
+func (d *Client) AssignRole(ctx context.Context, userUUID, role string) (err error) {
+	ctx, end := startSpan(ctx, "AssignRole")
+	defer end(&err)
+
+	_, err = d.DB.ExecContext(ctx, assignRoleFunctionCall, service.TenantFromContext(ctx), userUUID, role, service.AuditInfoFromContext(ctx).Actor)
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+//go:embed scripts/postgres_revoke_role_function_call.sql
+var revokeRoleFunctionCall string
+
+// RevokeRole takes the role away from the user.
+func (d *Client) RevokeRole(ctx context.Context, userUUID, role string) (err error) {
+	ctx, end := startSpan(ctx, "RevokeRole")
+	defer end(&err)
+
+	_, err = d.DB.ExecContext(ctx, revokeRoleFunctionCall, service.TenantFromContext(ctx), userUUID, role)
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+//go:embed scripts/postgres_create_api_key_function_call.sql
+var createAPIKeyFunctionCall string
+
+// CreateAPIKey stores a new key, recording the caller as the one who created it.
+func (d *Client) CreateAPIKey(ctx context.Context, key dto.APIKeyDTO) (_ dto.APIKeyDTO, err error) {
+	ctx, end := startSpan(ctx, "CreateAPIKey")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, createAPIKeyFunctionCall,
+		service.TenantFromContext(ctx),
+		key.Name,
+		key.KeyHash,
+		pq.Array(key.Scopes),
+		key.ExpiresAt,
+		service.AuditInfoFromContext(ctx).Actor,
+	)
+	if err != nil {
+		return dto.APIKeyDTO{}, fmt.Errorf("database error: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	return scanAPIKey(rows)
+}
+
+//go:embed scripts/postgres_rotate_api_key_function_call.sql
+var rotateAPIKeyFunctionCall string
+
+// RotateAPIKey replaces the hash of a key still in use, the old key stops working straight away.
+func (d *Client) RotateAPIKey(ctx context.Context, id, keyHash string) (_ dto.APIKeyDTO, err error) {
+	ctx, end := startSpan(ctx, "RotateAPIKey")
+	defer end(&err)
+
+	rows, err := d.DB.QueryContext(ctx, rotateAPIKeyFunctionCall, service.TenantFromContext(ctx), id, keyHash)
+	if err != nil {
+		return dto.APIKeyDTO{}, fmt.Errorf("database error: %w", classifyError(err))
+	}
+	defer rows.Close()
+
+	return scanAPIKey(rows)
+}
+
+//go:embed scripts/postgres_revoke_api_key_function_call.sql
+var revokeAPIKeyFunctionCall string
+
+// RevokeAPIKey stops a key from working, the key is kept for the record.
+func (d *Client) RevokeAPIKey(ctx context.Context, id string) (err error) {
+	ctx, end := startSpan(ctx, "RevokeAPIKey")
+	defer end(&err)
+
+	_, err = d.DB.ExecContext(ctx, revokeAPIKeyFunctionCall, service.TenantFromContext(ctx), id)
+	if err != nil {
+		return fmt.Errorf("database error: %w", classifyError(err))
+	}
+
+	return nil
+}
+
+// scanAPIKey reads the single key returned by a write, the function raises rather than returning no row.
+func scanAPIKey(rows *sql.Rows) (dto.APIKeyDTO, error) {
+	keys, err := scanAPIKeys(rows)
+	if err != nil {
+		return dto.APIKeyDTO{}, fmt.Errorf("database error: %w", classifyError(err))
+	}
+	if len(keys) == 0 {
+		return dto.APIKeyDTO{}, fmt.Errorf("database error: no api key returned")
+	}
+
+	return keys[0], nil
+}
@@ -2,6 +2,7 @@ package dto
 
 import (
 	"database/sql"
+	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/utils"
@@ -17,6 +18,17 @@ type UserDTO struct {
 	Country   sql.NullString `json:"country"`
 	CreatedAt sql.NullTime   `json:"created_at"`
 	UpdatedAt sql.NullTime   `json:"updated_at"`
+	// EmailVerifiedAt is null until the current email is confirmed
+	EmailVerifiedAt sql.NullTime `json:"email_verified_at"`
+	// PendingEmail is a changed email waiting to be confirmed
+	PendingEmail sql.NullString `json:"pending_email"`
+	// DeletedAt is set once the user is soft deleted
+	DeletedAt sql.NullTime `json:"deleted_at"`
+	// Version goes up on every write, when writing it is the version the write expects to replace
+	// and a null version writes unconditionally
+	Version sql.NullInt64 `json:"version"`
+	// TenantID is only read back where users of every tenant are returned together
+	TenantID sql.NullString `json:"tenant_id"`
 }
 
 type UsersDTO []UserDTO
@@ -30,6 +42,16 @@ type GetUsersArgs struct {
 	FilterNickname  sql.NullString
 	FilterEmail     sql.NullString
 	FilterCountry   sql.NullString
+	AfterCreatedAt  sql.NullTime
+	AfterID         sql.NullString
+	// OrderByFields and OrderByDirections are parallel, each field is sorted ASC or DESC
+	// by the direction at the same index. Empty means the default newest first order.
+	OrderByFields     []string
+	OrderByDirections []string
+	// FilterEmailVerified matches only verified or only unverified users when valid
+	FilterEmailVerified sql.NullBool
+	// IncludeDeleted also matches soft deleted users
+	IncludeDeleted bool
 }
 
 func (g *GetUsersArgs) FromAPI(req *api.GetUsersRequest) {
@@ -41,4 +63,86 @@ func (g *GetUsersArgs) FromAPI(req *api.GetUsersRequest) {
 	g.FilterNickname = utils.ToNullString(req.FilterNickname)
 	g.FilterEmail = utils.ToNullString(req.FilterEmail)
 	g.FilterCountry = utils.ToNullString(req.FilterCountry)
+	if req.FilterEmailVerified != nil {
+		g.FilterEmailVerified = sql.NullBool{Bool: *req.FilterEmailVerified, Valid: true}
+	}
+	g.IncludeDeleted = req.IncludeDeleted
+}
+
+type GetUserArgs struct {
+	ID    sql.NullString
+	Email sql.NullString
+}
+
+func (g *GetUserArgs) FromAPI(req *api.GetUserRequest) {
+	g.ID = utils.ToNullString(req.Id)
+	g.Email = utils.ToNullString(req.Email)
+}
+
+type ExportUsersArgs struct {
+	FilterID        sql.NullString
+	FilterFirstName sql.NullString
+	FilterLastName  sql.NullString
+	FilterNickname  sql.NullString
+	FilterEmail     sql.NullString
+	FilterCountry   sql.NullString
+	// FilterEmailVerified matches only verified or only unverified users when valid
+	FilterEmailVerified sql.NullBool
+	// IncludeDeleted also matches soft deleted users
+	IncludeDeleted bool
+}
+
+func (e *ExportUsersArgs) FromAPI(req *api.ExportUsersRequest) {
+	e.FilterID = utils.ToNullString(req.FilterId)
+	e.FilterFirstName = utils.ToNullString(req.FilterFirstName)
+	e.FilterLastName = utils.ToNullString(req.FilterLastName)
+	e.FilterNickname = utils.ToNullString(req.FilterNickname)
+	e.FilterEmail = utils.ToNullString(req.FilterEmail)
+	e.FilterCountry = utils.ToNullString(req.FilterCountry)
+	if req.FilterEmailVerified != nil {
+		e.FilterEmailVerified = sql.NullBool{Bool: *req.FilterEmailVerified, Valid: true}
+	}
+	e.IncludeDeleted = req.IncludeDeleted
+}
+
+// IdempotencyKeyDTO is what an idempotency key holds when it is reserved. Reserved is set when the
+// key was free and now belongs to the caller, otherwise RequestHash is the request the key was first
+// used for and Response is that request's stored response, nil while it is still in progress.
+type IdempotencyKeyDTO struct {
+	Reserved    bool
+	RequestHash string
+	Response    []byte
+}
+
+// UserAuditDTO is one entry of a user's audit history, Changes is the JSON object of every changed
+// field mapped to its old and new values.
+type UserAuditDTO struct {
+	ID         int64
+	UserID     string
+	Action     string
+	Changes    []byte
+	Actor      sql.NullString
+	RequestID  sql.NullString
+	OccurredAt time.Time
+}
+
+type GetUserHistoryArgs struct {
+	UserID sql.NullString
+	// BeforeID continues the history strictly before the entry with this ID when valid
+	BeforeID sql.NullInt64
+	Limit    int32
+}
+
+// APIKeyDTO is a stored API key, KeyHash is only set on keys being written as the hash is never read back.
+type APIKeyDTO struct {
+	ID        string
+	TenantID  string
+	Name      string
+	KeyHash   string
+	Scopes    []string
+	ExpiresAt sql.NullTime
+	CreatedBy sql.NullString
+	CreatedAt time.Time
+	RotatedAt sql.NullTime
+	RevokedAt sql.NullTime
 }
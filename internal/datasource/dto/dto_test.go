@@ -2,9 +2,11 @@ package dto
 
 import (
 	"database/sql"
+	"reflect"
 	"testing"
 
 	"github.com/EFG/api"
+	"github.com/EFG/internal/utils"
 )
 
 func TestGetUsersArgs_FromAPI(t *testing.T) {
@@ -86,3 +88,91 @@ func TestGetUsersArgs_FromAPI(t *testing.T) {
 		})
 	}
 }
+
+func TestGetUserArgs_FromAPI(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *api.GetUserRequest
+		want GetUserArgs
+	}{
+		{
+			name: "empty request",
+			req:  &api.GetUserRequest{},
+			want: GetUserArgs{
+				ID:    sql.NullString{Valid: false},
+				Email: sql.NullString{Valid: false},
+			},
+		},
+		{
+			name: "lookup by id",
+			req:  &api.GetUserRequest{Id: "1"},
+			want: GetUserArgs{
+				ID:    sql.NullString{Valid: true, String: "1"},
+				Email: sql.NullString{Valid: false},
+			},
+		},
+		{
+			name: "lookup by email",
+			req:  &api.GetUserRequest{Email: "john.doe@example.com"},
+			want: GetUserArgs{
+				ID:    sql.NullString{Valid: false},
+				Email: sql.NullString{Valid: true, String: "john.doe@example.com"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var g GetUserArgs
+			g.FromAPI(tt.req)
+			if !reflect.DeepEqual(g, tt.want) {
+				t.Errorf("GetUserArgs.FromAPI() = %v, want %v", g, tt.want)
+			}
+		})
+	}
+}
+
+func TestExportUsersArgs_FromAPI(t *testing.T) {
+	tests := []struct {
+		name string
+		req  *api.ExportUsersRequest
+		want ExportUsersArgs
+	}{
+		{
+			name: "empty request",
+			req:  &api.ExportUsersRequest{},
+			want: ExportUsersArgs{},
+		},
+		{
+			name: "non-empty request",
+			req: &api.ExportUsersRequest{
+				FilterId:            "1",
+				FilterFirstName:     "John",
+				FilterLastName:      "Doe",
+				FilterNickname:      "johndoe",
+				FilterEmail:         "john.doe@example.com",
+				FilterCountry:       "US",
+				FilterEmailVerified: utils.Ptr(false),
+				IncludeDeleted:      true,
+			},
+			want: ExportUsersArgs{
+				FilterID:            sql.NullString{Valid: true, String: "1"},
+				FilterFirstName:     sql.NullString{Valid: true, String: "John"},
+				FilterLastName:      sql.NullString{Valid: true, String: "Doe"},
+				FilterNickname:      sql.NullString{Valid: true, String: "johndoe"},
+				FilterEmail:         sql.NullString{Valid: true, String: "john.doe@example.com"},
+				FilterCountry:       sql.NullString{Valid: true, String: "US"},
+				FilterEmailVerified: sql.NullBool{Valid: true, Bool: false},
+				IncludeDeleted:      true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e ExportUsersArgs
+			e.FromAPI(tt.req)
+			if !reflect.DeepEqual(e, tt.want) {
+				t.Errorf("ExportUsersArgs.FromAPI() = %v, want %v", e, tt.want)
+			}
+		})
+	}
+}
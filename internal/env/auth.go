@@ -0,0 +1,35 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/viper"
+)
+
+// AuthConfig holds the configuration for authenticating callers.
+type AuthConfig struct {
+	// Required rejects calls that carry neither an API key nor an access token
+	Required bool `mapstructure:"REQUIRED"`
+}
+
+func LoadAuthConfig() (config AuthConfig, err error) {
+	if err = viperBindAuth("AUTH", &config); err != nil {
+		return AuthConfig{}, fmt.Errorf("failed to load auth configs for prefix %s: %w", "AUTH", err)
+	}
+
+	slog.Info("Loaded auth configuration",
+		"prefix", "AUTH",
+		"required", config.Required)
+
+	return
+}
+
+func viperBindAuth(prefix string, config *AuthConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("REQUIRED")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,24 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadAuthConfig(t *testing.T) {
+	os.Setenv("AUTH_REQUIRED", "true")
+
+	config, err := LoadAuthConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.Required)
+
+	os.Unsetenv("AUTH_REQUIRED")
+}
+
+func TestLoadAuthConfig_Defaults(t *testing.T) {
+	config, err := LoadAuthConfig()
+	assert.NoError(t, err)
+	assert.False(t, config.Required)
+}
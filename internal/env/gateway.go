@@ -0,0 +1,41 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/viper"
+)
+
+// defaultGatewayPort is used when no port is configured for the REST gateway.
+const defaultGatewayPort = "8080"
+
+// GatewayConfig holds the REST/JSON gateway configuration.
+type GatewayConfig struct {
+	Port string `mapstructure:"PORT"`
+}
+
+func LoadGatewayConfig() (config GatewayConfig, err error) {
+	if err = viperBindGateway("GATEWAY", &config); err != nil {
+		return GatewayConfig{}, fmt.Errorf("failed to load gateway configs for prefix %s: %w", "GATEWAY", err)
+	}
+
+	if config.Port == "" {
+		config.Port = defaultGatewayPort
+	}
+
+	slog.Info("Loaded gateway configuration",
+		"prefix", "GATEWAY",
+		"port", config.Port)
+
+	return
+}
+
+func viperBindGateway(prefix string, config *GatewayConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("PORT")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,22 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadGatewayConfig(t *testing.T) {
+	os.Setenv("GATEWAY_PORT", "8081")
+
+	config, err := LoadGatewayConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "8081", config.Port)
+
+	os.Unsetenv("GATEWAY_PORT")
+
+	config, err = LoadGatewayConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultGatewayPort, config.Port)
+}
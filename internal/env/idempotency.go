@@ -0,0 +1,42 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// defaultIdempotencyTTL is how long responses are kept for retries when no TTL is configured.
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// IdempotencyConfig holds the configuration for idempotency keys on mutating RPCs.
+type IdempotencyConfig struct {
+	TTL time.Duration `mapstructure:"TTL"`
+}
+
+func LoadIdempotencyConfig() (config IdempotencyConfig, err error) {
+	if err = viperBindIdempotency("IDEMPOTENCY", &config); err != nil {
+		return IdempotencyConfig{}, fmt.Errorf("failed to load idempotency configs for prefix %s: %w", "IDEMPOTENCY", err)
+	}
+
+	if config.TTL <= 0 {
+		config.TTL = defaultIdempotencyTTL
+	}
+
+	slog.Info("Loaded idempotency configuration",
+		"prefix", "IDEMPOTENCY",
+		"ttl", config.TTL)
+
+	return
+}
+
+func viperBindIdempotency(prefix string, config *IdempotencyConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("TTL")
+
+	return viper.Unmarshal(&config)
+}
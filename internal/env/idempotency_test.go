@@ -0,0 +1,25 @@
+package env
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadIdempotencyConfig(t *testing.T) {
+	os.Setenv("IDEMPOTENCY_TTL", "2h")
+
+	config, err := LoadIdempotencyConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, config.TTL)
+
+	os.Unsetenv("IDEMPOTENCY_TTL")
+}
+
+func TestLoadIdempotencyConfig_Defaults(t *testing.T) {
+	config, err := LoadIdempotencyConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, config.TTL)
+}
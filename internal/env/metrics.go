@@ -0,0 +1,42 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/spf13/viper"
+)
+
+// defaultMetricsPort is used when no port is configured for the metrics endpoint.
+const defaultMetricsPort = "9090"
+
+// MetricsConfig holds the configuration of the Prometheus metrics endpoint, served apart from the
+// gateway so it isn't exposed alongside the public API.
+type MetricsConfig struct {
+	Port string `mapstructure:"PORT"`
+}
+
+func LoadMetricsConfig() (config MetricsConfig, err error) {
+	if err = viperBindMetrics("METRICS", &config); err != nil {
+		return MetricsConfig{}, fmt.Errorf("failed to load metrics configs for prefix %s: %w", "METRICS", err)
+	}
+
+	if config.Port == "" {
+		config.Port = defaultMetricsPort
+	}
+
+	slog.Info("Loaded metrics configuration",
+		"prefix", "METRICS",
+		"port", config.Port)
+
+	return
+}
+
+func viperBindMetrics(prefix string, config *MetricsConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("PORT")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,22 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadMetricsConfig(t *testing.T) {
+	os.Setenv("METRICS_PORT", "9091")
+
+	config, err := LoadMetricsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, "9091", config.Port)
+
+	os.Unsetenv("METRICS_PORT")
+
+	config, err = LoadMetricsConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, defaultMetricsPort, config.Port)
+}
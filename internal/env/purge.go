@@ -0,0 +1,60 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultPurgeRetention is how long soft deleted users are kept before they are purged.
+	defaultPurgeRetention = 30 * 24 * time.Hour
+	// defaultPurgeInterval is how often the purge looks for users past the retention window.
+	defaultPurgeInterval = time.Hour
+	// defaultPurgeBatchSize is the most users purged per round trip.
+	defaultPurgeBatchSize = 100
+)
+
+// PurgeConfig holds the configuration for permanently removing soft deleted users.
+type PurgeConfig struct {
+	Retention time.Duration `mapstructure:"RETENTION"`
+	Interval  time.Duration `mapstructure:"INTERVAL"`
+	BatchSize int           `mapstructure:"BATCH_SIZE"`
+}
+
+func LoadPurgeConfig() (config PurgeConfig, err error) {
+	if err = viperBindPurge("PURGE", &config); err != nil {
+		return PurgeConfig{}, fmt.Errorf("failed to load purge configs for prefix %s: %w", "PURGE", err)
+	}
+
+	if config.Retention <= 0 {
+		config.Retention = defaultPurgeRetention
+	}
+	if config.Interval <= 0 {
+		config.Interval = defaultPurgeInterval
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultPurgeBatchSize
+	}
+
+	slog.Info("Loaded purge configuration",
+		"prefix", "PURGE",
+		"retention", config.Retention,
+		"interval", config.Interval,
+		"batchSize", config.BatchSize)
+
+	return
+}
+
+func viperBindPurge(prefix string, config *PurgeConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("RETENTION")
+	viper.BindEnv("INTERVAL")
+	viper.BindEnv("BATCH_SIZE")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,35 @@
+package env
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadPurgeConfig(t *testing.T) {
+	os.Setenv("PURGE_RETENTION", "168h")
+	os.Setenv("PURGE_INTERVAL", "15m")
+	os.Setenv("PURGE_BATCH_SIZE", "50")
+
+	config, err := LoadPurgeConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 7*24*time.Hour, config.Retention)
+	assert.Equal(t, 15*time.Minute, config.Interval)
+	assert.Equal(t, 50, config.BatchSize)
+
+	os.Unsetenv("PURGE_RETENTION")
+	os.Unsetenv("PURGE_INTERVAL")
+	os.Unsetenv("PURGE_BATCH_SIZE")
+}
+
+func TestLoadPurgeConfig_Defaults(t *testing.T) {
+	config, err := LoadPurgeConfig()
+	assert.NoError(t, err)
+
+	assert.Equal(t, 30*24*time.Hour, config.Retention)
+	assert.Equal(t, time.Hour, config.Interval)
+	assert.Equal(t, 100, config.BatchSize)
+}
@@ -0,0 +1,125 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// addressRateFactor is how many callers' worth of calls an address can make by default, so a
+// handful of callers sharing an address aren't held back by it.
+const addressRateFactor = 10
+
+// RateLimitConfig holds the per caller rate limits, calls aren't limited when Rate is zero.
+type RateLimitConfig struct {
+	// Rate is how many calls a second each caller can make to RPCs without a limit of their own
+	Rate float64 `mapstructure:"RATE"`
+	// Burst is how many calls a caller can make at once, Rate rounded up when unset
+	Burst int `mapstructure:"BURST"`
+	// Methods is a comma separated list of RPC=rate:burst limits for RPCs that need their own, e.g.
+	// "CreateUser=1:5,ImportUsers=0.1:1". Each is counted separately from the other RPCs
+	Methods string `mapstructure:"METHODS"`
+	// MethodLimits is Methods parsed, keyed by RPC name
+	MethodLimits map[string]RateLimit `mapstructure:"-"`
+	// AddressRate is how many calls a second can come from one address whoever makes them, checked
+	// before callers are authenticated. Ten times Rate when unset
+	AddressRate float64 `mapstructure:"ADDRESS_RATE"`
+	// AddressBurst is how many calls can come from one address at once, ten times Burst when unset
+	AddressBurst int `mapstructure:"ADDRESS_BURST"`
+}
+
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+func LoadRateLimitConfig() (config RateLimitConfig, err error) {
+	if err = viperBindRateLimit("RATE_LIMIT", &config); err != nil {
+		return RateLimitConfig{}, fmt.Errorf("failed to load rate limit configs for prefix %s: %w", "RATE_LIMIT", err)
+	}
+
+	if config.Rate < 0 {
+		return RateLimitConfig{}, fmt.Errorf("validation failed  %s: rate cannot be negative", "RATE_LIMIT")
+	}
+	if config.AddressRate < 0 {
+		return RateLimitConfig{}, fmt.Errorf("validation failed  %s: address rate cannot be negative", "RATE_LIMIT")
+	}
+	if config.Burst <= 0 {
+		config.Burst = int(math.Ceil(config.Rate))
+	}
+	if config.AddressRate == 0 {
+		config.AddressRate = config.Rate * addressRateFactor
+	}
+	if config.AddressBurst <= 0 {
+		config.AddressBurst = config.Burst * addressRateFactor
+	}
+	if config.MethodLimits, err = parseRateLimitMethods(config.Methods); err != nil {
+		return RateLimitConfig{}, fmt.Errorf("validation failed  %s: %w", "RATE_LIMIT", err)
+	}
+
+	slog.Info("Loaded rate limit configuration",
+		"prefix", "RATE_LIMIT",
+		"rate", config.Rate,
+		"burst", config.Burst,
+		"addressRate", config.AddressRate,
+		"addressBurst", config.AddressBurst,
+		"methods", len(config.MethodLimits))
+
+	return
+}
+
+// IsEnabled reports whether a rate is configured, without one calls aren't limited.
+func (c RateLimitConfig) IsEnabled() bool {
+	return c.Rate > 0
+}
+
+func parseRateLimitMethods(methods string) (map[string]RateLimit, error) {
+	limits := map[string]RateLimit{}
+
+	for _, entry := range strings.Split(methods, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		method, limit, ok := strings.Cut(entry, "=")
+		rate, burst, hasBurst := strings.Cut(limit, ":")
+		method = strings.TrimSpace(method)
+		if !ok || !hasBurst || method == "" {
+			return nil, fmt.Errorf("method limit %q must be in the form rpc=rate:burst", entry)
+		}
+		if _, seen := limits[method]; seen {
+			return nil, fmt.Errorf("method %q is listed more than once", method)
+		}
+
+		r, err := strconv.ParseFloat(strings.TrimSpace(rate), 64)
+		if err != nil || r <= 0 {
+			return nil, fmt.Errorf("rate of method %q must be a positive number", method)
+		}
+		b, err := strconv.Atoi(strings.TrimSpace(burst))
+		if err != nil || b <= 0 {
+			return nil, fmt.Errorf("burst of method %q must be a positive whole number", method)
+		}
+
+		limits[method] = RateLimit{Rate: r, Burst: b}
+	}
+
+	return limits, nil
+}
+
+func viperBindRateLimit(prefix string, config *RateLimitConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("RATE")
+	viper.BindEnv("BURST")
+	viper.BindEnv("METHODS")
+	viper.BindEnv("ADDRESS_RATE")
+	viper.BindEnv("ADDRESS_BURST")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,89 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadRateLimitConfig(t *testing.T) {
+	os.Setenv("RATE_LIMIT_RATE", "20")
+	os.Setenv("RATE_LIMIT_BURST", "40")
+	os.Setenv("RATE_LIMIT_METHODS", "CreateUser=1:5, ImportUsers=0.1:1")
+	os.Setenv("RATE_LIMIT_ADDRESS_RATE", "100")
+	os.Setenv("RATE_LIMIT_ADDRESS_BURST", "150")
+
+	config, err := LoadRateLimitConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.IsEnabled())
+	assert.Equal(t, 20.0, config.Rate)
+	assert.Equal(t, 40, config.Burst)
+	assert.Equal(t, map[string]RateLimit{
+		"CreateUser":  {Rate: 1, Burst: 5},
+		"ImportUsers": {Rate: 0.1, Burst: 1},
+	}, config.MethodLimits)
+	assert.Equal(t, 100.0, config.AddressRate)
+	assert.Equal(t, 150, config.AddressBurst)
+
+	os.Unsetenv("RATE_LIMIT_RATE")
+	os.Unsetenv("RATE_LIMIT_BURST")
+	os.Unsetenv("RATE_LIMIT_METHODS")
+	os.Unsetenv("RATE_LIMIT_ADDRESS_RATE")
+	os.Unsetenv("RATE_LIMIT_ADDRESS_BURST")
+}
+
+func TestLoadRateLimitConfig_Defaults(t *testing.T) {
+	config, err := LoadRateLimitConfig()
+	assert.NoError(t, err)
+	assert.False(t, config.IsEnabled())
+	assert.Empty(t, config.MethodLimits)
+
+	os.Setenv("RATE_LIMIT_RATE", "2.5")
+	defer os.Unsetenv("RATE_LIMIT_RATE")
+
+	config, err = LoadRateLimitConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, config.Burst)
+	assert.Equal(t, 25.0, config.AddressRate)
+	assert.Equal(t, 30, config.AddressBurst)
+}
+
+func TestLoadRateLimitConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		methods     string
+		expectedErr string
+	}{
+		{
+			name:        "missing burst",
+			methods:     "CreateUser=1",
+			expectedErr: `method limit "CreateUser=1" must be in the form rpc=rate:burst`,
+		},
+		{
+			name:        "duplicate method",
+			methods:     "CreateUser=1:5,CreateUser=2:5",
+			expectedErr: `method "CreateUser" is listed more than once`,
+		},
+		{
+			name:        "zero rate",
+			methods:     "CreateUser=0:5",
+			expectedErr: `rate of method "CreateUser" must be a positive number`,
+		},
+		{
+			name:        "fractional burst",
+			methods:     "CreateUser=1:0.5",
+			expectedErr: `burst of method "CreateUser" must be a positive whole number`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv("RATE_LIMIT_METHODS", tc.methods)
+			defer os.Unsetenv("RATE_LIMIT_METHODS")
+
+			_, err := LoadRateLimitConfig()
+			assert.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package env
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// defaultTLSReloadInterval is how often the certificate files are checked for a rotation.
+	defaultTLSReloadInterval = time.Minute
+	// defaultTLSServerName is the name the gateway expects on the server certificate, it dials the
+	// server on localhost.
+	defaultTLSServerName = "localhost"
+)
+
+// TLSConfig holds the TLS configuration of the gRPC listener, without a certificate it listens in
+// plaintext.
+type TLSConfig struct {
+	CertFile string `mapstructure:"CERT_FILE"`
+	KeyFile  string `mapstructure:"KEY_FILE"`
+	// ClientCAFile is a PEM bundle of the CAs client certificates have to be issued by, setting it
+	// turns on mutual TLS
+	ClientCAFile string `mapstructure:"CLIENT_CA_FILE"`
+	// CAFile is a PEM bundle the gateway verifies the server certificate against, the system roots
+	// are used when it is empty
+	CAFile string `mapstructure:"CA_FILE"`
+	// ServerName is the name the gateway expects on the server certificate
+	ServerName string `mapstructure:"SERVER_NAME"`
+	// ReloadInterval is how often the files are checked for rotated certificates
+	ReloadInterval time.Duration `mapstructure:"RELOAD_INTERVAL"`
+}
+
+func LoadTLSConfig() (config TLSConfig, err error) {
+	if err = viperBindTLS("TLS", &config); err != nil {
+		return TLSConfig{}, fmt.Errorf("failed to load tls configs for prefix %s: %w", "TLS", err)
+	}
+
+	if err = config.Validate(); err != nil {
+		return TLSConfig{}, fmt.Errorf("validation failed  %s: %w", "TLS", err)
+	}
+
+	if config.ReloadInterval <= 0 {
+		config.ReloadInterval = defaultTLSReloadInterval
+	}
+	if config.ServerName == "" {
+		config.ServerName = defaultTLSServerName
+	}
+
+	slog.Info("Loaded tls configuration",
+		"prefix", "TLS",
+		"enabled", config.IsEnabled(),
+		"mutual", config.IsMutual(),
+		"certFile", config.CertFile,
+		"clientCAFile", config.ClientCAFile,
+		"reloadInterval", config.ReloadInterval)
+
+	return
+}
+
+// IsEnabled reports whether a certificate is configured, without one the listener is plaintext.
+func (c TLSConfig) IsEnabled() bool {
+	return c.CertFile != ""
+}
+
+// IsMutual reports whether clients have to present a certificate.
+func (c TLSConfig) IsMutual() bool {
+	return c.ClientCAFile != ""
+}
+
+// Validate ensures the certificate and key are set together and client certificates are only
+// verified over TLS.
+func (c TLSConfig) Validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("both or neither of the certificate and key files must be set")
+	}
+	if c.IsMutual() && !c.IsEnabled() {
+		return errors.New("a client ca file needs a certificate and key to be set")
+	}
+
+	return nil
+}
+
+func viperBindTLS(prefix string, config *TLSConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("CERT_FILE")
+	viper.BindEnv("KEY_FILE")
+	viper.BindEnv("CLIENT_CA_FILE")
+	viper.BindEnv("CA_FILE")
+	viper.BindEnv("SERVER_NAME")
+	viper.BindEnv("RELOAD_INTERVAL")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,79 @@
+package env
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTLSConfig(t *testing.T) {
+	os.Setenv("TLS_CERT_FILE", "/certs/server.pem")
+	os.Setenv("TLS_KEY_FILE", "/certs/server-key.pem")
+	os.Setenv("TLS_CLIENT_CA_FILE", "/certs/clients-ca.pem")
+	os.Setenv("TLS_CA_FILE", "/certs/ca.pem")
+	os.Setenv("TLS_SERVER_NAME", "users.internal")
+	os.Setenv("TLS_RELOAD_INTERVAL", "30s")
+
+	config, err := LoadTLSConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.IsEnabled())
+	assert.True(t, config.IsMutual())
+	assert.Equal(t, "/certs/server.pem", config.CertFile)
+	assert.Equal(t, "/certs/server-key.pem", config.KeyFile)
+	assert.Equal(t, "/certs/clients-ca.pem", config.ClientCAFile)
+	assert.Equal(t, "/certs/ca.pem", config.CAFile)
+	assert.Equal(t, "users.internal", config.ServerName)
+	assert.Equal(t, 30*time.Second, config.ReloadInterval)
+
+	os.Unsetenv("TLS_CERT_FILE")
+	os.Unsetenv("TLS_KEY_FILE")
+	os.Unsetenv("TLS_CLIENT_CA_FILE")
+	os.Unsetenv("TLS_CA_FILE")
+	os.Unsetenv("TLS_SERVER_NAME")
+	os.Unsetenv("TLS_RELOAD_INTERVAL")
+}
+
+func TestLoadTLSConfig_Defaults(t *testing.T) {
+	config, err := LoadTLSConfig()
+	assert.NoError(t, err)
+	assert.False(t, config.IsEnabled())
+	assert.False(t, config.IsMutual())
+	assert.Equal(t, "localhost", config.ServerName)
+	assert.Equal(t, time.Minute, config.ReloadInterval)
+}
+
+func TestLoadTLSConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expectedErr string
+	}{
+		{
+			name:        "certificate without a key",
+			env:         map[string]string{"TLS_CERT_FILE": "/certs/server.pem"},
+			expectedErr: "both or neither of the certificate and key files must be set",
+		},
+		{
+			name:        "client ca without a certificate",
+			env:         map[string]string{"TLS_CLIENT_CA_FILE": "/certs/clients-ca.pem"},
+			expectedErr: "a client ca file needs a certificate and key to be set",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			_, err := LoadTLSConfig()
+			assert.ErrorContains(t, err, tt.expectedErr)
+
+			for k := range tt.env {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// TokenConfig holds the access token signing configuration.
+type TokenConfig struct {
+	// Keys is a comma separated list of id=path pairs, e.g. "2025-02=/keys/2025-02.pem,2025-01=/keys/2025-01.pub.pem".
+	// Every listed key is published and accepted for validation, which lets keys be rotated
+	// without invalidating tokens signed by the previous key.
+	Keys         string        `mapstructure:"KEYS"`
+	SigningKeyID string        `mapstructure:"SIGNING_KEY_ID"`
+	Issuer       string        `mapstructure:"ISSUER"`
+	TTL          time.Duration `mapstructure:"TTL"`
+	// Audience is the aud claim tokens are issued with and must carry to be accepted
+	Audience string `mapstructure:"AUDIENCE"`
+	// KeyFiles is Keys parsed into key IDs and PEM file paths, in the order they were listed
+	KeyFiles []TokenKeyFile `mapstructure:"-"`
+}
+
+type TokenKeyFile struct {
+	ID   string
+	Path string
+}
+
+func LoadTokenConfig() (config TokenConfig, err error) {
+	if err = viperBindToken("TOKEN", &config); err != nil {
+		return TokenConfig{}, fmt.Errorf("failed to load token configs for prefix %s: %w", "TOKEN", err)
+	}
+
+	if config.KeyFiles, err = parseTokenKeyFiles(config.Keys); err != nil {
+		return TokenConfig{}, fmt.Errorf("validation failed  %s: %w", "TOKEN", err)
+	}
+	if err = config.Validate(); err != nil {
+		return TokenConfig{}, fmt.Errorf("validation failed  %s: %w", "TOKEN", err)
+	}
+
+	slog.Info("Loaded token configuration",
+		"prefix", "TOKEN",
+		"keys", len(config.KeyFiles),
+		"signingKeyID", config.SigningKeyID,
+		"issuer", config.Issuer,
+		"audience", config.Audience,
+		"ttl", config.TTL)
+
+	return
+}
+
+// IsEnabled reports whether any keys are configured, without them no tokens are issued.
+func (c *TokenConfig) IsEnabled() bool {
+	return len(c.KeyFiles) > 0
+}
+
+// Validate ensures the signing key is one of the configured keys when tokens are enabled.
+func (c TokenConfig) Validate() error {
+	if !c.IsEnabled() {
+		return nil
+	}
+
+	for _, k := range c.KeyFiles {
+		if k.ID == c.SigningKeyID {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("signing key id %q is not one of the configured keys", c.SigningKeyID)
+}
+
+func parseTokenKeyFiles(keys string) ([]TokenKeyFile, error) {
+	var files []TokenKeyFile
+	seen := map[string]bool{}
+
+	for _, pair := range strings.Split(keys, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		id, path, ok := strings.Cut(pair, "=")
+		id, path = strings.TrimSpace(id), strings.TrimSpace(path)
+		if !ok || id == "" || path == "" {
+			return nil, fmt.Errorf("key %q must be in the form id=path", pair)
+		}
+		if seen[id] {
+			return nil, fmt.Errorf("key id %q is listed more than once", id)
+		}
+		seen[id] = true
+
+		files = append(files, TokenKeyFile{ID: id, Path: path})
+	}
+
+	return files, nil
+}
+
+func viperBindToken(prefix string, config *TokenConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("KEYS")
+	viper.BindEnv("SIGNING_KEY_ID")
+	viper.BindEnv("ISSUER")
+	viper.BindEnv("AUDIENCE")
+	viper.BindEnv("TTL")
+
+	return viper.Unmarshal(&config)
+}
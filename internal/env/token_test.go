@@ -0,0 +1,81 @@
+package env
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTokenConfig(t *testing.T) {
+	os.Setenv("TOKEN_KEYS", "2025-02=/keys/2025-02.pem, 2025-01=/keys/2025-01.pub.pem")
+	os.Setenv("TOKEN_SIGNING_KEY_ID", "2025-02")
+	os.Setenv("TOKEN_ISSUER", "user-service")
+	os.Setenv("TOKEN_AUDIENCE", "users")
+	os.Setenv("TOKEN_TTL", "10m")
+
+	config, err := LoadTokenConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.IsEnabled())
+	assert.Equal(t, []TokenKeyFile{
+		{ID: "2025-02", Path: "/keys/2025-02.pem"},
+		{ID: "2025-01", Path: "/keys/2025-01.pub.pem"},
+	}, config.KeyFiles)
+	assert.Equal(t, "2025-02", config.SigningKeyID)
+	assert.Equal(t, "user-service", config.Issuer)
+	assert.Equal(t, "users", config.Audience)
+	assert.Equal(t, 10*time.Minute, config.TTL)
+
+	os.Unsetenv("TOKEN_KEYS")
+	os.Unsetenv("TOKEN_SIGNING_KEY_ID")
+	os.Unsetenv("TOKEN_ISSUER")
+	os.Unsetenv("TOKEN_AUDIENCE")
+	os.Unsetenv("TOKEN_TTL")
+}
+
+func TestLoadTokenConfig_DisabledWithoutKeys(t *testing.T) {
+	config, err := LoadTokenConfig()
+	assert.NoError(t, err)
+	assert.False(t, config.IsEnabled())
+}
+
+func TestLoadTokenConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name         string
+		keys         string
+		signingKeyID string
+		expectedErr  string
+	}{
+		{
+			name:         "malformed pair",
+			keys:         "2025-02",
+			signingKeyID: "2025-02",
+			expectedErr:  `key "2025-02" must be in the form id=path`,
+		},
+		{
+			name:         "duplicate id",
+			keys:         "a=/keys/a.pem,a=/keys/b.pem",
+			signingKeyID: "a",
+			expectedErr:  `key id "a" is listed more than once`,
+		},
+		{
+			name:         "unknown signing key",
+			keys:         "a=/keys/a.pem",
+			signingKeyID: "b",
+			expectedErr:  `signing key id "b" is not one of the configured keys`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			os.Setenv("TOKEN_KEYS", tc.keys)
+			os.Setenv("TOKEN_SIGNING_KEY_ID", tc.signingKeyID)
+			defer os.Unsetenv("TOKEN_KEYS")
+			defer os.Unsetenv("TOKEN_SIGNING_KEY_ID")
+
+			_, err := LoadTokenConfig()
+			assert.ErrorContains(t, err, tc.expectedErr)
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package env
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/spf13/viper"
+)
+
+// Exporters spans can be sent to.
+const (
+	TracingExporterOTLP   = "otlp"
+	TracingExporterStderr = "stderr"
+)
+
+const (
+	// defaultTracingEndpoint is the OTLP gRPC port of a collector running alongside the service.
+	defaultTracingEndpoint = "localhost:4317"
+	// defaultTracingServiceName is the service spans are reported under.
+	defaultTracingServiceName = "user-service"
+	// defaultTracingSampleRatio records every trace not already sampled by the caller.
+	defaultTracingSampleRatio = 1.0
+)
+
+// TracingConfig holds where spans are exported to, without an exporter nothing is recorded but trace
+// context is still passed on from callers to SNS messages.
+type TracingConfig struct {
+	// Exporter is otlp to send spans to a collector or stderr to print them, stdout is left to the logs
+	Exporter string `mapstructure:"EXPORTER"`
+	// Endpoint is the host and port of the collector's OTLP gRPC receiver
+	Endpoint string `mapstructure:"ENDPOINT"`
+	// Insecure sends spans to the collector in plaintext
+	Insecure    bool   `mapstructure:"INSECURE"`
+	ServiceName string `mapstructure:"SERVICE_NAME"`
+	// SampleRatio is the share of traces started here that are recorded, traces started by a caller
+	// follow the caller's decision
+	SampleRatio float64 `mapstructure:"SAMPLE_RATIO"`
+}
+
+func LoadTracingConfig() (config TracingConfig, err error) {
+	if err = viperBindTracing("TRACING", &config); err != nil {
+		return TracingConfig{}, fmt.Errorf("failed to load tracing configs for prefix %s: %w", "TRACING", err)
+	}
+
+	if err = config.Validate(); err != nil {
+		return TracingConfig{}, fmt.Errorf("validation failed  %s: %w", "TRACING", err)
+	}
+
+	if config.Endpoint == "" {
+		config.Endpoint = defaultTracingEndpoint
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = defaultTracingServiceName
+	}
+	// a ratio of 0 records nothing, so only a ratio that isn't given at all takes the default
+	if os.Getenv("TRACING_SAMPLE_RATIO") == "" {
+		config.SampleRatio = defaultTracingSampleRatio
+	}
+
+	slog.Info("Loaded tracing configuration",
+		"prefix", "TRACING",
+		"enabled", config.IsEnabled(),
+		"exporter", config.Exporter,
+		"endpoint", config.Endpoint,
+		"serviceName", config.ServiceName,
+		"sampleRatio", config.SampleRatio)
+
+	return
+}
+
+// IsEnabled reports whether spans are exported.
+func (c TracingConfig) IsEnabled() bool {
+	return c.Exporter != ""
+}
+
+// Validate ensures the exporter is one spans can be sent to and the sample ratio is a ratio.
+func (c TracingConfig) Validate() error {
+	if c.IsEnabled() && !slices.Contains([]string{TracingExporterOTLP, TracingExporterStderr}, c.Exporter) {
+		return fmt.Errorf("unknown exporter %q, expected %s or %s", c.Exporter, TracingExporterOTLP, TracingExporterStderr)
+	}
+	if c.SampleRatio < 0 || c.SampleRatio > 1 {
+		return fmt.Errorf("sample ratio %v is not between 0 and 1", c.SampleRatio)
+	}
+
+	return nil
+}
+
+func viperBindTracing(prefix string, config *TracingConfig) error {
+	viper.SetEnvPrefix(prefix)
+	viper.AutomaticEnv()
+
+	viper.BindEnv("EXPORTER")
+	viper.BindEnv("ENDPOINT")
+	viper.BindEnv("INSECURE")
+	viper.BindEnv("SERVICE_NAME")
+	viper.BindEnv("SAMPLE_RATIO")
+
+	return viper.Unmarshal(&config)
+}
@@ -0,0 +1,88 @@
+package env
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadTracingConfig(t *testing.T) {
+	os.Setenv("TRACING_EXPORTER", "otlp")
+	os.Setenv("TRACING_ENDPOINT", "otel-collector:4317")
+	os.Setenv("TRACING_INSECURE", "true")
+	os.Setenv("TRACING_SERVICE_NAME", "users")
+	os.Setenv("TRACING_SAMPLE_RATIO", "0.25")
+
+	config, err := LoadTracingConfig()
+	assert.NoError(t, err)
+	assert.True(t, config.IsEnabled())
+	assert.Equal(t, TracingExporterOTLP, config.Exporter)
+	assert.Equal(t, "otel-collector:4317", config.Endpoint)
+	assert.True(t, config.Insecure)
+	assert.Equal(t, "users", config.ServiceName)
+	assert.Equal(t, 0.25, config.SampleRatio)
+
+	os.Unsetenv("TRACING_EXPORTER")
+	os.Unsetenv("TRACING_ENDPOINT")
+	os.Unsetenv("TRACING_INSECURE")
+	os.Unsetenv("TRACING_SERVICE_NAME")
+	os.Unsetenv("TRACING_SAMPLE_RATIO")
+}
+
+func TestLoadTracingConfig_Defaults(t *testing.T) {
+	config, err := LoadTracingConfig()
+	assert.NoError(t, err)
+	assert.False(t, config.IsEnabled())
+	assert.Equal(t, "localhost:4317", config.Endpoint)
+	assert.False(t, config.Insecure)
+	assert.Equal(t, "user-service", config.ServiceName)
+	assert.Equal(t, 1.0, config.SampleRatio)
+
+	// a ratio of 0 is kept, it turns recording off rather than falling back to the default
+	os.Setenv("TRACING_SAMPLE_RATIO", "0")
+	defer os.Unsetenv("TRACING_SAMPLE_RATIO")
+
+	config, err = LoadTracingConfig()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, config.SampleRatio)
+}
+
+func TestLoadTracingConfig_Invalid(t *testing.T) {
+	tests := []struct {
+		name        string
+		env         map[string]string
+		expectedErr string
+	}{
+		{
+			name:        "unknown exporter",
+			env:         map[string]string{"TRACING_EXPORTER": "zipkin"},
+			expectedErr: `unknown exporter "zipkin"`,
+		},
+		{
+			name:        "sample ratio over 1",
+			env:         map[string]string{"TRACING_SAMPLE_RATIO": "2"},
+			expectedErr: "sample ratio 2 is not between 0 and 1",
+		},
+		{
+			name:        "negative sample ratio",
+			env:         map[string]string{"TRACING_SAMPLE_RATIO": "-0.5"},
+			expectedErr: "sample ratio -0.5 is not between 0 and 1",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+			}
+
+			_, err := LoadTracingConfig()
+			assert.ErrorContains(t, err, tt.expectedErr)
+
+			for k := range tt.env {
+				os.Unsetenv(k)
+			}
+		})
+	}
+}
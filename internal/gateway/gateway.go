@@ -0,0 +1,147 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/EFG/api"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// route maps a REST method and path onto a unary UserService RPC. Path wildcards are copied
+// into the request field of the same name, the rest of the request comes from the JSON body
+// when body is set and from the query string otherwise.
+type route struct {
+	method   string
+	pattern  string
+	summary  string
+	body     bool
+	request  protoreflect.MessageType
+	response protoreflect.MessageType
+	invoke   func(ctx context.Context, req proto.Message, opts ...grpc.CallOption) (proto.Message, error)
+}
+
+func unaryRoute[Req, Resp proto.Message](method, pattern, summary string, body bool, call func(context.Context, Req, ...grpc.CallOption) (Resp, error)) route {
+	var req Req
+	var resp Resp
+
+	return route{
+		method:   method,
+		pattern:  pattern,
+		summary:  summary,
+		body:     body,
+		request:  req.ProtoReflect().Type(),
+		response: resp.ProtoReflect().Type(),
+		invoke: func(ctx context.Context, m proto.Message, opts ...grpc.CallOption) (proto.Message, error) {
+			return call(ctx, m.(Req), opts...)
+		},
+	}
+}
+
+func userServiceRoutes(client api.UserServiceClient) []route {
+	return []route{
+		unaryRoute(http.MethodPost, "/v1/users", "Create a new user", true, client.CreateUser),
+		unaryRoute(http.MethodGet, "/v1/users", "Get a paginated list of users with optional filters", false, client.GetUsers),
+		unaryRoute(http.MethodGet, "/v1/users/{id}", "Get a single user by ID", false, client.GetUser),
+		unaryRoute(http.MethodPatch, "/v1/users/{id}", "Modify an existing user", true, client.ModifyUser),
+		unaryRoute(http.MethodDelete, "/v1/users/{id}", "Soft delete an existing user, or remove them permanently with hard_delete", false, client.DeleteUser),
+		unaryRoute(http.MethodPost, "/v1/users/{id}/restore", "Bring back a soft deleted user that hasn't been purged yet", false, client.RestoreUser),
+		unaryRoute(http.MethodDelete, "/v1/users/{id}/purge", "Permanently remove a user, whether or not they were soft deleted first", false, client.PurgeUser),
+		unaryRoute(http.MethodGet, "/v1/users/{id}/history", "Get a page of the audit history of a user, newest change first", false, client.GetUserHistory),
+		unaryRoute(http.MethodGet, "/v1/users/{id}/roles", "List the roles a user holds", false, client.ListRoles),
+		unaryRoute(http.MethodPost, "/v1/users/{id}/roles", "Give a user a role", true, client.AssignRole),
+		unaryRoute(http.MethodDelete, "/v1/users/{id}/roles/{role}", "Take a role away from a user", false, client.RevokeRole),
+		unaryRoute(http.MethodGet, "/v1/roles", "List every role and the permissions it grants", false, client.ListRoles),
+		unaryRoute(http.MethodPost, "/v1/apiKeys", "Create an API key for a service, the key is only returned this once", true, client.CreateApiKey),
+		unaryRoute(http.MethodGet, "/v1/apiKeys", "List the API keys of the tenant, revoked keys included", false, client.ListApiKeys),
+		unaryRoute(http.MethodPost, "/v1/apiKeys/{id}/rotate", "Replace an API key with a new one, the old key stops working straight away", false, client.RotateApiKey),
+		unaryRoute(http.MethodDelete, "/v1/apiKeys/{id}", "Revoke an API key for good", false, client.RevokeApiKey),
+		unaryRoute(http.MethodPost, "/v1/users:authenticate", "Verify an email and password against the stored credentials", true, client.AuthenticateUser),
+		unaryRoute(http.MethodPost, "/v1/tokens:validate", "Verify the signature and lifetime of an access token", true, client.ValidateToken),
+		unaryRoute(http.MethodPost, "/v1/users:requestPasswordReset", "Send a single-use password reset token to the email if it belongs to a user", true, client.RequestPasswordReset),
+		unaryRoute(http.MethodPost, "/v1/users:completePasswordReset", "Set a new password using a password reset token", true, client.CompletePasswordReset),
+		unaryRoute(http.MethodPost, "/v1/users/{id}/email:sendVerification", "Send a single-use verification token to the user's pending or unverified email", false, client.SendEmailVerification),
+		unaryRoute(http.MethodPost, "/v1/users:confirmEmail", "Confirm ownership of the email a verification token was sent to", true, client.ConfirmEmail),
+	}
+}
+
+// KeySet publishes the public keys access tokens can be verified with.
+type KeySet interface {
+	JWKS() []byte
+}
+
+// jwksMaxAge is how long verifiers may cache the key set, short enough that a newly added
+// key is picked up well before it starts signing tokens.
+const jwksMaxAge = 5 * time.Minute
+
+type options struct {
+	keySet KeySet
+}
+
+// Option configures optional routes of the gateway.
+type Option func(*options)
+
+// WithJWKS serves the key set at /.well-known/jwks.json so other services can verify tokens offline.
+func WithJWKS(k KeySet) Option {
+	return func(o *options) {
+		o.keySet = k
+	}
+}
+
+// NewHandler returns an HTTP handler that transcodes REST/JSON requests onto the UserService
+// through client, so calls pass through the same gRPC server and interceptors as native clients.
+// The OpenAPI document describing the routes is served at /v1/openapi.json.
+func NewHandler(client api.UserServiceClient, opts ...Option) http.Handler {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	routes := userServiceRoutes(client)
+
+	mux := http.NewServeMux()
+	for _, rt := range routes {
+		mux.Handle(rt.method+" "+rt.pattern, rt)
+	}
+
+	document := openAPIDocument(routes, o.keySet != nil)
+	mux.HandleFunc("GET /v1/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(document)
+	})
+
+	if o.keySet != nil {
+		mux.HandleFunc("GET /.well-known/jwks.json", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge.Seconds())))
+			w.Write(o.keySet.JWKS())
+		})
+	}
+
+	return mux
+}
+
+func (rt route) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := rt.request.New().Interface()
+
+	if err := decodeRequest(w, r, req, rt.body); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var header metadata.MD
+	resp, err := rt.invoke(outgoingContext(r), req, grpc.Header(&header))
+	setResponseHeaders(w, header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	setETagHeader(w, resp)
+	writeMessage(w, http.StatusOK, resp)
+}
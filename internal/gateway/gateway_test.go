@@ -0,0 +1,424 @@
+package gateway
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/notifier"
+	"github.com/EFG/internal/ratelimit"
+	"github.com/EFG/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// setupGateway serves the user server over an in-memory listener and returns a gateway dialled to it,
+// the incoming metadata of the last call is recorded in lastMetadata.
+func setupGateway(t *testing.T, mockDatasource *postgres.MockClient, interceptors ...grpc.UnaryServerInterceptor) (http.Handler, *metadata.MD) {
+	lis := bufconn.Listen(1024 * 1024)
+
+	var lastMetadata metadata.MD
+	authenticationUnary, _ := server.NewAuthenticationInterceptors(nil, mockDatasource, false, time.Now)
+	auditUnary, _ := server.NewAuditInterceptors()
+	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(append([]grpc.UnaryServerInterceptor{func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		lastMetadata, _ = metadata.FromIncomingContext(ctx)
+		return handler(ctx, req)
+	}, authenticationUnary, auditUnary}, interceptors...)...))
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	api.RegisterUserServiceServer(grpcServer, server.NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow))
+
+	go grpcServer.Serve(lis)
+	t.Cleanup(grpcServer.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return NewHandler(api.NewUserServiceClient(conn)), &lastMetadata
+}
+
+func serve(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestGateway_CreateUser(t *testing.T) {
+	mockDatasource := &postgres.MockClient{UUID: "123e4567-e89b-12d3-a456-426614174000"}
+	handler, _ := setupGateway(t, mockDatasource)
+
+	rec := serve(handler, http.MethodPost, "/v1/users", `{
+		"firstName": "John",
+		"lastName": "Doe",
+		"nickname": "johndoe",
+		"email": "john.doe@example.com",
+		"password": "password123",
+		"country": "UK"
+	}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+	var resp map[string]any
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174000", resp["id"])
+
+	assert.Len(t, mockDatasource.GetCallHistory(), 1)
+	assert.Equal(t, "john.doe@example.com", mockDatasource.GetCallHistory()[0].Email.String)
+}
+
+func TestGateway_ValidationErrorIsBadRequestWithDetails(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+
+	rec := serve(handler, http.MethodPost, "/v1/users", `{"firstName": "John"}`)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+
+	var resp struct {
+		Code    int              `json:"code"`
+		Message string           `json:"message"`
+		Details []map[string]any `json:"details"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, 3, resp.Code)
+	assert.Contains(t, resp.Message, "LastName cannot be empty")
+	assert.Len(t, resp.Details, 1)
+	assert.Equal(t, "type.googleapis.com/google.rpc.BadRequest", resp.Details[0]["@type"])
+}
+
+func TestGateway_GetUsersFromQueryString(t *testing.T) {
+	mockDatasource := &postgres.MockClient{TotalUsers: 42}
+	handler, _ := setupGateway(t, mockDatasource)
+
+	rec := serve(handler, http.MethodGet, "/v1/users?page=1&pageSize=2&filter_country=US&order_by=last_name&filter_email_verified=false", "")
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var resp struct {
+		Users      []map[string]any `json:"users"`
+		TotalCount int              `json:"totalCount"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Len(t, resp.Users, 2)
+	assert.Equal(t, 42, resp.TotalCount)
+
+	assert.Len(t, mockDatasource.GetUsersCallHistory, 1)
+	args := mockDatasource.GetUsersCallHistory[0]
+	assert.Equal(t, int32(2), args.PageSize.Int32)
+	assert.Equal(t, "US", args.FilterCountry.String)
+	assert.Equal(t, []string{"last_name"}, args.OrderByFields)
+	assert.Equal(t, sql.NullBool{Bool: false, Valid: true}, args.FilterEmailVerified)
+}
+
+func TestGateway_QueryStringErrors(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+
+	tests := []struct {
+		name        string
+		target      string
+		expectedErr string
+	}{
+		{
+			name:        "unknown parameter",
+			target:      "/v1/users?colour=blue",
+			expectedErr: `unknown query parameter \"colour\"`,
+		},
+		{
+			name:        "malformed number",
+			target:      "/v1/users?page=first",
+			expectedErr: `invalid value \"first\" for \"page\"`,
+		},
+		{
+			name:        "repeated scalar",
+			target:      "/v1/users?page=1&page=2",
+			expectedErr: `parameter \"page\" can only be supplied once`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := serve(handler, http.MethodGet, tc.target, "")
+
+			assert.Equal(t, http.StatusBadRequest, rec.Code)
+			assert.Contains(t, rec.Body.String(), tc.expectedErr)
+		})
+	}
+}
+
+func TestGateway_GetUserByPath(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+
+	rec := serve(handler, http.MethodGet, "/v1/users/2", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"email":"jane.doe@example.com"`)
+
+	rec = serve(handler, http.MethodGet, "/v1/users/3", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestGateway_ModifyUserTakesIDFromPath(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+	handler, _ := setupGateway(t, mockDatasource)
+
+	rec := serve(handler, http.MethodPatch, "/v1/users/123e4567-e89b-12d3-a456-426614174001", `{
+		"id": "ignored",
+		"nickname": "",
+		"updateMask": "nickname"
+	}`)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Len(t, mockDatasource.GetCallHistory(), 1)
+	written := mockDatasource.GetCallHistory()[0]
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174001", written.ID.String)
+	assert.True(t, written.Nickname.Valid)
+	assert.False(t, written.FirstName.Valid)
+}
+
+func TestGateway_DeleteUserForwardsAuthorization(t *testing.T) {
+	handler, lastMetadata := setupGateway(t, &postgres.MockClient{})
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/users/123e4567-e89b-12d3-a456-426614174001", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.Header.Set("Grpc-Metadata-X-Trace", "abc")
+	req.Header.Set("Idempotency-Key", "delete-1")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Successfully deleted user")
+	assert.Equal(t, []string{"Bearer token"}, lastMetadata.Get("authorization"))
+	assert.Equal(t, []string{"abc"}, lastMetadata.Get("x-trace"))
+	assert.Equal(t, []string{"delete-1"}, lastMetadata.Get("idempotency-key"))
+}
+
+func TestGateway_ETagHeaders(t *testing.T) {
+	mockDatasource := &postgres.MockClient{Version: 4}
+	handler, _ := setupGateway(t, mockDatasource)
+
+	req := httptest.NewRequest(http.MethodPatch, "/v1/users/123e4567-e89b-12d3-a456-426614174001", strings.NewReader(`{"nickname": "jd"}`))
+	req.Header.Set("If-Match", `"4"`)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `"5"`, rec.Header().Get("ETag"))
+	assert.Equal(t, int64(4), mockDatasource.GetCallHistory()[0].Version.Int64)
+
+	// the etag is now stale
+	req = httptest.NewRequest(http.MethodDelete, "/v1/users/123e4567-e89b-12d3-a456-426614174001", nil)
+	req.Header.Set("If-Match", `"4"`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), "Version mismatch")
+
+	// a weak etag is compared as the etag it was issued as
+	req = httptest.NewRequest(http.MethodPatch, "/v1/users/123e4567-e89b-12d3-a456-426614174001", strings.NewReader(`{"nickname": "jd"}`))
+	req.Header.Set("If-Match", `W/"5"`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, int64(5), mockDatasource.GetCallHistory()[1].Version.Int64)
+
+	// * matches any version so the write is unconditional
+	req = httptest.NewRequest(http.MethodDelete, "/v1/users/123e4567-e89b-12d3-a456-426614174001", nil)
+	req.Header.Set("If-Match", "*")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	req = httptest.NewRequest(http.MethodDelete, "/v1/users/123e4567-e89b-12d3-a456-426614174001", nil)
+	req.Header.Set("If-Match", `"5", "6"`)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "If-Match must be a single etag or *")
+}
+
+func TestGateway_GetUserHistory(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UserHistory: []dto.UserAuditDTO{
+			{ID: 2, UserID: "123e4567-e89b-12d3-a456-426614174001", Action: "modify", Changes: []byte(`{"nickname": {"old": "jd", "new": "johnny"}}`), OccurredAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+			{ID: 1, UserID: "123e4567-e89b-12d3-a456-426614174001", Action: "create", Changes: []byte(`{"nickname": {"old": null, "new": "jd"}}`), OccurredAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+		},
+	}
+	handler, lastMetadata := setupGateway(t, mockDatasource)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/users/123e4567-e89b-12d3-a456-426614174001/history?page_size=1", nil)
+	req.Header.Set("X-Request-Id", "req-1")
+	req.Header.Set("X-Tenant-Id", "default")
+	req.Header.Set("Traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"newValue":"johnny"`)
+	assert.NotContains(t, rec.Body.String(), `"action":"create"`)
+	assert.Contains(t, rec.Body.String(), `"nextPageToken"`)
+	assert.Equal(t, []string{"req-1"}, lastMetadata.Get("x-request-id"))
+	assert.Equal(t, "req-1", rec.Header().Get("X-Request-Id"))
+	assert.Equal(t, []string{"default"}, lastMetadata.Get("x-tenant-id"))
+	assert.Equal(t, []string{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"}, lastMetadata.Get("traceparent"))
+	assert.Equal(t, []string{"192.0.2.1"}, lastMetadata.Get("x-forwarded-for"))
+}
+
+func TestGateway_RateLimited(t *testing.T) {
+	rateLimitUnary, _, err := server.NewRateLimitInterceptors(ratelimit.Limit{Rate: 0.1, Burst: 1}, nil, time.Now)
+	require.NoError(t, err)
+	handler, _ := setupGateway(t, &postgres.MockClient{}, rateLimitUnary)
+
+	rec := serve(handler, http.MethodGet, "/v1/roles", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// every HTTP client is limited by its own address rather than the gateway's
+	req := httptest.NewRequest(http.MethodGet, "/v1/roles", nil)
+	req.RemoteAddr = "192.0.2.2:1234"
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(handler, http.MethodGet, "/v1/roles", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "10", rec.Header().Get("Retry-After"))
+}
+
+func TestGateway_Roles(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+	handler, _ := setupGateway(t, mockDatasource)
+
+	rec := serve(handler, http.MethodPost, "/v1/users/123e4567-e89b-12d3-a456-426614174001/roles", `{"role":"editor"}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []string{"editor"}, mockDatasource.Roles["123e4567-e89b-12d3-a456-426614174001"])
+
+	rec = serve(handler, http.MethodGet, "/v1/users/123e4567-e89b-12d3-a456-426614174001/roles", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"users.write"`)
+
+	rec = serve(handler, http.MethodDelete, "/v1/users/123e4567-e89b-12d3-a456-426614174001/roles/editor", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, mockDatasource.Roles["123e4567-e89b-12d3-a456-426614174001"])
+
+	rec = serve(handler, http.MethodGet, "/v1/roles", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"name":"moderator"`)
+
+	rec = serve(handler, http.MethodPost, "/v1/users/123e4567-e89b-12d3-a456-426614174001/roles", `{"role":"superuser"}`)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+func TestGateway_ApiKeys(t *testing.T) {
+	mockDatasource := &postgres.MockClient{UUID: "123e4567-e89b-12d3-a456-426614174000"}
+	handler, lastMetadata := setupGateway(t, mockDatasource)
+
+	rec := serve(handler, http.MethodPost, "/v1/apiKeys", `{"name":"billing","scopes":["users.read"]}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	require.Len(t, mockDatasource.APIKeys, 1)
+
+	var created api.CreateApiKeyResponse
+	require.NoError(t, protojson.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "billing", created.ApiKey.Name)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/apiKeys", nil)
+	req.Header.Set("X-Api-Key", created.Key)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"billing"`)
+	assert.Equal(t, []string{created.Key}, lastMetadata.Get("x-api-key"))
+
+	rec = serve(handler, http.MethodPost, "/v1/apiKeys/"+created.ApiKey.Id+"/rotate", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = serve(handler, http.MethodDelete, "/v1/apiKeys/"+created.ApiKey.Id, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, mockDatasource.APIKeys[0].RevokedAt.Valid)
+
+	// the revoked key no longer authenticates
+	req = httptest.NewRequest(http.MethodGet, "/v1/apiKeys", nil)
+	req.Header.Set("X-Api-Key", created.Key)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestGateway_UnknownRouteAndMethod(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/v1/accounts", "").Code)
+	assert.Equal(t, http.StatusMethodNotAllowed, serve(handler, http.MethodPut, "/v1/users/1", "").Code)
+}
+
+func TestGateway_OpenAPIDocument(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+
+	rec := serve(handler, http.MethodGet, "/v1/openapi.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var document struct {
+		OpenAPI    string                               `json:"openapi"`
+		Paths      map[string]map[string]map[string]any `json:"paths"`
+		Components struct {
+			Schemas map[string]any `json:"schemas"`
+		} `json:"components"`
+	}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &document))
+	assert.Equal(t, "3.0.3", document.OpenAPI)
+
+	assert.Contains(t, document.Paths["/v1/users"], "post")
+	assert.Contains(t, document.Paths["/v1/users"], "get")
+	assert.Contains(t, document.Paths["/v1/users/{id}"], "patch")
+	assert.Contains(t, document.Paths["/v1/users/{id}"], "delete")
+	assert.Equal(t, "CreateUser", document.Paths["/v1/users"]["post"]["operationId"])
+
+	for _, schema := range []string{"CreateUserRequest", "GetUsersResponse", "User", "ModifyUserRequest", "Status"} {
+		assert.Contains(t, document.Components.Schemas, schema)
+	}
+}
+
+type staticKeySet string
+
+func (k staticKeySet) JWKS() []byte {
+	return []byte(k)
+}
+
+func TestGateway_JWKS(t *testing.T) {
+	handler, _ := setupGateway(t, &postgres.MockClient{})
+	assert.Equal(t, http.StatusNotFound, serve(handler, http.MethodGet, "/.well-known/jwks.json", "").Code)
+	assert.NotContains(t, serve(handler, http.MethodGet, "/v1/openapi.json", "").Body.String(), "/.well-known/jwks.json")
+
+	handler = NewHandler(api.NewUserServiceClient(nil), WithJWKS(staticKeySet(`{"keys":[{"kid":"2025-01"}]}`)))
+
+	rec := serve(handler, http.MethodGet, "/.well-known/jwks.json", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "public, max-age=300", rec.Header().Get("Cache-Control"))
+	assert.JSONEq(t, `{"keys":[{"kid":"2025-01"}]}`, rec.Body.String())
+	assert.Contains(t, serve(handler, http.MethodGet, "/v1/openapi.json", "").Body.String(), "/.well-known/jwks.json")
+}
@@ -0,0 +1,207 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+var pathParamPattern = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// openAPIDocument describes the gateway routes as an OpenAPI 3 document, schemas are derived
+// from the proto descriptors so the document can't drift from the messages on the wire.
+func openAPIDocument(routes []route, withJWKS bool) []byte {
+	schemas := map[string]any{
+		"Status": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"code":    map[string]any{"type": "integer", "format": "int32"},
+				"message": map[string]any{"type": "string"},
+				"details": map[string]any{"type": "array", "items": map[string]any{"type": "object"}},
+			},
+		},
+	}
+
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		request := rt.request.Descriptor()
+		response := rt.response.Descriptor()
+		addSchema(schemas, response)
+
+		var pathParams []string
+		for _, match := range pathParamPattern.FindAllStringSubmatch(rt.pattern, -1) {
+			pathParams = append(pathParams, match[1])
+		}
+
+		var parameters []any
+		for _, name := range pathParams {
+			parameters = append(parameters, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   fieldSchema(request.Fields().ByName(protoreflect.Name(name))),
+			})
+		}
+
+		operation := map[string]any{
+			"operationId": strings.TrimSuffix(string(request.Name()), "Request"),
+			"summary":     rt.summary,
+			"responses": map[string]any{
+				"200": map[string]any{
+					"description": "A successful response.",
+					"content":     jsonContent(schemaRef(response)),
+				},
+				"default": map[string]any{
+					"description": "An error response.",
+					"content":     jsonContent(map[string]any{"$ref": "#/components/schemas/Status"}),
+				},
+			},
+		}
+
+		if rt.body {
+			addSchema(schemas, request)
+			operation["requestBody"] = map[string]any{
+				"required": true,
+				"content":  jsonContent(schemaRef(request)),
+			}
+		} else {
+			fields := request.Fields()
+			for i := 0; i < fields.Len(); i++ {
+				fd := fields.Get(i)
+				if fd.Kind() == protoreflect.MessageKind || slices.Contains(pathParams, string(fd.Name())) {
+					continue
+				}
+				parameters = append(parameters, map[string]any{
+					"name":   string(fd.Name()),
+					"in":     "query",
+					"schema": fieldSchema(fd),
+				})
+			}
+		}
+
+		if len(parameters) > 0 {
+			operation["parameters"] = parameters
+		}
+
+		if paths[rt.pattern] == nil {
+			paths[rt.pattern] = map[string]any{}
+		}
+		paths[rt.pattern][strings.ToLower(rt.method)] = operation
+	}
+
+	paths["/v1/openapi.json"] = map[string]any{
+		strings.ToLower(http.MethodGet): map[string]any{
+			"operationId": "GetOpenAPIDocument",
+			"summary":     "This document",
+			"responses": map[string]any{
+				"200": map[string]any{"description": "The OpenAPI document for the gateway."},
+			},
+		},
+	}
+
+	if withJWKS {
+		paths["/.well-known/jwks.json"] = map[string]any{
+			strings.ToLower(http.MethodGet): map[string]any{
+				"operationId": "GetJSONWebKeySet",
+				"summary":     "Public keys for verifying access tokens",
+				"responses": map[string]any{
+					"200": map[string]any{"description": "The JSON Web Key Set of every active signing key."},
+				},
+			},
+		}
+	}
+
+	document := map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "UserService",
+			"version": "v1",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": schemas},
+	}
+
+	raw, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		// the document is built from plain maps so this can only be a programming error
+		panic(err)
+	}
+
+	return raw
+}
+
+// addSchema registers the schema for md and every message it references.
+func addSchema(schemas map[string]any, md protoreflect.MessageDescriptor) {
+	name := string(md.Name())
+	if _, ok := schemas[name]; ok || isWellKnown(md) {
+		return
+	}
+
+	properties := map[string]any{}
+	schemas[name] = map[string]any{"type": "object", "properties": properties}
+
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		properties[fd.JSONName()] = fieldSchema(fd)
+		if fd.Kind() == protoreflect.MessageKind {
+			addSchema(schemas, fd.Message())
+		}
+	}
+}
+
+func fieldSchema(fd protoreflect.FieldDescriptor) map[string]any {
+	var schema map[string]any
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		schema = map[string]any{"type": "string"}
+	case protoreflect.BoolKind:
+		schema = map[string]any{"type": "boolean"}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		schema = map[string]any{"type": "integer", "format": "int32"}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		// protojson renders 64 bit integers as strings
+		schema = map[string]any{"type": "string", "format": "int64"}
+	case protoreflect.EnumKind:
+		var names []string
+		values := fd.Enum().Values()
+		for i := 0; i < values.Len(); i++ {
+			names = append(names, string(values.Get(i).Name()))
+		}
+		schema = map[string]any{"type": "string", "enum": names}
+	case protoreflect.MessageKind:
+		schema = schemaRef(fd.Message())
+	default:
+		schema = map[string]any{}
+	}
+
+	if fd.IsList() {
+		return map[string]any{"type": "array", "items": schema}
+	}
+
+	return schema
+}
+
+func schemaRef(md protoreflect.MessageDescriptor) map[string]any {
+	switch md.FullName() {
+	case "google.protobuf.FieldMask":
+		return map[string]any{"type": "string", "description": "Comma separated field paths."}
+	case "google.protobuf.Timestamp":
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	return map[string]any{"$ref": "#/components/schemas/" + string(md.Name())}
+}
+
+func isWellKnown(md protoreflect.MessageDescriptor) bool {
+	return md.ParentFile().Package() == "google.protobuf"
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
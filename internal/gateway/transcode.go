@@ -0,0 +1,295 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	// registers the error detail types so they can be rendered in error responses
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// maxBodyBytes caps the size of a JSON request body.
+const maxBodyBytes = 1 << 20
+
+// metadataHeaderPrefix marks HTTP headers that are forwarded to the gRPC server as metadata
+// with the prefix removed, Authorization, Idempotency-Key, X-Request-Id and X-Tenant-Id are always forwarded.
+const metadataHeaderPrefix = "Grpc-Metadata-"
+
+var marshalOptions = protojson.MarshalOptions{EmitUnpopulated: true}
+
+// etagField is the field carrying a user's etag, over HTTP it is also exchanged through the
+// ETag and If-Match headers.
+const etagField = "etag"
+
+// decodeRequest fills req from the JSON body or the query string and then from the path
+// wildcards, which take precedence so the path always identifies the resource. An If-Match
+// header sets the etag of requests that take one.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req proto.Message, body bool) error {
+	if body {
+		raw, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "failed to read request body: %v", err)
+		}
+		if len(raw) > 0 {
+			if err := protojson.Unmarshal(raw, req); err != nil {
+				return status.Errorf(codes.InvalidArgument, "failed to decode request body: %v", err)
+			}
+		}
+	} else if err := populateQueryParams(req.ProtoReflect(), r); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	msg := req.ProtoReflect()
+	fields := msg.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if value := r.PathValue(string(fd.Name())); value != "" {
+			if err := setField(msg, fd, []string{value}); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+	}
+
+	if fd := fields.ByName(etagField); fd != nil {
+		etag, err := etagFromIfMatch(r.Header.Get("If-Match"))
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if etag != "" {
+			msg.Set(fd, protoreflect.ValueOfString(etag))
+		}
+	}
+
+	return nil
+}
+
+// etagFromIfMatch returns the etag an If-Match header makes a write conditional on. "*" matches
+// whatever the user currently is so sets no precondition, and a weak etag is taken as the etag it
+// was issued as. Only one etag can be given since a write is checked against a single version.
+func etagFromIfMatch(ifMatch string) (string, error) {
+	ifMatch = strings.TrimSpace(ifMatch)
+	if ifMatch == "" || ifMatch == "*" {
+		return "", nil
+	}
+
+	if strings.Contains(ifMatch, ",") {
+		return "", fmt.Errorf("If-Match must be a single etag or *")
+	}
+
+	return strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`), nil
+}
+
+// setETagHeader sets the ETag header from the user in resp, if it has one.
+func setETagHeader(w http.ResponseWriter, resp proto.Message) {
+	msg := resp.ProtoReflect()
+	fd := msg.Descriptor().Fields().ByName("user")
+	if fd == nil || fd.Message() == nil || !msg.Has(fd) {
+		return
+	}
+
+	user := msg.Get(fd).Message()
+	if etag := user.Descriptor().Fields().ByName(etagField); etag != nil && user.Get(etag).String() != "" {
+		w.Header().Set("ETag", `"`+user.Get(etag).String()+`"`)
+	}
+}
+
+// populateQueryParams sets a request field for every query parameter, parameters may use
+// either the proto or JSON name of the field.
+func populateQueryParams(msg protoreflect.Message, r *http.Request) error {
+	fields := msg.Descriptor().Fields()
+	for key, values := range r.URL.Query() {
+		fd := fields.ByName(protoreflect.Name(key))
+		if fd == nil {
+			fd = fields.ByJSONName(key)
+		}
+		if fd == nil {
+			return fmt.Errorf("unknown query parameter %q", key)
+		}
+
+		if err := setField(msg, fd, values); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func setField(msg protoreflect.Message, fd protoreflect.FieldDescriptor, values []string) error {
+	if fd.IsList() {
+		list := msg.Mutable(fd).List()
+		for _, raw := range values {
+			value, err := parseScalar(fd, raw)
+			if err != nil {
+				return err
+			}
+			list.Append(value)
+		}
+		return nil
+	}
+
+	if len(values) != 1 {
+		return fmt.Errorf("parameter %q can only be supplied once", fd.Name())
+	}
+
+	if fd.Kind() == protoreflect.MessageKind {
+		// well known messages such as a FieldMask have a string JSON form, decode it as such
+		value := msg.NewField(fd)
+		if err := protojson.Unmarshal([]byte(strconv.Quote(values[0])), value.Message().Interface()); err != nil {
+			return fmt.Errorf("invalid value %q for %q: %w", values[0], fd.Name(), err)
+		}
+		msg.Set(fd, value)
+		return nil
+	}
+
+	value, err := parseScalar(fd, values[0])
+	if err != nil {
+		return err
+	}
+	msg.Set(fd, value)
+
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, raw string) (protoreflect.Value, error) {
+	var value protoreflect.Value
+	var err error
+
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		value = protoreflect.ValueOfString(raw)
+	case protoreflect.BoolKind:
+		var b bool
+		b, err = strconv.ParseBool(raw)
+		value = protoreflect.ValueOfBool(b)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 10, 32)
+		value = protoreflect.ValueOfInt32(int32(n))
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		var n int64
+		n, err = strconv.ParseInt(raw, 10, 64)
+		value = protoreflect.ValueOfInt64(n)
+	case protoreflect.EnumKind:
+		ev := fd.Enum().Values().ByName(protoreflect.Name(raw))
+		if ev == nil {
+			return value, fmt.Errorf("invalid value %q for %q", raw, fd.Name())
+		}
+		value = protoreflect.ValueOfEnum(ev.Number())
+	default:
+		return value, fmt.Errorf("parameter %q can't be set from the query string", fd.Name())
+	}
+
+	if err != nil {
+		return value, fmt.Errorf("invalid value %q for %q: %w", raw, fd.Name(), err)
+	}
+
+	return value, nil
+}
+
+// outgoingContext carries the request's Authorization, X-Api-Key, Idempotency-Key, X-Request-Id, X-Tenant-Id,
+// Traceparent, Tracestate and Grpc-Metadata-* headers to the gRPC server as metadata, it is cancelled with the HTTP
+// request. The address of the HTTP client is passed on as x-forwarded-for, so anonymous callers are rate limited by
+// their own address rather than the gateway's.
+func outgoingContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	for name, values := range r.Header {
+		switch {
+		case name == "Authorization":
+			md.Append("authorization", values...)
+		case name == "X-Api-Key":
+			md.Append("x-api-key", values...)
+		case name == "Idempotency-Key":
+			md.Append("idempotency-key", values...)
+		case name == "X-Request-Id":
+			md.Append("x-request-id", values...)
+		case name == "X-Tenant-Id":
+			md.Append("x-tenant-id", values...)
+		case name == "Traceparent":
+			md.Append("traceparent", values...)
+		case name == "Tracestate":
+			md.Append("tracestate", values...)
+		case strings.HasPrefix(name, metadataHeaderPrefix):
+			key := strings.TrimPrefix(name, metadataHeaderPrefix)
+			md.Append(strings.ToLower(key), values...)
+		}
+	}
+
+	// set last so a Grpc-Metadata-X-Forwarded-For header can't claim another address
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		md.Set("x-forwarded-for", host)
+	}
+
+	return metadata.NewOutgoingContext(r.Context(), md)
+}
+
+// setResponseHeaders returns the request ID the gRPC server handled the call under and how long a rate limited
+// client has to wait, if it sent them.
+func setResponseHeaders(w http.ResponseWriter, header metadata.MD) {
+	if values := header.Get("x-request-id"); len(values) > 0 {
+		w.Header().Set("X-Request-Id", values[0])
+	}
+	if values := header.Get("retry-after"); len(values) > 0 {
+		w.Header().Set("Retry-After", values[0])
+	}
+}
+
+func writeMessage(w http.ResponseWriter, code int, msg proto.Message) {
+	raw, err := marshalOptions.Marshal(msg)
+	if err != nil {
+		slog.Error("failed to marshal gateway response", "error", err)
+		http.Error(w, "failed to marshal response", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(raw)
+}
+
+// writeError renders err as a google.rpc.Status JSON body with the HTTP status matching its code.
+func writeError(w http.ResponseWriter, err error) {
+	st := status.Convert(err)
+	writeMessage(w, httpStatusFromCode(st.Code()), st.Proto())
+}
+
+// httpStatusFromCode follows the canonical mapping of gRPC codes onto HTTP statuses.
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric the service exports.
+const namespace = "user_service"
+
+// Registry holds every metric the service exports, along with the Go runtime and process metrics.
+var Registry = newRegistry()
+
+var (
+	rpcRequests = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rpc_requests_total",
+		Help:      "RPCs handled, by full method name and status code.",
+	}, []string{"method", "code"})
+
+	rpcDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rpc_duration_seconds",
+		Help:      "Time taken to handle RPCs, by full method name and status code. Streams are measured until they end.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+
+	passwordHashDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "password_hash_duration_seconds",
+		Help:      "Time taken by bcrypt to hash a new password or compare one against its hash.",
+		// bcrypt is deliberately slow, the default buckets would put every observation in the top few
+		Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5},
+	}, []string{"operation"})
+
+	notifierPublishes = promauto.With(Registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "notifier_publishes_total",
+		Help:      "User changes published, by notifier and whether publishing succeeded.",
+	}, []string{"notifier", "outcome"})
+
+	notifierDuration = promauto.With(Registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "notifier_publish_duration_seconds",
+		Help:      "Time taken to publish user changes, by notifier.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"notifier"})
+
+	healthStatus = promauto.With(Registry).NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "health_status",
+		Help:      "Result of the last health check by service, 1 when serving and 0 when not.",
+	}, []string{"service"})
+)
+
+func newRegistry() *prometheus.Registry {
+	r := prometheus.NewRegistry()
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return r
+}
+
+// Handler serves every metric in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// RegisterDatabase exports the connection pool statistics of db under name, read when scraped.
+func RegisterDatabase(db *sql.DB, name string) error {
+	return Registry.Register(collectors.NewDBStatsCollector(db, name))
+}
+
+// ObserveRPC records an RPC handled with the status code it ended with.
+func ObserveRPC(method, code string, d time.Duration) {
+	rpcRequests.WithLabelValues(method, code).Inc()
+	rpcDuration.WithLabelValues(method, code).Observe(d.Seconds())
+}
+
+// ObservePasswordHash records how long a bcrypt operation, hash or compare, took.
+func ObservePasswordHash(operation string, d time.Duration) {
+	passwordHashDuration.WithLabelValues(operation).Observe(d.Seconds())
+}
+
+// ObservePublish records a user change published by notifier, failed when err is set.
+func ObservePublish(notifier string, err error, d time.Duration) {
+	outcome := "success"
+	if err != nil {
+		outcome = "failure"
+	}
+	notifierPublishes.WithLabelValues(notifier, outcome).Inc()
+	notifierDuration.WithLabelValues(notifier).Observe(d.Seconds())
+}
+
+// SetHealth records the result of the last health check of service.
+func SetHealth(service string, serving bool) {
+	value := 0.0
+	if serving {
+		value = 1
+	}
+	healthStatus.WithLabelValues(service).Set(value)
+}
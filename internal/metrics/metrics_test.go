@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRPC(t *testing.T) {
+	ObserveRPC("/api.UserService/GetUser", "OK", 10*time.Millisecond)
+	ObserveRPC("/api.UserService/GetUser", "OK", 20*time.Millisecond)
+	ObserveRPC("/api.UserService/GetUser", "NotFound", time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(rpcRequests.WithLabelValues("/api.UserService/GetUser", "OK")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(rpcRequests.WithLabelValues("/api.UserService/GetUser", "NotFound")))
+}
+
+func TestObservePublish(t *testing.T) {
+	ObservePublish("sns", nil, time.Millisecond)
+	ObservePublish("sns", errors.New("throttled"), time.Millisecond)
+	ObservePublish("sns", nil, time.Millisecond)
+
+	assert.Equal(t, 2.0, testutil.ToFloat64(notifierPublishes.WithLabelValues("sns", "success")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(notifierPublishes.WithLabelValues("sns", "failure")))
+}
+
+func TestSetHealth(t *testing.T) {
+	SetHealth("api.UserService", true)
+	assert.Equal(t, 1.0, testutil.ToFloat64(healthStatus.WithLabelValues("api.UserService")))
+
+	SetHealth("api.UserService", false)
+	assert.Equal(t, 0.0, testutil.ToFloat64(healthStatus.WithLabelValues("api.UserService")))
+}
+
+func TestHandler(t *testing.T) {
+	ObservePasswordHash("hash", 50*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `user_service_password_hash_duration_seconds_count{operation="hash"} 1`)
+	assert.Contains(t, rec.Body.String(), "go_goroutines")
+}
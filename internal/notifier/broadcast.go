@@ -0,0 +1,113 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/EFG/internal/service"
+)
+
+// subscriberBufferSize is how many changes a watcher can lag behind before it is dropped.
+const subscriberBufferSize = 64
+
+type subscriber struct {
+	filter  service.ChangeFilter
+	changes chan service.UserChange
+}
+
+// BroadcastNotifier fans out every user change to in-process watchers before
+// handing the message on to the next notifier, it keeps a bounded history so
+// watchers can resume from a point in time.
+type BroadcastNotifier struct {
+	next        service.Notifier
+	historySize int
+
+	mu          sync.Mutex
+	history     []service.UserChange
+	subscribers map[*subscriber]struct{}
+}
+
+func NewBroadcastNotifier(next service.Notifier, historySize int) *BroadcastNotifier {
+	return &BroadcastNotifier{
+		next:        next,
+		historySize: historySize,
+		subscribers: make(map[*subscriber]struct{}),
+	}
+}
+
+func (b *BroadcastNotifier) PublishUserChange(ctx context.Context, message []byte) error {
+	var change service.UserChange
+	if err := json.Unmarshal(message, &change); err != nil {
+		slog.Error("BroadcastNotifier: failed to unmarshal user change, skipping broadcast", "error", err)
+	} else {
+		b.broadcast(change)
+	}
+
+	return b.next.PublishUserChange(ctx, message)
+}
+
+func (b *BroadcastNotifier) Subscribe(ctx context.Context, filter service.ChangeFilter) (<-chan service.UserChange, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var replay []service.UserChange
+	for _, change := range b.history {
+		if filter.IsReplayable(change) {
+			replay = append(replay, change)
+		}
+	}
+
+	sub := &subscriber{
+		filter:  filter,
+		changes: make(chan service.UserChange, subscriberBufferSize+len(replay)),
+	}
+	for _, change := range replay {
+		sub.changes <- change
+	}
+	b.subscribers[sub] = struct{}{}
+
+	go func() {
+		<-ctx.Done()
+		b.unsubscribe(sub)
+	}()
+
+	return sub.changes, nil
+}
+
+func (b *BroadcastNotifier) broadcast(change service.UserChange) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.historySize > 0 {
+		if len(b.history) == b.historySize {
+			b.history = b.history[1:]
+		}
+		b.history = append(b.history, change)
+	}
+
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(change) {
+			continue
+		}
+		select {
+		case sub.changes <- change:
+		default:
+			// drop watchers that can't keep up rather than blocking writes
+			slog.Warn("BroadcastNotifier: dropping slow watcher")
+			delete(b.subscribers, sub)
+			close(sub.changes)
+		}
+	}
+}
+
+func (b *BroadcastNotifier) unsubscribe(sub *subscriber) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.changes)
+	}
+}
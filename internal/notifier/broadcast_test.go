@@ -0,0 +1,127 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func publishChange(t *testing.T, b *BroadcastNotifier, change service.UserChange) {
+	message, err := json.Marshal(change)
+	assert.NoError(t, err)
+	assert.NoError(t, b.PublishUserChange(context.Background(), message))
+}
+
+func TestBroadcastNotifier_FansOutToMatchingWatchers(t *testing.T) {
+	next := &MockNotifier{}
+	b := NewBroadcastNotifier(next, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	all, err := b.Subscribe(ctx, service.ChangeFilter{})
+	assert.NoError(t, err)
+
+	deletesOnly, err := b.Subscribe(ctx, service.ChangeFilter{ChangeTypes: []string{"delete"}})
+	assert.NoError(t, err)
+
+	singleUser, err := b.Subscribe(ctx, service.ChangeFilter{UserID: "2"})
+	assert.NoError(t, err)
+
+	singleTenant, err := b.Subscribe(ctx, service.ChangeFilter{TenantID: "brand-a"})
+	assert.NoError(t, err)
+
+	publishChange(t, b, service.UserChange{ChangeType: "create", UserID: "1", EventTime: "2025-01-01T00:00:00Z", TenantID: "brand-a"})
+	publishChange(t, b, service.UserChange{ChangeType: "delete", UserID: "2", EventTime: "2025-01-01T00:00:01Z", TenantID: "brand-b"})
+
+	assert.Len(t, all, 2)
+	assert.Len(t, deletesOnly, 1)
+	assert.Len(t, singleUser, 1)
+	assert.Len(t, singleTenant, 1)
+	assert.Equal(t, "2", (<-deletesOnly).UserID)
+
+	// the next notifier in the chain still receives every message
+	assert.Len(t, next.PublishedMessages, 2)
+}
+
+func TestBroadcastNotifier_ReplaysHistorySince(t *testing.T) {
+	b := NewBroadcastNotifier(&MockNotifier{}, 2)
+
+	publishChange(t, b, service.UserChange{ChangeType: "create", UserID: "1", EventTime: "2025-01-01T00:00:00Z"})
+	publishChange(t, b, service.UserChange{ChangeType: "modify", UserID: "1", EventTime: "2025-01-01T00:00:05Z"})
+	publishChange(t, b, service.UserChange{ChangeType: "delete", UserID: "1", EventTime: "2025-01-01T00:00:10Z"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := b.Subscribe(ctx, service.ChangeFilter{Since: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)})
+	assert.NoError(t, err)
+
+	// history is bounded so the oldest change has been evicted
+	assert.Len(t, changes, 2)
+	assert.Equal(t, "modify", (<-changes).ChangeType)
+	assert.Equal(t, "delete", (<-changes).ChangeType)
+
+	// no replay without a since timestamp
+	live, err := b.Subscribe(ctx, service.ChangeFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, live, 0)
+}
+
+func TestBroadcastNotifier_ClosesChannelWhenContextDone(t *testing.T) {
+	b := NewBroadcastNotifier(&MockNotifier{}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changes, err := b.Subscribe(ctx, service.ChangeFilter{})
+	assert.NoError(t, err)
+
+	cancel()
+
+	select {
+	case _, ok := <-changes:
+		assert.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("expected channel to be closed after context cancellation")
+	}
+}
+
+func TestBroadcastNotifier_DropsSlowWatchers(t *testing.T) {
+	b := NewBroadcastNotifier(&MockNotifier{}, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := b.Subscribe(ctx, service.ChangeFilter{})
+	assert.NoError(t, err)
+
+	for i := 0; i <= subscriberBufferSize; i++ {
+		publishChange(t, b, service.UserChange{ChangeType: "create", UserID: "1", EventTime: "2025-01-01T00:00:00Z"})
+	}
+
+	received := 0
+	for range changes {
+		received++
+	}
+	assert.Equal(t, subscriberBufferSize, received)
+}
+
+func TestBroadcastNotifier_PublishErrorFromNextNotifier(t *testing.T) {
+	b := NewBroadcastNotifier(&MockNotifier{TestRequiresPublishError: true}, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	changes, err := b.Subscribe(ctx, service.ChangeFilter{})
+	assert.NoError(t, err)
+
+	message, _ := json.Marshal(service.UserChange{ChangeType: "create", UserID: "1"})
+	err = b.PublishUserChange(context.Background(), message)
+	assert.Error(t, err)
+
+	// watchers are still told about the change
+	assert.Len(t, changes, 1)
+}
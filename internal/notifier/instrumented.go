@@ -0,0 +1,32 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/EFG/internal/metrics"
+	"github.com/EFG/internal/service"
+)
+
+// InstrumentedNotifier counts and times every publish of the notifier it wraps, labelled with name
+// so each implementation can be told apart.
+type InstrumentedNotifier struct {
+	next    service.Notifier
+	name    string
+	timeNow func() time.Time
+}
+
+func NewInstrumentedNotifier(next service.Notifier, name string, timeNow func() time.Time) *InstrumentedNotifier {
+	return &InstrumentedNotifier{
+		next:    next,
+		name:    name,
+		timeNow: timeNow,
+	}
+}
+
+func (n *InstrumentedNotifier) PublishUserChange(ctx context.Context, message []byte) error {
+	start := n.timeNow()
+	err := n.next.PublishUserChange(ctx, message)
+	metrics.ObservePublish(n.name, err, n.timeNow().Sub(start))
+	return err
+}
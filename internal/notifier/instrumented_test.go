@@ -0,0 +1,29 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstrumentedNotifier(t *testing.T) {
+	next := &MockNotifier{}
+	n := NewInstrumentedNotifier(next, "mock", time.Now)
+
+	assert.NoError(t, n.PublishUserChange(context.Background(), []byte(`{}`)))
+	assert.Len(t, next.PublishedMessages, 1)
+
+	next.TestRequiresPublishError = true
+	assert.Error(t, n.PublishUserChange(context.Background(), []byte(`{}`)))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `user_service_notifier_publishes_total{notifier="mock",outcome="success"} 1`)
+	assert.Contains(t, rec.Body.String(), `user_service_notifier_publishes_total{notifier="mock",outcome="failure"} 1`)
+	assert.Contains(t, rec.Body.String(), `user_service_notifier_publish_duration_seconds_count{notifier="mock"} 2`)
+}
@@ -3,6 +3,7 @@ package notifier
 import (
 	"context"
 	"errors"
+	"time"
 )
 
 type MockNotifier struct {
@@ -23,3 +24,39 @@ func (m *MockNotifier) PublishUserChange(ctx context.Context, message []byte) er
 
 	return nil
 }
+
+type MockPasswordResetSender struct {
+	SentTokens            map[string]string
+	TestRequiresSendError bool
+}
+
+func (m *MockPasswordResetSender) SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error {
+	if m.TestRequiresSendError {
+		return errors.New("mock send error")
+	}
+
+	if m.SentTokens == nil {
+		m.SentTokens = make(map[string]string)
+	}
+	m.SentTokens[email] = token
+
+	return nil
+}
+
+type MockEmailVerificationSender struct {
+	SentTokens            map[string]string
+	TestRequiresSendError bool
+}
+
+func (m *MockEmailVerificationSender) SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error {
+	if m.TestRequiresSendError {
+		return errors.New("mock send error")
+	}
+
+	if m.SentTokens == nil {
+		m.SentTokens = make(map[string]string)
+	}
+	m.SentTokens[email] = token
+
+	return nil
+}
@@ -3,6 +3,7 @@ package notifier
 import (
 	"context"
 	"log/slog"
+	"time"
 )
 
 type NoOpNotifier struct{}
@@ -15,3 +16,27 @@ func (n *NoOpNotifier) PublishUserChange(ctx context.Context, message []byte) er
 	slog.Info("NoOpNotifier: would have published", "message", string(message))
 	return nil
 }
+
+type NoOpPasswordResetSender struct{}
+
+func NewNoOpPasswordResetSender() *NoOpPasswordResetSender {
+	return &NoOpPasswordResetSender{}
+}
+
+// SendPasswordReset logs that a reset was requested, the token itself is never logged.
+func (n *NoOpPasswordResetSender) SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error {
+	slog.Info("NoOpPasswordResetSender: would have sent password reset", "email", email, "expiresAt", expiresAt)
+	return nil
+}
+
+type NoOpEmailVerificationSender struct{}
+
+func NewNoOpEmailVerificationSender() *NoOpEmailVerificationSender {
+	return &NoOpEmailVerificationSender{}
+}
+
+// SendEmailVerification logs that a verification was requested, the token itself is never logged.
+func (n *NoOpEmailVerificationSender) SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error {
+	slog.Info("NoOpEmailVerificationSender: would have sent email verification", "email", email, "expiresAt", expiresAt)
+	return nil
+}
@@ -19,7 +19,7 @@ func NewSNSNotifier(notification aws.SNS) *SNSNotifier {
 
 // PublishUserChange sends the notification via SNS
 func (n *SNSNotifier) PublishUserChange(ctx context.Context, message []byte) error {
-	messageID, err := n.snsClient.PublishMessage(message, n.snsClient.Config.UserChangeNotificationTopic)
+	messageID, err := n.snsClient.PublishMessage(ctx, message, n.snsClient.Config.UserChangeNotificationTopic)
 	if err != nil {
 		return err
 	}
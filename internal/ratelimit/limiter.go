@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that have refilled are dropped.
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled at Rate tokens a second and holding at most Burst, so Burst
+// calls can be made at once before being held to Rate.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter keeps a token bucket per key, every bucket with the same limit.
+type Limiter struct {
+	limit Limit
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{
+		limit:   limit,
+		buckets: map[string]*bucket{},
+	}
+}
+
+// Allow takes a token from the bucket of key. When the bucket is empty nothing is taken and the
+// time until a token is available is returned instead.
+func (l *Limiter) Allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), updated: now}
+		l.buckets[key] = b
+	} else if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = min(float64(l.limit.Burst), b.tokens+elapsed.Seconds()*l.limit.Rate)
+		b.updated = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - b.tokens) / l.limit.Rate * float64(time.Second))
+}
+
+// sweep drops the buckets that would have refilled since they were last used, a full bucket is no
+// different from a new one so only keys seen recently take up memory.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+
+	refill := time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.updated) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_Allow(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(Limit{Rate: 2, Burst: 3})
+
+	// a new caller can use the whole burst straight away
+	for i := 0; i < 3; i++ {
+		ok, _ := l.Allow("a", now)
+		assert.True(t, ok)
+	}
+
+	ok, retryAfter := l.Allow("a", now)
+	assert.False(t, ok)
+	assert.Equal(t, 500*time.Millisecond, retryAfter)
+
+	// other callers have buckets of their own
+	ok, _ = l.Allow("b", now)
+	assert.True(t, ok)
+
+	// tokens come back at the rate
+	ok, _ = l.Allow("a", now.Add(500*time.Millisecond))
+	assert.True(t, ok)
+	ok, retryAfter = l.Allow("a", now.Add(750*time.Millisecond))
+	assert.False(t, ok)
+	assert.Equal(t, 250*time.Millisecond, retryAfter)
+
+	// but never beyond the burst
+	for i := 0; i < 3; i++ {
+		ok, _ = l.Allow("a", now.Add(time.Hour))
+		assert.True(t, ok)
+	}
+	ok, _ = l.Allow("a", now.Add(time.Hour))
+	assert.False(t, ok)
+}
+
+func TestLimiter_Sweep(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	l := NewLimiter(Limit{Rate: 1, Burst: 1})
+
+	l.Allow("a", now)
+	l.Allow("b", now.Add(sweepInterval-time.Second/2))
+	assert.ElementsMatch(t, []string{"a", "b"}, keys(l))
+
+	// a has refilled by the next sweep, b was used too recently
+	l.Allow("c", now.Add(sweepInterval))
+	assert.ElementsMatch(t, []string{"b", "c"}, keys(l))
+}
+
+func keys(l *Limiter) []string {
+	var keys []string
+	for k := range l.buckets {
+		keys = append(keys, k)
+	}
+	return keys
+}
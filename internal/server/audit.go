@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// RequestIDHeader is the metadata key a request ID is read from and returned in.
+const RequestIDHeader = "x-request-id"
+
+// maxRequestIDLength keeps a caller supplied request ID to a sensible size for the audit log.
+const maxRequestIDLength = 128
+
+// NewAuditInterceptors tag every call with its caller and a request ID so the changes it makes are
+// attributed in the audit log. The caller is the one attached by the authentication interceptors and
+// is left empty for anonymous calls. A request ID sent by the caller is kept, otherwise one is
+// generated, either way it is returned in the response header.
+func NewAuditInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		return handler(withAuditInfo(ctx), req)
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, &auditedStream{ServerStream: ss, ctx: withAuditInfo(ss.Context())})
+	}
+
+	return unary, stream
+}
+
+// auditedStream swaps in a context carrying the audit info of the call.
+type auditedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *auditedStream) Context() context.Context {
+	return s.ctx
+}
+
+func withAuditInfo(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	info := service.AuditInfo{
+		Actor:     service.CallerFromContext(ctx).Actor(),
+		RequestID: firstMetadataValue(md, RequestIDHeader),
+	}
+	if info.RequestID == "" || len(info.RequestID) > maxRequestIDLength {
+		info.RequestID = newRequestID()
+	}
+
+	if err := grpc.SetHeader(ctx, metadata.Pairs(RequestIDHeader, info.RequestID)); err != nil {
+		slog.Warn("failed to set request id header", "error", err)
+	}
+
+	return service.WithAuditInfo(ctx, info)
+}
+
+func firstMetadataValue(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	// crypto/rand only fails if the OS can't supply randomness, in which case nothing else works either
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
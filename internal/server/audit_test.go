@@ -0,0 +1,81 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// headerStream records the headers a handler sets.
+type headerStream struct {
+	grpc.ServerTransportStream
+	header metadata.MD
+}
+
+func (s *headerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestAuditInterceptor(t *testing.T) {
+	tokens := &mockTokenIssuer{}
+	token, _, err := tokens.IssueToken(service.TokenClaims{UserID: "123e4567-e89b-12d3-a456-426614174001"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name              string
+		tokens            service.TokenIssuer
+		md                metadata.MD
+		expectedActor     string
+		expectedRequestID string
+	}{
+		{
+			name:              "valid token and request id",
+			tokens:            tokens,
+			md:                metadata.Pairs("authorization", "Bearer "+token, RequestIDHeader, "req-1"),
+			expectedActor:     "123e4567-e89b-12d3-a456-426614174001",
+			expectedRequestID: "req-1",
+		},
+		{
+			name:   "invalid token has no actor",
+			tokens: tokens,
+			md:     metadata.Pairs("authorization", "Bearer not-a-token"),
+		},
+		{
+			name:   "tokens not configured",
+			tokens: nil,
+			md:     metadata.Pairs("authorization", "Bearer "+token),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticationUnary, _ := NewAuthenticationInterceptors(tt.tokens, &postgres.MockClient{}, false, time.Now)
+			auditUnary, _ := NewAuditInterceptors()
+			unary := chainUnary(authenticationUnary, auditUnary)
+			stream := &headerStream{}
+			ctx := grpc.NewContextWithServerTransportStream(metadata.NewIncomingContext(context.Background(), tt.md), stream)
+
+			var info service.AuditInfo
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				info = service.AuditInfoFromContext(ctx)
+				return nil, nil
+			})
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedActor, info.Actor)
+			if tt.expectedRequestID != "" {
+				assert.Equal(t, tt.expectedRequestID, info.RequestID)
+			} else {
+				assert.Len(t, info.RequestID, 32)
+			}
+			assert.Equal(t, []string{info.RequestID}, stream.header.Get(RequestIDHeader))
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// APIKeyHeader is the metadata key services send their API key in.
+const APIKeyHeader = "x-api-key"
+
+// NewAuthenticationInterceptors work out who is making each call and attach them to its context as
+// the service.Caller every later interceptor and handler sees. A service is identified by an API key
+// in the x-api-key header and a user by a bearer token in the authorization header when tokens is
+// set. An API key that is unknown, expired or revoked fails the call, an invalid bearer token is
+// ignored and left for the RPC to reject if it needs one. With required set, calls to the
+// UserService without either are rejected, other services such as health checks are left alone.
+// The subject of a verified client certificate is attached as well but is never enough on its own.
+func NewAuthenticationInterceptors(tokens service.TokenIssuer, keys service.APIKeyStore, required bool, timeNow func() time.Time) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		caller, err := authenticate(ctx, tokens, keys, required, timeNow, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(service.WithCaller(ctx, caller), req)
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		caller, err := authenticate(ss.Context(), tokens, keys, required, timeNow, info.FullMethod)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: ss, ctx: service.WithCaller(ss.Context(), caller)})
+	}
+
+	return unary, stream
+}
+
+// authenticatedStream swaps in a context carrying the caller of the call.
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func authenticate(ctx context.Context, tokens service.TokenIssuer, keys service.APIKeyStore, required bool, timeNow func() time.Time, method string) (service.Caller, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	var caller service.Caller
+	if key := firstMetadataValue(md, APIKeyHeader); key != "" {
+		var err error
+		caller, err = service.AuthenticateAPIKey(ctx, keys, key, timeNow())
+		if err != nil {
+			return service.Caller{}, statusFromError(err)
+		}
+	} else if claims, ok := bearerClaims(md, tokens); ok {
+		caller = service.Caller{
+			UserID:   claims.UserID,
+			Email:    claims.Email,
+			TenantID: claims.TenantID,
+		}
+		// tokens issued before tenancy belong to the default tenant like their users
+		if caller.TenantID == "" {
+			caller.TenantID = service.DefaultTenant
+		}
+	}
+	caller.Certificate = certificateSubject(ctx)
+
+	if required && !caller.Authenticated() && isUserServiceMethod(method) {
+		return service.Caller{}, status.Error(codes.Unauthenticated, "an api key or access token is required")
+	}
+
+	if caller.Authenticated() {
+		slog.Debug("Authenticated call", "method", method, "actor", caller.Actor(), "apiKeyName", caller.APIKeyName, "certificate", caller.Certificate, "tenant", caller.TenantID)
+	}
+
+	return caller, nil
+}
+
+// bearerClaims returns the claims of a valid bearer token in the authorization header. An invalid
+// token is ignored here and left for the RPC to reject if it needs one.
+func bearerClaims(md metadata.MD, tokens service.TokenIssuer) (service.TokenClaims, bool) {
+	if tokens == nil {
+		return service.TokenClaims{}, false
+	}
+
+	token, ok := strings.CutPrefix(firstMetadataValue(md, "authorization"), "Bearer ")
+	if !ok || token == "" {
+		return service.TokenClaims{}, false
+	}
+
+	claims, err := tokens.ValidateToken(token)
+	if err != nil {
+		return service.TokenClaims{}, false
+	}
+
+	return claims, true
+}
+
+// certificateSubject returns the common name of the client certificate verified for the connection,
+// falling back to the whole subject for certificates without one. It is empty unless the listener
+// verifies client certificates.
+func certificateSubject(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return ""
+	}
+
+	subject := info.State.VerifiedChains[0][0].Subject
+	if subject.CommonName != "" {
+		return subject.CommonName
+	}
+	return subject.String()
+}
+
+func isUserServiceMethod(method string) bool {
+	return strings.HasPrefix(method, "/"+api.UserService_ServiceDesc.ServiceName+"/")
+}
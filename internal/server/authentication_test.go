@@ -0,0 +1,201 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// chainUnary runs the interceptors around a handler in order, the way grpc.ChainUnaryInterceptor does.
+func chainUnary(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+func TestAuthenticationInterceptor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tokens := &mockTokenIssuer{}
+	token, _, err := tokens.IssueToken(service.TokenClaims{UserID: "123e4567-e89b-12d3-a456-426614174001", TenantID: "brand-a"})
+	require.NoError(t, err)
+
+	keys := &postgres.MockClient{UUID: "123e4567-e89b-12d3-a456-426614174000"}
+	tenantCtx := service.WithTenant(context.Background(), "brand-a")
+	key, stored, err := service.CreateAPIKey(tenantCtx, keys, "billing", []string{"users.read"}, time.Time{})
+	require.NoError(t, err)
+	expiredKey, _, err := service.CreateAPIKey(tenantCtx, keys, "reports", []string{"users.read"}, now.Add(-time.Minute))
+	require.NoError(t, err)
+	revokedKey, revoked, err := service.CreateAPIKey(tenantCtx, keys, "legacy", []string{"users.read"}, time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, service.RevokeAPIKey(tenantCtx, keys, revoked.ID))
+
+	tests := []struct {
+		name           string
+		md             metadata.MD
+		certificate    string
+		method         string
+		required       bool
+		expectedCaller service.Caller
+		expectedCode   codes.Code
+	}{
+		{
+			name:   "api key",
+			md:     metadata.Pairs(APIKeyHeader, key),
+			method: api.UserService_GetUsers_FullMethodName,
+			expectedCaller: service.Caller{
+				APIKeyID:   stored.ID,
+				APIKeyName: "billing",
+				Scopes:     []service.Permission{service.PermissionRead},
+				TenantID:   "brand-a",
+			},
+		},
+		{
+			name:   "api key wins over a bearer token",
+			md:     metadata.Pairs(APIKeyHeader, key, "authorization", "Bearer "+token),
+			method: api.UserService_GetUsers_FullMethodName,
+			expectedCaller: service.Caller{
+				APIKeyID:   stored.ID,
+				APIKeyName: "billing",
+				Scopes:     []service.Permission{service.PermissionRead},
+				TenantID:   "brand-a",
+			},
+		},
+		{
+			name:         "unknown api key",
+			md:           metadata.Pairs(APIKeyHeader, service.APIKeyPrefix+"unknown"),
+			method:       api.UserService_GetUsers_FullMethodName,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "expired api key",
+			md:           metadata.Pairs(APIKeyHeader, expiredKey),
+			method:       api.UserService_GetUsers_FullMethodName,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "revoked api key",
+			md:           metadata.Pairs(APIKeyHeader, revokedKey),
+			method:       api.UserService_GetUsers_FullMethodName,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:   "bearer token",
+			md:     metadata.Pairs("authorization", "Bearer "+token),
+			method: api.UserService_GetUsers_FullMethodName,
+			expectedCaller: service.Caller{
+				UserID:   "123e4567-e89b-12d3-a456-426614174001",
+				TenantID: "brand-a",
+			},
+		},
+		{
+			name:   "invalid bearer token is anonymous",
+			md:     metadata.Pairs("authorization", "Bearer not-a-token"),
+			method: api.UserService_GetUsers_FullMethodName,
+		},
+		{
+			name:         "anonymous call when credentials are required",
+			md:           metadata.MD{},
+			method:       api.UserService_GetUsers_FullMethodName,
+			required:     true,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:           "client certificate subject",
+			md:             metadata.MD{},
+			certificate:    "billing",
+			method:         api.UserService_GetUsers_FullMethodName,
+			expectedCaller: service.Caller{Certificate: "billing"},
+		},
+		{
+			name:        "client certificate alongside a bearer token",
+			md:          metadata.Pairs("authorization", "Bearer "+token),
+			certificate: "gateway",
+			method:      api.UserService_GetUsers_FullMethodName,
+			expectedCaller: service.Caller{
+				UserID:      "123e4567-e89b-12d3-a456-426614174001",
+				TenantID:    "brand-a",
+				Certificate: "gateway",
+			},
+		},
+		{
+			name:         "client certificate alone when credentials are required",
+			md:           metadata.MD{},
+			certificate:  "billing",
+			method:       api.UserService_GetUsers_FullMethodName,
+			required:     true,
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:     "anonymous call to another service when credentials are required",
+			md:       metadata.MD{},
+			method:   "/grpc.health.v1.Health/Check",
+			required: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unary, _ := NewAuthenticationInterceptors(tokens, keys, tt.required, func() time.Time { return now })
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+			if tt.certificate != "" {
+				ctx = peer.NewContext(ctx, &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+					VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: tt.certificate}}}},
+				}}})
+			}
+
+			var caller service.Caller
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req any) (any, error) {
+				caller = service.CallerFromContext(ctx)
+				return nil, nil
+			})
+
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+			assert.Equal(t, tt.expectedCaller, caller)
+		})
+	}
+}
+
+func TestAuthenticationInterceptor_Stream(t *testing.T) {
+	keys := &postgres.MockClient{}
+	key, stored, err := service.CreateAPIKey(context.Background(), keys, "exporter", []string{"users.read"}, time.Time{})
+	require.NoError(t, err)
+
+	_, stream := NewAuthenticationInterceptors(nil, keys, true, time.Now)
+	info := &grpc.StreamServerInfo{FullMethod: api.UserService_ExportUsers_FullMethodName}
+
+	var caller service.Caller
+	handler := func(srv any, ss grpc.ServerStream) error {
+		caller = service.CallerFromContext(ss.Context())
+		return nil
+	}
+
+	err = stream(nil, &tenantStream{ctx: context.Background()}, info, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyHeader, key))
+	err = stream(nil, &tenantStream{ctx: ctx}, info, handler)
+	assert.NoError(t, err)
+	assert.Equal(t, stored.ID, caller.APIKeyID)
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// accessRule is what a caller needs to call an RPC.
+type accessRule struct {
+	// permission is required of the caller, empty for RPCs anyone can call without credentials
+	permission service.Permission
+	// owner returns the user a request acts on, a caller acting on their own record needs no permission
+	owner func(req any) string
+	// admin reports requests that need users.admin rather than permission
+	admin func(req any) bool
+}
+
+// accessRules hold the rule of every UserService RPC, an RPC missing from here is refused to everyone.
+var accessRules = map[string]accessRule{
+	// registering, signing in and the token based flows are how callers get an identity in the first place
+	api.UserService_CreateUser_FullMethodName:            {},
+	api.UserService_AuthenticateUser_FullMethodName:      {},
+	api.UserService_ValidateToken_FullMethodName:         {},
+	api.UserService_RequestPasswordReset_FullMethodName:  {},
+	api.UserService_CompletePasswordReset_FullMethodName: {},
+	api.UserService_ConfirmEmail_FullMethodName:          {},
+
+	api.UserService_GetUser_FullMethodName:          {permission: service.PermissionRead, owner: requestID},
+	api.UserService_GetUsers_FullMethodName:         {permission: service.PermissionRead},
+	api.UserService_ExportUsers_FullMethodName:      {permission: service.PermissionRead},
+	api.UserService_WatchUserChanges_FullMethodName: {permission: service.PermissionRead},
+	api.UserService_GetUserHistory_FullMethodName:   {permission: service.PermissionRead},
+
+	api.UserService_ModifyUser_FullMethodName:            {permission: service.PermissionWrite, owner: requestID},
+	api.UserService_SendEmailVerification_FullMethodName: {permission: service.PermissionWrite, owner: requestID},
+	api.UserService_ImportUsers_FullMethodName:           {permission: service.PermissionWrite},
+
+	api.UserService_DeleteUser_FullMethodName:  {permission: service.PermissionDelete, admin: isHardDelete},
+	api.UserService_RestoreUser_FullMethodName: {permission: service.PermissionDelete},
+
+	api.UserService_PurgeUser_FullMethodName:  {permission: service.PermissionAdmin},
+	api.UserService_AssignRole_FullMethodName: {permission: service.PermissionAdmin},
+	api.UserService_RevokeRole_FullMethodName: {permission: service.PermissionAdmin},
+	api.UserService_ListRoles_FullMethodName:  {permission: service.PermissionAdmin, owner: requestID},
+
+	api.UserService_CreateApiKey_FullMethodName: {permission: service.PermissionAdmin},
+	api.UserService_RotateApiKey_FullMethodName: {permission: service.PermissionAdmin},
+	api.UserService_RevokeApiKey_FullMethodName: {permission: service.PermissionAdmin},
+	api.UserService_ListApiKeys_FullMethodName:  {permission: service.PermissionAdmin},
+}
+
+// requestID returns the id field of the request, which every request acting on a single user carries.
+func requestID(req any) string {
+	if r, ok := req.(interface{ GetId() string }); ok {
+		return r.GetId()
+	}
+	return ""
+}
+
+func isHardDelete(req any) bool {
+	r, ok := req.(*api.DeleteUserRequest)
+	return ok && r.HardDelete
+}
+
+// NewAuthorizationInterceptors only let a call through when its caller holds the permission the RPC
+// needs. A service calling with an API key has the scopes of the key, and a user calling with an
+// access token has the permissions of the roles assigned to them in the tenant of the call. Users
+// can read and modify their own record without any role, and RPCs outside the UserService such as
+// health checks are left alone. Callers without credentials are let through when allowAnonymous is
+// set, for deployments that don't ask callers to identify themselves, except to RPCs needing
+// users.admin. A caller presenting credentials is always held to them.
+func NewAuthorizationInterceptors(roles service.RoleStore, allowAnonymous bool) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := authorize(ctx, roles, allowAnonymous, info.FullMethod, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	// the request of a stream isn't known yet, none of the streaming RPCs act on a single user anyway
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := authorize(ss.Context(), roles, allowAnonymous, info.FullMethod, nil); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+
+	return unary, stream
+}
+
+func authorize(ctx context.Context, roles service.RoleStore, allowAnonymous bool, method string, req any) error {
+	rule, ok := accessRules[method]
+	if !ok {
+		if isUserServiceMethod(method) {
+			slog.Error("refused call to an RPC without an access rule", "method", method)
+			return status.Error(codes.PermissionDenied, "permission denied")
+		}
+		return nil
+	}
+	if rule.permission == "" {
+		return nil
+	}
+
+	permission := rule.permission
+	if rule.admin != nil && req != nil && rule.admin(req) {
+		permission = service.PermissionAdmin
+	}
+
+	caller := service.CallerFromContext(ctx)
+	if !caller.Authenticated() {
+		// hard deletes, purges and handing out roles or keys can't be undone, so they always need a
+		// caller that can be held to account
+		if allowAnonymous && permission != service.PermissionAdmin {
+			return nil
+		}
+		return status.Error(codes.Unauthenticated, "a valid access token or api key is required")
+	}
+
+	if rule.owner != nil && caller.UserID != "" && req != nil && rule.owner(req) == caller.UserID {
+		return nil
+	}
+
+	var allowed bool
+	if caller.APIKeyID != "" {
+		allowed = slices.Contains(caller.Scopes, permission)
+	} else {
+		userRoles, err := service.GetUserRolesFromDatasource(ctx, roles, caller.UserID)
+		if err != nil {
+			return statusFromError(err)
+		}
+		allowed = service.HasPermission(userRoles, permission)
+	}
+
+	if !allowed {
+		slog.Warn("refused call without the required permission", "method", method, "actor", caller.Actor(), "permission", permission)
+		return status.Errorf(codes.PermissionDenied, "the %s permission is required", permission)
+	}
+
+	return nil
+}
@@ -0,0 +1,292 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthorizationInterceptor(t *testing.T) {
+	const (
+		viewerID = "123e4567-e89b-12d3-a456-426614174001"
+		adminID  = "123e4567-e89b-12d3-a456-426614174002"
+		plainID  = "123e4567-e89b-12d3-a456-426614174003"
+	)
+
+	tokens := &mockTokenIssuer{}
+	tokenFor := func(userID string) string {
+		token, _, err := tokens.IssueToken(service.TokenClaims{UserID: userID})
+		assert.NoError(t, err)
+		return token
+	}
+	viewerToken, adminToken, plainToken := tokenFor(viewerID), tokenFor(adminID), tokenFor(plainID)
+
+	roles := &postgres.MockClient{Roles: map[string][]string{
+		viewerID: {service.RoleViewer},
+		adminID:  {service.RoleAdmin},
+	}}
+	readerKey, _, err := service.CreateAPIKey(context.Background(), roles, "reader", []string{"users.read"}, time.Time{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		method       string
+		req          any
+		token        string
+		apiKey       string
+		expectedCode codes.Code
+	}{
+		{
+			name:   "public rpc without a token",
+			method: api.UserService_CreateUser_FullMethodName,
+			req:    &api.CreateUserRequest{},
+		},
+		{
+			name:         "protected rpc without a token",
+			method:       api.UserService_GetUsers_FullMethodName,
+			req:          &api.GetUsersRequest{},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "protected rpc with an invalid token",
+			method:       api.UserService_GetUsers_FullMethodName,
+			req:          &api.GetUsersRequest{},
+			token:        "not-a-token",
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:   "permission granted by role",
+			method: api.UserService_GetUsers_FullMethodName,
+			req:    &api.GetUsersRequest{},
+			token:  viewerToken,
+		},
+		{
+			name:         "permission not granted by role",
+			method:       api.UserService_DeleteUser_FullMethodName,
+			req:          &api.DeleteUserRequest{Id: plainID},
+			token:        viewerToken,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "user without roles",
+			method:       api.UserService_GetUsers_FullMethodName,
+			req:          &api.GetUsersRequest{},
+			token:        plainToken,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:   "user modifying their own record",
+			method: api.UserService_ModifyUser_FullMethodName,
+			req:    &api.ModifyUserRequest{Id: plainID},
+			token:  plainToken,
+		},
+		{
+			name:         "user modifying someone else's record",
+			method:       api.UserService_ModifyUser_FullMethodName,
+			req:          &api.ModifyUserRequest{Id: viewerID},
+			token:        plainToken,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:   "user listing their own roles",
+			method: api.UserService_ListRoles_FullMethodName,
+			req:    &api.ListRolesRequest{Id: plainID},
+			token:  plainToken,
+		},
+		{
+			name:         "user deleting their own record",
+			method:       api.UserService_DeleteUser_FullMethodName,
+			req:          &api.DeleteUserRequest{Id: plainID},
+			token:        plainToken,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:   "admin hard deleting",
+			method: api.UserService_DeleteUser_FullMethodName,
+			req:    &api.DeleteUserRequest{Id: plainID, HardDelete: true},
+			token:  adminToken,
+		},
+		{
+			name:   "admin assigning a role",
+			method: api.UserService_AssignRole_FullMethodName,
+			req:    &api.AssignRoleRequest{Id: plainID, Role: service.RoleEditor},
+			token:  adminToken,
+		},
+		{
+			name:   "permission granted by api key scope",
+			method: api.UserService_GetUsers_FullMethodName,
+			req:    &api.GetUsersRequest{},
+			apiKey: readerKey,
+		},
+		{
+			name:         "permission not granted by api key scope",
+			method:       api.UserService_ImportUsers_FullMethodName,
+			apiKey:       readerKey,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:         "unknown user service rpc",
+			method:       "/api.UserService/DropUsers",
+			token:        adminToken,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:   "rpc of another service",
+			method: "/grpc.health.v1.Health/Check",
+		},
+	}
+
+	authenticationUnary, _ := NewAuthenticationInterceptors(tokens, roles, false, time.Now)
+	authorizationUnary, _ := NewAuthorizationInterceptors(roles, false)
+	unary := chainUnary(authenticationUnary, authorizationUnary)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.token != "" {
+				md.Set("authorization", "Bearer "+tt.token)
+			}
+			if tt.apiKey != "" {
+				md.Set(APIKeyHeader, tt.apiKey)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+
+			called := false
+			_, err := unary(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req any) (any, error) {
+				called = true
+				return nil, nil
+			})
+
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+			assert.Equal(t, tt.expectedCode == codes.OK, called)
+		})
+	}
+}
+
+func TestAuthorizationInterceptor_AllowAnonymous(t *testing.T) {
+	const plainID = "123e4567-e89b-12d3-a456-426614174003"
+
+	tokens := &mockTokenIssuer{}
+	plainToken, _, err := tokens.IssueToken(service.TokenClaims{UserID: plainID})
+	assert.NoError(t, err)
+
+	roles := &postgres.MockClient{}
+	readerKey, _, err := service.CreateAPIKey(context.Background(), roles, "reader", []string{"users.read"}, time.Time{})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name         string
+		method       string
+		req          any
+		token        string
+		apiKey       string
+		expectedCode codes.Code
+	}{
+		{
+			name:   "anonymous caller",
+			method: api.UserService_GetUsers_FullMethodName,
+			req:    &api.GetUsersRequest{},
+		},
+		{
+			name:         "anonymous hard delete",
+			method:       api.UserService_DeleteUser_FullMethodName,
+			req:          &api.DeleteUserRequest{Id: plainID, HardDelete: true},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "anonymous purge",
+			method:       api.UserService_PurgeUser_FullMethodName,
+			req:          &api.PurgeUserRequest{Id: plainID},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "anonymous role assignment",
+			method:       api.UserService_AssignRole_FullMethodName,
+			req:          &api.AssignRoleRequest{Id: plainID, Role: service.RoleAdmin},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "anonymous api key creation",
+			method:       api.UserService_CreateApiKey_FullMethodName,
+			req:          &api.CreateApiKeyRequest{},
+			expectedCode: codes.Unauthenticated,
+		},
+		{
+			name:         "api key without the scope",
+			method:       api.UserService_PurgeUser_FullMethodName,
+			req:          &api.PurgeUserRequest{Id: plainID},
+			apiKey:       readerKey,
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:   "api key with the scope",
+			method: api.UserService_GetUsers_FullMethodName,
+			req:    &api.GetUsersRequest{},
+			apiKey: readerKey,
+		},
+		{
+			name:         "user without roles",
+			method:       api.UserService_GetUsers_FullMethodName,
+			req:          &api.GetUsersRequest{},
+			token:        plainToken,
+			expectedCode: codes.PermissionDenied,
+		},
+	}
+
+	authenticationUnary, _ := NewAuthenticationInterceptors(tokens, roles, false, time.Now)
+	authorizationUnary, _ := NewAuthorizationInterceptors(roles, true)
+	unary := chainUnary(authenticationUnary, authorizationUnary)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md := metadata.MD{}
+			if tt.token != "" {
+				md.Set("authorization", "Bearer "+tt.token)
+			}
+			if tt.apiKey != "" {
+				md.Set(APIKeyHeader, tt.apiKey)
+			}
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+
+			_, err := unary(ctx, tt.req, &grpc.UnaryServerInfo{FullMethod: tt.method}, func(ctx context.Context, req any) (any, error) {
+				return nil, nil
+			})
+
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+		})
+	}
+}
+
+func TestAuthorizationInterceptor_Stream(t *testing.T) {
+	roles := &postgres.MockClient{}
+	_, stream := NewAuthorizationInterceptors(roles, false)
+
+	ctx := service.WithCaller(context.Background(), service.Caller{UserID: "123e4567-e89b-12d3-a456-426614174001", TenantID: service.DefaultTenant})
+	info := &grpc.StreamServerInfo{FullMethod: api.UserService_ExportUsers_FullMethodName}
+	handler := func(srv any, ss grpc.ServerStream) error { return nil }
+
+	err := stream(nil, &tenantStream{ctx: ctx}, info, handler)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	roles.Roles = map[string][]string{"123e4567-e89b-12d3-a456-426614174001": {service.RoleViewer}}
+	err = stream(nil, &tenantStream{ctx: ctx}, info, handler)
+	assert.NoError(t, err)
+}
+
+func TestAccessRules_CoverEveryRPC(t *testing.T) {
+	for _, m := range api.UserService_ServiceDesc.Methods {
+		assert.Contains(t, accessRules, "/"+api.UserService_ServiceDesc.ServiceName+"/"+m.MethodName)
+	}
+	for _, s := range api.UserService_ServiceDesc.Streams {
+		assert.Contains(t, accessRules, "/"+api.UserService_ServiceDesc.ServiceName+"/"+s.StreamName)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/EFG/internal/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// statusFromError maps the service's typed errors onto canonical gRPC status codes so clients
+// can branch on the code rather than the message. Errors that already carry a status are
+// returned untouched and anything unclassified is left for gRPC to report as Unknown.
+func statusFromError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	var (
+		notFound        *service.NotFoundError
+		alreadyExists   *service.AlreadyExistsError
+		invalid         *service.InvalidArgumentError
+		unavailable     *service.UnavailableError
+		unauthenticated *service.UnauthenticatedError
+		precondition    *service.FailedPreconditionError
+		aborted         *service.AbortedError
+	)
+
+	switch {
+	case errors.As(err, &invalid):
+		return invalidArgumentStatus(err, invalid)
+	case errors.As(err, &notFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.As(err, &alreadyExists):
+		return status.Error(codes.AlreadyExists, err.Error())
+	case errors.As(err, &unauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.As(err, &precondition):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	case errors.As(err, &aborted):
+		return status.Error(codes.Aborted, err.Error())
+	case errors.As(err, &unavailable):
+		return status.Error(codes.Unavailable, err.Error())
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return status.FromContextError(err).Err()
+	}
+
+	return err
+}
+
+// invalidArgumentStatus attaches each violation as a BadRequest field violation.
+func invalidArgumentStatus(err error, invalid *service.InvalidArgumentError) error {
+	badRequest := &errdetails.BadRequest{}
+	for _, v := range invalid.Violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       v.Field,
+			Description: v.Description,
+		})
+	}
+
+	st, detailsErr := status.New(codes.InvalidArgument, err.Error()).WithDetails(badRequest)
+	if detailsErr != nil {
+		slog.Error("failed to attach bad request details", "error", detailsErr)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusFromError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want codes.Code
+	}{
+		{
+			name: "not found",
+			err:  fmt.Errorf("failed to get user: %w", service.ErrUserNotFound),
+			want: codes.NotFound,
+		},
+		{
+			name: "already exists",
+			err:  fmt.Errorf("failed to create user: %w: john.doe@example.com", service.ErrEmailAlreadyExists),
+			want: codes.AlreadyExists,
+		},
+		{
+			name: "invalid argument",
+			err:  fmt.Errorf("database error: %w", service.NewInvalidArgumentError("", "Invalid input: page must be >= 1.")),
+			want: codes.InvalidArgument,
+		},
+		{
+			name: "failed precondition",
+			err:  fmt.Errorf("failed to create email verification token: %w", service.ErrEmailAlreadyVerified),
+			want: codes.FailedPrecondition,
+		},
+		{
+			name: "aborted",
+			err:  fmt.Errorf("failed to modify user: %w", &service.AbortedError{Msg: "Version mismatch: user with id 1 has been changed since version 2."}),
+			want: codes.Aborted,
+		},
+		{
+			name: "unavailable",
+			err:  fmt.Errorf("database error: %w", &service.UnavailableError{Err: errors.New("connection refused")}),
+			want: codes.Unavailable,
+		},
+		{
+			name: "deadline exceeded",
+			err:  fmt.Errorf("database error: %w", context.DeadlineExceeded),
+			want: codes.DeadlineExceeded,
+		},
+		{
+			name: "existing status is kept",
+			err:  status.Error(codes.ResourceExhausted, "slow down"),
+			want: codes.ResourceExhausted,
+		},
+		{
+			name: "unclassified",
+			err:  errors.New("something went wrong"),
+			want: codes.Unknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := statusFromError(tt.err)
+			assert.Equal(t, tt.want, status.Code(err))
+			assert.Contains(t, err.Error(), status.Convert(tt.err).Message())
+		})
+	}
+
+	assert.NoError(t, statusFromError(nil))
+}
+
+func TestStatusFromError_InvalidArgumentDetails(t *testing.T) {
+	err := statusFromError(&service.InvalidArgumentError{Violations: []service.FieldViolation{
+		{Field: "first_name", Description: "FirstName cannot be empty"},
+		{Field: "email", Description: "Email cannot be empty"},
+	}})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Equal(t, "FirstName cannot be empty; Email cannot be empty", st.Message())
+	assert.Len(t, st.Details(), 1)
+
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	assert.True(t, ok)
+	assert.Len(t, badRequest.GetFieldViolations(), 2)
+	assert.Equal(t, "first_name", badRequest.GetFieldViolations()[0].GetField())
+	assert.Equal(t, "Email cannot be empty", badRequest.GetFieldViolations()[1].GetDescription())
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+// IdempotencyKeyHeader is the metadata key clients send an idempotency key in.
+const IdempotencyKeyHeader = "idempotency-key"
+
+// maxIdempotencyKeyLength leaves room for a UUID or any reasonable client generated key.
+const maxIdempotencyKeyLength = 255
+
+// idempotencyReservationTTL is how long a key is held for a request that is still running. A request
+// that never finishes, say because the server stopped, only blocks retries for this long.
+const idempotencyReservationTTL = time.Minute
+
+// idempotentResponses are the RPCs that honour an idempotency key, each with a constructor for its
+// response so a stored response can be decoded and replayed.
+var idempotentResponses = map[string]func() proto.Message{
+	api.UserService_CreateUser_FullMethodName:  func() proto.Message { return &api.CreateUserResponse{} },
+	api.UserService_ModifyUser_FullMethodName:  func() proto.Message { return &api.ModifyUserResponse{} },
+	api.UserService_DeleteUser_FullMethodName:  func() proto.Message { return &api.DeleteUserResponse{} },
+	api.UserService_RestoreUser_FullMethodName: func() proto.Message { return &api.RestoreUserResponse{} },
+	api.UserService_PurgeUser_FullMethodName:   func() proto.Message { return &api.PurgeUserResponse{} },
+}
+
+// NewIdempotencyInterceptor makes mutating RPCs safe to retry. When a call carries an idempotency-key
+// header its successful response is stored for ttl, and a retry of the same request with the same key
+// gets that response back without the user being written or the change published again. Failed calls
+// aren't stored so they can be retried for real, and calls without a key are untouched.
+func NewIdempotencyInterceptor(store service.IdempotencyStore, ttl time.Duration, timeNow func() time.Time) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		newResponse, ok := idempotentResponses[info.FullMethod]
+		if !ok {
+			return handler(ctx, req)
+		}
+
+		key := idempotencyKeyFromContext(ctx)
+		if key == "" {
+			return handler(ctx, req)
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return nil, statusFromError(service.NewInvalidArgumentError(IdempotencyKeyHeader, "idempotency key cannot be longer than %d characters", maxIdempotencyKeyLength))
+		}
+
+		requestHash, err := hashRequest(req)
+		if err != nil {
+			return nil, err
+		}
+
+		now := timeNow()
+		stored, replay, err := service.ReserveIdempotencyKey(ctx, store, key, info.FullMethod, requestHash, now.Add(idempotencyReservationTTL), now)
+		if err != nil {
+			return nil, statusFromError(err)
+		}
+		if replay {
+			resp := newResponse()
+			if err := proto.Unmarshal(stored, resp); err != nil {
+				return nil, fmt.Errorf("failed to decode stored response: %w", err)
+			}
+			return resp, nil
+		}
+
+		// the key has to be settled even when the client has given up, that is exactly when it retries
+		settleCtx := context.WithoutCancel(ctx)
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			service.ReleaseIdempotencyKey(settleCtx, store, key, info.FullMethod)
+			return nil, err
+		}
+
+		raw, marshalErr := proto.Marshal(resp.(proto.Message))
+		if marshalErr != nil {
+			slog.Error("failed to encode response for idempotency key", "method", info.FullMethod, "error", marshalErr)
+			service.ReleaseIdempotencyKey(settleCtx, store, key, info.FullMethod)
+			return resp, nil
+		}
+
+		// the change has been made by now, failing to store the response mustn't fail the call
+		service.CompleteIdempotencyKey(settleCtx, store, key, info.FullMethod, raw, timeNow().Add(ttl))
+
+		return resp, nil
+	}
+}
+
+func idempotencyKeyFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	if values := md.Get(IdempotencyKeyHeader); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// hashRequest fingerprints a request so a key can't replay a response to a different request.
+func hashRequest(req any) (string, error) {
+	raw, err := proto.MarshalOptions{Deterministic: true}.Marshal(req.(proto.Message))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
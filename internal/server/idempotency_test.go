@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/notifier"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestIdempotencyInterceptor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockTimeNow := func() time.Time { return now }
+
+	createInfo := &grpc.UnaryServerInfo{FullMethod: api.UserService_CreateUser_FullMethodName}
+	createReq := &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "US",
+		Nickname:  "johndoe",
+	}
+	withKey := func(key string) context.Context {
+		return metadata.NewIncomingContext(context.Background(), metadata.Pairs(IdempotencyKeyHeader, key))
+	}
+
+	setup := func() (*postgres.MockClient, *notifier.MockNotifier, grpc.UnaryServerInterceptor, grpc.UnaryHandler) {
+		mockDatasource := &postgres.MockClient{UUID: "123e4567-e89b-12d3-a456-426614174000"}
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+		handler := func(ctx context.Context, req any) (any, error) {
+			return srv.CreateUser(ctx, req.(*api.CreateUserRequest))
+		}
+		return mockDatasource, mockNotifier, NewIdempotencyInterceptor(mockDatasource, time.Hour, mockTimeNow), handler
+	}
+
+	t.Run("retry replays the original response", func(t *testing.T) {
+		mockDatasource, mockNotifier, interceptor, handler := setup()
+
+		first, err := interceptor(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+		retry, err := interceptor(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+
+		assert.Equal(t, first.(*api.CreateUserResponse).Id, retry.(*api.CreateUserResponse).Id)
+		assert.Len(t, mockDatasource.GetCallHistory(), 1)
+		assert.Len(t, mockNotifier.PublishedMessages, 1)
+	})
+
+	t.Run("same key from another caller runs again", func(t *testing.T) {
+		mockDatasource, mockNotifier, interceptor, handler := setup()
+
+		alice := service.WithCaller(withKey("key-1"), service.Caller{APIKeyID: "key-alice"})
+		bob := service.WithCaller(withKey("key-1"), service.Caller{APIKeyID: "key-bob"})
+
+		_, err := interceptor(alice, createReq, createInfo, handler)
+		assert.NoError(t, err)
+		// bob choosing the same key is never handed alice's response
+		_, err = interceptor(bob, createReq, createInfo, handler)
+		assert.NoError(t, err)
+
+		assert.Len(t, mockDatasource.GetCallHistory(), 2)
+		assert.Len(t, mockNotifier.PublishedMessages, 2)
+	})
+
+	t.Run("expired key runs again", func(t *testing.T) {
+		mockDatasource, _, interceptor, handler := setup()
+
+		_, err := interceptor(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+
+		later := NewIdempotencyInterceptor(mockDatasource, time.Hour, func() time.Time { return now.Add(2 * time.Hour) })
+		_, err = later(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+		assert.Len(t, mockDatasource.GetCallHistory(), 2)
+	})
+
+	t.Run("key reused for a different request", func(t *testing.T) {
+		_, _, interceptor, handler := setup()
+
+		_, err := interceptor(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+
+		otherReq := &api.CreateUserRequest{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@example.com", Password: "password123", Country: "US"}
+		_, err = interceptor(withKey("key-1"), otherReq, createInfo, handler)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "idempotency key has already been used for a different request")
+	})
+
+	t.Run("key still in progress", func(t *testing.T) {
+		_, _, interceptor, _ := setup()
+
+		inProgress := func(ctx context.Context, req any) (any, error) {
+			// a retry arriving while the first call is running
+			_, err := interceptor(withKey("key-1"), createReq, createInfo, func(context.Context, any) (any, error) {
+				t.Fatal("retry must not run the handler")
+				return nil, nil
+			})
+			assert.Equal(t, codes.Aborted, status.Code(err))
+			return &api.CreateUserResponse{Id: "1"}, nil
+		}
+
+		_, err := interceptor(withKey("key-1"), createReq, createInfo, inProgress)
+		assert.NoError(t, err)
+	})
+
+	t.Run("failed call releases the key", func(t *testing.T) {
+		mockDatasource, _, interceptor, handler := setup()
+
+		failing := func(ctx context.Context, req any) (any, error) {
+			return nil, status.Error(codes.Unavailable, "try again")
+		}
+		_, err := interceptor(withKey("key-1"), createReq, createInfo, failing)
+		assert.Equal(t, codes.Unavailable, status.Code(err))
+
+		_, err = interceptor(withKey("key-1"), createReq, createInfo, handler)
+		assert.NoError(t, err)
+		assert.Len(t, mockDatasource.GetCallHistory(), 1)
+	})
+
+	t.Run("without a key every call runs", func(t *testing.T) {
+		mockDatasource, _, interceptor, handler := setup()
+
+		_, err := interceptor(context.Background(), createReq, createInfo, handler)
+		assert.NoError(t, err)
+		_, err = interceptor(context.Background(), createReq, createInfo, handler)
+		assert.NoError(t, err)
+		assert.Len(t, mockDatasource.GetCallHistory(), 2)
+		assert.Empty(t, mockDatasource.IdempotencyKeys)
+	})
+
+	t.Run("read only methods ignore the key", func(t *testing.T) {
+		mockDatasource, _, interceptor, _ := setup()
+
+		info := &grpc.UnaryServerInfo{FullMethod: api.UserService_GetUser_FullMethodName}
+		calls := 0
+		handler := func(ctx context.Context, req any) (any, error) {
+			calls++
+			return &api.GetUserResponse{}, nil
+		}
+		interceptor(withKey("key-1"), &api.GetUserRequest{Id: "1"}, info, handler)
+		interceptor(withKey("key-1"), &api.GetUserRequest{Id: "1"}, info, handler)
+		assert.Equal(t, 2, calls)
+		assert.Empty(t, mockDatasource.IdempotencyKeys)
+	})
+
+	t.Run("key too long", func(t *testing.T) {
+		_, _, interceptor, handler := setup()
+
+		_, err := interceptor(withKey(strings.Repeat("k", 256)), createReq, createInfo, handler)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+)
+
+// importBatchSize is the number of valid rows written to the datasource in one round trip.
+const importBatchSize = 500
+
+type pendingImport struct {
+	index int
+	user  service.User
+}
+
+func (s *server) ImportUsers(stream grpc.ClientStreamingServer[api.CreateUserRequest, api.ImportUsersResponse]) error {
+	ctx := stream.Context()
+
+	var results []*api.ImportUserResult
+	var batch []pendingImport
+
+	// flush writes the batch, an error is only returned once the client has gone away or the
+	// deadline has passed since nothing more can be written for them
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() { batch = batch[:0] }()
+
+		users := make(service.Users, len(batch))
+		for i, p := range batch {
+			users[i] = p.user
+		}
+
+		ids, err := service.FormatNewUsersAndPersist(ctx, s.Datasource, users)
+		switch {
+		case err == nil:
+			for i, p := range batch {
+				results = append(results, s.importResult(stream, p, ids[i]))
+			}
+		case isRowError(err):
+			// the batch is written in one statement so a single bad row fails all of them, write
+			// each row on its own to find out which
+			slog.Error("failed to write import batch, retrying row by row", "rows", len(batch), "error", err)
+			for _, p := range batch {
+				results = append(results, s.importUser(stream, p))
+			}
+		default:
+			// retrying row by row can't get past a lost database or client, it would only cost a
+			// bcrypt hash and a round trip per row
+			slog.Error("failed to write import batch", "rows", len(batch), "error", err)
+			for _, p := range batch {
+				results = append(results, &api.ImportUserResult{
+					Index: int32(p.index),
+					Email: p.user.Email,
+					Error: importErrorMessage(err),
+				})
+			}
+		}
+
+		return ctx.Err()
+	}
+
+	for index := 0; ; index++ {
+		req, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			slog.Error("failed to receive import user request", "error", err)
+			return err
+		}
+
+		if err := validateCreateUserRequest(req); err != nil {
+			results = append(results, &api.ImportUserResult{
+				Index: int32(index),
+				Email: req.Email,
+				Error: err.Error(),
+			})
+			continue
+		}
+
+		batch = append(batch, pendingImport{index: index, user: service.NewUserFromCreateRequest(req)})
+		if len(batch) == importBatchSize {
+			if err := flush(); err != nil {
+				return statusFromError(err)
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return statusFromError(err)
+	}
+
+	// validation failures are recorded straight away so put everything back in stream order
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Index < results[j].Index
+	})
+
+	resp := &api.ImportUsersResponse{Results: results}
+	for _, r := range results {
+		if r.Error == "" {
+			resp.CreatedCount++
+		} else {
+			resp.FailedCount++
+		}
+	}
+
+	slog.Info("Imported users", "created", resp.CreatedCount, "failed", resp.FailedCount)
+
+	return stream.SendAndClose(resp)
+}
+
+// importUser writes a single row of an import.
+func (s *server) importUser(stream grpc.ServerStream, p pendingImport) *api.ImportUserResult {
+	ids, err := service.FormatNewUsersAndPersist(stream.Context(), s.Datasource, service.Users{p.user})
+	if err != nil {
+		slog.Error("failed to import user", "index", p.index, "error", err)
+		return &api.ImportUserResult{
+			Index: int32(p.index),
+			Email: p.user.Email,
+			Error: importErrorMessage(err),
+		}
+	}
+
+	return s.importResult(stream, p, ids[0])
+}
+
+// importResult reports a written row, an empty id means the email was already taken.
+func (s *server) importResult(stream grpc.ServerStream, p pendingImport, id string) *api.ImportUserResult {
+	result := &api.ImportUserResult{
+		Index: int32(p.index),
+		Email: p.user.Email,
+	}
+
+	if id == "" {
+		result.Error = fmt.Sprintf("email already exists: %s", p.user.Email)
+		return result
+	}
+
+	result.Id = id
+	s.notifyImportedUser(stream, id)
+
+	return result
+}
+
+// isRowError reports whether a batch failed because of what one of its rows holds, the rows are
+// then worth writing one at a time.
+func isRowError(err error) bool {
+	var (
+		alreadyExists *service.AlreadyExistsError
+		invalid       *service.InvalidArgumentError
+	)
+	return errors.As(err, &alreadyExists) || errors.As(err, &invalid)
+}
+
+// importErrorMessage is the error reported against a row that could not be written. Only the
+// service's typed errors are meant for callers, anything else may carry datasource details and
+// is reported generically, the full error having been logged.
+func importErrorMessage(err error) string {
+	var (
+		alreadyExists *service.AlreadyExistsError
+		invalid       *service.InvalidArgumentError
+	)
+
+	switch {
+	case errors.As(err, &alreadyExists):
+		return alreadyExists.Error()
+	case errors.As(err, &invalid):
+		return invalid.Error()
+	}
+
+	return "failed to create user"
+}
+
+// notifyImportedUser publishes the create notification for an imported user, a failure is
+// logged rather than failing the row since the user has already been written.
+func (s *server) notifyImportedUser(stream grpc.ServerStream, id string) {
+	userChangeNotification := service.CreateUserChangeNotification("create", id, s.timeNow())
+
+	if err := service.NotifyOfUserChange(stream.Context(), s.Notifier, userChangeNotification); err != nil {
+		slog.Error("failed to notify of imported user", "id", id, "error", err)
+	}
+}
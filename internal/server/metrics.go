@@ -0,0 +1,30 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/EFG/internal/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// NewMetricsInterceptors count every RPC and time how long it took by method and status code. They
+// run first so calls turned away by the other interceptors are counted too.
+func NewMetricsInterceptors(timeNow func() time.Time) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := timeNow()
+		resp, err := handler(ctx, req)
+		metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), timeNow().Sub(start))
+		return resp, err
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := timeNow()
+		err := handler(srv, ss)
+		metrics.ObserveRPC(info.FullMethod, status.Code(err).String(), timeNow().Sub(start))
+		return err
+	}
+
+	return unary, stream
+}
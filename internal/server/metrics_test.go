@@ -0,0 +1,36 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/metrics"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMetricsInterceptor(t *testing.T) {
+	unary, _ := NewMetricsInterceptors(time.Now)
+	info := &grpc.UnaryServerInfo{FullMethod: api.UserService_PurgeUser_FullMethodName}
+
+	_, err := unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, nil
+	})
+	assert.NoError(t, err)
+	_, err = unary(context.Background(), nil, info, func(ctx context.Context, req any) (any, error) {
+		return nil, status.Error(codes.NotFound, "user not found")
+	})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	rec := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, rec.Body.String(), `user_service_rpc_requests_total{code="OK",method="/api.UserService/PurgeUser"} 1`)
+	assert.Contains(t, rec.Body.String(), `user_service_rpc_requests_total{code="NotFound",method="/api.UserService/PurgeUser"} 1`)
+	assert.Contains(t, rec.Body.String(), `user_service_rpc_duration_seconds_count{code="NotFound",method="/api.UserService/PurgeUser"} 1`)
+}
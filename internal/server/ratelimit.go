@@ -0,0 +1,171 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/ratelimit"
+	"github.com/EFG/internal/service"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+const (
+	// RetryAfterHeader is the metadata key a limited call is told how many seconds to wait in.
+	RetryAfterHeader = "retry-after"
+	// ForwardedForHeader is the metadata key a proxy on the same host, such as the gateway, passes
+	// on the address of the client it is calling for in.
+	ForwardedForHeader = "x-forwarded-for"
+)
+
+// NewRateLimitInterceptors hold every caller of the UserService to limit, apart from the RPCs named
+// in methodLimits which are each held to a limit of their own. Callers are told apart by who they
+// are, their API key, user or client certificate, and otherwise by the address they call from. A
+// call over the limit is rejected with ResourceExhausted and a retry-after header saying how many
+// seconds until it would be let through.
+func NewRateLimitInterceptors(limit ratelimit.Limit, methodLimits map[string]ratelimit.Limit, timeNow func() time.Time) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor, error) {
+	defaultLimiter := ratelimit.NewLimiter(limit)
+	limiters := map[string]*ratelimit.Limiter{}
+	for method, l := range methodLimits {
+		fullMethod := "/" + api.UserService_ServiceDesc.ServiceName + "/" + method
+		if _, ok := accessRules[fullMethod]; !ok {
+			return nil, nil, fmt.Errorf("rate limit given for unknown rpc %q", method)
+		}
+		limiters[fullMethod] = ratelimit.NewLimiter(l)
+	}
+
+	unary, stream := rateLimitInterceptors(func(ctx context.Context, method string) (metadata.MD, error) {
+		limiter, ok := limiters[method]
+		if !ok {
+			limiter = defaultLimiter
+		}
+		return allowCall(limiter, rateLimitKey(ctx), method, timeNow())
+	})
+
+	return unary, stream, nil
+}
+
+// NewAddressRateLimitInterceptors hold every address calling the UserService to limit whoever is
+// calling from it. They run ahead of authentication so a flood of calls with made up API keys or
+// tokens is turned away before each costs a lookup, the per caller limits then apply once callers
+// are known.
+func NewAddressRateLimitInterceptors(limit ratelimit.Limit, timeNow func() time.Time) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	limiter := ratelimit.NewLimiter(limit)
+
+	return rateLimitInterceptors(func(ctx context.Context, method string) (metadata.MD, error) {
+		return allowCall(limiter, addressKey(ctx), method, timeNow())
+	})
+}
+
+// rateLimitInterceptors reject the calls to the UserService that allow turns away, allow returns the
+// header to send along with the error of a call over the limit.
+func rateLimitInterceptors(allow func(ctx context.Context, method string) (metadata.MD, error)) (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if !isUserServiceMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if header, err := allow(ctx, info.FullMethod); err != nil {
+			if headerErr := grpc.SetHeader(ctx, header); headerErr != nil {
+				slog.Error("failed to set retry-after header", "error", headerErr)
+			}
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !isUserServiceMethod(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		if header, err := allow(ss.Context(), info.FullMethod); err != nil {
+			if headerErr := ss.SetHeader(header); headerErr != nil {
+				slog.Error("failed to set retry-after header", "error", headerErr)
+			}
+			return err
+		}
+		return handler(srv, ss)
+	}
+
+	return unary, stream
+}
+
+// allowCall takes a token from the bucket of key, returning the retry-after header and the error
+// to reject the call with when the bucket is empty.
+func allowCall(limiter *ratelimit.Limiter, key, method string, now time.Time) (metadata.MD, error) {
+	allowed, retryAfter := limiter.Allow(key, now)
+	if allowed {
+		return nil, nil
+	}
+
+	slog.Warn("rate limited call", "method", method, "caller", key, "retryAfter", retryAfter)
+	// retry-after is in whole seconds as in HTTP, so a client waiting it out is never early
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	return metadata.Pairs(RetryAfterHeader, strconv.Itoa(seconds)), rateLimitedStatus(retryAfter)
+}
+
+// rateLimitKey identifies the caller of a call, anonymous calls are counted against their address.
+func rateLimitKey(ctx context.Context) string {
+	if actor := service.CallerFromContext(ctx).Actor(); actor != "" {
+		return actor
+	}
+
+	return addressKey(ctx)
+}
+
+// addressKey is the address a call comes from, or the address a proxy on the same host forwarded
+// it for. The forwarded address is only trusted from local peers so remote clients can't claim any
+// address they like.
+func addressKey(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return "unknown"
+	}
+
+	host, _, err := net.SplitHostPort(p.Addr.String())
+	if err != nil {
+		host = p.Addr.String()
+	}
+
+	if isLocalPeer(p.Addr, host) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if forwarded := firstMetadataValue(md, ForwardedForHeader); forwarded != "" {
+			return "addr:" + forwarded
+		}
+	}
+
+	return "addr:" + host
+}
+
+// isLocalPeer reports connections from the same host, over loopback or a connection that isn't TCP
+// at all such as a unix socket or an in-process listener.
+func isLocalPeer(addr net.Addr, host string) bool {
+	if addr.Network() != "tcp" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// rateLimitedStatus carries how long to wait as RetryInfo for clients that read status details.
+func rateLimitedStatus(retryAfter time.Duration) error {
+	st, err := status.New(codes.ResourceExhausted, "rate limit exceeded").WithDetails(&errdetails.RetryInfo{
+		RetryDelay: durationpb.New(retryAfter),
+	})
+	if err != nil {
+		slog.Error("failed to attach retry info", "error", err)
+		return status.Error(codes.ResourceExhausted, "rate limit exceeded")
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/ratelimit"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+func TestRateLimitInterceptor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	unary, _, err := NewRateLimitInterceptors(
+		ratelimit.Limit{Rate: 1, Burst: 2},
+		map[string]ratelimit.Limit{"CreateUser": {Rate: 0.5, Burst: 1}},
+		func() time.Time { return now },
+	)
+	require.NoError(t, err)
+
+	call := func(ctx context.Context, method string) (metadata.MD, error) {
+		stream := &headerStream{}
+		ctx = grpc.NewContextWithServerTransportStream(ctx, stream)
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: method}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return stream.header, err
+	}
+	from := func(addr string, md metadata.MD) context.Context {
+		ctx := metadata.NewIncomingContext(context.Background(), md)
+		return peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 50000}})
+	}
+
+	alice := service.WithCaller(from("10.0.0.1", nil), service.Caller{UserID: "123e4567-e89b-12d3-a456-426614174001"})
+
+	// RPCs without a limit of their own share the default one
+	_, err = call(alice, api.UserService_GetUsers_FullMethodName)
+	assert.NoError(t, err)
+	_, err = call(alice, api.UserService_GetUser_FullMethodName)
+	assert.NoError(t, err)
+	header, err := call(alice, api.UserService_GetUsers_FullMethodName)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, header.Get(RetryAfterHeader))
+
+	var retryInfo *errdetails.RetryInfo
+	for _, d := range status.Convert(err).Details() {
+		if info, ok := d.(*errdetails.RetryInfo); ok {
+			retryInfo = info
+		}
+	}
+	require.NotNil(t, retryInfo)
+	assert.Equal(t, time.Second, retryInfo.RetryDelay.AsDuration())
+
+	// CreateUser is counted separately and held to its own limit
+	_, err = call(alice, api.UserService_CreateUser_FullMethodName)
+	assert.NoError(t, err)
+	header, err = call(alice, api.UserService_CreateUser_FullMethodName)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"2"}, header.Get(RetryAfterHeader))
+
+	// another caller from the same address has limits of their own
+	bob := service.WithCaller(from("10.0.0.1", nil), service.Caller{APIKeyID: "key-1"})
+	_, err = call(bob, api.UserService_GetUsers_FullMethodName)
+	assert.NoError(t, err)
+
+	// anonymous calls are counted by address
+	_, err = call(from("10.0.0.2", nil), api.UserService_CreateUser_FullMethodName)
+	assert.NoError(t, err)
+	_, err = call(from("10.0.0.2", nil), api.UserService_CreateUser_FullMethodName)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// a forwarded address is only trusted from a loopback peer such as the gateway
+	_, err = call(from("127.0.0.1", metadata.Pairs(ForwardedForHeader, "10.0.0.3")), api.UserService_CreateUser_FullMethodName)
+	assert.NoError(t, err)
+	_, err = call(from("127.0.0.1", metadata.Pairs(ForwardedForHeader, "10.0.0.4")), api.UserService_CreateUser_FullMethodName)
+	assert.NoError(t, err)
+	_, err = call(from("10.0.0.2", metadata.Pairs(ForwardedForHeader, "10.0.0.5")), api.UserService_CreateUser_FullMethodName)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// other services such as health checks aren't limited
+	for i := 0; i < 5; i++ {
+		_, err = call(alice, "/grpc.health.v1.Health/Check")
+		assert.NoError(t, err)
+	}
+
+	// tokens come back over time
+	now = now.Add(time.Second)
+	_, err = call(alice, api.UserService_GetUsers_FullMethodName)
+	assert.NoError(t, err)
+}
+
+// headerServerStream records the headers set on a stream.
+type headerServerStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	header metadata.MD
+}
+
+func (s *headerServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *headerServerStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func TestRateLimitInterceptor_Stream(t *testing.T) {
+	_, stream, err := NewRateLimitInterceptors(ratelimit.Limit{Rate: 1, Burst: 1}, nil, time.Now)
+	require.NoError(t, err)
+
+	ctx := service.WithCaller(context.Background(), service.Caller{UserID: "123e4567-e89b-12d3-a456-426614174001"})
+	info := &grpc.StreamServerInfo{FullMethod: api.UserService_ExportUsers_FullMethodName}
+	handler := func(srv any, ss grpc.ServerStream) error { return nil }
+
+	assert.NoError(t, stream(nil, &headerServerStream{ctx: ctx}, info, handler))
+
+	ss := &headerServerStream{ctx: ctx}
+	err = stream(nil, ss, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, []string{"1"}, ss.header.Get(RetryAfterHeader))
+}
+
+func TestRateLimitInterceptor_UnknownMethod(t *testing.T) {
+	_, _, err := NewRateLimitInterceptors(ratelimit.Limit{Rate: 1, Burst: 1}, map[string]ratelimit.Limit{"DropUsers": {Rate: 1, Burst: 1}}, time.Now)
+	assert.EqualError(t, err, `rate limit given for unknown rpc "DropUsers"`)
+}
+
+func TestAddressRateLimitInterceptor(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	unary, _ := NewAddressRateLimitInterceptors(ratelimit.Limit{Rate: 1, Burst: 2}, func() time.Time { return now })
+
+	call := func(addr, apiKey string) error {
+		ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(APIKeyHeader, apiKey))
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 50000}})
+		ctx = grpc.NewContextWithServerTransportStream(ctx, &headerStream{})
+		_, err := unary(ctx, nil, &grpc.UnaryServerInfo{FullMethod: api.UserService_GetUsers_FullMethodName}, func(ctx context.Context, req any) (any, error) {
+			return nil, nil
+		})
+		return err
+	}
+
+	// callers haven't been authenticated yet, so a new key every call doesn't get a new bucket
+	assert.NoError(t, call("10.0.0.1", "umsk_guess-1"))
+	assert.NoError(t, call("10.0.0.1", "umsk_guess-2"))
+	assert.Equal(t, codes.ResourceExhausted, status.Code(call("10.0.0.1", "umsk_guess-3")))
+
+	// other addresses have limits of their own
+	assert.NoError(t, call("10.0.0.2", "umsk_guess-4"))
+}
@@ -2,65 +2,164 @@ package server
 
 import (
 	context "context"
+	"errors"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/datasource/dto"
 	"github.com/EFG/internal/service"
+	"github.com/EFG/internal/utils"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// passwordResetSendTimeout bounds how long delivering a password reset token can take once the
+// request has been answered.
+const passwordResetSendTimeout = 30 * time.Second
+
 type server struct {
 	api.UnimplementedUserServiceServer
 	// we are importing the service package anyway seems redundant to define the interface here for package independence
 	service.Datasource
 	service.Notifier
+	watcher service.Watcher
+	tokens  service.TokenIssuer
+	resets  service.PasswordResetSender
+	verify  service.EmailVerificationSender
 	timeNow func() time.Time
+	// purgeRetention is how long soft deleted users are kept, zero when it isn't known
+	purgeRetention time.Duration
+	// background tracks work carried on after a response has been sent
+	background sync.WaitGroup
+}
+
+// Option configures optional dependencies of the server.
+type Option func(*server)
+
+// WithWatcher enables the WatchUserChanges feed using the supplied watcher.
+func WithWatcher(w service.Watcher) Option {
+	return func(s *server) {
+		s.watcher = w
+	}
+}
+
+// WithTokenIssuer enables access tokens on AuthenticateUser and the ValidateToken RPC.
+func WithTokenIssuer(t service.TokenIssuer) Option {
+	return func(s *server) {
+		s.tokens = t
+	}
+}
+
+// WithPasswordResetSender enables RequestPasswordReset, tokens are delivered to users through p.
+func WithPasswordResetSender(p service.PasswordResetSender) Option {
+	return func(s *server) {
+		s.resets = p
+	}
+}
+
+// WithEmailVerificationSender enables SendEmailVerification, tokens are delivered to users through v.
+func WithEmailVerificationSender(v service.EmailVerificationSender) Option {
+	return func(s *server) {
+		s.verify = v
+	}
+}
+
+// WithPurgeRetention tells consumers of delete changes when the user will be purged.
+func WithPurgeRetention(retention time.Duration) Option {
+	return func(s *server) {
+		s.purgeRetention = retention
+	}
 }
 
-func NewServer(d service.Datasource, n service.Notifier, tn func() time.Time) *server {
-	return &server{
+func NewServer(d service.Datasource, n service.Notifier, tn func() time.Time, opts ...Option) *server {
+	s := &server{
 		Datasource: d,
 		Notifier:   n,
 		timeNow:    tn,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func (s *server) GetUsers(ctx context.Context, req *api.GetUsersRequest) (*api.GetUsersResponse, error) {
+	if err := validateGetUsersRequest(req); err != nil {
+		slog.Error("failed to validate get users request", "error", err)
+		return nil, statusFromError(err)
+	}
+
 	var getUserArgs dto.GetUsersArgs
 	getUserArgs.FromAPI(req)
 
+	if req.PageToken != "" {
+		if err := service.ApplyPageToken(&getUserArgs, req.PageToken); err != nil {
+			slog.Error("failed to apply page token", "error", err)
+			return nil, statusFromError(service.NewInvalidArgumentError("page_token", "%v", err))
+		}
+	}
+
+	if req.OrderBy != "" {
+		if err := service.ApplyOrderBy(&getUserArgs, req.OrderBy); err != nil {
+			slog.Error("failed to apply order by", "error", err)
+			return nil, statusFromError(service.NewInvalidArgumentError("order_by", "%v", err))
+		}
+	}
+
 	usersFromDatasource, count, err := service.GetPaginatedUsersList(ctx, s.Datasource, getUserArgs)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	usersForResponse := service.FromDTOToAPI(usersFromDatasource)
 
 	return &api.GetUsersResponse{
-		Users:      usersForResponse,
-		TotalCount: int32(count),
+		Users:         usersForResponse,
+		TotalCount:    int32(count),
+		NextPageToken: service.NextPageToken(getUserArgs, usersFromDatasource),
+	}, nil
+}
+
+func (s *server) GetUser(ctx context.Context, req *api.GetUserRequest) (*api.GetUserResponse, error) {
+	if err := validateGetUserRequest(req); err != nil {
+		slog.Error("failed to validate get user request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	var getUserArgs dto.GetUserArgs
+	getUserArgs.FromAPI(req)
+
+	userFromDatasource, err := service.GetUserByIDOrEmail(ctx, s.Datasource, getUserArgs)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.GetUserResponse{
+		User: service.FromDTOToAPIUser(userFromDatasource),
 	}, nil
 }
 
 func (s *server) CreateUser(ctx context.Context, req *api.CreateUserRequest) (*api.CreateUserResponse, error) {
 	if err := validateCreateUserRequest(req); err != nil {
 		slog.Error("failed to validate create user request required fields missing", "error", err)
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	user := service.NewUserFromCreateRequest(req)
 
 	id, err := service.FormatNewUserAndPersist(ctx, s.Datasource, user)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	userChangeNotification := service.CreateUserChangeNotification("create", id, s.timeNow())
 
 	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	return &api.CreateUserResponse{
@@ -72,46 +171,512 @@ func (s *server) CreateUser(ctx context.Context, req *api.CreateUserRequest) (*a
 func (s *server) ModifyUser(ctx context.Context, req *api.ModifyUserRequest) (*api.ModifyUserResponse, error) {
 	if err := validateExistingUserRequest(req); err != nil {
 		slog.Error("failed to validate modify user request required fields missing", "error", err)
-		return nil, err
+		return nil, statusFromError(err)
+	}
+
+	if err := validateUpdateMask(req); err != nil {
+		slog.Error("failed to validate modify user request update mask", "error", err)
+		return nil, statusFromError(err)
 	}
 
 	user := service.NewUserFromModifyRequest(req)
 
-	err := service.FormatExistingUserAndPersist(ctx, s.Datasource, user)
+	modifiedUser, err := service.FormatExistingUserAndPersist(ctx, s.Datasource, user)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	userChangeNotification := service.CreateUserChangeNotification("modify", user.ID, s.timeNow())
 
 	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	return &api.ModifyUserResponse{
 		Message: "Successfully modified user",
+		User:    service.FromDTOToAPIUser(modifiedUser),
 	}, nil
 }
 
 func (s *server) DeleteUser(ctx context.Context, req *api.DeleteUserRequest) (*api.DeleteUserResponse, error) {
-	if err := validateExistingUserRequest(&api.ModifyUserRequest{Id: req.Id}); err != nil {
-		slog.Error("failed to validate delete user request required fields missing", "error", err)
-		return nil, err
+	if err := validateDeleteUserRequest(req); err != nil {
+		slog.Error("failed to validate delete user request", "error", err)
+		return nil, statusFromError(err)
 	}
-	err := service.DeleteUserFromDatasource(ctx, s.Datasource, req.Id)
+
+	if req.HardDelete {
+		if err := s.purgeUser(ctx, req.Id); err != nil {
+			return nil, err
+		}
+		return &api.DeleteUserResponse{
+			Message: "Successfully purged user",
+		}, nil
+	}
+
+	err := service.DeleteUserFromDatasource(ctx, s.Datasource, req.Id, req.Etag)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
-	userChangeNotification := service.CreateUserChangeNotification("delete", req.Id, s.timeNow())
+	now := s.timeNow()
+	userChangeNotification := service.CreateUserChangeNotification("delete", req.Id, now)
+	if s.purgeRetention > 0 {
+		userChangeNotification.PurgeAfter = now.Add(s.purgeRetention).Format(time.RFC3339)
+	}
 
 	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
 	if err != nil {
-		return nil, err
+		return nil, statusFromError(err)
 	}
 
 	return &api.DeleteUserResponse{
 		Message: "Successfully deleted user",
 	}, nil
 }
+
+func (s *server) RestoreUser(ctx context.Context, req *api.RestoreUserRequest) (*api.RestoreUserResponse, error) {
+	if err := validateRestoreUserRequest(req); err != nil {
+		slog.Error("failed to validate restore user request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	restoredUser, err := service.RestoreUserInDatasource(ctx, s.Datasource, req.Id)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	userChangeNotification := service.CreateUserChangeNotification("restore", req.Id, s.timeNow())
+
+	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.RestoreUserResponse{
+		Message: "Successfully restored user",
+		User:    service.FromDTOToAPIUser(restoredUser),
+	}, nil
+}
+
+func (s *server) PurgeUser(ctx context.Context, req *api.PurgeUserRequest) (*api.PurgeUserResponse, error) {
+	if err := validatePurgeUserRequest(req); err != nil {
+		slog.Error("failed to validate purge user request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	if err := s.purgeUser(ctx, req.Id); err != nil {
+		return nil, err
+	}
+
+	return &api.PurgeUserResponse{
+		Message: "Successfully purged user",
+	}, nil
+}
+
+// purgeUser permanently removes the user and publishes the purge change, errors are returned as statuses.
+func (s *server) purgeUser(ctx context.Context, id string) error {
+	err := service.PurgeUserFromDatasource(ctx, s.Datasource, id)
+	if err != nil {
+		return statusFromError(err)
+	}
+
+	userChangeNotification := service.CreateUserChangeNotification("purge", id, s.timeNow())
+
+	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
+	if err != nil {
+		return statusFromError(err)
+	}
+
+	return nil
+}
+
+func (s *server) GetUserHistory(ctx context.Context, req *api.GetUserHistoryRequest) (*api.GetUserHistoryResponse, error) {
+	if err := validateGetUserHistoryRequest(req); err != nil {
+		slog.Error("failed to validate get user history request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	args := dto.GetUserHistoryArgs{
+		UserID: utils.ToNullString(req.Id),
+		Limit:  service.DefaultHistoryPageSize,
+	}
+	if req.PageSize > 0 {
+		args.Limit = req.PageSize
+	}
+
+	if req.PageToken != "" {
+		if err := service.ApplyHistoryPageToken(&args, req.PageToken); err != nil {
+			slog.Error("failed to apply page token", "error", err)
+			return nil, statusFromError(service.NewInvalidArgumentError("page_token", "%v", err))
+		}
+	}
+
+	entries, err := service.GetUserHistoryFromDatasource(ctx, s.Datasource, args)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	resp := &api.GetUserHistoryResponse{
+		NextPageToken: service.NextHistoryPageToken(args, entries),
+	}
+	for _, e := range entries {
+		entry, err := service.FromDTOToAPIAuditEntry(e)
+		if err != nil {
+			slog.Error("failed to convert audit entry", "error", err)
+			return nil, statusFromError(err)
+		}
+		resp.Entries = append(resp.Entries, entry)
+	}
+
+	return resp, nil
+}
+
+func (s *server) AssignRole(ctx context.Context, req *api.AssignRoleRequest) (*api.AssignRoleResponse, error) {
+	if err := validateAssignRoleRequest(req); err != nil {
+		slog.Error("failed to validate assign role request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	if err := service.AssignRoleInDatasource(ctx, s.Datasource, req.Id, req.Role); err != nil {
+		return nil, statusFromError(err)
+	}
+
+	roles, err := s.userRoles(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.AssignRoleResponse{
+		Message: "Successfully assigned role",
+		Roles:   roles,
+	}, nil
+}
+
+func (s *server) RevokeRole(ctx context.Context, req *api.RevokeRoleRequest) (*api.RevokeRoleResponse, error) {
+	if err := validateRevokeRoleRequest(req); err != nil {
+		slog.Error("failed to validate revoke role request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	if err := service.RevokeRoleFromDatasource(ctx, s.Datasource, req.Id, req.Role); err != nil {
+		return nil, statusFromError(err)
+	}
+
+	roles, err := s.userRoles(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.RevokeRoleResponse{
+		Message: "Successfully revoked role",
+		Roles:   roles,
+	}, nil
+}
+
+func (s *server) ListRoles(ctx context.Context, req *api.ListRolesRequest) (*api.ListRolesResponse, error) {
+	if req.Id == "" {
+		return &api.ListRolesResponse{Roles: service.ToAPIRoles(service.Roles())}, nil
+	}
+
+	roles, err := s.userRoles(ctx, req.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ListRolesResponse{Roles: roles}, nil
+}
+
+// userRoles returns the roles held by the user, errors are returned as statuses.
+func (s *server) userRoles(ctx context.Context, id string) ([]*api.Role, error) {
+	roles, err := service.GetUserRolesFromDatasource(ctx, s.Datasource, id)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return service.ToAPIRoles(roles), nil
+}
+
+func (s *server) CreateApiKey(ctx context.Context, req *api.CreateApiKeyRequest) (*api.CreateApiKeyResponse, error) {
+	expiresAt, err := apiKeyExpiryFromRequest(req, s.timeNow())
+	if err != nil {
+		slog.Error("failed to validate create api key request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	key, stored, err := service.CreateAPIKey(ctx, s.Datasource, req.Name, req.Scopes, expiresAt)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.CreateApiKeyResponse{
+		Message: "Successfully created api key",
+		Key:     key,
+		ApiKey:  service.FromDTOToAPIKey(stored),
+	}, nil
+}
+
+func (s *server) RotateApiKey(ctx context.Context, req *api.RotateApiKeyRequest) (*api.RotateApiKeyResponse, error) {
+	if err := validateRotateApiKeyRequest(req); err != nil {
+		slog.Error("failed to validate rotate api key request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	key, stored, err := service.RotateAPIKey(ctx, s.Datasource, req.Id)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.RotateApiKeyResponse{
+		Message: "Successfully rotated api key",
+		Key:     key,
+		ApiKey:  service.FromDTOToAPIKey(stored),
+	}, nil
+}
+
+func (s *server) RevokeApiKey(ctx context.Context, req *api.RevokeApiKeyRequest) (*api.RevokeApiKeyResponse, error) {
+	if err := validateRevokeApiKeyRequest(req); err != nil {
+		slog.Error("failed to validate revoke api key request", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	if err := service.RevokeAPIKey(ctx, s.Datasource, req.Id); err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.RevokeApiKeyResponse{Message: "Successfully revoked api key"}, nil
+}
+
+func (s *server) ListApiKeys(ctx context.Context, req *api.ListApiKeysRequest) (*api.ListApiKeysResponse, error) {
+	keys, err := service.ListAPIKeys(ctx, s.Datasource)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	resp := &api.ListApiKeysResponse{}
+	for _, k := range keys {
+		resp.ApiKeys = append(resp.ApiKeys, service.FromDTOToAPIKey(k))
+	}
+
+	return resp, nil
+}
+
+func (s *server) AuthenticateUser(ctx context.Context, req *api.AuthenticateUserRequest) (*api.AuthenticateUserResponse, error) {
+	if err := validateAuthenticateUserRequest(req); err != nil {
+		slog.Error("failed to validate authenticate user request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	user, err := service.AuthenticateUser(ctx, s.Datasource, req.Email, req.Password)
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		s.notifyAuthentication(ctx, "", service.OutcomeFailure)
+		return nil, statusFromError(err)
+	}
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	s.notifyAuthentication(ctx, user.ID.String, service.OutcomeSuccess)
+
+	resp := &api.AuthenticateUserResponse{
+		User: service.FromDTOToAPIUser(user),
+	}
+
+	if s.tokens != nil {
+		token, claims, err := s.tokens.IssueToken(service.TokenClaims{
+			UserID:   user.ID.String,
+			Email:    user.Email.String,
+			TenantID: service.TenantFromContext(ctx),
+		})
+		if err != nil {
+			slog.Error("failed to issue access token", "error", err)
+			return nil, statusFromError(err)
+		}
+		resp.AccessToken = token
+		resp.TokenType = "Bearer"
+		resp.ExpiresAt = claims.ExpiresAt.Format(time.RFC3339)
+	}
+
+	return resp, nil
+}
+
+func (s *server) ValidateToken(ctx context.Context, req *api.ValidateTokenRequest) (*api.ValidateTokenResponse, error) {
+	if s.tokens == nil {
+		return nil, status.Error(codes.Unimplemented, "access tokens are not enabled")
+	}
+
+	if err := validateValidateTokenRequest(req); err != nil {
+		slog.Error("failed to validate validate token request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	claims, err := s.tokens.ValidateToken(req.Token)
+	if err != nil {
+		slog.Info("rejected access token", "reason", err)
+		return nil, statusFromError(err)
+	}
+
+	resp := &api.ValidateTokenResponse{
+		UserId:    claims.UserID,
+		Email:     claims.Email,
+		IssuedAt:  claims.IssuedAt.Format(time.RFC3339),
+		ExpiresAt: claims.ExpiresAt.Format(time.RFC3339),
+		TenantId:  claims.TenantID,
+	}
+	if resp.TenantId == "" {
+		resp.TenantId = service.DefaultTenant
+	}
+
+	return resp, nil
+}
+
+func (s *server) RequestPasswordReset(ctx context.Context, req *api.RequestPasswordResetRequest) (*api.RequestPasswordResetResponse, error) {
+	if s.resets == nil {
+		return nil, status.Error(codes.Unimplemented, "password reset is not enabled")
+	}
+
+	if err := validateRequestPasswordResetRequest(req); err != nil {
+		slog.Error("failed to validate request password reset request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	reset, err := service.RequestPasswordReset(ctx, s.Datasource, req.Email, s.timeNow())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	// the token is sent in the background so neither how long sending takes nor it failing gives
+	// away that the email is registered
+	s.background.Add(1)
+	go func() {
+		defer s.background.Done()
+		sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), passwordResetSendTimeout)
+		defer cancel()
+		service.SendPasswordReset(sendCtx, s.resets, reset)
+	}()
+
+	// the response is the same for unknown emails so it can't be used to find registered users
+	return &api.RequestPasswordResetResponse{
+		Message: "If the email is registered a password reset token has been sent",
+	}, nil
+}
+
+func (s *server) CompletePasswordReset(ctx context.Context, req *api.CompletePasswordResetRequest) (*api.CompletePasswordResetResponse, error) {
+	if err := validateCompletePasswordResetRequest(req); err != nil {
+		slog.Error("failed to validate complete password reset request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	userID, err := service.CompletePasswordReset(ctx, s.Datasource, req.Token, req.NewPassword, s.timeNow())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	userChangeNotification := service.CreateUserChangeNotification("password_reset", userID, s.timeNow())
+
+	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.CompletePasswordResetResponse{
+		Message: "Successfully reset password",
+	}, nil
+}
+
+func (s *server) SendEmailVerification(ctx context.Context, req *api.SendEmailVerificationRequest) (*api.SendEmailVerificationResponse, error) {
+	if s.verify == nil {
+		return nil, status.Error(codes.Unimplemented, "email verification is not enabled")
+	}
+
+	if err := validateSendEmailVerificationRequest(req); err != nil {
+		slog.Error("failed to validate send email verification request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	err := service.SendEmailVerification(ctx, s.Datasource, s.verify, req.Id, s.timeNow())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.SendEmailVerificationResponse{
+		Message: "Successfully sent email verification",
+	}, nil
+}
+
+func (s *server) ConfirmEmail(ctx context.Context, req *api.ConfirmEmailRequest) (*api.ConfirmEmailResponse, error) {
+	if err := validateConfirmEmailRequest(req); err != nil {
+		slog.Error("failed to validate confirm email request required fields missing", "error", err)
+		return nil, statusFromError(err)
+	}
+
+	userID, err := service.ConfirmEmail(ctx, s.Datasource, req.Token, s.timeNow())
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	userChangeNotification := service.CreateUserChangeNotification("email_verified", userID, s.timeNow())
+
+	err = service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification)
+	if err != nil {
+		return nil, statusFromError(err)
+	}
+
+	return &api.ConfirmEmailResponse{
+		Message: "Successfully confirmed email",
+	}, nil
+}
+
+// notifyAuthentication publishes the authenticate change, a failure is logged rather than failing
+// the login. Failed attempts carry no user ID so they don't reveal which emails are registered.
+func (s *server) notifyAuthentication(ctx context.Context, userID, outcome string) {
+	userChangeNotification := service.CreateUserChangeNotification("authenticate", userID, s.timeNow())
+	userChangeNotification.Outcome = outcome
+
+	if err := service.NotifyOfUserChange(ctx, s.Notifier, userChangeNotification); err != nil {
+		slog.Error("failed to notify of authentication", "outcome", outcome, "error", err)
+	}
+}
+
+func (s *server) WatchUserChanges(req *api.WatchUserChangesRequest, stream grpc.ServerStreamingServer[api.UserChangeEvent]) error {
+	if s.watcher == nil {
+		return status.Error(codes.Unimplemented, "user change feed is not enabled")
+	}
+
+	filter, err := changeFilterFromRequest(req)
+	if err != nil {
+		slog.Error("failed to validate watch user changes request", "error", err)
+		return statusFromError(err)
+	}
+	// watchers only ever see changes to users of their own tenant
+	filter.TenantID = service.TenantFromContext(stream.Context())
+
+	err = service.WatchUserChanges(stream.Context(), s.watcher, filter, func(change service.UserChange) error {
+		return stream.Send(&api.UserChangeEvent{
+			ChangeType: change.ChangeType,
+			UserId:     change.UserID,
+			EventTime:  change.EventTime,
+			Outcome:    change.Outcome,
+			PurgeAfter: change.PurgeAfter,
+		})
+	})
+	if err != nil {
+		if errors.Is(err, service.ErrWatcherFellBehind) {
+			return status.Error(codes.ResourceExhausted, "watcher fell behind the change feed, resume using since")
+		}
+		return statusFromError(err)
+	}
+
+	return nil
+}
+
+func (s *server) ExportUsers(req *api.ExportUsersRequest, stream grpc.ServerStreamingServer[api.User]) error {
+	var exportUsersArgs dto.ExportUsersArgs
+	exportUsersArgs.FromAPI(req)
+
+	err := service.ExportUsersList(stream.Context(), s.Datasource, exportUsersArgs, func(u dto.UserDTO) error {
+		return stream.Send(service.FromDTOToAPIUser(u))
+	})
+
+	return statusFromError(err)
+}
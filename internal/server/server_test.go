@@ -2,14 +2,26 @@ package server
 
 import (
 	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/datasource/dto"
 	"github.com/EFG/internal/notifier"
+	"github.com/EFG/internal/service"
 	"github.com/EFG/internal/utils"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func TestCreateUser_WritesToDataSource(t *testing.T) {
@@ -177,12 +189,46 @@ func TestCreateUser_ValidationErrorsForMissingFieldsInRequest(t *testing.T) {
 			resp, err := srv.CreateUser(context.Background(), req)
 
 			assert.Nil(t, resp)
-			assert.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
 			assert.Contains(t, err.Error(), tc.expectedErr)
 		})
 	}
 }
 
+func TestCreateUser_ValidationErrorDetailsListEveryMissingField(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	resp, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		Email:     "john.doe@example.com",
+		Nickname:  "johndoe",
+	})
+	assert.Nil(t, resp)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.InvalidArgument, st.Code())
+	assert.Len(t, st.Details(), 1)
+
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	assert.True(t, ok)
+
+	var fields []string
+	for _, v := range badRequest.GetFieldViolations() {
+		fields = append(fields, v.GetField())
+	}
+	assert.Equal(t, []string{"last_name", "password", "country"}, fields)
+}
+
 func TestModifyUser_WritesToDataSource(t *testing.T) {
 	mockDatasource := &postgres.MockClient{
 		UUID: "123e4567-e89b-12d3-a456-426614174001",
@@ -331,6 +377,13 @@ func TestModifyUser_ValidationErrorsForMissingFieldsInRequest(t *testing.T) {
 			},
 			expectedErr: nil,
 		},
+		{
+			name: "password too long",
+			modifyReq: func(req *api.ModifyUserRequest) {
+				req.Password = strings.Repeat("a", 73)
+			},
+			expectedErr: utils.Ptr("Password cannot be longer than 72 bytes"),
+		},
 	}
 
 	for _, tc := range tests {
@@ -396,6 +449,24 @@ func TestDeleteUser_WritesToDataSource(t *testing.T) {
 	assert.Contains(t, string(mockNotifier.PublishedMessages[0]), "123e4567-e89b-12d3-a456-426614174001")
 }
 
+func TestDeleteUser_ChangeSaysWhenTheUserIsPurged(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174001",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow, WithPurgeRetention(30*24*time.Hour))
+
+	_, err := srv.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, `{"changeType":"delete","eventTime":"2025-01-01T00:00:00Z","userId":"123e4567-e89b-12d3-a456-426614174001","purgeAfter":"2025-01-31T00:00:00Z","tenantId":"default"}`, string(mockNotifier.PublishedMessages[0]))
+}
+
 func TestDeleteUser_DataSourceError(t *testing.T) {
 	mockDatasource := &postgres.MockClient{
 		UUID:              "123e4567-e89b-12d3-a456-426614174001",
@@ -482,6 +553,376 @@ func TestDeleteUser_ValidationErrorsForMissingFieldsInRequest(t *testing.T) {
 	}
 }
 
+func TestModifyUser_ETag(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name         string
+		etag         string
+		expectedCode codes.Code
+		expectedErr  string
+	}{
+		{
+			name:         "current etag",
+			etag:         "3",
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "no etag writes unconditionally",
+			etag:         "",
+			expectedCode: codes.OK,
+		},
+		{
+			name:         "stale etag",
+			etag:         "2",
+			expectedCode: codes.Aborted,
+			expectedErr:  "has been changed since version 2",
+		},
+		{
+			name:         "malformed etag",
+			etag:         "abc",
+			expectedCode: codes.InvalidArgument,
+			expectedErr:  `etag "abc" was not issued by this service`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockDatasource := &postgres.MockClient{Version: 3}
+			mockNotifier := &notifier.MockNotifier{}
+			srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+			resp, err := srv.ModifyUser(context.Background(), &api.ModifyUserRequest{
+				Id:       "123e4567-e89b-12d3-a456-426614174001",
+				Nickname: "jd",
+				Etag:     tc.etag,
+			})
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+			if tc.expectedCode != codes.OK {
+				assert.Nil(t, resp)
+				assert.Contains(t, err.Error(), tc.expectedErr)
+				assert.Empty(t, mockDatasource.GetCallHistory())
+				assert.False(t, mockNotifier.PublishCalled)
+				return
+			}
+
+			assert.Equal(t, "4", resp.User.Etag)
+		})
+	}
+}
+
+func TestDeleteUser_ETag(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("stale etag", func(t *testing.T) {
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(&postgres.MockClient{Version: 3}, mockNotifier, mockTimeNow)
+
+		resp, err := srv.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001", Etag: "2"})
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.Aborted, status.Code(err))
+		assert.False(t, mockNotifier.PublishCalled)
+	})
+
+	t.Run("current etag", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{Version: 3}
+		srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+		_, err := srv.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001", Etag: "3"})
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), mockDatasource.GetCallHistory()[0].Version.Int64)
+	})
+
+	t.Run("etag with hard delete", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{Version: 3}
+		srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+		_, err := srv.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001", Etag: "3", HardDelete: true})
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Empty(t, mockDatasource.PurgeUserCallHistory)
+	})
+}
+
+func TestDeleteUser_HardDeletePurges(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	resp, err := srv.DeleteUser(context.Background(), &api.DeleteUserRequest{
+		Id:         "123e4567-e89b-12d3-a456-426614174001",
+		HardDelete: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully purged user", resp.Message)
+
+	// the soft delete is skipped entirely
+	assert.Empty(t, mockDatasource.GetCallHistory())
+	assert.Equal(t, []string{"123e4567-e89b-12d3-a456-426614174001"}, mockDatasource.PurgeUserCallHistory)
+
+	assert.Len(t, mockNotifier.PublishedMessages, 1)
+	assert.Contains(t, string(mockNotifier.PublishedMessages[0]), "purge")
+}
+
+func TestRestoreUser(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("restores the user", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{}
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+		resp, err := srv.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Successfully restored user", resp.Message)
+		assert.Equal(t, "123e4567-e89b-12d3-a456-426614174001", resp.User.Id)
+
+		assert.Len(t, mockNotifier.PublishedMessages, 1)
+		assert.Contains(t, string(mockNotifier.PublishedMessages[0]), "restore")
+	})
+
+	t.Run("missing id", func(t *testing.T) {
+		srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow)
+
+		resp, err := srv.RestoreUser(context.Background(), &api.RestoreUserRequest{})
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+		assert.Contains(t, err.Error(), "Id cannot be empty")
+	})
+
+	t.Run("datasource error", func(t *testing.T) {
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(&postgres.MockClient{TestRequiresError: true}, mockNotifier, mockTimeNow)
+
+		resp, err := srv.RestoreUser(context.Background(), &api.RestoreUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001"})
+		assert.Nil(t, resp)
+		assert.Contains(t, err.Error(), "failed to restore user: mock db error for restore user")
+		assert.False(t, mockNotifier.PublishCalled)
+	})
+}
+
+func TestPurgeUser(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	t.Run("purges the user", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{}
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+		resp, err := srv.PurgeUser(context.Background(), &api.PurgeUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001"})
+		assert.NoError(t, err)
+		assert.Equal(t, "Successfully purged user", resp.Message)
+		assert.Equal(t, []string{"123e4567-e89b-12d3-a456-426614174001"}, mockDatasource.PurgeUserCallHistory)
+
+		assert.Len(t, mockNotifier.PublishedMessages, 1)
+		assert.Contains(t, string(mockNotifier.PublishedMessages[0]), "purge")
+	})
+
+	t.Run("missing id", func(t *testing.T) {
+		srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow)
+
+		resp, err := srv.PurgeUser(context.Background(), &api.PurgeUserRequest{})
+		assert.Nil(t, resp)
+		assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	})
+
+	t.Run("datasource error", func(t *testing.T) {
+		mockNotifier := &notifier.MockNotifier{}
+		srv := NewServer(&postgres.MockClient{TestRequiresError: true}, mockNotifier, mockTimeNow)
+
+		resp, err := srv.PurgeUser(context.Background(), &api.PurgeUserRequest{Id: "123e4567-e89b-12d3-a456-426614174001"})
+		assert.Nil(t, resp)
+		assert.Contains(t, err.Error(), "failed to purge user: mock db error for purge user")
+		assert.False(t, mockNotifier.PublishCalled)
+	})
+}
+
+func TestGetUserHistory(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	userID := "123e4567-e89b-12d3-a456-426614174001"
+	history := []dto.UserAuditDTO{
+		{ID: 3, UserID: userID, Action: "delete", Changes: []byte(`{"deleted_at": {"old": null, "new": "2025-01-03T00:00:00+00:00"}}`), OccurredAt: time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{ID: 2, UserID: userID, Action: "modify", Changes: []byte(`{"password": {"old": "[REDACTED]", "new": "[REDACTED]"}}`), Actor: sql.NullString{String: userID, Valid: true}, RequestID: sql.NullString{String: "req-2", Valid: true}, OccurredAt: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{ID: 1, UserID: userID, Action: "create", Changes: []byte(`{"first_name": {"old": null, "new": "John"}}`), OccurredAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	t.Run("pages through the history newest first", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{UserHistory: history}
+		srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+		resp, err := srv.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: userID, PageSize: 2})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Entries, 2)
+		assert.Equal(t, "delete", resp.Entries[0].Action)
+		assert.Equal(t, "modify", resp.Entries[1].Action)
+		assert.Equal(t, userID, resp.Entries[1].Actor)
+		assert.Equal(t, "req-2", resp.Entries[1].RequestId)
+		assert.Equal(t, []*api.FieldChange{{Field: "password", OldValue: "[REDACTED]", NewValue: "[REDACTED]"}}, resp.Entries[1].Changes)
+		assert.NotEmpty(t, resp.NextPageToken)
+
+		resp, err = srv.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: userID, PageSize: 2, PageToken: resp.NextPageToken})
+		assert.NoError(t, err)
+		assert.Len(t, resp.Entries, 1)
+		assert.Equal(t, "create", resp.Entries[0].Action)
+		assert.Empty(t, resp.NextPageToken)
+	})
+
+	t.Run("page size defaults", func(t *testing.T) {
+		mockDatasource := &postgres.MockClient{}
+		srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+		_, err := srv.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: userID})
+		assert.NoError(t, err)
+		assert.Equal(t, int32(service.DefaultHistoryPageSize), mockDatasource.GetUserHistoryCallHistory[0].Limit)
+	})
+
+	tests := []struct {
+		name        string
+		req         *api.GetUserHistoryRequest
+		expectedErr string
+	}{
+		{
+			name:        "missing id",
+			req:         &api.GetUserHistoryRequest{},
+			expectedErr: "Id cannot be empty",
+		},
+		{
+			name:        "page size too large",
+			req:         &api.GetUserHistoryRequest{Id: userID, PageSize: 101},
+			expectedErr: "PageSize must be between 1 and 100",
+		},
+		{
+			name:        "invalid page token",
+			req:         &api.GetUserHistoryRequest{Id: userID, PageToken: "not-a-token!"},
+			expectedErr: "invalid page token",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow)
+
+			resp, err := srv.GetUserHistory(context.Background(), tt.req)
+			assert.Nil(t, resp)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, err.Error(), tt.expectedErr)
+		})
+	}
+
+	t.Run("datasource error", func(t *testing.T) {
+		srv := NewServer(&postgres.MockClient{TestRequiresError: true}, &notifier.MockNotifier{}, mockTimeNow)
+
+		resp, err := srv.GetUserHistory(context.Background(), &api.GetUserHistoryRequest{Id: userID})
+		assert.Nil(t, resp)
+		assert.Contains(t, err.Error(), "failed to get user history: mock db error for get user history")
+	})
+}
+
+func TestRoles(t *testing.T) {
+	userID := "123e4567-e89b-12d3-a456-426614174001"
+	mockDatasource := &postgres.MockClient{}
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, time.Now)
+
+	assigned, err := srv.AssignRole(context.Background(), &api.AssignRoleRequest{Id: userID, Role: service.RoleViewer})
+	assert.NoError(t, err)
+	assert.Equal(t, []*api.Role{{Name: "viewer", Permissions: []string{"users.read"}}}, assigned.Roles)
+
+	_, err = srv.AssignRole(context.Background(), &api.AssignRoleRequest{Id: userID, Role: service.RoleEditor})
+	assert.NoError(t, err)
+
+	listed, err := srv.ListRoles(context.Background(), &api.ListRolesRequest{Id: userID})
+	assert.NoError(t, err)
+	assert.Len(t, listed.Roles, 2)
+	assert.Equal(t, "editor", listed.Roles[0].Name)
+	assert.Equal(t, "viewer", listed.Roles[1].Name)
+
+	revoked, err := srv.RevokeRole(context.Background(), &api.RevokeRoleRequest{Id: userID, Role: service.RoleViewer})
+	assert.NoError(t, err)
+	assert.Equal(t, []*api.Role{{Name: "editor", Permissions: []string{"users.read", "users.write"}}}, revoked.Roles)
+
+	// without a user every role there is is listed
+	listed, err = srv.ListRoles(context.Background(), &api.ListRolesRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, listed.Roles, 4)
+	assert.Equal(t, "admin", listed.Roles[0].Name)
+	assert.Equal(t, []string{"users.read", "users.write", "users.delete", "users.admin"}, listed.Roles[0].Permissions)
+
+	_, err = srv.AssignRole(context.Background(), &api.AssignRoleRequest{Id: userID, Role: "superuser"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = srv.RevokeRole(context.Background(), &api.RevokeRoleRequest{Role: service.RoleEditor})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Id cannot be empty", status.Convert(err).Message())
+
+	mockDatasource.TestRequiresError = true
+	_, err = srv.AssignRole(context.Background(), &api.AssignRoleRequest{Id: userID, Role: service.RoleViewer})
+	assert.Equal(t, codes.Unknown, status.Code(err))
+}
+
+func TestApiKeys(t *testing.T) {
+	mockDatasource := &postgres.MockClient{UUID: "123e4567-e89b-12d3-a456-426614174000"}
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+	created, err := srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read"}, ExpiresAt: "2026-01-01T00:00:00Z"})
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Key, service.APIKeyPrefix))
+	assert.Equal(t, "billing", created.ApiKey.Name)
+	assert.Equal(t, "2026-01-01T00:00:00Z", created.ApiKey.ExpiresAt)
+	// only a hash of the key is stored
+	assert.NotContains(t, mockDatasource.APIKeys[0].KeyHash, created.Key)
+
+	rotated, err := srv.RotateApiKey(context.Background(), &api.RotateApiKeyRequest{Id: created.ApiKey.Id})
+	assert.NoError(t, err)
+	assert.NotEqual(t, created.Key, rotated.Key)
+	assert.Equal(t, "2025-01-02T00:00:00Z", rotated.ApiKey.RotatedAt)
+
+	_, err = srv.RevokeApiKey(context.Background(), &api.RevokeApiKeyRequest{Id: created.ApiKey.Id})
+	assert.NoError(t, err)
+
+	listed, err := srv.ListApiKeys(context.Background(), &api.ListApiKeysRequest{})
+	assert.NoError(t, err)
+	assert.Len(t, listed.ApiKeys, 1)
+	assert.Equal(t, "2025-01-03T00:00:00Z", listed.ApiKeys[0].RevokedAt)
+
+	_, err = srv.RevokeApiKey(context.Background(), &api.RevokeApiKeyRequest{Id: created.ApiKey.Id})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.everything"}})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	_, err = srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "billing", Scopes: []string{"users.read"}, ExpiresAt: "2024-01-01T00:00:00Z"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "ExpiresAt must be in the future", status.Convert(err).Message())
+
+	_, err = srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Scopes: []string{"users.read"}})
+	assert.Equal(t, "Name cannot be empty", status.Convert(err).Message())
+
+	_, err = srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "reports", Scopes: []string{"users.read"}})
+	assert.NoError(t, err)
+	_, err = srv.CreateApiKey(context.Background(), &api.CreateApiKeyRequest{Name: "reports", Scopes: []string{"users.write"}})
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	mockDatasource.TestRequiresError = true
+	_, err = srv.ListApiKeys(context.Background(), &api.ListApiKeysRequest{})
+	assert.Equal(t, codes.Unknown, status.Code(err))
+}
+
 func TestGetUser_ReadsFromDataSource(t *testing.T) {
 	mockDatasource := &postgres.MockClient{}
 
@@ -541,3 +982,1263 @@ func TestGetUsers_DataSourceError(t *testing.T) {
 	assert.Nil(t, resp)
 	assert.Contains(t, err.Error(), "failed to get users: mock db error for get users")
 }
+
+func TestGetSingleUser_ReadsFromDataSource(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	tests := []struct {
+		name              string
+		req               *api.GetUserRequest
+		expectedFirstName string
+	}{
+		{
+			name:              "lookup by id",
+			req:               &api.GetUserRequest{Id: "2"},
+			expectedFirstName: "Jane",
+		},
+		{
+			name:              "lookup by email",
+			req:               &api.GetUserRequest{Email: "john.doe@example.com"},
+			expectedFirstName: "John",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Call the gRPC method
+			resp, err := srv.GetUser(context.Background(), tc.req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedFirstName, resp.User.FirstName)
+			assert.Equal(t, "Doe", resp.User.LastName)
+			assert.Equal(t, "US", resp.User.Country)
+		})
+	}
+}
+
+func TestGetSingleUser_NotFound(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	// Mock gRPC request
+	req := &api.GetUserRequest{
+		Email: "nobody@example.com",
+	}
+
+	// Call the gRPC method
+	resp, err := srv.GetUser(context.Background(), req)
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, err.Error(), "user not found")
+}
+
+func TestGetSingleUser_ValidationErrors(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	tests := []struct {
+		name        string
+		req         *api.GetUserRequest
+		expectedErr string
+	}{
+		{
+			name:        "missing id and email",
+			req:         &api.GetUserRequest{},
+			expectedErr: "one of Id or Email must be supplied",
+		},
+		{
+			name:        "both id and email",
+			req:         &api.GetUserRequest{Id: "1", Email: "john.doe@example.com"},
+			expectedErr: "only one of Id or Email can be supplied",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			// Call the gRPC method
+			resp, err := srv.GetUser(context.Background(), tc.req)
+
+			assert.Nil(t, resp)
+			assert.Error(t, err)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestGetSingleUser_DataSourceError(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		TestRequiresError: true,
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	// Call the gRPC method
+	resp, err := srv.GetUser(context.Background(), &api.GetUserRequest{Id: "1"})
+	assert.Error(t, err)
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "failed to get user: mock db error for get user")
+}
+
+type mockUserChangeStream struct {
+	grpc.ServerStream
+	ctx    context.Context
+	events []*api.UserChangeEvent
+	onSend func()
+}
+
+func (m *mockUserChangeStream) Context() context.Context {
+	return m.ctx
+}
+
+func (m *mockUserChangeStream) Send(event *api.UserChangeEvent) error {
+	m.events = append(m.events, event)
+	if m.onSend != nil {
+		m.onSend()
+	}
+	return nil
+}
+
+func TestWatchUserChanges_StreamsFilteredChanges(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	broadcastNotifier := notifier.NewBroadcastNotifier(&notifier.MockNotifier{}, 10)
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, broadcastNotifier, mockTimeNow, WithWatcher(broadcastNotifier), WithPurgeRetention(720*time.Hour))
+
+	_, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "USA",
+		Nickname:  "johndoe",
+	})
+	assert.NoError(t, err)
+
+	_, err = srv.DeleteUser(context.Background(), &api.DeleteUserRequest{Id: "123e4567-e89b-12d3-a456-426614174000"})
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stream := &mockUserChangeStream{ctx: ctx, onSend: cancel}
+
+	// Only the delete is replayed, the stream ends once it is sent
+	err = srv.WatchUserChanges(&api.WatchUserChangesRequest{
+		ChangeTypes: []string{"delete"},
+		Since:       "2025-01-01T00:00:00Z",
+	}, stream)
+	assert.NoError(t, err)
+
+	assert.Len(t, stream.events, 1)
+	assert.Equal(t, "delete", stream.events[0].ChangeType)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174000", stream.events[0].UserId)
+	assert.Equal(t, "2025-01-01T00:00:00Z", stream.events[0].EventTime)
+	assert.Equal(t, "2025-01-31T00:00:00Z", stream.events[0].PurgeAfter)
+}
+
+func TestWatchUserChanges_ValidationErrors(t *testing.T) {
+	broadcastNotifier := notifier.NewBroadcastNotifier(&notifier.MockNotifier{}, 10)
+
+	srv := NewServer(&postgres.MockClient{}, broadcastNotifier, time.Now, WithWatcher(broadcastNotifier))
+
+	stream := &mockUserChangeStream{ctx: context.Background()}
+
+	err := srv.WatchUserChanges(&api.WatchUserChangesRequest{Since: "yesterday"}, stream)
+	assert.Error(t, err)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "Since must be an RFC3339 timestamp")
+}
+
+func TestWatchUserChanges_NotEnabled(t *testing.T) {
+	srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, time.Now)
+
+	stream := &mockUserChangeStream{ctx: context.Background()}
+
+	err := srv.WatchUserChanges(&api.WatchUserChangesRequest{}, stream)
+	assert.Error(t, err)
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+type mockImportUsersStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	requests []*api.CreateUserRequest
+	response *api.ImportUsersResponse
+}
+
+func (m *mockImportUsersStream) Context() context.Context {
+	if m.ctx != nil {
+		return m.ctx
+	}
+	return context.Background()
+}
+
+func (m *mockImportUsersStream) Recv() (*api.CreateUserRequest, error) {
+	if len(m.requests) == 0 {
+		return nil, io.EOF
+	}
+	req := m.requests[0]
+	m.requests = m.requests[1:]
+	return req, nil
+}
+
+func (m *mockImportUsersStream) SendAndClose(resp *api.ImportUsersResponse) error {
+	m.response = resp
+	return nil
+}
+
+func TestImportUsers_WritesValidRowsAndReportsFailures(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johndoe"},
+			{FirstName: "", LastName: "Doe", Email: "nameless@example.com", Password: "password123", Country: "USA", Nickname: "nameless"},
+			{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@example.com", Password: "password123", Country: "USA", Nickname: "janedoe"},
+			{FirstName: "Johnny", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johnnydoe"},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.NoError(t, err)
+
+	resp := stream.response
+	assert.Equal(t, int32(2), resp.CreatedCount)
+	assert.Equal(t, int32(2), resp.FailedCount)
+	assert.Len(t, resp.Results, 4)
+
+	for i, r := range resp.Results {
+		assert.Equal(t, int32(i), r.Index)
+	}
+
+	assert.NotEmpty(t, resp.Results[0].Id)
+	assert.Empty(t, resp.Results[0].Error)
+
+	assert.Empty(t, resp.Results[1].Id)
+	assert.Contains(t, resp.Results[1].Error, "FirstName cannot be empty")
+
+	assert.NotEmpty(t, resp.Results[2].Id)
+	assert.Equal(t, "jane.doe@example.com", resp.Results[2].Email)
+
+	assert.Empty(t, resp.Results[3].Id)
+	assert.Contains(t, resp.Results[3].Error, "email already exists: john.doe@example.com")
+
+	// Check the mock datasource call history, passwords are hashed before writing
+	assert.Len(t, mockDatasource.GetCallHistory(), 2)
+	assert.NotEqual(t, "password123", mockDatasource.GetCallHistory()[0].Password.String)
+
+	// check the mock notifier call history
+	assert.Len(t, mockNotifier.PublishedMessages, 2)
+	assert.Contains(t, string(mockNotifier.PublishedMessages[0]), "create")
+}
+
+func TestImportUsers_DataSourceErrorFailsRowsWithoutAbortingStream(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		TestRequiresError: true,
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johndoe"},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.NoError(t, err)
+
+	assert.Equal(t, int32(0), stream.response.CreatedCount)
+	assert.Equal(t, int32(1), stream.response.FailedCount)
+	assert.Equal(t, "failed to create user", stream.response.Results[0].Error)
+
+	assert.False(t, mockNotifier.PublishCalled)
+}
+
+func TestImportUsers_RejectedRowDoesNotFailItsBatch(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID:           "123e4567-e89b-12d3-a456-426614174000",
+		RejectedEmails: []string{"rejected@example.com"},
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johndoe"},
+			{FirstName: "Rejected", LastName: "Doe", Email: "rejected@example.com", Password: "password123", Country: "USA", Nickname: "rejected"},
+			{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@example.com", Password: "password123", Country: "USA", Nickname: "janedoe"},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.NoError(t, err)
+
+	resp := stream.response
+	assert.Equal(t, int32(2), resp.CreatedCount)
+	assert.Equal(t, int32(1), resp.FailedCount)
+
+	assert.NotEmpty(t, resp.Results[0].Id)
+	assert.NotEmpty(t, resp.Results[2].Id)
+
+	// only the row the datasource rejected fails
+	assert.Empty(t, resp.Results[1].Id)
+	assert.Equal(t, "value too long for type character varying(100)", resp.Results[1].Error)
+
+	assert.Equal(t, 4, mockDatasource.CreateUsersCalls)
+	assert.Len(t, mockNotifier.PublishedMessages, 2)
+}
+
+func TestImportUsers_UnavailableDatasourceIsNotRetriedRowByRow(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		CreateUsersError: &service.UnavailableError{Err: errors.New("connection refused")},
+	}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johndoe"},
+			{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@example.com", Password: "password123", Country: "USA", Nickname: "janedoe"},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.NoError(t, err)
+
+	assert.Equal(t, 1, mockDatasource.CreateUsersCalls)
+	assert.Equal(t, int32(2), stream.response.FailedCount)
+	for _, r := range stream.response.Results {
+		assert.Equal(t, "failed to create user", r.Error)
+	}
+}
+
+func TestImportUsers_StopsOnceTheClientHasGone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	mockDatasource := &postgres.MockClient{
+		CreateUsersError: fmt.Errorf("database error: %w", context.Canceled),
+	}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		ctx: ctx,
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: "johndoe"},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.Equal(t, codes.Canceled, status.Code(err))
+	assert.Equal(t, 1, mockDatasource.CreateUsersCalls)
+	assert.Nil(t, stream.response)
+}
+
+func TestImportUsers_RejectsValuesLongerThanTheirColumn(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow)
+
+	stream := &mockImportUsersStream{
+		requests: []*api.CreateUserRequest{
+			{FirstName: "John", LastName: "Doe", Email: "john.doe@example.com", Password: "password123", Country: "USA", Nickname: strings.Repeat("n", 101)},
+			{FirstName: "Jane", LastName: "Doe", Email: "jane.doe@example.com", Password: strings.Repeat("p", 73), Country: "USA", Nickname: "janedoe"},
+			{FirstName: "Jim", LastName: "Doe", Email: "jim.doe@example.com", Password: "password123", Country: "USA", Nickname: strings.Repeat("ñ", 100)},
+		},
+	}
+
+	err := srv.ImportUsers(stream)
+	assert.NoError(t, err)
+
+	resp := stream.response
+	assert.Equal(t, int32(1), resp.CreatedCount)
+	assert.Equal(t, int32(2), resp.FailedCount)
+
+	assert.Contains(t, resp.Results[0].Error, "NickName cannot be longer than 100 characters")
+	assert.Contains(t, resp.Results[1].Error, "Password cannot be longer than 72 bytes")
+	assert.NotEmpty(t, resp.Results[2].Id)
+
+	assert.Len(t, mockDatasource.GetCallHistory(), 1)
+}
+
+type mockExportUsersStream struct {
+	grpc.ServerStream
+	users []*api.User
+}
+
+func (m *mockExportUsersStream) Context() context.Context {
+	return context.Background()
+}
+
+func (m *mockExportUsersStream) Send(user *api.User) error {
+	m.users = append(m.users, user)
+	return nil
+}
+
+func TestExportUsers_StreamsFromDataSource(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockExportUsersStream{}
+
+	err := srv.ExportUsers(&api.ExportUsersRequest{FilterCountry: "US"}, stream)
+	assert.NoError(t, err)
+
+	assert.Len(t, stream.users, 2)
+	assert.Equal(t, "John", stream.users[0].FirstName)
+	assert.Equal(t, "Jane", stream.users[1].FirstName)
+	assert.NotEmpty(t, stream.users[0].CreatedAt)
+}
+
+func TestExportUsers_FiltersByEmailVerified(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockExportUsersStream{}
+	err := srv.ExportUsers(&api.ExportUsersRequest{FilterEmailVerified: utils.Ptr(true)}, stream)
+	assert.NoError(t, err)
+	assert.Len(t, stream.users, 1)
+	assert.Equal(t, "John", stream.users[0].FirstName)
+
+	stream = &mockExportUsersStream{}
+	err = srv.ExportUsers(&api.ExportUsersRequest{FilterEmailVerified: utils.Ptr(false)}, stream)
+	assert.NoError(t, err)
+	assert.Len(t, stream.users, 1)
+	assert.Equal(t, "Jane", stream.users[0].FirstName)
+}
+
+func TestExportUsers_IncludeDeleted(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	err := srv.ExportUsers(&api.ExportUsersRequest{}, &mockExportUsersStream{})
+	assert.NoError(t, err)
+	err = srv.ExportUsers(&api.ExportUsersRequest{IncludeDeleted: true}, &mockExportUsersStream{})
+	assert.NoError(t, err)
+
+	assert.Len(t, mockDatasource.ExportUsersCallHistory, 2)
+	assert.False(t, mockDatasource.ExportUsersCallHistory[0].IncludeDeleted)
+	assert.True(t, mockDatasource.ExportUsersCallHistory[1].IncludeDeleted)
+}
+
+func TestExportUsers_DataSourceError(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		TestRequiresError: true,
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	stream := &mockExportUsersStream{}
+
+	err := srv.ExportUsers(&api.ExportUsersRequest{}, stream)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to export users: mock db error for export users")
+	assert.Len(t, stream.users, 0)
+}
+
+func TestGetUsers_KeysetPagination(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	// A full page without a page number hands back a cursor for the next one
+	resp, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{PageSize: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 2)
+	assert.NotEmpty(t, resp.NextPageToken)
+
+	resp2, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{PageSize: 2, PageToken: resp.NextPageToken})
+	assert.NoError(t, err)
+	assert.NotNil(t, resp2)
+
+	// Paging by page number keeps the existing behaviour
+	resp3, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{Page: 1, PageSize: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, resp3.NextPageToken)
+}
+
+func TestGetUsers_PageTokenValidationErrors(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	tests := []struct {
+		name        string
+		req         *api.GetUsersRequest
+		expectedErr string
+	}{
+		{
+			name:        "page and page token",
+			req:         &api.GetUsersRequest{Page: 1, PageSize: 2, PageToken: "abc"},
+			expectedErr: "only one of Page or PageToken can be supplied",
+		},
+		{
+			name:        "malformed page token",
+			req:         &api.GetUsersRequest{PageSize: 2, PageToken: "not-a-token"},
+			expectedErr: "invalid page token",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := srv.GetUsers(context.Background(), tc.req)
+
+			assert.Nil(t, resp)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+}
+
+func TestGetUsers_ReturnsTotalCountFromDataSource(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		TotalUsers: 42,
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	resp, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{Page: 1, PageSize: 2})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 2)
+	assert.Equal(t, int32(42), resp.TotalCount)
+}
+
+func TestModifyUser_UpdateMaskWritesOnlyMaskedFields(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174001",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	// Mock gRPC request clearing the nickname and ignoring the unmasked last name
+	req := &api.ModifyUserRequest{
+		Id:         "123e4567-e89b-12d3-a456-426614174001",
+		FirstName:  "John",
+		LastName:   "Ignored",
+		Nickname:   "",
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"first_name", "nickname"}},
+	}
+
+	// Call the gRPC method
+	resp, err := srv.ModifyUser(context.Background(), req)
+	assert.NoError(t, err)
+	assert.Equal(t, "Successfully modified user", resp.Message)
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174001", resp.User.Id)
+
+	// Check the mock datasource call history
+	assert.Len(t, mockDatasource.GetCallHistory(), 1)
+	written := mockDatasource.GetCallHistory()[0]
+	assert.True(t, written.FirstName.Valid)
+	assert.Equal(t, "John", written.FirstName.String)
+	assert.True(t, written.Nickname.Valid)
+	assert.Equal(t, "", written.Nickname.String)
+	assert.False(t, written.LastName.Valid)
+	assert.False(t, written.Email.Valid)
+	assert.False(t, written.Password.Valid)
+	assert.False(t, written.Country.Valid)
+}
+
+func TestModifyUser_UpdateMaskValidationErrors(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174001",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	tests := []struct {
+		name        string
+		paths       []string
+		expectedErr string
+	}{
+		{
+			name:        "unknown field",
+			paths:       []string{"created_at"},
+			expectedErr: `UpdateMask contains unknown field "created_at"`,
+		},
+		{
+			name:        "id cannot be masked",
+			paths:       []string{"id"},
+			expectedErr: `UpdateMask contains unknown field "id"`,
+		},
+		{
+			name:        "clearing a required field",
+			paths:       []string{"email"},
+			expectedErr: "email cannot be cleared",
+		},
+		{
+			name:        "clearing the password",
+			paths:       []string{"password"},
+			expectedErr: "password cannot be cleared",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := &api.ModifyUserRequest{
+				Id:         "123e4567-e89b-12d3-a456-426614174001",
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: tc.paths},
+			}
+
+			resp, err := srv.ModifyUser(context.Background(), req)
+
+			assert.Nil(t, resp)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+
+	assert.Len(t, mockDatasource.GetCallHistory(), 0)
+}
+
+func TestGetUsers_OrderBy(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	resp, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{PageSize: 2, OrderBy: "last_name, created_at desc"})
+	assert.NoError(t, err)
+	assert.Len(t, resp.Users, 2)
+	// Cursors only follow the default order so a custom order pages by number
+	assert.Empty(t, resp.NextPageToken)
+
+	assert.Len(t, mockDatasource.GetUsersCallHistory, 1)
+	args := mockDatasource.GetUsersCallHistory[0]
+	assert.Equal(t, []string{"last_name", "created_at"}, args.OrderByFields)
+	assert.Equal(t, []string{"ASC", "DESC"}, args.OrderByDirections)
+}
+
+func TestGetUsers_OrderByValidationErrors(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	tests := []struct {
+		name        string
+		req         *api.GetUsersRequest
+		expectedErr string
+	}{
+		{
+			name:        "field not in allow-list",
+			req:         &api.GetUsersRequest{Page: 1, PageSize: 2, OrderBy: "password"},
+			expectedErr: `cannot order by "password"`,
+		},
+		{
+			name:        "unknown direction",
+			req:         &api.GetUsersRequest{Page: 1, PageSize: 2, OrderBy: "email up"},
+			expectedErr: `unknown direction "up"`,
+		},
+		{
+			name:        "order by with page token",
+			req:         &api.GetUsersRequest{PageSize: 2, PageToken: "abc", OrderBy: "email"},
+			expectedErr: "OrderBy cannot be combined with PageToken",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := srv.GetUsers(context.Background(), tc.req)
+
+			assert.Nil(t, resp)
+			assert.Equal(t, codes.InvalidArgument, status.Code(err))
+			assert.Contains(t, err.Error(), tc.expectedErr)
+		})
+	}
+
+	assert.Len(t, mockDatasource.GetUsersCallHistory, 0)
+}
+
+func TestAuthenticateUser(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	// Create the gRPC server with the mock
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow)
+
+	_, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "UK",
+		Nickname:  "johndoe",
+	})
+	assert.NoError(t, err)
+	mockNotifier.PublishedMessages = nil
+
+	tests := []struct {
+		name         string
+		req          *api.AuthenticateUserRequest
+		expectedCode codes.Code
+		expectedMsg  string
+		expectedUser string
+	}{
+		{
+			name:         "correct credentials",
+			req:          &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password123"},
+			expectedCode: codes.OK,
+			expectedMsg:  `{"changeType":"authenticate","eventTime":"2025-01-01T00:00:00Z","userId":"123e4567-e89b-12d3-a456-426614174000","outcome":"success","tenantId":"default"}`,
+			expectedUser: "123e4567-e89b-12d3-a456-426614174000",
+		},
+		{
+			name:         "wrong password",
+			req:          &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password321"},
+			expectedCode: codes.Unauthenticated,
+			expectedMsg:  `{"changeType":"authenticate","eventTime":"2025-01-01T00:00:00Z","userId":"","outcome":"failure","tenantId":"default"}`,
+		},
+		{
+			name:         "unknown email",
+			req:          &api.AuthenticateUserRequest{Email: "jane.doe@example.com", Password: "password123"},
+			expectedCode: codes.Unauthenticated,
+			expectedMsg:  `{"changeType":"authenticate","eventTime":"2025-01-01T00:00:00Z","userId":"","outcome":"failure","tenantId":"default"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			mockNotifier.PublishedMessages = nil
+
+			resp, err := srv.AuthenticateUser(context.Background(), tc.req)
+
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+			if tc.expectedCode == codes.OK {
+				assert.Equal(t, tc.expectedUser, resp.User.Id)
+				assert.Equal(t, "john.doe@example.com", resp.User.Email)
+			} else {
+				assert.Nil(t, resp)
+				// the same message whichever credential was wrong
+				assert.Equal(t, "invalid email or password", status.Convert(err).Message())
+			}
+
+			assert.Len(t, mockNotifier.PublishedMessages, 1)
+			assert.JSONEq(t, tc.expectedMsg, string(mockNotifier.PublishedMessages[0]))
+		})
+	}
+}
+
+func TestAuthenticateUser_ValidationAndDataSourceErrors(t *testing.T) {
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	srv := NewServer(&postgres.MockClient{}, mockNotifier, mockTimeNow)
+
+	resp, err := srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com"})
+	assert.Nil(t, resp)
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Contains(t, err.Error(), "Password cannot be empty")
+
+	srv = NewServer(&postgres.MockClient{TestRequiresError: true}, mockNotifier, mockTimeNow)
+
+	resp, err = srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password123"})
+	assert.Nil(t, resp)
+	assert.Contains(t, err.Error(), "failed to get user credentials: mock db error for get user credentials")
+
+	// neither case is an authentication attempt so nothing is published
+	assert.Len(t, mockNotifier.PublishedMessages, 0)
+}
+
+type mockTokenIssuer struct {
+	issued []service.TokenClaims
+}
+
+func (m *mockTokenIssuer) IssueToken(claims service.TokenClaims) (string, service.TokenClaims, error) {
+	claims.IssuedAt = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	claims.ExpiresAt = claims.IssuedAt.Add(15 * time.Minute)
+	m.issued = append(m.issued, claims)
+	return "token-for-" + claims.UserID, claims, nil
+}
+
+func (m *mockTokenIssuer) ValidateToken(token string) (service.TokenClaims, error) {
+	for _, claims := range m.issued {
+		if token == "token-for-"+claims.UserID {
+			return claims, nil
+		}
+	}
+	return service.TokenClaims{}, fmt.Errorf("%w: unknown token", service.ErrInvalidToken)
+}
+
+func TestAuthenticateUser_IssuesAccessToken(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	tokens := &mockTokenIssuer{}
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow, WithTokenIssuer(tokens))
+
+	_, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "UK",
+		Nickname:  "johndoe",
+	})
+	assert.NoError(t, err)
+
+	resp, err := srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password123"})
+	assert.NoError(t, err)
+	assert.Equal(t, "token-for-123e4567-e89b-12d3-a456-426614174000", resp.AccessToken)
+	assert.Equal(t, "Bearer", resp.TokenType)
+	assert.Equal(t, "2025-01-01T00:15:00Z", resp.ExpiresAt)
+	assert.Equal(t, []service.TokenClaims{{
+		UserID:    "123e4567-e89b-12d3-a456-426614174000",
+		Email:     "john.doe@example.com",
+		TenantID:  service.DefaultTenant,
+		IssuedAt:  time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExpiresAt: time.Date(2025, 1, 1, 0, 15, 0, 0, time.UTC),
+	}}, tokens.issued)
+
+	_, err = srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password321"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Len(t, tokens.issued, 1)
+
+	// without an issuer the user is still authenticated but no token is returned
+	srv = NewServer(mockDatasource, mockNotifier, mockTimeNow)
+	resp, err = srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password123"})
+	assert.NoError(t, err)
+	assert.Empty(t, resp.AccessToken)
+	assert.Empty(t, resp.TokenType)
+}
+
+func TestValidateToken(t *testing.T) {
+	tokens := &mockTokenIssuer{}
+	_, _, err := tokens.IssueToken(service.TokenClaims{UserID: "123", Email: "john.doe@example.com"})
+	assert.NoError(t, err)
+
+	srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, time.Now, WithTokenIssuer(tokens))
+
+	resp, err := srv.ValidateToken(context.Background(), &api.ValidateTokenRequest{Token: "token-for-123"})
+	assert.NoError(t, err)
+	assert.Equal(t, &api.ValidateTokenResponse{
+		UserId:    "123",
+		Email:     "john.doe@example.com",
+		IssuedAt:  "2025-01-01T00:00:00Z",
+		ExpiresAt: "2025-01-01T00:15:00Z",
+		// tokens issued before tenancy belong to the default tenant
+		TenantId: service.DefaultTenant,
+	}, resp)
+
+	_, _, err = tokens.IssueToken(service.TokenClaims{UserID: "789", Email: "jane.doe@example.com", TenantID: "brand-a"})
+	assert.NoError(t, err)
+	resp, err = srv.ValidateToken(context.Background(), &api.ValidateTokenRequest{Token: "token-for-789"})
+	assert.NoError(t, err)
+	assert.Equal(t, "brand-a", resp.TenantId)
+
+	_, err = srv.ValidateToken(context.Background(), &api.ValidateTokenRequest{Token: "token-for-456"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	assert.Equal(t, "invalid token: unknown token", status.Convert(err).Message())
+
+	_, err = srv.ValidateToken(context.Background(), &api.ValidateTokenRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Token cannot be empty", status.Convert(err).Message())
+
+	srv = NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, time.Now)
+	_, err = srv.ValidateToken(context.Background(), &api.ValidateTokenRequest{Token: "token-for-123"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestPasswordReset(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+	mockSender := &notifier.MockPasswordResetSender{}
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockTimeNow := func() time.Time {
+		return now
+	}
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow, WithPasswordResetSender(mockSender))
+
+	_, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "UK",
+		Nickname:  "johndoe",
+	})
+	assert.NoError(t, err)
+
+	// an unknown email gets the same response and no token
+	unknown, err := srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "jane.doe@example.com"})
+	assert.NoError(t, err)
+	assert.Empty(t, mockSender.SentTokens)
+
+	resp, err := srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "john.doe@example.com"})
+	assert.NoError(t, err)
+	assert.Equal(t, unknown.Message, resp.Message)
+	srv.background.Wait()
+
+	token := mockSender.SentTokens["john.doe@example.com"]
+	assert.NotEmpty(t, token)
+	assert.Len(t, mockDatasource.PasswordResetTokens, 1)
+	assert.NotEqual(t, token, mockDatasource.PasswordResetTokens[0].TokenHash, "only a hash of the token is stored")
+	assert.Equal(t, now.Add(time.Hour), mockDatasource.PasswordResetTokens[0].ExpiresAt)
+
+	mockNotifier.PublishedMessages = nil
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: token, NewPassword: "newpassword456"})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"changeType":"password_reset","eventTime":"2025-01-01T00:00:00Z","userId":"123e4567-e89b-12d3-a456-426614174000","tenantId":"default"}`, string(mockNotifier.PublishedMessages[0]))
+
+	written := mockDatasource.WriteUserRowCallHistory[len(mockDatasource.WriteUserRowCallHistory)-1]
+	assert.NotEqual(t, "newpassword456", written.Password.String, "the new password is hashed")
+
+	_, err = srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "newpassword456"})
+	assert.NoError(t, err)
+	_, err = srv.AuthenticateUser(context.Background(), &api.AuthenticateUserRequest{Email: "john.doe@example.com", Password: "password123"})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	// the token can only be used once
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: token, NewPassword: "anotherpassword"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "failed to complete password reset: password reset token is invalid or expired", status.Convert(err).Message())
+
+	// and stops working once it expires
+	_, err = srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "john.doe@example.com"})
+	assert.NoError(t, err)
+	srv.background.Wait()
+	now = now.Add(time.Hour)
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: mockSender.SentTokens["john.doe@example.com"], NewPassword: "anotherpassword"})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestPasswordReset_ValidationAndDataSourceErrors(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	mockSender := &notifier.MockPasswordResetSender{}
+
+	srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow)
+	_, err := srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "john.doe@example.com"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	srv = NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow, WithPasswordResetSender(mockSender))
+	_, err = srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Email cannot be empty", status.Convert(err).Message())
+
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Token cannot be empty; NewPassword cannot be empty", status.Convert(err).Message())
+
+	// bcrypt refuses anything past 72 bytes, that is the caller's mistake rather than an internal error
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: "token", NewPassword: strings.Repeat("a", 73)})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "NewPassword cannot be longer than 72 bytes", status.Convert(err).Message())
+
+	srv = NewServer(&postgres.MockClient{TestRequiresError: true}, &notifier.MockNotifier{}, mockTimeNow, WithPasswordResetSender(mockSender))
+	_, err = srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "john.doe@example.com"})
+	assert.Error(t, err)
+	_, err = srv.CompletePasswordReset(context.Background(), &api.CompletePasswordResetRequest{Token: "token", NewPassword: "newpassword456"})
+	assert.Error(t, err)
+
+	mockDatasource := &postgres.MockClient{WriteUserRowCallHistory: dto.UsersDTO{{Email: utils.ToNullString("john.doe@example.com")}}}
+	// failing to send is only logged, an error would give away that the email is registered
+	failingSender := &notifier.MockPasswordResetSender{TestRequiresSendError: true}
+	srv = NewServer(mockDatasource, &notifier.MockNotifier{}, mockTimeNow, WithPasswordResetSender(failingSender))
+	_, err = srv.RequestPasswordReset(context.Background(), &api.RequestPasswordResetRequest{Email: "john.doe@example.com"})
+	assert.NoError(t, err)
+	srv.background.Wait()
+	assert.Empty(t, failingSender.SentTokens)
+}
+
+func TestEmailVerification(t *testing.T) {
+	mockDatasource := &postgres.MockClient{
+		UUID: "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	mockNotifier := &notifier.MockNotifier{}
+	mockSender := &notifier.MockEmailVerificationSender{}
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	mockTimeNow := func() time.Time {
+		return now
+	}
+	srv := NewServer(mockDatasource, mockNotifier, mockTimeNow, WithEmailVerificationSender(mockSender))
+
+	_, err := srv.CreateUser(context.Background(), &api.CreateUserRequest{
+		FirstName: "John",
+		LastName:  "Doe",
+		Email:     "john.doe@example.com",
+		Password:  "password123",
+		Country:   "UK",
+		Nickname:  "johndoe",
+	})
+	assert.NoError(t, err)
+
+	_, err = srv.SendEmailVerification(context.Background(), &api.SendEmailVerificationRequest{Id: "123e4567-e89b-12d3-a456-426614174000"})
+	assert.NoError(t, err)
+
+	token := mockSender.SentTokens["john.doe@example.com"]
+	assert.NotEmpty(t, token)
+	assert.Len(t, mockDatasource.EmailVerificationTokens, 1)
+	assert.NotEqual(t, token, mockDatasource.EmailVerificationTokens[0].TokenHash, "only a hash of the token is stored")
+	assert.Equal(t, now.Add(24*time.Hour), mockDatasource.EmailVerificationTokens[0].ExpiresAt)
+
+	mockNotifier.PublishedMessages = nil
+	_, err = srv.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: token})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"changeType":"email_verified","eventTime":"2025-01-01T00:00:00Z","userId":"123e4567-e89b-12d3-a456-426614174000","tenantId":"default"}`, string(mockNotifier.PublishedMessages[0]))
+
+	written := mockDatasource.WriteUserRowCallHistory[len(mockDatasource.WriteUserRowCallHistory)-1]
+	assert.Equal(t, "john.doe@example.com", written.Email.String)
+	assert.Equal(t, now, written.EmailVerifiedAt.Time)
+
+	// the token can only be used once
+	_, err = srv.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: token})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	// and stops working once it expires
+	_, err = srv.SendEmailVerification(context.Background(), &api.SendEmailVerificationRequest{Id: "123e4567-e89b-12d3-a456-426614174000"})
+	assert.NoError(t, err)
+	now = now.Add(24 * time.Hour)
+	_, err = srv.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{Token: mockSender.SentTokens["john.doe@example.com"]})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "failed to confirm email: email verification token is invalid or expired", status.Convert(err).Message())
+}
+
+func TestSendEmailVerification_Errors(t *testing.T) {
+	mockTimeNow := func() time.Time {
+		return time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	srv := NewServer(&postgres.MockClient{}, &notifier.MockNotifier{}, mockTimeNow)
+	_, err := srv.SendEmailVerification(context.Background(), &api.SendEmailVerificationRequest{Id: "123"})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+
+	tests := []struct {
+		name           string
+		mockDatasource *postgres.MockClient
+		mockSender     *notifier.MockEmailVerificationSender
+		req            *api.SendEmailVerificationRequest
+		expectedCode   codes.Code
+	}{
+		{
+			name:           "missing id",
+			mockDatasource: &postgres.MockClient{UUID: "123"},
+			mockSender:     &notifier.MockEmailVerificationSender{},
+			req:            &api.SendEmailVerificationRequest{},
+			expectedCode:   codes.InvalidArgument,
+		},
+		{
+			name:           "unknown user",
+			mockDatasource: &postgres.MockClient{UUID: "123"},
+			mockSender:     &notifier.MockEmailVerificationSender{},
+			req:            &api.SendEmailVerificationRequest{Id: "456"},
+			expectedCode:   codes.NotFound,
+		},
+		{
+			name:           "already verified",
+			mockDatasource: &postgres.MockClient{UUID: "123", EmailAlreadyVerified: true},
+			mockSender:     &notifier.MockEmailVerificationSender{},
+			req:            &api.SendEmailVerificationRequest{Id: "123"},
+			expectedCode:   codes.FailedPrecondition,
+		},
+		{
+			name:           "datasource error",
+			mockDatasource: &postgres.MockClient{UUID: "123", TestRequiresError: true},
+			mockSender:     &notifier.MockEmailVerificationSender{},
+			req:            &api.SendEmailVerificationRequest{Id: "123"},
+			expectedCode:   codes.Unknown,
+		},
+		{
+			name:           "send error",
+			mockDatasource: &postgres.MockClient{UUID: "123"},
+			mockSender:     &notifier.MockEmailVerificationSender{TestRequiresSendError: true},
+			req:            &api.SendEmailVerificationRequest{Id: "123"},
+			expectedCode:   codes.Unknown,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := NewServer(tc.mockDatasource, &notifier.MockNotifier{}, mockTimeNow, WithEmailVerificationSender(tc.mockSender))
+
+			_, err := srv.SendEmailVerification(context.Background(), tc.req)
+			assert.Equal(t, tc.expectedCode, status.Code(err))
+		})
+	}
+
+	_, err = srv.ConfirmEmail(context.Background(), &api.ConfirmEmailRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, "Token cannot be empty", status.Convert(err).Message())
+}
+
+func TestGetUsers_EmailVerificationStatus(t *testing.T) {
+	mockDatasource := &postgres.MockClient{}
+	srv := NewServer(mockDatasource, &notifier.MockNotifier{}, time.Now)
+
+	resp, err := srv.GetUsers(context.Background(), &api.GetUsersRequest{FilterEmailVerified: utils.Ptr(true)})
+	assert.NoError(t, err)
+	assert.Equal(t, sql.NullBool{Bool: true, Valid: true}, mockDatasource.GetUsersCallHistory[0].FilterEmailVerified)
+
+	assert.True(t, resp.Users[0].EmailVerified)
+	assert.NotEmpty(t, resp.Users[0].EmailVerifiedAt)
+	assert.Empty(t, resp.Users[0].PendingEmail)
+	assert.False(t, resp.Users[1].EmailVerified)
+	assert.Empty(t, resp.Users[1].EmailVerifiedAt)
+	assert.Equal(t, "jane@example.com", resp.Users[1].PendingEmail)
+
+	_, err = srv.GetUsers(context.Background(), &api.GetUsersRequest{})
+	assert.NoError(t, err)
+	assert.False(t, mockDatasource.GetUsersCallHistory[1].FilterEmailVerified.Valid)
+}
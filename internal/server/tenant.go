@@ -0,0 +1,72 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/EFG/internal/service"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// TenantHeader is the metadata key a caller names its tenant in.
+const TenantHeader = "x-tenant-id"
+
+// NewTenantInterceptors scope every call to a single tenant, so it only reads and writes that
+// tenant's users. The access token or API key of an authenticated caller decides the tenant, and
+// naming a different one in the x-tenant-id header is refused. For anonymous callers the header is
+// trusted, and a call naming no tenant at all belongs to the default tenant.
+func NewTenantInterceptors() (grpc.UnaryServerInterceptor, grpc.StreamServerInterceptor) {
+	unary := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		tenantID, err := resolveTenant(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(service.WithTenant(ctx, tenantID), req)
+	}
+
+	stream := func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		tenantID, err := resolveTenant(ss.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &tenantStream{ServerStream: ss, ctx: service.WithTenant(ss.Context(), tenantID)})
+	}
+
+	return unary, stream
+}
+
+// tenantStream swaps in a context scoped to the tenant of the call.
+type tenantStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *tenantStream) Context() context.Context {
+	return s.ctx
+}
+
+func resolveTenant(ctx context.Context) (string, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	requested := firstMetadataValue(md, TenantHeader)
+
+	if caller := service.CallerFromContext(ctx); caller.Authenticated() {
+		if requested != "" && requested != caller.TenantID {
+			slog.Warn("refused call naming another tenant than its credentials", "actor", caller.Actor(), "callerTenant", caller.TenantID, "requestedTenant", requested)
+			return "", status.Error(codes.PermissionDenied, "credentials were not issued for the requested tenant")
+		}
+		return caller.TenantID, nil
+	}
+
+	if requested == "" {
+		return service.DefaultTenant, nil
+	}
+
+	if err := service.ValidateTenantID(TenantHeader, requested); err != nil {
+		return "", statusFromError(err)
+	}
+
+	return requested, nil
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestTenantInterceptor(t *testing.T) {
+	tokens := &mockTokenIssuer{}
+	tenantToken, _, err := tokens.IssueToken(service.TokenClaims{UserID: "123e4567-e89b-12d3-a456-426614174001", TenantID: "brand-a"})
+	assert.NoError(t, err)
+	defaultToken, _, err := tokens.IssueToken(service.TokenClaims{UserID: "123e4567-e89b-12d3-a456-426614174002"})
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		md             metadata.MD
+		expectedTenant string
+		expectedCode   codes.Code
+	}{
+		{
+			name:           "token decides the tenant",
+			md:             metadata.Pairs("authorization", "Bearer "+tenantToken),
+			expectedTenant: "brand-a",
+		},
+		{
+			name:           "header matching the token",
+			md:             metadata.Pairs("authorization", "Bearer "+tenantToken, TenantHeader, "brand-a"),
+			expectedTenant: "brand-a",
+		},
+		{
+			name:         "header naming another tenant than the token",
+			md:           metadata.Pairs("authorization", "Bearer "+tenantToken, TenantHeader, "brand-b"),
+			expectedCode: codes.PermissionDenied,
+		},
+		{
+			name:           "token without a tenant",
+			md:             metadata.Pairs("authorization", "Bearer "+defaultToken),
+			expectedTenant: service.DefaultTenant,
+		},
+		{
+			name:           "header without a token",
+			md:             metadata.Pairs(TenantHeader, "brand-b"),
+			expectedTenant: "brand-b",
+		},
+		{
+			name:           "invalid token falls back to the header",
+			md:             metadata.Pairs("authorization", "Bearer not-a-token", TenantHeader, "brand-b"),
+			expectedTenant: "brand-b",
+		},
+		{
+			name:         "invalid tenant header",
+			md:           metadata.Pairs(TenantHeader, "Brand A"),
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:           "no token or header",
+			md:             metadata.MD{},
+			expectedTenant: service.DefaultTenant,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticationUnary, _ := NewAuthenticationInterceptors(tokens, &postgres.MockClient{}, false, time.Now)
+			tenantUnary, _ := NewTenantInterceptors()
+			unary := chainUnary(authenticationUnary, tenantUnary)
+			ctx := metadata.NewIncomingContext(context.Background(), tt.md)
+
+			var tenantID string
+			_, err := unary(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+				tenantID = service.TenantFromContext(ctx)
+				return nil, nil
+			})
+
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+			assert.Equal(t, tt.expectedTenant, tenantID)
+		})
+	}
+}
@@ -2,39 +2,298 @@ package server
 
 import (
 	"fmt"
+	"slices"
+	"time"
+	"unicode/utf8"
 
 	"github.com/EFG/api"
+	"github.com/EFG/internal/service"
 )
 
-type requiredFields map[string]string
+// Column limits of the users table, in characters, checked up front so one oversized value is
+// reported against its own row rather than failing the write of a whole import batch.
+const (
+	maxNameLength     = 255
+	maxNicknameLength = 100
+	maxEmailLength    = 320
+	maxCountryLength  = 100
+	// bcrypt only looks at the first 72 bytes of a password and refuses anything longer
+	maxPasswordBytes = 72
+)
 
-func validateCreateUserRequest(req *api.CreateUserRequest) error {
-	fields := requiredFields{
-		"FirstName": req.FirstName,
-		"LastName":  req.LastName,
-		"Email":     req.Email,
-		"Password":  req.Password,
-		"Country":   req.Country,
-		"NickName":  req.Nickname,
-	}
-
-	for field, value := range fields {
-		if value == "" {
-			return fmt.Errorf("%s cannot be empty", field)
+// requiredField is a request value that must be supplied, field is the API name reported in
+// violations and name is the name used in the description. A non zero maxLength caps the value
+// in characters.
+type requiredField struct {
+	field     string
+	name      string
+	value     string
+	maxLength int
+}
+
+// validateRequiredFields reports a violation for every required field left empty or too long.
+func validateRequiredFields(fields []requiredField) error {
+	var violations []service.FieldViolation
+	for _, f := range fields {
+		switch {
+		case f.value == "":
+			violations = append(violations, service.FieldViolation{
+				Field:       f.field,
+				Description: fmt.Sprintf("%s cannot be empty", f.name),
+			})
+		case f.maxLength > 0 && utf8.RuneCountInString(f.value) > f.maxLength:
+			violations = append(violations, service.FieldViolation{
+				Field:       f.field,
+				Description: fmt.Sprintf("%s cannot be longer than %d characters", f.name, f.maxLength),
+			})
 		}
 	}
 
+	if len(violations) > 0 {
+		return &service.InvalidArgumentError{Violations: violations}
+	}
+
+	return nil
+}
+
+func validateCreateUserRequest(req *api.CreateUserRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: service.FieldFirstName, name: "FirstName", value: req.FirstName, maxLength: maxNameLength},
+		{field: service.FieldLastName, name: "LastName", value: req.LastName, maxLength: maxNameLength},
+		{field: service.FieldEmail, name: "Email", value: req.Email, maxLength: maxEmailLength},
+		{field: service.FieldPassword, name: "Password", value: req.Password},
+		{field: service.FieldCountry, name: "Country", value: req.Country, maxLength: maxCountryLength},
+		{field: service.FieldNickname, name: "NickName", value: req.Nickname, maxLength: maxNicknameLength},
+	}); err != nil {
+		return err
+	}
+
+	return validatePasswordLength(service.FieldPassword, "Password", req.Password)
+}
+
+// validatePasswordLength rejects a password bcrypt would refuse to hash, field and name are as
+// for requiredField.
+func validatePasswordLength(field, name, password string) error {
+	if len(password) > maxPasswordBytes {
+		return service.NewInvalidArgumentError(field, "%s cannot be longer than %d bytes", name, maxPasswordBytes)
+	}
+
 	return nil
 }
 
 func validateExistingUserRequest(req *api.ModifyUserRequest) error {
-	fields := requiredFields{
-		"Id": req.Id,
+	if err := validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	}); err != nil {
+		return err
+	}
+
+	return validatePasswordLength(service.FieldPassword, "Password", req.Password)
+}
+
+// validateDeleteUserRequest also rejects an etag on a hard delete, purging is never conditional.
+func validateDeleteUserRequest(req *api.DeleteUserRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	}); err != nil {
+		return err
+	}
+
+	if req.HardDelete && req.Etag != "" {
+		return service.NewInvalidArgumentError("etag", "Etag cannot be combined with HardDelete")
+	}
+
+	return nil
+}
+
+func validateAuthenticateUserRequest(req *api.AuthenticateUserRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: service.FieldEmail, name: "Email", value: req.Email},
+		{field: service.FieldPassword, name: "Password", value: req.Password},
+	})
+}
+
+func validateValidateTokenRequest(req *api.ValidateTokenRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "token", name: "Token", value: req.Token},
+	})
+}
+
+func validateRequestPasswordResetRequest(req *api.RequestPasswordResetRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: service.FieldEmail, name: "Email", value: req.Email},
+	})
+}
+
+func validateCompletePasswordResetRequest(req *api.CompletePasswordResetRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: "token", name: "Token", value: req.Token},
+		{field: "new_password", name: "NewPassword", value: req.NewPassword},
+	}); err != nil {
+		return err
+	}
+
+	return validatePasswordLength("new_password", "NewPassword", req.NewPassword)
+}
+
+func validateSendEmailVerificationRequest(req *api.SendEmailVerificationRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	})
+}
+
+func validateConfirmEmailRequest(req *api.ConfirmEmailRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "token", name: "Token", value: req.Token},
+	})
+}
+
+func validateRestoreUserRequest(req *api.RestoreUserRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	})
+}
+
+func validatePurgeUserRequest(req *api.PurgeUserRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	})
+}
+
+func validateGetUserHistoryRequest(req *api.GetUserHistoryRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	}); err != nil {
+		return err
+	}
+
+	if req.PageSize < 0 || req.PageSize > service.MaxHistoryPageSize {
+		return service.NewInvalidArgumentError("page_size", "PageSize must be between 1 and %d", service.MaxHistoryPageSize)
+	}
+
+	return nil
+}
+
+func validateAssignRoleRequest(req *api.AssignRoleRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+		{field: "role", name: "Role", value: req.Role},
+	}); err != nil {
+		return err
+	}
+
+	return service.ValidateRole("role", req.Role)
+}
+
+func validateRevokeRoleRequest(req *api.RevokeRoleRequest) error {
+	if err := validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+		{field: "role", name: "Role", value: req.Role},
+	}); err != nil {
+		return err
+	}
+
+	return service.ValidateRole("role", req.Role)
+}
+
+// apiKeyExpiryFromRequest validates a create api key request and returns when the key expires, zero
+// for a key that never expires.
+func apiKeyExpiryFromRequest(req *api.CreateApiKeyRequest, now time.Time) (time.Time, error) {
+	if err := validateRequiredFields([]requiredField{
+		{field: "name", name: "Name", value: req.Name},
+	}); err != nil {
+		return time.Time{}, err
+	}
+
+	if err := service.ValidateScopes("scopes", req.Scopes); err != nil {
+		return time.Time{}, err
 	}
 
-	for field, value := range fields {
-		if value == "" {
-			return fmt.Errorf("%s cannot be empty", field)
+	if req.ExpiresAt == "" {
+		return time.Time{}, nil
+	}
+
+	expiresAt, err := time.Parse(time.RFC3339, req.ExpiresAt)
+	if err != nil {
+		return time.Time{}, service.NewInvalidArgumentError("expires_at", "ExpiresAt must be an RFC3339 timestamp: %v", err)
+	}
+	if !expiresAt.After(now) {
+		return time.Time{}, service.NewInvalidArgumentError("expires_at", "ExpiresAt must be in the future")
+	}
+
+	return expiresAt, nil
+}
+
+func validateRotateApiKeyRequest(req *api.RotateApiKeyRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	})
+}
+
+func validateRevokeApiKeyRequest(req *api.RevokeApiKeyRequest) error {
+	return validateRequiredFields([]requiredField{
+		{field: "id", name: "Id", value: req.Id},
+	})
+}
+
+func validateGetUserRequest(req *api.GetUserRequest) error {
+	if req.Id == "" && req.Email == "" {
+		return service.NewInvalidArgumentError("id", "one of Id or Email must be supplied")
+	}
+
+	if req.Id != "" && req.Email != "" {
+		return service.NewInvalidArgumentError("email", "only one of Id or Email can be supplied")
+	}
+
+	return nil
+}
+
+func changeFilterFromRequest(req *api.WatchUserChangesRequest) (service.ChangeFilter, error) {
+	filter := service.ChangeFilter{
+		ChangeTypes: req.ChangeTypes,
+		UserID:      req.UserId,
+	}
+
+	if req.Since != "" {
+		since, err := time.Parse(time.RFC3339, req.Since)
+		if err != nil {
+			return service.ChangeFilter{}, service.NewInvalidArgumentError("since", "Since must be an RFC3339 timestamp: %v", err)
+		}
+		filter.Since = since
+	}
+
+	return filter, nil
+}
+
+func validateGetUsersRequest(req *api.GetUsersRequest) error {
+	if req.Page != 0 && req.PageToken != "" {
+		return service.NewInvalidArgumentError("page_token", "only one of Page or PageToken can be supplied")
+	}
+
+	if req.OrderBy != "" && req.PageToken != "" {
+		return service.NewInvalidArgumentError("order_by", "OrderBy cannot be combined with PageToken")
+	}
+
+	return nil
+}
+
+// validateUpdateMask checks every masked field can be updated and that required fields are not being cleared.
+func validateUpdateMask(req *api.ModifyUserRequest) error {
+	requiredWhenMasked := map[string]string{
+		service.FieldFirstName: req.FirstName,
+		service.FieldLastName:  req.LastName,
+		service.FieldEmail:     req.Email,
+		service.FieldPassword:  req.Password,
+		service.FieldCountry:   req.Country,
+	}
+
+	for _, path := range req.GetUpdateMask().GetPaths() {
+		if !slices.Contains(service.UpdatableFields, path) {
+			return service.NewInvalidArgumentError("update_mask", "UpdateMask contains unknown field %q", path)
+		}
+
+		if value, required := requiredWhenMasked[path]; required && value == "" {
+			return service.NewInvalidArgumentError(path, "%s cannot be cleared", path)
 		}
 	}
 
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/tracing"
+)
+
+// APIKeyPrefix starts every API key so a leaked key is easy to recognise, such as by secret scanners.
+const APIKeyPrefix = "umsk_"
+
+// permissions are every permission an API key can be scoped to.
+var permissions = []Permission{PermissionRead, PermissionWrite, PermissionDelete, PermissionAdmin}
+
+// APIKeyStore keeps the API keys services call with, only a hash of each key is stored.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key dto.APIKeyDTO) (dto.APIKeyDTO, error)
+	RotateAPIKey(ctx context.Context, id, keyHash string) (dto.APIKeyDTO, error)
+	RevokeAPIKey(ctx context.Context, id string) error
+	ListAPIKeys(ctx context.Context) ([]dto.APIKeyDTO, error)
+	GetAPIKeyByHash(ctx context.Context, keyHash string) (dto.APIKeyDTO, error)
+}
+
+// ValidateScopes checks every scope named by a caller is a permission.
+func ValidateScopes(field string, scopes []string) error {
+	if len(scopes) == 0 {
+		return NewInvalidArgumentError(field, "at least one scope is required")
+	}
+
+	for _, scope := range scopes {
+		if !slices.Contains(permissions, Permission(scope)) {
+			names := make([]string, len(permissions))
+			for i, p := range permissions {
+				names[i] = string(p)
+			}
+			return NewInvalidArgumentError(field, "unknown scope %q, expected any of %s", scope, strings.Join(names, ", "))
+		}
+	}
+
+	return nil
+}
+
+// CreateAPIKey creates a key for a service with the scopes, a zero expiresAt creates a key that never
+// expires. The key is returned alongside what is stored and can't be recovered afterwards.
+func CreateAPIKey(ctx context.Context, store APIKeyStore, name string, scopes []string, expiresAt time.Time) (_ string, _ dto.APIKeyDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.CreateAPIKey")
+	defer end(&err)
+
+	key, err := newAPIKey()
+	if err != nil {
+		slog.Error("failed to generate api key", "error", err)
+		return "", dto.APIKeyDTO{}, fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	stored, err := store.CreateAPIKey(ctx, dto.APIKeyDTO{
+		Name:      name,
+		KeyHash:   hashSingleUseToken(key),
+		Scopes:    scopes,
+		ExpiresAt: sql.NullTime{Time: expiresAt, Valid: !expiresAt.IsZero()},
+	})
+	if err != nil {
+		slog.Error("failed to create api key", "name", name, "error", err)
+		return "", dto.APIKeyDTO{}, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	slog.Info("API key created", "id", stored.ID, "name", name)
+	return key, stored, nil
+}
+
+// RotateAPIKey replaces a key with a new one keeping its name, scopes and expiry, the old key stops
+// working straight away.
+func RotateAPIKey(ctx context.Context, store APIKeyStore, id string) (_ string, _ dto.APIKeyDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.RotateAPIKey")
+	defer end(&err)
+
+	key, err := newAPIKey()
+	if err != nil {
+		slog.Error("failed to generate api key", "error", err)
+		return "", dto.APIKeyDTO{}, fmt.Errorf("failed to generate api key: %w", err)
+	}
+
+	stored, err := store.RotateAPIKey(ctx, id, hashSingleUseToken(key))
+	if err != nil {
+		slog.Error("failed to rotate api key", "id", id, "error", err)
+		return "", dto.APIKeyDTO{}, fmt.Errorf("failed to rotate api key: %w", err)
+	}
+
+	slog.Info("API key rotated", "id", id, "name", stored.Name)
+	return key, stored, nil
+}
+
+// RevokeAPIKey stops a key from working for good.
+func RevokeAPIKey(ctx context.Context, store APIKeyStore, id string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.RevokeAPIKey")
+	defer end(&err)
+
+	if err := store.RevokeAPIKey(ctx, id); err != nil {
+		slog.Error("failed to revoke api key", "id", id, "error", err)
+		return fmt.Errorf("failed to revoke api key: %w", err)
+	}
+
+	slog.Info("API key revoked", "id", id)
+	return nil
+}
+
+// ListAPIKeys returns every key of the tenant, revoked keys included.
+func ListAPIKeys(ctx context.Context, store APIKeyStore) (_ []dto.APIKeyDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.ListAPIKeys")
+	defer end(&err)
+
+	keys, err := store.ListAPIKeys(ctx)
+	if err != nil {
+		slog.Error("failed to list api keys", "error", err)
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// AuthenticateAPIKey returns the caller a key identifies, an unknown, expired or revoked key is
+// ErrInvalidAPIKey.
+func AuthenticateAPIKey(ctx context.Context, store APIKeyStore, key string, now time.Time) (_ Caller, err error) {
+	ctx, end := tracing.Start(ctx, "service.AuthenticateAPIKey")
+	defer end(&err)
+
+	if !strings.HasPrefix(key, APIKeyPrefix) {
+		return Caller{}, ErrInvalidAPIKey
+	}
+
+	stored, err := store.GetAPIKeyByHash(ctx, hashSingleUseToken(key))
+	if errors.Is(err, ErrAPIKeyNotFound) {
+		return Caller{}, ErrInvalidAPIKey
+	}
+	if err != nil {
+		slog.Error("failed to look up api key", "error", err)
+		return Caller{}, fmt.Errorf("failed to look up api key: %w", err)
+	}
+
+	switch {
+	case stored.RevokedAt.Valid:
+		slog.Info("rejected revoked api key", "id", stored.ID, "name", stored.Name)
+		return Caller{}, ErrInvalidAPIKey
+	case stored.ExpiresAt.Valid && !stored.ExpiresAt.Time.After(now):
+		slog.Info("rejected expired api key", "id", stored.ID, "name", stored.Name)
+		return Caller{}, ErrInvalidAPIKey
+	}
+
+	caller := Caller{
+		APIKeyID:   stored.ID,
+		APIKeyName: stored.Name,
+		TenantID:   stored.TenantID,
+	}
+	for _, scope := range stored.Scopes {
+		caller.Scopes = append(caller.Scopes, Permission(scope))
+	}
+
+	return caller, nil
+}
+
+// FromDTOToAPIKey converts a stored key, the key itself is never part of it.
+func FromDTOToAPIKey(k dto.APIKeyDTO) *api.ApiKey {
+	key := &api.ApiKey{
+		Id:        k.ID,
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		CreatedBy: k.CreatedBy.String,
+		CreatedAt: k.CreatedAt.Format(time.RFC3339),
+	}
+	if k.ExpiresAt.Valid {
+		key.ExpiresAt = k.ExpiresAt.Time.Format(time.RFC3339)
+	}
+	if k.RotatedAt.Valid {
+		key.RotatedAt = k.RotatedAt.Time.Format(time.RFC3339)
+	}
+	if k.RevokedAt.Valid {
+		key.RevokedAt = k.RevokedAt.Time.Format(time.RFC3339)
+	}
+	return key
+}
+
+func newAPIKey() (string, error) {
+	secret, err := newSingleUseToken()
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + secret, nil
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateScopes(t *testing.T) {
+	assert.NoError(t, ValidateScopes("scopes", []string{"users.read", "users.admin"}))
+	assert.EqualError(t, ValidateScopes("scopes", nil), "at least one scope is required")
+	assert.EqualError(t, ValidateScopes("scopes", []string{"users.read", "users.everything"}), `unknown scope "users.everything", expected any of users.read, users.write, users.delete, users.admin`)
+}
+
+func TestCallerActor(t *testing.T) {
+	assert.Equal(t, "", Caller{}.Actor())
+	assert.Equal(t, "123e4567-e89b-12d3-a456-426614174001", Caller{UserID: "123e4567-e89b-12d3-a456-426614174001"}.Actor())
+	assert.Equal(t, "api-key:key-1", Caller{APIKeyID: "key-1", APIKeyName: "billing"}.Actor())
+	assert.Equal(t, "cert:billing", Caller{Certificate: "billing"}.Actor())
+	assert.Equal(t, "api-key:key-1", Caller{APIKeyID: "key-1", Certificate: "billing"}.Actor())
+}
+
+func TestFromDTOToAPIKey(t *testing.T) {
+	key := FromDTOToAPIKey(dto.APIKeyDTO{
+		ID:        "key-1",
+		Name:      "billing",
+		KeyHash:   "hash",
+		Scopes:    []string{"users.read"},
+		ExpiresAt: sql.NullTime{Time: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+		CreatedBy: sql.NullString{String: "123e4567-e89b-12d3-a456-426614174001", Valid: true},
+		CreatedAt: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+
+	assert.Equal(t, &api.ApiKey{
+		Id:        "key-1",
+		Name:      "billing",
+		Scopes:    []string{"users.read"},
+		ExpiresAt: "2026-01-01T00:00:00Z",
+		CreatedBy: "123e4567-e89b-12d3-a456-426614174001",
+		CreatedAt: "2025-01-01T00:00:00Z",
+	}, key)
+}
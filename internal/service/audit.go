@@ -0,0 +1,132 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/tracing"
+)
+
+// DefaultHistoryPageSize and MaxHistoryPageSize bound how many audit entries are returned at once.
+const (
+	DefaultHistoryPageSize = 20
+	MaxHistoryPageSize     = 100
+)
+
+// AuditInfo identifies who made a change and the request it was made in, writers record it
+// against every change to a user.
+type AuditInfo struct {
+	// Actor is the ID of the authenticated user making the change, empty when unknown
+	Actor     string
+	RequestID string
+}
+
+type auditInfoKey struct{}
+
+// WithAuditInfo returns a copy of ctx carrying info for the writes made with it.
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditInfoKey{}, info)
+}
+
+// AuditInfoFromContext returns the audit info set by WithAuditInfo, or the zero value if there is none.
+func AuditInfoFromContext(ctx context.Context) AuditInfo {
+	info, _ := ctx.Value(auditInfoKey{}).(AuditInfo)
+	return info
+}
+
+// GetUserHistoryFromDatasource returns a page of the user's audit entries, newest first.
+func GetUserHistoryFromDatasource(ctx context.Context, reader Reader, args dto.GetUserHistoryArgs) (_ []dto.UserAuditDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.GetUserHistoryFromDatasource")
+	defer end(&err)
+
+	entries, err := reader.GetUserHistory(ctx, args)
+	if err != nil {
+		slog.Error("failed to get user history", "error", err)
+		return nil, fmt.Errorf("failed to get user history: %w", err)
+	}
+
+	return entries, nil
+}
+
+// ApplyHistoryPageToken points the history args at the entries after the page the token was issued for.
+func ApplyHistoryPageToken(args *dto.GetUserHistoryArgs, token string) error {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	id, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || id < 1 {
+		return fmt.Errorf("%w: missing cursor position", ErrInvalidPageToken)
+	}
+
+	args.BeforeID = sql.NullInt64{Int64: id, Valid: true}
+
+	return nil
+}
+
+// NextHistoryPageToken returns the token for the page following entries, or an empty string when
+// the page was not full so no more entries can follow.
+func NextHistoryPageToken(args dto.GetUserHistoryArgs, entries []dto.UserAuditDTO) string {
+	if len(entries) == 0 || len(entries) < int(args.Limit) {
+		return ""
+	}
+
+	last := entries[len(entries)-1]
+
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(last.ID, 10)))
+}
+
+// FromDTOToAPIAuditEntry converts an audit entry, its changes are listed in field order.
+func FromDTOToAPIAuditEntry(e dto.UserAuditDTO) (*api.UserAuditEntry, error) {
+	var changes map[string]struct {
+		Old json.RawMessage `json:"old"`
+		New json.RawMessage `json:"new"`
+	}
+	if err := json.Unmarshal(e.Changes, &changes); err != nil {
+		return nil, fmt.Errorf("failed to decode changes of audit entry %d: %w", e.ID, err)
+	}
+
+	entry := &api.UserAuditEntry{
+		UserId:     e.UserID,
+		Action:     e.Action,
+		Actor:      e.Actor.String,
+		RequestId:  e.RequestID.String,
+		OccurredAt: e.OccurredAt.Format(time.RFC3339),
+	}
+	for field, change := range changes {
+		entry.Changes = append(entry.Changes, &api.FieldChange{
+			Field:    field,
+			OldValue: auditValue(change.Old),
+			NewValue: auditValue(change.New),
+		})
+	}
+	sort.Slice(entry.Changes, func(i, j int) bool {
+		return entry.Changes[i].Field < entry.Changes[j].Field
+	})
+
+	return entry, nil
+}
+
+// auditValue renders a recorded JSON value, strings are unquoted and a missing or null value is empty.
+func auditValue(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+
+	return string(raw)
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromDTOToAPIAuditEntry(t *testing.T) {
+	entry, err := FromDTOToAPIAuditEntry(dto.UserAuditDTO{
+		ID:     7,
+		UserID: "123e4567-e89b-12d3-a456-426614174000",
+		Action: "modify",
+		Changes: []byte(`{
+			"password": {"old": "[REDACTED]", "new": "[REDACTED]"},
+			"email": {"old": "john.doe@example.com", "new": "john@example.com"},
+			"nickname": {"old": "jd", "new": null},
+			"email_verified_at": {"old": "2025-01-01T00:00:00+00:00", "new": null}
+		}`),
+		Actor:      sql.NullString{String: "123e4567-e89b-12d3-a456-426614174001", Valid: true},
+		RequestID:  sql.NullString{String: "req-1", Valid: true},
+		OccurredAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC),
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, &api.UserAuditEntry{
+		UserId: "123e4567-e89b-12d3-a456-426614174000",
+		Action: "modify",
+		Changes: []*api.FieldChange{
+			{Field: "email", OldValue: "john.doe@example.com", NewValue: "john@example.com"},
+			{Field: "email_verified_at", OldValue: "2025-01-01T00:00:00+00:00", NewValue: ""},
+			{Field: "nickname", OldValue: "jd", NewValue: ""},
+			{Field: "password", OldValue: "[REDACTED]", NewValue: "[REDACTED]"},
+		},
+		Actor:      "123e4567-e89b-12d3-a456-426614174001",
+		RequestId:  "req-1",
+		OccurredAt: "2025-01-02T03:04:05Z",
+	}, entry)
+
+	_, err = FromDTOToAPIAuditEntry(dto.UserAuditDTO{ID: 8, Changes: []byte("not json")})
+	assert.ErrorContains(t, err, "failed to decode changes of audit entry 8")
+}
+
+func TestHistoryPageToken(t *testing.T) {
+	args := dto.GetUserHistoryArgs{Limit: 2}
+	entries := []dto.UserAuditDTO{{ID: 9}, {ID: 4}}
+
+	token := NextHistoryPageToken(args, entries)
+	assert.NotEmpty(t, token)
+
+	assert.NoError(t, ApplyHistoryPageToken(&args, token))
+	assert.Equal(t, sql.NullInt64{Int64: 4, Valid: true}, args.BeforeID)
+
+	// a short page is the last one
+	assert.Empty(t, NextHistoryPageToken(args, entries[:1]))
+
+	err := ApplyHistoryPageToken(&args, "not-a-token!")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+	err = ApplyHistoryPageToken(&args, "YWJj")
+	assert.ErrorIs(t, err, ErrInvalidPageToken)
+}
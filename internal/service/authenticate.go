@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/metrics"
+	"github.com/EFG/internal/tracing"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Outcomes recorded on authenticate changes.
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// dummyPasswordHash is compared against when no user has the email, so an unknown email takes
+// as long to reject as a wrong password and can't be told apart by timing.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// AuthenticateUser verifies the password against the stored bcrypt hash for the email and returns
+// the user without their hash. Any credential mismatch is reported as ErrInvalidCredentials.
+func AuthenticateUser(ctx context.Context, reader Reader, email, password string) (_ dto.UserDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.AuthenticateUser")
+	defer end(&err)
+
+	user, err := reader.GetUserCredentials(ctx, email)
+	if err != nil {
+		var notFound *NotFoundError
+		if !errors.As(err, &notFound) {
+			slog.Error("failed to get user credentials", "error", err)
+			return dto.UserDTO{}, fmt.Errorf("failed to get user credentials: %w", err)
+		}
+
+		comparePassword(ctx, dummyPasswordHash(), password)
+		return dto.UserDTO{}, ErrInvalidCredentials
+	}
+
+	if err := comparePassword(ctx, []byte(user.Password.String), password); err != nil {
+		return dto.UserDTO{}, ErrInvalidCredentials
+	}
+
+	user.Password = sql.NullString{}
+
+	return user, nil
+}
+
+// comparePassword checks password against its bcrypt hash, timing the comparison. A mismatch isn't
+// a failure of the span, wrong passwords are expected.
+func comparePassword(ctx context.Context, hash []byte, password string) error {
+	_, end := tracing.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer end(nil)
+
+	start := time.Now()
+	err := bcrypt.CompareHashAndPassword(hash, []byte(password))
+	metrics.ObservePasswordHash("compare", time.Since(start))
+	return err
+}
@@ -0,0 +1,62 @@
+package service
+
+import "context"
+
+const (
+	// apiKeyActorPrefix marks actors that are API keys rather than users in the audit log.
+	apiKeyActorPrefix = "api-key:"
+	// certificateActorPrefix marks actors only known by their client certificate.
+	certificateActorPrefix = "cert:"
+)
+
+// Caller is who a call is made by, a user presenting an access token or a service presenting an
+// API key. The zero value is an anonymous caller.
+type Caller struct {
+	// UserID and Email are those of the user an access token was issued to
+	UserID string
+	Email  string
+	// APIKeyID and APIKeyName identify the API key a service called with
+	APIKeyID   string
+	APIKeyName string
+	// Scopes are the permissions granted to an API key, users get theirs from their roles instead
+	Scopes []Permission
+	// TenantID is the tenant the token or key belongs to
+	TenantID string
+	// Certificate is the subject common name of the client certificate verified for the connection
+	// the call came in on. It identifies the service on the other end of the connection but grants
+	// nothing, as with the gateway it may be passing on calls of anyone
+	Certificate string
+}
+
+// Authenticated reports whether the caller presented a valid access token or API key.
+func (c Caller) Authenticated() bool {
+	return c.UserID != "" || c.APIKeyID != ""
+}
+
+// Actor is how the caller is recorded in the audit log, the user ID for users and the key ID
+// prefixed with api-key: for services. Callers without either are recorded by their client
+// certificate prefixed with cert:, and are empty when there is none.
+func (c Caller) Actor() string {
+	switch {
+	case c.APIKeyID != "":
+		return apiKeyActorPrefix + c.APIKeyID
+	case c.UserID != "":
+		return c.UserID
+	case c.Certificate != "":
+		return certificateActorPrefix + c.Certificate
+	}
+	return ""
+}
+
+type callerKey struct{}
+
+// WithCaller returns a copy of ctx made by the caller.
+func WithCaller(ctx context.Context, caller Caller) context.Context {
+	return context.WithValue(ctx, callerKey{}, caller)
+}
+
+// CallerFromContext returns the caller set by WithCaller, or an anonymous caller if there is none.
+func CallerFromContext(ctx context.Context) Caller {
+	caller, _ := ctx.Value(callerKey{}).(Caller)
+	return caller
+}
@@ -0,0 +1,59 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EFG/internal/tracing"
+)
+
+// EmailVerificationTTL is how long an email verification token can be used for after it is sent.
+const EmailVerificationTTL = 24 * time.Hour
+
+// EmailVerificationSender delivers an email verification token to the address being verified.
+type EmailVerificationSender interface {
+	SendEmailVerification(ctx context.Context, email, token string, expiresAt time.Time) error
+}
+
+// SendEmailVerification stores a new single-use token for the user's pending email, or their current
+// email while it is unverified, and sends it to that address. ErrEmailAlreadyVerified is returned
+// when there is nothing to verify.
+func SendEmailVerification(ctx context.Context, writer Writer, sender EmailVerificationSender, userID string, now time.Time) (err error) {
+	ctx, end := tracing.Start(ctx, "service.SendEmailVerification")
+	defer end(&err)
+
+	token, err := newSingleUseToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email verification token: %w", err)
+	}
+	expiresAt := now.Add(EmailVerificationTTL)
+
+	email, err := writer.CreateEmailVerificationToken(ctx, userID, hashSingleUseToken(token), expiresAt)
+	if err != nil {
+		slog.Error("failed to create email verification token", "error", err)
+		return fmt.Errorf("failed to create email verification token: %w", err)
+	}
+
+	if err := sender.SendEmailVerification(ctx, email, token, expiresAt); err != nil {
+		slog.Error("failed to send email verification token", "error", err)
+		return fmt.Errorf("failed to send email verification token: %w", err)
+	}
+
+	return nil
+}
+
+// ConfirmEmail marks the address the token was sent to as verified, promoting it to the user's email
+// if it was pending, and returns the user's ID.
+func ConfirmEmail(ctx context.Context, writer Writer, token string, now time.Time) (_ string, err error) {
+	ctx, end := tracing.Start(ctx, "service.ConfirmEmail")
+	defer end(&err)
+
+	userID, err := writer.ConfirmEmail(ctx, hashSingleUseToken(token), now)
+	if err != nil {
+		return "", fmt.Errorf("failed to confirm email: %w", err)
+	}
+
+	return userID, nil
+}
@@ -0,0 +1,126 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The typed errors below classify failures independently of any transport, datasources
+// return them and the server maps each kind onto its own status code.
+
+// NotFoundError is returned when a lookup or change matches no user.
+type NotFoundError struct {
+	Msg string
+}
+
+func (e *NotFoundError) Error() string {
+	return e.Msg
+}
+
+// AlreadyExistsError is returned when a write would duplicate a unique value such as an email.
+type AlreadyExistsError struct {
+	Msg string
+}
+
+func (e *AlreadyExistsError) Error() string {
+	return e.Msg
+}
+
+// UnauthenticatedError is returned when supplied credentials can't be verified.
+type UnauthenticatedError struct {
+	Msg string
+}
+
+func (e *UnauthenticatedError) Error() string {
+	return e.Msg
+}
+
+// FailedPreconditionError is returned when a request is valid but the user isn't in a state it applies to.
+type FailedPreconditionError struct {
+	Msg string
+}
+
+func (e *FailedPreconditionError) Error() string {
+	return e.Msg
+}
+
+// AbortedError is returned when a conditional write loses to a concurrent change, the caller
+// should read the user again and retry.
+type AbortedError struct {
+	Msg string
+}
+
+func (e *AbortedError) Error() string {
+	return e.Msg
+}
+
+// FieldViolation describes why a single request field is invalid.
+type FieldViolation struct {
+	Field       string
+	Description string
+}
+
+// InvalidArgumentError is returned when a request is rejected before or by the datasource,
+// it carries one violation per offending field.
+type InvalidArgumentError struct {
+	Violations []FieldViolation
+}
+
+func NewInvalidArgumentError(field, format string, args ...any) *InvalidArgumentError {
+	return &InvalidArgumentError{
+		Violations: []FieldViolation{{Field: field, Description: fmt.Sprintf(format, args...)}},
+	}
+}
+
+func (e *InvalidArgumentError) Error() string {
+	descriptions := make([]string, len(e.Violations))
+	for i, v := range e.Violations {
+		descriptions[i] = v.Description
+	}
+	return strings.Join(descriptions, "; ")
+}
+
+// UnavailableError is returned when a dependency such as the database can't currently be
+// reached, the request may succeed if retried.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("unavailable: %v", e.Err)
+}
+
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// ErrUserNotFound is returned by datasources when a lookup matches no user.
+var ErrUserNotFound error = &NotFoundError{Msg: "user not found"}
+
+// ErrInvalidCredentials is returned for any failed authentication, whether the email or the password was wrong.
+var ErrInvalidCredentials error = &UnauthenticatedError{Msg: "invalid email or password"}
+
+// ErrInvalidToken is returned when an access token is malformed, badly signed or expired.
+var ErrInvalidToken error = &UnauthenticatedError{Msg: "invalid token"}
+
+// ErrInvalidPasswordResetToken is returned when a password reset token is unknown, already used or expired.
+var ErrInvalidPasswordResetToken error = NewInvalidArgumentError("token", "password reset token is invalid or expired")
+
+// ErrInvalidEmailVerificationToken is returned when an email verification token is unknown, already used,
+// expired or was sent to an address the user no longer has.
+var ErrInvalidEmailVerificationToken error = NewInvalidArgumentError("token", "email verification token is invalid or expired")
+
+// ErrEmailAlreadyVerified is returned when verification is requested for a user with nothing left to verify.
+var ErrEmailAlreadyVerified error = &FailedPreconditionError{Msg: "email is already verified"}
+
+// ErrEmailAlreadyExists is returned by datasources when a user is written with an email already in use.
+var ErrEmailAlreadyExists error = &AlreadyExistsError{Msg: "email already exists"}
+
+// ErrAPIKeyNotFound is returned by datasources when no API key has the hash looked up.
+var ErrAPIKeyNotFound error = &NotFoundError{Msg: "api key not found"}
+
+// ErrInvalidAPIKey is returned when an API key is unknown, expired or revoked.
+var ErrInvalidAPIKey error = &UnauthenticatedError{Msg: "invalid api key"}
+
+// ErrAPIKeyNameAlreadyExists is returned by datasources when a key is created with the name of a key still in use.
+var ErrAPIKeyNameAlreadyExists error = &AlreadyExistsError{Msg: "an api key with this name already exists"}
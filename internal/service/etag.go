@@ -0,0 +1,26 @@
+package service
+
+import (
+	"database/sql"
+	"strconv"
+)
+
+// ETagFromVersion renders a user's version as the opaque etag handed to clients.
+func ETagFromVersion(version int64) string {
+	return strconv.FormatInt(version, 10)
+}
+
+// VersionFromETag parses an etag previously handed out by ETagFromVersion, an empty etag
+// gives a null version so the write it guards is unconditional.
+func VersionFromETag(etag string) (sql.NullInt64, error) {
+	if etag == "" {
+		return sql.NullInt64{}, nil
+	}
+
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil || version < 1 {
+		return sql.NullInt64{}, NewInvalidArgumentError("etag", "etag %q was not issued by this service", etag)
+	}
+
+	return sql.NullInt64{Int64: version, Valid: true}, nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionFromETag(t *testing.T) {
+	tests := []struct {
+		name        string
+		etag        string
+		want        sql.NullInt64
+		expectedErr string
+	}{
+		{
+			name: "empty is unconditional",
+			etag: "",
+			want: sql.NullInt64{},
+		},
+		{
+			name: "round trips",
+			etag: ETagFromVersion(42),
+			want: sql.NullInt64{Int64: 42, Valid: true},
+		},
+		{
+			name:        "not a version",
+			etag:        "W/\"42\"",
+			expectedErr: `etag "W/\"42\"" was not issued by this service`,
+		},
+		{
+			name:        "versions start at one",
+			etag:        "0",
+			expectedErr: `etag "0" was not issued by this service`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := VersionFromETag(tc.etag)
+			if tc.expectedErr != "" {
+				assert.EqualError(t, err, tc.expectedErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/tracing"
+)
+
+// IdempotencyStore remembers the response of requests sent with an idempotency key so a retry
+// of the same request gets the original response instead of being run again.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, key, method, requestHash string, expiresAt, now time.Time) (dto.IdempotencyKeyDTO, error)
+	CompleteIdempotencyKey(ctx context.Context, key, method string, response []byte, expiresAt time.Time) error
+	ReleaseIdempotencyKey(ctx context.Context, key, method string) error
+	DeleteExpiredIdempotencyKeys(ctx context.Context, now time.Time) (int, error)
+}
+
+// ErrIdempotencyKeyInProgress is returned when a retry arrives while the request that first used
+// the key is still running, the retry can be sent again shortly.
+var ErrIdempotencyKeyInProgress error = &AbortedError{Msg: "a request with this idempotency key is still in progress"}
+
+// ErrIdempotencyKeyReused is returned when a key is sent with a different request than it was first used for.
+var ErrIdempotencyKeyReused error = NewInvalidArgumentError("idempotency-key", "idempotency key has already been used for a different request")
+
+// ReserveIdempotencyKey claims the key for the request until expiresAt. When the key was already
+// used for the same request its stored response is returned with replay set, a key in use for
+// another request or still in progress is an error.
+func ReserveIdempotencyKey(ctx context.Context, store IdempotencyStore, key, method, requestHash string, expiresAt, now time.Time) (response []byte, replay bool, err error) {
+	ctx, end := tracing.Start(ctx, "service.ReserveIdempotencyKey")
+	defer end(&err)
+
+	stored, err := store.ReserveIdempotencyKey(ctx, key, method, requestHash, expiresAt, now)
+	if err != nil {
+		slog.Error("failed to reserve idempotency key", "method", method, "error", err)
+		return nil, false, fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+
+	switch {
+	case stored.Reserved:
+		return nil, false, nil
+	case stored.RequestHash != requestHash:
+		return nil, false, ErrIdempotencyKeyReused
+	case stored.Response == nil:
+		return nil, false, ErrIdempotencyKeyInProgress
+	}
+
+	slog.Info("Replaying response for idempotency key", "method", method)
+	return stored.Response, true, nil
+}
+
+// CompleteIdempotencyKey stores the response of a request that succeeded so retries replay it until expiresAt.
+func CompleteIdempotencyKey(ctx context.Context, store IdempotencyStore, key, method string, response []byte, expiresAt time.Time) (err error) {
+	ctx, end := tracing.Start(ctx, "service.CompleteIdempotencyKey")
+	defer end(&err)
+
+	if err := store.CompleteIdempotencyKey(ctx, key, method, response, expiresAt); err != nil {
+		slog.Error("failed to complete idempotency key", "method", method, "error", err)
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseIdempotencyKey frees the key of a request that failed so a retry runs it again.
+func ReleaseIdempotencyKey(ctx context.Context, store IdempotencyStore, key, method string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.ReleaseIdempotencyKey")
+	defer end(&err)
+
+	if err := store.ReleaseIdempotencyKey(ctx, key, method); err != nil {
+		slog.Error("failed to release idempotency key", "method", method, "error", err)
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteExpiredIdempotencyKeys removes the keys that have outlived their TTL.
+func DeleteExpiredIdempotencyKeys(ctx context.Context, store IdempotencyStore, now time.Time) (_ int, err error) {
+	ctx, end := tracing.Start(ctx, "service.DeleteExpiredIdempotencyKeys")
+	defer end(&err)
+
+	deleted, err := store.DeleteExpiredIdempotencyKeys(ctx, now)
+	if err != nil {
+		slog.Error("failed to delete expired idempotency keys", "error", err)
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+
+	return deleted, nil
+}
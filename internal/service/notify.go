@@ -5,13 +5,23 @@ import (
 	"encoding/json"
 	"fmt"
 	"log/slog"
+
+	"github.com/EFG/internal/tracing"
 )
 
 type Notifier interface {
 	PublishUserChange(ctx context.Context, message []byte) error
 }
 
-func NotifyOfUserChange(ctx context.Context, notifier Notifier, changeData UserChange) error {
+// NotifyOfUserChange publishes the change, it is stamped with the tenant of ctx when it doesn't name one.
+func NotifyOfUserChange(ctx context.Context, notifier Notifier, changeData UserChange) (err error) {
+	ctx, end := tracing.Start(ctx, "service.NotifyOfUserChange")
+	defer end(&err)
+
+	if changeData.TenantID == "" {
+		changeData.TenantID = TenantFromContext(ctx)
+	}
+
 	slog.Info("Notifying of user change", "changeData", changeData)
 
 	notificationMessage, err := json.Marshal(changeData)
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/utils"
+)
+
+// ErrInvalidPageToken is returned when a page token can't be decoded.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// PageCursor is the position of the last user on a page, the next page starts strictly after it.
+type PageCursor struct {
+	CreatedAt time.Time `json:"createdAt"`
+	ID        string    `json:"id"`
+}
+
+// EncodePageToken turns a cursor into an opaque token safe to hand to clients.
+func EncodePageToken(cursor PageCursor) string {
+	raw, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func DecodePageToken(token string) (PageCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return PageCursor{}, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	var cursor PageCursor
+	if err := json.Unmarshal(raw, &cursor); err != nil {
+		return PageCursor{}, fmt.Errorf("%w: %v", ErrInvalidPageToken, err)
+	}
+
+	if cursor.CreatedAt.IsZero() || cursor.ID == "" {
+		return PageCursor{}, fmt.Errorf("%w: missing cursor position", ErrInvalidPageToken)
+	}
+
+	return cursor, nil
+}
+
+// ApplyPageToken points the query args at the page after the one the token was issued for.
+func ApplyPageToken(args *dto.GetUsersArgs, token string) error {
+	cursor, err := DecodePageToken(token)
+	if err != nil {
+		return err
+	}
+
+	args.AfterCreatedAt = utils.ToNullTime(cursor.CreatedAt)
+	args.AfterID = utils.ToNullString(cursor.ID)
+
+	return nil
+}
+
+// NextPageToken returns the token for the page following users, or an empty string when
+// the args are paging by page number, use a custom order or the page was not full so no more users can follow.
+func NextPageToken(args dto.GetUsersArgs, users dto.UsersDTO) string {
+	if args.Page.Valid || len(args.OrderByFields) > 0 || !args.PageSize.Valid || len(users) < int(args.PageSize.Int32) {
+		return ""
+	}
+
+	last := users[len(users)-1]
+
+	return EncodePageToken(PageCursor{
+		CreatedAt: last.CreatedAt.Time,
+		ID:        last.ID.String,
+	})
+}
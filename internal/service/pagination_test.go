@@ -0,0 +1,102 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPageToken_RoundTrip(t *testing.T) {
+	cursor := PageCursor{
+		CreatedAt: time.Date(2025, 1, 1, 12, 30, 0, 123456000, time.UTC),
+		ID:        "123e4567-e89b-12d3-a456-426614174000",
+	}
+
+	decoded, err := DecodePageToken(EncodePageToken(cursor))
+	assert.NoError(t, err)
+	assert.True(t, cursor.CreatedAt.Equal(decoded.CreatedAt))
+	assert.Equal(t, cursor.ID, decoded.ID)
+}
+
+func TestDecodePageToken_Invalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "not base64", token: "!!!"},
+		{name: "not json", token: "bm90IGpzb24"},
+		{name: "missing position", token: EncodePageToken(PageCursor{})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := DecodePageToken(tt.token)
+			assert.ErrorIs(t, err, ErrInvalidPageToken)
+		})
+	}
+}
+
+func TestApplyPageToken(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	token := EncodePageToken(PageCursor{CreatedAt: createdAt, ID: "2"})
+
+	var args dto.GetUsersArgs
+	err := ApplyPageToken(&args, token)
+	assert.NoError(t, err)
+	assert.True(t, args.AfterCreatedAt.Valid)
+	assert.True(t, createdAt.Equal(args.AfterCreatedAt.Time))
+	assert.Equal(t, "2", args.AfterID.String)
+}
+
+func TestNextPageToken(t *testing.T) {
+	createdAt := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	users := dto.UsersDTO{
+		{ID: utils.ToNullString("1"), CreatedAt: utils.ToNullTime(createdAt.Add(time.Second))},
+		{ID: utils.ToNullString("2"), CreatedAt: utils.ToNullTime(createdAt)},
+	}
+
+	tests := []struct {
+		name      string
+		args      dto.GetUsersArgs
+		wantToken bool
+	}{
+		{
+			name:      "full page by cursor",
+			args:      dto.GetUsersArgs{PageSize: utils.ToNullInt32(2)},
+			wantToken: true,
+		},
+		{
+			name:      "partial page by cursor",
+			args:      dto.GetUsersArgs{PageSize: utils.ToNullInt32(3)},
+			wantToken: false,
+		},
+		{
+			name:      "paging by page number",
+			args:      dto.GetUsersArgs{Page: utils.ToNullInt32(1), PageSize: utils.ToNullInt32(2)},
+			wantToken: false,
+		},
+		{
+			name:      "no page size",
+			args:      dto.GetUsersArgs{},
+			wantToken: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := NextPageToken(tt.args, users)
+			if !tt.wantToken {
+				assert.Empty(t, token)
+				return
+			}
+
+			cursor, err := DecodePageToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, "2", cursor.ID)
+			assert.True(t, createdAt.Equal(cursor.CreatedAt))
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EFG/internal/tracing"
+)
+
+// PasswordResetTTL is how long a password reset token can be used for after it is requested.
+const PasswordResetTTL = time.Hour
+
+// PasswordResetSender delivers a password reset token to the owner of the email.
+type PasswordResetSender interface {
+	SendPasswordReset(ctx context.Context, email, token string, expiresAt time.Time) error
+}
+
+// PasswordReset is a token stored for a user that has still to be sent to them.
+type PasswordReset struct {
+	UserID    string
+	Email     string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// RequestPasswordReset stores a new single-use token for the user with the email, returning the reset
+// to send with SendPasswordReset. An unknown email is not an error and returns an empty reset so
+// callers can respond the same way whether or not the email is registered.
+func RequestPasswordReset(ctx context.Context, writer Writer, email string, now time.Time) (_ PasswordReset, err error) {
+	ctx, end := tracing.Start(ctx, "service.RequestPasswordReset")
+	defer end(&err)
+
+	token, err := newSingleUseToken()
+	if err != nil {
+		return PasswordReset{}, fmt.Errorf("failed to generate password reset token: %w", err)
+	}
+	expiresAt := now.Add(PasswordResetTTL)
+
+	userID, err := writer.CreatePasswordResetToken(ctx, email, hashSingleUseToken(token), expiresAt)
+	if err != nil {
+		var notFound *NotFoundError
+		if errors.As(err, &notFound) {
+			return PasswordReset{}, nil
+		}
+		slog.Error("failed to create password reset token", "error", err)
+		return PasswordReset{}, fmt.Errorf("failed to create password reset token: %w", err)
+	}
+
+	return PasswordReset{UserID: userID, Email: email, Token: token, ExpiresAt: expiresAt}, nil
+}
+
+// SendPasswordReset delivers the token of a reset to its user, an empty reset is not sent. A failure
+// is only logged, telling the caller would tell them the email is registered.
+func SendPasswordReset(ctx context.Context, sender PasswordResetSender, reset PasswordReset) {
+	if reset.Token == "" {
+		return
+	}
+
+	ctx, end := tracing.Start(ctx, "service.SendPasswordReset")
+	var err error
+	defer end(&err)
+
+	if err = sender.SendPasswordReset(ctx, reset.Email, reset.Token, reset.ExpiresAt); err != nil {
+		slog.Error("failed to send password reset token", "id", reset.UserID, "error", err)
+	}
+}
+
+// CompletePasswordReset sets the new password for the user the token was issued to and spends the
+// token, returning the user's ID. ErrInvalidPasswordResetToken is returned if the token can't be used.
+func CompletePasswordReset(ctx context.Context, writer Writer, token, newPassword string, now time.Time) (_ string, err error) {
+	ctx, end := tracing.Start(ctx, "service.CompletePasswordReset")
+	defer end(&err)
+
+	user := User{Password: newPassword}
+	if err := user.hashPassword(ctx); err != nil {
+		slog.Error("failed to hash password", "error", err)
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	userID, err := writer.CompletePasswordReset(ctx, hashSingleUseToken(token), user.Password, now)
+	if err != nil {
+		return "", fmt.Errorf("failed to complete password reset: %w", err)
+	}
+
+	return userID, nil
+}
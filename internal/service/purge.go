@@ -0,0 +1,39 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/EFG/internal/tracing"
+)
+
+// PurgeExpiredUsers permanently removes users of every tenant soft deleted before the cutoff, batchSize
+// at a time until none are left, and publishes a purge change for each one. It returns how many were purged.
+// A failed notification is logged rather than returned, the users are already gone by then.
+func PurgeExpiredUsers(ctx context.Context, writer Writer, notifier Notifier, deletedBefore time.Time, batchSize int, timeNow func() time.Time) (_ int, err error) {
+	ctx, end := tracing.Start(ctx, "service.PurgeExpiredUsers")
+	defer end(&err)
+
+	purged := 0
+	for {
+		users, err := writer.PurgeDeletedUsers(ctx, deletedBefore, batchSize)
+		if err != nil {
+			slog.Error("failed to purge deleted users", "error", err)
+			return purged, fmt.Errorf("failed to purge deleted users: %w", err)
+		}
+
+		for _, u := range users {
+			tenantCtx := WithTenant(ctx, u.TenantID.String)
+			if err := NotifyOfUserChange(tenantCtx, notifier, CreateUserChangeNotification("purge", u.ID.String, timeNow())); err != nil {
+				slog.Warn("failed to notify of purged user", "id", u.ID.String, "error", err)
+			}
+		}
+		purged += len(users)
+
+		if len(users) < batchSize {
+			return purged, nil
+		}
+	}
+}
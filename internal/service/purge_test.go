@@ -0,0 +1,66 @@
+package service_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/notifier"
+	"github.com/EFG/internal/service"
+	"github.com/EFG/internal/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func expiredUsers(tenantID string, ids ...string) dto.UsersDTO {
+	users := make(dto.UsersDTO, len(ids))
+	for i, id := range ids {
+		users[i] = dto.UserDTO{ID: utils.ToNullString(id), TenantID: utils.ToNullString(tenantID)}
+	}
+	return users
+}
+
+func TestPurgeExpiredUsers(t *testing.T) {
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	cutoff := now.Add(-30 * 24 * time.Hour)
+
+	t.Run("purges every batch and notifies per user", func(t *testing.T) {
+		mockDB := &postgres.MockClient{ExpiredUsers: expiredUsers("brand-a", "1", "2", "3", "4", "5")}
+		mockNotifier := &notifier.MockNotifier{}
+
+		purged, err := service.PurgeExpiredUsers(context.Background(), mockDB, mockNotifier, cutoff, 2, func() time.Time { return now })
+		assert.NoError(t, err)
+		assert.Equal(t, 5, purged)
+		assert.Equal(t, []time.Time{cutoff, cutoff, cutoff}, mockDB.PurgeDeletedUsersCallHistory)
+		assert.Len(t, mockNotifier.PublishedMessages, 5)
+		assert.JSONEq(t, `{"changeType":"purge","userId":"1","eventTime":"2025-03-01T12:00:00Z","tenantId":"brand-a"}`, string(mockNotifier.PublishedMessages[0]))
+	})
+
+	t.Run("nothing to purge", func(t *testing.T) {
+		mockDB := &postgres.MockClient{}
+		mockNotifier := &notifier.MockNotifier{}
+
+		purged, err := service.PurgeExpiredUsers(context.Background(), mockDB, mockNotifier, cutoff, 2, func() time.Time { return now })
+		assert.NoError(t, err)
+		assert.Zero(t, purged)
+		assert.False(t, mockNotifier.PublishCalled)
+	})
+
+	t.Run("notify failure does not stop the purge", func(t *testing.T) {
+		mockDB := &postgres.MockClient{ExpiredUsers: expiredUsers("brand-a", "1", "2")}
+		mockNotifier := &notifier.MockNotifier{TestRequiresPublishError: true}
+
+		purged, err := service.PurgeExpiredUsers(context.Background(), mockDB, mockNotifier, cutoff, 2, func() time.Time { return now })
+		assert.NoError(t, err)
+		assert.Equal(t, 2, purged)
+		assert.Empty(t, mockDB.ExpiredUsers)
+	})
+
+	t.Run("datasource error", func(t *testing.T) {
+		mockDB := &postgres.MockClient{TestRequiresError: true}
+
+		_, err := service.PurgeExpiredUsers(context.Background(), mockDB, &notifier.MockNotifier{}, cutoff, 2, func() time.Time { return now })
+		assert.ErrorContains(t, err, "failed to purge deleted users: mock db error for purge deleted users")
+	})
+}
@@ -6,13 +6,23 @@ import (
 	"log/slog"
 
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/tracing"
 )
 
 type Reader interface {
 	GetUsers(ctx context.Context, args dto.GetUsersArgs) (dto.UsersDTO, int, error)
+	GetUser(ctx context.Context, args dto.GetUserArgs) (dto.UserDTO, error)
+	ExportUsers(ctx context.Context, args dto.ExportUsersArgs, send func(dto.UserDTO) error) error
+	// GetUserCredentials returns the user with the given email including their password hash.
+	GetUserCredentials(ctx context.Context, email string) (dto.UserDTO, error)
+	// GetUserHistory returns a page of the user's audit entries, newest first.
+	GetUserHistory(ctx context.Context, args dto.GetUserHistoryArgs) ([]dto.UserAuditDTO, error)
 }
 
-func GetPaginatedUsersList(ctx context.Context, reader Reader, args dto.GetUsersArgs) (dto.UsersDTO, int, error) {
+func GetPaginatedUsersList(ctx context.Context, reader Reader, args dto.GetUsersArgs) (_ dto.UsersDTO, _ int, err error) {
+	ctx, end := tracing.Start(ctx, "service.GetPaginatedUsersList")
+	defer end(&err)
+
 	users, total, err := reader.GetUsers(ctx, args)
 	if err != nil {
 		slog.Error("failed to get users", "error", err)
@@ -21,3 +31,29 @@ func GetPaginatedUsersList(ctx context.Context, reader Reader, args dto.GetUsers
 
 	return users, total, nil
 }
+
+func GetUserByIDOrEmail(ctx context.Context, reader Reader, args dto.GetUserArgs) (_ dto.UserDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.GetUserByIDOrEmail")
+	defer end(&err)
+
+	user, err := reader.GetUser(ctx, args)
+	if err != nil {
+		slog.Error("failed to get user", "error", err)
+		return dto.UserDTO{}, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+func ExportUsersList(ctx context.Context, reader Reader, args dto.ExportUsersArgs, send func(dto.UserDTO) error) (err error) {
+	ctx, end := tracing.Start(ctx, "service.ExportUsersList")
+	defer end(&err)
+
+	err = reader.ExportUsers(ctx, args, send)
+	if err != nil {
+		slog.Error("failed to export users", "error", err)
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/EFG/api"
+	"github.com/EFG/internal/tracing"
+)
+
+// Permission allows its holder to call a group of RPCs.
+type Permission string
+
+const (
+	PermissionRead   Permission = "users.read"
+	PermissionWrite  Permission = "users.write"
+	PermissionDelete Permission = "users.delete"
+	PermissionAdmin  Permission = "users.admin"
+)
+
+// Role names.
+const (
+	RoleViewer    = "viewer"
+	RoleEditor    = "editor"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// rolePermissions are the permissions each role grants, roles are fixed and only their assignment
+// to users is stored.
+var rolePermissions = map[string][]Permission{
+	RoleViewer:    {PermissionRead},
+	RoleEditor:    {PermissionRead, PermissionWrite},
+	RoleModerator: {PermissionRead, PermissionWrite, PermissionDelete},
+	RoleAdmin:     {PermissionRead, PermissionWrite, PermissionDelete, PermissionAdmin},
+}
+
+// RoleStore keeps the roles assigned to each user of a tenant.
+type RoleStore interface {
+	GetUserRoles(ctx context.Context, userUUID string) ([]string, error)
+	AssignRole(ctx context.Context, userUUID, role string) error
+	RevokeRole(ctx context.Context, userUUID, role string) error
+}
+
+// Roles returns the name of every role in name order.
+func Roles() []string {
+	roles := make([]string, 0, len(rolePermissions))
+	for role := range rolePermissions {
+		roles = append(roles, role)
+	}
+	slices.Sort(roles)
+	return roles
+}
+
+// HasPermission reports whether any of the roles grants the permission.
+func HasPermission(roles []string, permission Permission) bool {
+	for _, role := range roles {
+		if slices.Contains(rolePermissions[role], permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// ToAPIRoles converts role names into roles listing the permissions each grants.
+func ToAPIRoles(names []string) []*api.Role {
+	roles := make([]*api.Role, 0, len(names))
+	for _, name := range names {
+		role := &api.Role{Name: name}
+		for _, permission := range rolePermissions[name] {
+			role.Permissions = append(role.Permissions, string(permission))
+		}
+		roles = append(roles, role)
+	}
+	return roles
+}
+
+// ValidateRole checks a role named by a caller exists.
+func ValidateRole(field, role string) error {
+	if _, ok := rolePermissions[role]; !ok {
+		return NewInvalidArgumentError(field, "unknown role %q, expected one of %s", role, strings.Join(Roles(), ", "))
+	}
+	return nil
+}
+
+// GetUserRolesFromDatasource returns the roles held by the user in name order.
+func GetUserRolesFromDatasource(ctx context.Context, store RoleStore, userUUID string) (_ []string, err error) {
+	ctx, end := tracing.Start(ctx, "service.GetUserRolesFromDatasource")
+	defer end(&err)
+
+	roles, err := store.GetUserRoles(ctx, userUUID)
+	if err != nil {
+		slog.Error("failed to get user roles", "error", err)
+		return nil, fmt.Errorf("failed to get user roles: %w", err)
+	}
+
+	return roles, nil
+}
+
+// AssignRoleInDatasource gives the user the role, assigning a role the user already holds changes nothing.
+func AssignRoleInDatasource(ctx context.Context, store RoleStore, userUUID, role string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.AssignRoleInDatasource")
+	defer end(&err)
+
+	if err := store.AssignRole(ctx, userUUID, role); err != nil {
+		slog.Error("failed to assign role", "role", role, "error", err)
+		return fmt.Errorf("failed to assign role: %w", err)
+	}
+
+	slog.Info("Role assigned", "userID", userUUID, "role", role)
+	return nil
+}
+
+// RevokeRoleFromDatasource takes the role away from the user, revoking a role the user doesn't hold changes nothing.
+func RevokeRoleFromDatasource(ctx context.Context, store RoleStore, userUUID, role string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.RevokeRoleFromDatasource")
+	defer end(&err)
+
+	if err := store.RevokeRole(ctx, userUUID, role); err != nil {
+		slog.Error("failed to revoke role", "role", role, "error", err)
+		return fmt.Errorf("failed to revoke role: %w", err)
+	}
+
+	slog.Info("Role revoked", "userID", userUUID, "role", role)
+	return nil
+}
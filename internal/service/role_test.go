@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHasPermission(t *testing.T) {
+	tests := []struct {
+		name       string
+		roles      []string
+		permission Permission
+		want       bool
+	}{
+		{
+			name:       "no roles",
+			permission: PermissionRead,
+			want:       false,
+		},
+		{
+			name:       "granted by a role",
+			roles:      []string{RoleEditor},
+			permission: PermissionWrite,
+			want:       true,
+		},
+		{
+			name:       "not granted by a lesser role",
+			roles:      []string{RoleEditor},
+			permission: PermissionDelete,
+			want:       false,
+		},
+		{
+			name:       "granted by any of several roles",
+			roles:      []string{RoleViewer, RoleAdmin},
+			permission: PermissionAdmin,
+			want:       true,
+		},
+		{
+			name:       "unknown roles grant nothing",
+			roles:      []string{"superuser"},
+			permission: PermissionRead,
+			want:       false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, HasPermission(tc.roles, tc.permission))
+		})
+	}
+}
+
+func TestValidateRole(t *testing.T) {
+	assert.NoError(t, ValidateRole("role", RoleModerator))
+	assert.EqualError(t, ValidateRole("role", "superuser"), `unknown role "superuser", expected one of admin, editor, moderator, viewer`)
+}
+
+func TestToAPIRoles(t *testing.T) {
+	roles := ToAPIRoles([]string{RoleViewer, RoleEditor})
+
+	assert.Len(t, roles, 2)
+	assert.Equal(t, "viewer", roles[0].Name)
+	assert.Equal(t, []string{"users.read"}, roles[0].Permissions)
+	assert.Equal(t, "editor", roles[1].Name)
+	assert.Equal(t, []string{"users.read", "users.write"}, roles[1].Permissions)
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/EFG/internal/datasource/dto"
+)
+
+// ErrInvalidOrderBy is returned when an order by names an unknown field or direction.
+var ErrInvalidOrderBy = errors.New("invalid order by")
+
+// SortableFields are the user fields GetUsers can be ordered by.
+var SortableFields = []string{FieldFirstName, FieldLastName, FieldEmail, FieldCountry, FieldCreatedAt, FieldUpdatedAt}
+
+const (
+	SortAscending  = "ASC"
+	SortDescending = "DESC"
+)
+
+// SortField is a single field of an order by and the direction it is sorted in.
+type SortField struct {
+	Field     string
+	Direction string
+}
+
+// ParseOrderBy parses a comma separated list of fields each optionally followed by asc or desc,
+// e.g. "last_name, created_at desc". Fields default to ascending and may only be listed once.
+func ParseOrderBy(orderBy string) ([]SortField, error) {
+	var sortFields []SortField
+	for _, part := range strings.Split(orderBy, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 || len(words) > 2 {
+			return nil, fmt.Errorf("%w: malformed term %q", ErrInvalidOrderBy, strings.TrimSpace(part))
+		}
+
+		field := words[0]
+		if !slices.Contains(SortableFields, field) {
+			return nil, fmt.Errorf("%w: cannot order by %q", ErrInvalidOrderBy, field)
+		}
+
+		if slices.ContainsFunc(sortFields, func(s SortField) bool { return s.Field == field }) {
+			return nil, fmt.Errorf("%w: %q listed more than once", ErrInvalidOrderBy, field)
+		}
+
+		direction := SortAscending
+		if len(words) == 2 {
+			direction = strings.ToUpper(words[1])
+			if direction != SortAscending && direction != SortDescending {
+				return nil, fmt.Errorf("%w: unknown direction %q for %q", ErrInvalidOrderBy, words[1], field)
+			}
+		}
+
+		sortFields = append(sortFields, SortField{Field: field, Direction: direction})
+	}
+
+	return sortFields, nil
+}
+
+// ApplyOrderBy validates the order by and sets the fields and directions on the query args.
+func ApplyOrderBy(args *dto.GetUsersArgs, orderBy string) error {
+	sortFields, err := ParseOrderBy(orderBy)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range sortFields {
+		args.OrderByFields = append(args.OrderByFields, s.Field)
+		args.OrderByDirections = append(args.OrderByDirections, s.Direction)
+	}
+
+	return nil
+}
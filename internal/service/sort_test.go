@@ -0,0 +1,69 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/EFG/internal/datasource/dto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOrderBy(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+		want    []SortField
+	}{
+		{
+			name:    "single field defaults to ascending",
+			orderBy: "last_name",
+			want:    []SortField{{Field: FieldLastName, Direction: SortAscending}},
+		},
+		{
+			name:    "multiple fields with directions",
+			orderBy: "country desc, last_name ASC,created_at Desc",
+			want: []SortField{
+				{Field: FieldCountry, Direction: SortDescending},
+				{Field: FieldLastName, Direction: SortAscending},
+				{Field: FieldCreatedAt, Direction: SortDescending},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOrderBy(tt.orderBy)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseOrderBy_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderBy string
+	}{
+		{name: "unknown field", orderBy: "password"},
+		{name: "field not in allow-list", orderBy: "nickname"},
+		{name: "injection attempt", orderBy: "email; DROP TABLE users"},
+		{name: "unknown direction", orderBy: "email sideways"},
+		{name: "too many words", orderBy: "email asc desc"},
+		{name: "empty term", orderBy: "email,,country"},
+		{name: "duplicate field", orderBy: "email asc, email desc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOrderBy(tt.orderBy)
+			assert.ErrorIs(t, err, ErrInvalidOrderBy)
+		})
+	}
+}
+
+func TestApplyOrderBy(t *testing.T) {
+	var args dto.GetUsersArgs
+	err := ApplyOrderBy(&args, "email desc, first_name")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{FieldEmail, FieldFirstName}, args.OrderByFields)
+	assert.Equal(t, []string{SortDescending, SortAscending}, args.OrderByDirections)
+}
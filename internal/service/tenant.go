@@ -0,0 +1,37 @@
+package service
+
+import (
+	"context"
+	"regexp"
+)
+
+// DefaultTenant is the tenant of calls that don't name one, every user written before tenancy
+// was introduced belongs to it.
+const DefaultTenant = "default"
+
+// tenantIDPattern keeps tenant IDs to short lowercase slugs.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+type tenantKey struct{}
+
+// WithTenant returns a copy of ctx scoped to the tenant, every read and write made with it only
+// sees that tenant's users.
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or DefaultTenant if there is none.
+func TenantFromContext(ctx context.Context) string {
+	if tenantID, ok := ctx.Value(tenantKey{}).(string); ok && tenantID != "" {
+		return tenantID
+	}
+	return DefaultTenant
+}
+
+// ValidateTenantID checks a tenant ID supplied by a caller.
+func ValidateTenantID(field, tenantID string) error {
+	if !tenantIDPattern.MatchString(tenantID) {
+		return NewInvalidArgumentError(field, "tenant %q must be 1 to 63 lowercase letters, digits, '-' or '_'", tenantID)
+	}
+	return nil
+}
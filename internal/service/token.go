@@ -0,0 +1,22 @@
+package service
+
+import "time"
+
+// TokenClaims are the claims carried by an access token.
+type TokenClaims struct {
+	UserID string
+	Email  string
+	// TenantID is the tenant the user belongs to, empty in tokens issued before tenancy
+	TenantID  string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// TokenIssuer mints and verifies signed access tokens.
+type TokenIssuer interface {
+	// IssueToken signs a token for the user and email in claims, the issued and expiry times are
+	// set by the issuer and returned alongside the token.
+	IssueToken(claims TokenClaims) (string, TokenClaims, error)
+	// ValidateToken verifies the signature and lifetime of a token and returns its claims.
+	ValidateToken(token string) (TokenClaims, error)
+}
@@ -1,10 +1,17 @@
 package service
 
 import (
+	"context"
+	"database/sql"
+	"runtime"
+	"slices"
+	"sync"
 	"time"
 
 	"github.com/EFG/api"
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/metrics"
+	"github.com/EFG/internal/tracing"
 	"github.com/EFG/internal/utils"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -12,6 +19,8 @@ import (
 type Datasource interface {
 	Reader
 	Writer
+	RoleStore
+	APIKeyStore
 }
 
 type User struct {
@@ -24,12 +33,37 @@ type User struct {
 	Country   string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// UpdateMask lists the fields explicitly being modified, masked fields are written even
+	// when empty. A nil mask means only the non-empty fields are written.
+	UpdateMask []string
+	// ETag is the etag the modification is conditional on, empty to modify unconditionally
+	ETag string
 }
 
 type Users []User
 
-func (u *User) hashPassword() error {
+// Field names accepted in an update mask or order by, these match the API field names.
+const (
+	FieldFirstName = "first_name"
+	FieldLastName  = "last_name"
+	FieldNickname  = "nickname"
+	FieldEmail     = "email"
+	FieldPassword  = "password"
+	FieldCountry   = "country"
+	FieldCreatedAt = "created_at"
+	FieldUpdatedAt = "updated_at"
+)
+
+// UpdatableFields are the user fields that can be named in an update mask.
+var UpdatableFields = []string{FieldFirstName, FieldLastName, FieldNickname, FieldEmail, FieldPassword, FieldCountry}
+
+func (u *User) hashPassword(ctx context.Context) (err error) {
+	_, end := tracing.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer end(&err)
+
+	start := time.Now()
 	hashed, err := bcrypt.GenerateFromPassword([]byte(u.Password), bcrypt.DefaultCost)
+	metrics.ObservePasswordHash("hash", time.Since(start))
 	if err != nil {
 		return err
 	}
@@ -38,10 +72,41 @@ func (u *User) hashPassword() error {
 	return nil
 }
 
+// hashPasswords hashes every password in the batch, spreading the bcrypt work across the available CPUs.
+func (u Users) hashPasswords(ctx context.Context) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(u))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+
+	for i := range u {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			errs[i] = u[i].hashPassword(ctx)
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type UserChange struct {
 	ChangeType string `json:"changeType"`
 	EventTime  string `json:"eventTime"`
 	UserID     string `json:"userId"`
+	// Outcome is set for changes that can fail such as authenticate
+	Outcome string `json:"outcome,omitempty"`
+	// PurgeAfter is set for soft deletes, the user can be restored until then and is purged after
+	PurgeAfter string `json:"purgeAfter,omitempty"`
+	TenantID   string `json:"tenantId"`
 }
 
 func CreateUserChangeNotification(changeType string, userID string, eventTime time.Time) UserChange {
@@ -71,17 +136,23 @@ func NewUserFromModifyRequest(req *api.ModifyUserRequest) User {
 		return User{}
 	}
 	return User{
-		ID:        req.Id,
-		FirstName: req.FirstName,
-		LastName:  req.LastName,
-		Nickname:  req.Nickname,
-		Password:  req.Password,
-		Email:     req.Email,
-		Country:   req.Country,
+		ID:         req.Id,
+		FirstName:  req.FirstName,
+		LastName:   req.LastName,
+		Nickname:   req.Nickname,
+		Password:   req.Password,
+		Email:      req.Email,
+		Country:    req.Country,
+		UpdateMask: req.GetUpdateMask().GetPaths(),
+		ETag:       req.Etag,
 	}
 }
 
 func (u *User) toDTO() dto.UserDTO {
+	if u.UpdateMask != nil {
+		return u.toMaskedDTO()
+	}
+
 	return dto.UserDTO{
 		ID:        utils.ToNullString(u.ID),
 		FirstName: utils.ToNullString(u.FirstName),
@@ -95,17 +166,60 @@ func (u *User) toDTO() dto.UserDTO {
 	}
 }
 
+// toMaskedDTO carries field presence from the update mask, masked fields are valid even when
+// empty so they can be cleared and every other field is left null so it is untouched.
+func (u *User) toMaskedDTO() dto.UserDTO {
+	masked := func(field, value string) sql.NullString {
+		if slices.Contains(u.UpdateMask, field) {
+			return sql.NullString{String: value, Valid: true}
+		}
+		return sql.NullString{Valid: false}
+	}
+
+	return dto.UserDTO{
+		ID:        utils.ToNullString(u.ID),
+		FirstName: masked(FieldFirstName, u.FirstName),
+		LastName:  masked(FieldLastName, u.LastName),
+		Nickname:  masked(FieldNickname, u.Nickname),
+		Password:  masked(FieldPassword, u.Password),
+		Email:     masked(FieldEmail, u.Email),
+		Country:   masked(FieldCountry, u.Country),
+	}
+}
+
 func FromDTOToAPI(userDTO dto.UsersDTO) []*api.User {
 	users := make([]*api.User, len(userDTO))
 	for i, u := range userDTO {
-		users[i] = &api.User{
-			Id:        u.ID.String,
-			FirstName: u.FirstName.String,
-			LastName:  u.LastName.String,
-			Nickname:  u.Nickname.String,
-			Email:     u.Email.String,
-			Country:   u.Country.String,
-		}
+		users[i] = FromDTOToAPIUser(u)
 	}
 	return users
 }
+
+func FromDTOToAPIUser(u dto.UserDTO) *api.User {
+	user := &api.User{
+		Id:        u.ID.String,
+		FirstName: u.FirstName.String,
+		LastName:  u.LastName.String,
+		Nickname:  u.Nickname.String,
+		Email:     u.Email.String,
+		Country:   u.Country.String,
+	}
+	if u.CreatedAt.Valid {
+		user.CreatedAt = u.CreatedAt.Time.Format(time.RFC3339)
+	}
+	if u.UpdatedAt.Valid {
+		user.UpdatedAt = u.UpdatedAt.Time.Format(time.RFC3339)
+	}
+	if u.EmailVerifiedAt.Valid {
+		user.EmailVerified = true
+		user.EmailVerifiedAt = u.EmailVerifiedAt.Time.Format(time.RFC3339)
+	}
+	user.PendingEmail = u.PendingEmail.String
+	if u.DeletedAt.Valid {
+		user.DeletedAt = u.DeletedAt.Time.Format(time.RFC3339)
+	}
+	if u.Version.Valid {
+		user.Etag = ETagFromVersion(u.Version.Int64)
+	}
+	return user
+}
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"database/sql"
 	"reflect"
 	"testing"
 	"time"
@@ -10,6 +12,7 @@ import (
 	"github.com/EFG/internal/utils"
 	"github.com/stretchr/testify/assert"
 	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
 func TestUser_hashPassword(t *testing.T) {
@@ -51,7 +54,7 @@ func TestUser_hashPassword(t *testing.T) {
 				Password: tt.password,
 			}
 
-			err := u.hashPassword()
+			err := u.hashPassword(context.Background())
 
 			if tt.expectError {
 				assert.Error(t, err)
@@ -67,6 +70,24 @@ func TestUser_hashPassword(t *testing.T) {
 	}
 }
 
+func TestUsers_hashPasswords(t *testing.T) {
+	users := Users{
+		{Password: "password123"},
+		{Password: "p4ssw0rd!@#"},
+		{Password: ""},
+	}
+
+	err := users.hashPasswords(context.Background())
+	assert.NoError(t, err)
+
+	for i, plaintext := range []string{"password123", "p4ssw0rd!@#", ""} {
+		assert.NotEqual(t, plaintext, users[i].Password)
+
+		err = bcrypt.CompareHashAndPassword([]byte(users[i].Password), []byte(plaintext))
+		assert.NoError(t, err, "plaintext equivalent of hash should match the original password")
+	}
+}
+
 func TestNewUserFromCreateRequest(t *testing.T) {
 	type args struct {
 		req *api.CreateUserRequest
@@ -165,6 +186,20 @@ func TestNewUserFromModifyRequest(t *testing.T) {
 				Nickname:  "johndoe",
 			},
 		},
+		{
+			name: "request with update mask",
+			args: args{
+				req: &api.ModifyUserRequest{
+					Id:         "123",
+					Nickname:   "",
+					UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"nickname"}},
+				},
+			},
+			want: User{
+				ID:         "123",
+				UpdateMask: []string{"nickname"},
+			},
+		},
 		{
 			name: "empty request",
 			args: args{
@@ -235,6 +270,26 @@ func TestUser_toDTO(t *testing.T) {
 				UpdatedAt: utils.ToNullTime(timestamp),
 			},
 		},
+		{
+			name: "user with update mask",
+			user: User{
+				ID:         "123",
+				FirstName:  "John",
+				LastName:   "Doe",
+				Nickname:   "",
+				Country:    "USA",
+				UpdateMask: []string{"first_name", "nickname"},
+			},
+			want: dto.UserDTO{
+				ID:        utils.ToNullString("123"),
+				FirstName: sql.NullString{String: "John", Valid: true},
+				LastName:  sql.NullString{Valid: false},
+				Nickname:  sql.NullString{String: "", Valid: true},
+				Password:  sql.NullString{Valid: false},
+				Email:     sql.NullString{Valid: false},
+				Country:   sql.NullString{Valid: false},
+			},
+		},
 		{
 			name: "empty user",
 			user: User{},
@@ -336,6 +391,27 @@ func TestFromDTOToAPI(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "user with timestamps",
+			args: args{
+				userDTO: dto.UsersDTO{
+					{
+						ID:        utils.ToNullString("123"),
+						FirstName: utils.ToNullString("John"),
+						CreatedAt: utils.ToNullTime(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)),
+						UpdatedAt: utils.ToNullTime(time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)),
+					},
+				},
+			},
+			want: []*api.User{
+				{
+					Id:        "123",
+					FirstName: "John",
+					CreatedAt: "2025-01-01T00:00:00Z",
+					UpdatedAt: "2025-01-02T00:00:00Z",
+				},
+			},
+		},
 		{
 			name: "multiple users",
 			args: args{
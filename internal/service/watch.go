@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+)
+
+// ErrWatcherFellBehind is returned when a watcher could not keep up with the change feed and was dropped.
+var ErrWatcherFellBehind = errors.New("watcher fell behind the change feed")
+
+type Watcher interface {
+	Subscribe(ctx context.Context, filter ChangeFilter) (<-chan UserChange, error)
+}
+
+// ChangeFilter narrows down the user changes delivered to a watcher, zero values match everything.
+type ChangeFilter struct {
+	ChangeTypes []string
+	UserID      string
+	Since       time.Time
+	TenantID    string
+}
+
+func (f ChangeFilter) Matches(change UserChange) bool {
+	if len(f.ChangeTypes) > 0 && !slices.Contains(f.ChangeTypes, change.ChangeType) {
+		return false
+	}
+
+	if f.UserID != "" && f.UserID != change.UserID {
+		return false
+	}
+
+	if f.TenantID != "" && f.TenantID != change.TenantID {
+		return false
+	}
+
+	return true
+}
+
+// IsReplayable reports whether a previously published change should be replayed for this filter.
+func (f ChangeFilter) IsReplayable(change UserChange) bool {
+	if f.Since.IsZero() || !f.Matches(change) {
+		return false
+	}
+
+	eventTime, err := time.Parse(time.RFC3339, change.EventTime)
+	if err != nil {
+		return false
+	}
+
+	return !eventTime.Before(f.Since)
+}
+
+func WatchUserChanges(ctx context.Context, watcher Watcher, filter ChangeFilter, send func(UserChange) error) error {
+	changes, err := watcher.Subscribe(ctx, filter)
+	if err != nil {
+		slog.Error("failed to subscribe to user changes", "error", err)
+		return fmt.Errorf("failed to subscribe to user changes: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case change, ok := <-changes:
+			if !ok {
+				if ctx.Err() != nil {
+					return nil
+				}
+				return ErrWatcherFellBehind
+			}
+			if err := send(change); err != nil {
+				return fmt.Errorf("failed to send user change: %w", err)
+			}
+		}
+	}
+}
@@ -2,20 +2,35 @@ package service
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
+	"slices"
+	"time"
 
 	"github.com/EFG/internal/datasource/dto"
+	"github.com/EFG/internal/tracing"
 )
 
 type Writer interface {
 	CreateUser(ctx context.Context, user dto.UserDTO) (string, error)
-	ModifyUser(ctx context.Context, user dto.UserDTO) error
-	DeleteUser(ctx context.Context, userUUID string) error
+	CreateUsers(ctx context.Context, users dto.UsersDTO) (dto.UsersDTO, error)
+	ModifyUser(ctx context.Context, user dto.UserDTO) (dto.UserDTO, error)
+	DeleteUser(ctx context.Context, userUUID string, expectedVersion sql.NullInt64) error
+	RestoreUser(ctx context.Context, userUUID string) (dto.UserDTO, error)
+	PurgeUser(ctx context.Context, userUUID string) error
+	PurgeDeletedUsers(ctx context.Context, deletedBefore time.Time, limit int) (dto.UsersDTO, error)
+	CreatePasswordResetToken(ctx context.Context, email, tokenHash string, expiresAt time.Time) (string, error)
+	CompletePasswordReset(ctx context.Context, tokenHash, passwordHash string, now time.Time) (string, error)
+	CreateEmailVerificationToken(ctx context.Context, userID, tokenHash string, expiresAt time.Time) (string, error)
+	ConfirmEmail(ctx context.Context, tokenHash string, now time.Time) (string, error)
 }
 
 func FormatNewUserAndPersist(ctx context.Context, writer Writer, user User) (id string, err error) {
-	err = user.hashPassword()
+	ctx, end := tracing.Start(ctx, "service.FormatNewUserAndPersist")
+	defer end(&err)
+
+	err = user.hashPassword(ctx)
 	if err != nil {
 		slog.Error("failed to hash password", "error", err)
 		return "", fmt.Errorf("failed to hash password: %w", err)
@@ -32,30 +47,111 @@ func FormatNewUserAndPersist(ctx context.Context, writer Writer, user User) (id
 	return
 }
 
-func FormatExistingUserAndPersist(ctx context.Context, writer Writer, user User) error {
-	// if password is part of the modification, hash it
-	if user.Password != "" {
-		err := user.hashPassword()
+// FormatNewUsersAndPersist hashes and writes a batch of new users, the returned IDs line up
+// with the supplied users and are empty where the email already exists.
+func FormatNewUsersAndPersist(ctx context.Context, writer Writer, users Users) (_ []string, err error) {
+	ctx, end := tracing.Start(ctx, "service.FormatNewUsersAndPersist")
+	defer end(&err)
+
+	err = users.hashPasswords(ctx)
+	if err != nil {
+		slog.Error("failed to hash passwords", "error", err)
+		return nil, fmt.Errorf("failed to hash passwords: %w", err)
+	}
+
+	usersToWrite := make(dto.UsersDTO, len(users))
+	for i, u := range users {
+		usersToWrite[i] = u.toDTO()
+	}
+
+	created, err := writer.CreateUsers(ctx, usersToWrite)
+	if err != nil {
+		slog.Error("failed to create users", "error", err)
+		return nil, fmt.Errorf("failed to create users: %w", err)
+	}
+
+	createdIDs := make(map[string]string, len(created))
+	for _, u := range created {
+		createdIDs[u.Email.String] = u.ID.String
+	}
+
+	// only the first user with a given email can have been created
+	ids := make([]string, len(users))
+	for i, u := range users {
+		ids[i] = createdIDs[u.Email]
+		delete(createdIDs, u.Email)
+	}
+
+	return ids, nil
+}
+
+func FormatExistingUserAndPersist(ctx context.Context, writer Writer, user User) (_ dto.UserDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.FormatExistingUserAndPersist")
+	defer end(&err)
+
+	expectedVersion, err := VersionFromETag(user.ETag)
+	if err != nil {
+		return dto.UserDTO{}, err
+	}
+
+	// if password is part of the modification, hash it, a password left out of the update mask is
+	// never written so there is no point paying for the hash
+	if user.Password != "" && (user.UpdateMask == nil || slices.Contains(user.UpdateMask, FieldPassword)) {
+		err := user.hashPassword(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to hash password: %w", err)
+			return dto.UserDTO{}, fmt.Errorf("failed to hash password: %w", err)
 		}
 	}
 
 	userEntityToWrite := user.toDTO()
+	userEntityToWrite.Version = expectedVersion
 
-	err := writer.ModifyUser(ctx, userEntityToWrite)
+	modified, err := writer.ModifyUser(ctx, userEntityToWrite)
 	if err != nil {
-		return fmt.Errorf("failed to modify user: %w", err)
+		return dto.UserDTO{}, fmt.Errorf("failed to modify user: %w", err)
 	}
 
-	return nil
+	return modified, nil
 }
 
-func DeleteUserFromDatasource(ctx context.Context, writer Writer, userUUID string) error {
-	err := writer.DeleteUser(ctx, userUUID)
+// DeleteUserFromDatasource soft deletes the user, a non-empty etag makes the deletion conditional on it.
+func DeleteUserFromDatasource(ctx context.Context, writer Writer, userUUID, etag string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.DeleteUserFromDatasource")
+	defer end(&err)
+
+	expectedVersion, err := VersionFromETag(etag)
+	if err != nil {
+		return err
+	}
+
+	err = writer.DeleteUser(ctx, userUUID, expectedVersion)
 	if err != nil {
 		return fmt.Errorf("failed to delete user: %w", err)
 	}
 
 	return nil
 }
+
+func RestoreUserInDatasource(ctx context.Context, writer Writer, userUUID string) (_ dto.UserDTO, err error) {
+	ctx, end := tracing.Start(ctx, "service.RestoreUserInDatasource")
+	defer end(&err)
+
+	restored, err := writer.RestoreUser(ctx, userUUID)
+	if err != nil {
+		return dto.UserDTO{}, fmt.Errorf("failed to restore user: %w", err)
+	}
+
+	return restored, nil
+}
+
+func PurgeUserFromDatasource(ctx context.Context, writer Writer, userUUID string) (err error) {
+	ctx, end := tracing.Start(ctx, "service.PurgeUserFromDatasource")
+	defer end(&err)
+
+	err = writer.PurgeUser(ctx, userUUID)
+	if err != nil {
+		return fmt.Errorf("failed to purge user: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package service_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/EFG/internal/datasource/database/postgres"
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatExistingUserAndPersist_HashesOnlyAMaskedPassword(t *testing.T) {
+	// bcrypt refuses a password this long, so hashing it at all fails the modification
+	tooLong := strings.Repeat("a", 73)
+
+	mockDatasource := &postgres.MockClient{}
+	_, err := service.FormatExistingUserAndPersist(context.Background(), mockDatasource, service.User{
+		ID:         "123e4567-e89b-12d3-a456-426614174001",
+		FirstName:  "John",
+		Password:   tooLong,
+		UpdateMask: []string{service.FieldFirstName},
+	})
+	assert.NoError(t, err)
+	assert.Len(t, mockDatasource.GetCallHistory(), 1)
+	assert.False(t, mockDatasource.GetCallHistory()[0].Password.Valid)
+
+	mockDatasource = &postgres.MockClient{}
+	_, err = service.FormatExistingUserAndPersist(context.Background(), mockDatasource, service.User{
+		ID:         "123e4567-e89b-12d3-a456-426614174001",
+		Password:   tooLong,
+		UpdateMask: []string{service.FieldPassword},
+	})
+	assert.ErrorContains(t, err, "failed to hash password")
+	assert.Empty(t, mockDatasource.GetCallHistory())
+}
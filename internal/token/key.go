@@ -0,0 +1,124 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+// Supported signing algorithms.
+const (
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// minRSABits is the smallest RSA modulus accepted for signing or verification.
+const minRSABits = 2048
+
+// Key is a signing key identified by its key ID, a key loaded from a public key can
+// only verify tokens, which is how retired keys are kept around during a rotation.
+type Key struct {
+	ID        string
+	Algorithm string
+	signer    crypto.Signer
+	public    crypto.PublicKey
+}
+
+// CanSign reports whether the private half of the key is available.
+func (k Key) CanSign() bool {
+	return k.signer != nil
+}
+
+// LoadKeyFile reads a PEM encoded RSA or Ed25519 key, private or public, from path.
+func LoadKeyFile(id, path string) (Key, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to read key %s: %w", id, err)
+	}
+
+	return ParseKey(id, raw)
+}
+
+// ParseKey parses a PEM encoded PKCS#8 or PKCS#1 private key or a PKIX public key.
+func ParseKey(id string, pemBytes []byte) (Key, error) {
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return Key{}, fmt.Errorf("key %s is not PEM encoded", id)
+	}
+
+	var parsed any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return Key{}, fmt.Errorf("key %s has unsupported PEM type %q", id, block.Type)
+	}
+	if err != nil {
+		return Key{}, fmt.Errorf("failed to parse key %s: %w", id, err)
+	}
+
+	return newKey(id, parsed)
+}
+
+func newKey(id string, parsed any) (Key, error) {
+	key := Key{ID: id}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Algorithm, key.signer, key.public = AlgorithmRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Algorithm, key.public = AlgorithmRS256, k
+	case ed25519.PrivateKey:
+		key.Algorithm, key.signer, key.public = AlgorithmEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Algorithm, key.public = AlgorithmEdDSA, k
+	default:
+		return Key{}, fmt.Errorf("key %s has unsupported type %T, only RSA and Ed25519 keys are supported", id, parsed)
+	}
+
+	if rsaKey, ok := key.public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+		return Key{}, fmt.Errorf("key %s is %d bits, RSA keys must be at least %d bits", id, rsaKey.N.BitLen(), minRSABits)
+	}
+
+	return key, nil
+}
+
+// jwk is the JSON Web Key form of the public half of a key.
+type jwk struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+func (k Key) jwk() jwk {
+	out := jwk{KeyID: k.ID, Use: "sig", Algorithm: k.Algorithm}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		out.KeyType = "RSA"
+		out.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		out.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		out.KeyType = "OKP"
+		out.Curve = "Ed25519"
+		out.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return out
+}
@@ -0,0 +1,289 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/EFG/internal/service"
+)
+
+// leeway absorbs clock skew between the issuer and whoever validates the token.
+const leeway = 30 * time.Second
+
+// DefaultTTL is how long tokens are valid for when no TTL is configured.
+const DefaultTTL = 15 * time.Minute
+
+// DefaultAudience is the aud claim of tokens when no audience is configured.
+const DefaultAudience = "user-service"
+
+// tokenType is the typ header of access tokens, other JWTs signed with the same keys are refused.
+const tokenType = "JWT"
+
+type header struct {
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+	Type      string `json:"typ"`
+}
+
+type claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  audience `json:"aud,omitempty"`
+	Subject   string   `json:"sub"`
+	Email     string   `json:"email,omitempty"`
+	Tenant    string   `json:"tenant,omitempty"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	ID        string   `json:"jti"`
+}
+
+// audience is the aud claim, a single string or an array of them.
+type audience []string
+
+func (a audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+func (a *audience) UnmarshalJSON(raw []byte) error {
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	return json.Unmarshal(raw, (*[]string)(a))
+}
+
+type ManagerOpts struct {
+	// SigningKeyID is the key new tokens are signed with, it must have its private half loaded
+	SigningKeyID string
+	Issuer       string
+	// Audience is the aud claim of issued tokens and the audience tokens must name to be valid,
+	// DefaultAudience when unset
+	Audience string
+	TTL      time.Duration
+	TimeNow  func() time.Time
+}
+
+// Manager issues JWTs with the active signing key and validates tokens signed by any loaded key,
+// so a key can be rotated by adding the new key, switching the signing key ID and later dropping
+// the old key once its tokens have expired.
+type Manager struct {
+	keys       map[string]Key
+	signingKey Key
+	issuer     string
+	audience   string
+	ttl        time.Duration
+	timeNow    func() time.Time
+}
+
+func NewManager(keys []Key, opts ManagerOpts) (*Manager, error) {
+	m := &Manager{
+		keys:     make(map[string]Key, len(keys)),
+		issuer:   opts.Issuer,
+		audience: opts.Audience,
+		ttl:      opts.TTL,
+		timeNow:  opts.TimeNow,
+	}
+
+	for _, k := range keys {
+		if _, ok := m.keys[k.ID]; ok {
+			return nil, fmt.Errorf("key id %s is used more than once", k.ID)
+		}
+		m.keys[k.ID] = k
+	}
+
+	signingKey, ok := m.keys[opts.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %s is not one of the loaded keys", opts.SigningKeyID)
+	}
+	if !signingKey.CanSign() {
+		return nil, fmt.Errorf("signing key %s has no private key", opts.SigningKeyID)
+	}
+	m.signingKey = signingKey
+
+	if m.audience == "" {
+		m.audience = DefaultAudience
+	}
+	if m.ttl <= 0 {
+		m.ttl = DefaultTTL
+	}
+	if m.timeNow == nil {
+		m.timeNow = time.Now
+	}
+
+	return m, nil
+}
+
+func (m *Manager) IssueToken(tc service.TokenClaims) (string, service.TokenClaims, error) {
+	now := m.timeNow().Truncate(time.Second)
+	tc.IssuedAt = now
+	tc.ExpiresAt = now.Add(m.ttl)
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", service.TokenClaims{}, fmt.Errorf("failed to generate token id: %w", err)
+	}
+
+	signingInput, err := encodeSegments(
+		header{Algorithm: m.signingKey.Algorithm, KeyID: m.signingKey.ID, Type: tokenType},
+		claims{
+			Issuer:    m.issuer,
+			Audience:  audience{m.audience},
+			Subject:   tc.UserID,
+			Email:     tc.Email,
+			Tenant:    tc.TenantID,
+			IssuedAt:  tc.IssuedAt.Unix(),
+			NotBefore: tc.IssuedAt.Unix(),
+			ExpiresAt: tc.ExpiresAt.Unix(),
+			ID:        base64.RawURLEncoding.EncodeToString(jti),
+		},
+	)
+	if err != nil {
+		return "", service.TokenClaims{}, err
+	}
+
+	signature, err := sign(m.signingKey, []byte(signingInput))
+	if err != nil {
+		return "", service.TokenClaims{}, fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), tc, nil
+}
+
+func (m *Manager) ValidateToken(token string) (service.TokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return service.TokenClaims{}, fmt.Errorf("%w: malformed token", service.ErrInvalidToken)
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return service.TokenClaims{}, err
+	}
+
+	// typ is compared case insensitively as RFC 7519 recommends
+	if !strings.EqualFold(h.Type, tokenType) {
+		return service.TokenClaims{}, fmt.Errorf("%w: unexpected type %q", service.ErrInvalidToken, h.Type)
+	}
+
+	key, ok := m.keys[h.KeyID]
+	if !ok {
+		return service.TokenClaims{}, fmt.Errorf("%w: unknown key id %q", service.ErrInvalidToken, h.KeyID)
+	}
+	// the algorithm is pinned to the key so a token can't choose how it is verified
+	if h.Algorithm != key.Algorithm {
+		return service.TokenClaims{}, fmt.Errorf("%w: algorithm %q does not match key %s", service.ErrInvalidToken, h.Algorithm, key.ID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return service.TokenClaims{}, fmt.Errorf("%w: malformed signature", service.ErrInvalidToken)
+	}
+	if !verify(key, []byte(parts[0]+"."+parts[1]), signature) {
+		return service.TokenClaims{}, fmt.Errorf("%w: bad signature", service.ErrInvalidToken)
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return service.TokenClaims{}, err
+	}
+
+	now := m.timeNow()
+	switch {
+	case m.issuer != "" && c.Issuer != m.issuer:
+		return service.TokenClaims{}, fmt.Errorf("%w: unexpected issuer %q", service.ErrInvalidToken, c.Issuer)
+	case !slices.Contains(c.Audience, m.audience):
+		return service.TokenClaims{}, fmt.Errorf("%w: not issued for audience %q", service.ErrInvalidToken, m.audience)
+	case now.After(time.Unix(c.ExpiresAt, 0).Add(leeway)):
+		return service.TokenClaims{}, fmt.Errorf("%w: expired", service.ErrInvalidToken)
+	case now.Add(leeway).Before(time.Unix(c.NotBefore, 0)):
+		return service.TokenClaims{}, fmt.Errorf("%w: not valid yet", service.ErrInvalidToken)
+	case c.Subject == "":
+		return service.TokenClaims{}, fmt.Errorf("%w: missing subject", service.ErrInvalidToken)
+	}
+
+	return service.TokenClaims{
+		UserID:    c.Subject,
+		Email:     c.Email,
+		TenantID:  c.Tenant,
+		IssuedAt:  time.Unix(c.IssuedAt, 0).UTC(),
+		ExpiresAt: time.Unix(c.ExpiresAt, 0).UTC(),
+	}, nil
+}
+
+// JWKS returns the JSON Web Key Set of every loaded key so other services can verify tokens offline.
+func (m *Manager) JWKS() []byte {
+	set := struct {
+		Keys []jwk `json:"keys"`
+	}{Keys: []jwk{}}
+
+	// the signing key is listed first, the rest follow in a stable order
+	set.Keys = append(set.Keys, m.signingKey.jwk())
+	for _, id := range slices.Sorted(maps.Keys(m.keys)) {
+		if id != m.signingKey.ID {
+			set.Keys = append(set.Keys, m.keys[id].jwk())
+		}
+	}
+
+	raw, _ := json.Marshal(set)
+	return raw
+}
+
+func encodeSegments(h header, c claims) (string, error) {
+	rawHeader, err := json.Marshal(h)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal token header: %w", err)
+	}
+	rawClaims, err := json.Marshal(c)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal token claims: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims), nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return fmt.Errorf("%w: malformed segment", service.ErrInvalidToken)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("%w: malformed segment", service.ErrInvalidToken)
+	}
+	return nil
+}
+
+func sign(key Key, signingInput []byte) ([]byte, error) {
+	switch key.Algorithm {
+	case AlgorithmRS256:
+		digest := sha256.Sum256(signingInput)
+		return key.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	case AlgorithmEdDSA:
+		return key.signer.Sign(rand.Reader, signingInput, crypto.Hash(0))
+	}
+	return nil, fmt.Errorf("unsupported algorithm %s", key.Algorithm)
+}
+
+func verify(key Key, signingInput, signature []byte) bool {
+	switch pub := key.public.(type) {
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(signingInput)
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(pub, signingInput, signature)
+	}
+	return false
+}
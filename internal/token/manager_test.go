@@ -0,0 +1,282 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/EFG/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newEd25519Key(t *testing.T, id string) Key {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	key, err := newKey(id, priv)
+	require.NoError(t, err)
+	return key
+}
+
+func newRSAKey(t *testing.T, id string) Key {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	key, err := newKey(id, priv)
+	require.NoError(t, err)
+	return key
+}
+
+// publicOnly returns the verify only half of key as it would be loaded after a rotation.
+func publicOnly(key Key) Key {
+	key.signer = nil
+	return key
+}
+
+func fixedTime(t time.Time) func() time.Time {
+	return func() time.Time { return t }
+}
+
+func TestManager_IssueAndValidate(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		key  Key
+	}{
+		{name: "EdDSA", key: newEd25519Key(t, "ed-1")},
+		{name: "RS256", key: newRSAKey(t, "rsa-1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := NewManager([]Key{tt.key}, ManagerOpts{
+				SigningKeyID: tt.key.ID,
+				Issuer:       "user-service",
+				TTL:          10 * time.Minute,
+				TimeNow:      fixedTime(now),
+			})
+			require.NoError(t, err)
+
+			token, issued, err := m.IssueToken(service.TokenClaims{UserID: "123", Email: "john.doe@example.com", TenantID: "brand-a"})
+			assert.NoError(t, err)
+			assert.Equal(t, now, issued.IssuedAt)
+			assert.Equal(t, now.Add(10*time.Minute), issued.ExpiresAt)
+
+			var h header
+			assert.NoError(t, decodeSegment(strings.Split(token, ".")[0], &h))
+			assert.Equal(t, header{Algorithm: tt.key.Algorithm, KeyID: tt.key.ID, Type: "JWT"}, h)
+
+			claims, err := m.ValidateToken(token)
+			assert.NoError(t, err)
+			assert.Equal(t, issued, claims)
+		})
+	}
+}
+
+func TestManager_ValidateRejectsBadTokens(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	key := newEd25519Key(t, "ed-1")
+
+	m, err := NewManager([]Key{key}, ManagerOpts{SigningKeyID: key.ID, Issuer: "user-service", TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+
+	token, _, err := m.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+	parts := strings.Split(token, ".")
+
+	otherKey := newEd25519Key(t, "ed-1")
+	other, err := NewManager([]Key{otherKey}, ManagerOpts{SigningKeyID: otherKey.ID, Issuer: "user-service", TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+	forged, _, err := other.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+
+	otherIssuer, err := NewManager([]Key{key}, ManagerOpts{SigningKeyID: key.ID, Issuer: "someone-else", TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+	wrongIssuer, _, err := otherIssuer.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+
+	encode := func(v any) string {
+		raw, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(raw)
+	}
+	// signed is a token signed with the manager's own key, so only what it claims is wrong
+	signed := func(h header, c claims) string {
+		signingInput, err := encodeSegments(h, c)
+		require.NoError(t, err)
+		signature, err := sign(key, []byte(signingInput))
+		require.NoError(t, err)
+		return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+	}
+	validClaims := func(aud audience) claims {
+		return claims{Issuer: "user-service", Audience: aud, Subject: "123", IssuedAt: now.Unix(), NotBefore: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()}
+	}
+	validHeader := header{Algorithm: AlgorithmEdDSA, KeyID: "ed-1", Type: "JWT"}
+
+	tests := []struct {
+		name  string
+		token string
+		now   time.Time
+	}{
+		{name: "malformed", token: "not-a-token", now: now},
+		{name: "signed by another key with the same id", token: forged, now: now},
+		{name: "tampered claims", token: parts[0] + "." + encode(claims{Subject: "456", ExpiresAt: now.Add(time.Hour).Unix()}) + "." + parts[2], now: now},
+		{name: "unknown key id", token: encode(header{Algorithm: AlgorithmEdDSA, KeyID: "ed-2", Type: "JWT"}) + "." + parts[1] + "." + parts[2], now: now},
+		{name: "algorithm not pinned to key", token: encode(header{Algorithm: "none", KeyID: "ed-1", Type: "JWT"}) + "." + parts[1] + ".", now: now},
+		{name: "wrong issuer", token: wrongIssuer, now: now},
+		{name: "not an access token", token: signed(header{Algorithm: AlgorithmEdDSA, KeyID: "ed-1", Type: "logout+jwt"}, validClaims(audience{DefaultAudience})), now: now},
+		{name: "missing type", token: signed(header{Algorithm: AlgorithmEdDSA, KeyID: "ed-1"}, validClaims(audience{DefaultAudience})), now: now},
+		{name: "wrong audience", token: signed(validHeader, validClaims(audience{"billing-service"})), now: now},
+		{name: "missing audience", token: signed(validHeader, validClaims(nil)), now: now},
+		{name: "expired", token: token, now: now.Add(DefaultTTL + time.Minute)},
+		{name: "not valid yet", token: token, now: now.Add(-time.Minute)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m.timeNow = fixedTime(tt.now)
+
+			_, err := m.ValidateToken(tt.token)
+			assert.ErrorIs(t, err, service.ErrInvalidToken)
+		})
+	}
+}
+
+func TestManager_AcceptsAudienceArray(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	key := newEd25519Key(t, "ed-1")
+
+	m, err := NewManager([]Key{key}, ManagerOpts{SigningKeyID: key.ID, Audience: "users", TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+
+	token, _, err := m.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+
+	var c map[string]any
+	require.NoError(t, decodeSegment(strings.Split(token, ".")[1], &c))
+	assert.Equal(t, "users", c["aud"])
+
+	signingInput, err := encodeSegments(
+		header{Algorithm: AlgorithmEdDSA, KeyID: "ed-1", Type: "jwt"},
+		claims{Audience: audience{"billing", "users"}, Subject: "123", NotBefore: now.Unix(), ExpiresAt: now.Add(time.Hour).Unix()},
+	)
+	require.NoError(t, err)
+	signature, err := sign(key, []byte(signingInput))
+	require.NoError(t, err)
+
+	claims, err := m.ValidateToken(signingInput + "." + base64.RawURLEncoding.EncodeToString(signature))
+	assert.NoError(t, err)
+	assert.Equal(t, "123", claims.UserID)
+}
+
+func TestManager_Rotation(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	oldKey := newEd25519Key(t, "2025-01")
+	newKey := newRSAKey(t, "2025-02")
+
+	before, err := NewManager([]Key{oldKey}, ManagerOpts{SigningKeyID: oldKey.ID, TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+	oldToken, _, err := before.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+
+	// the new key signs while the old key is only kept to verify tokens it already issued
+	after, err := NewManager([]Key{newKey, publicOnly(oldKey)}, ManagerOpts{SigningKeyID: newKey.ID, TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+
+	_, err = after.ValidateToken(oldToken)
+	assert.NoError(t, err)
+
+	newToken, _, err := after.IssueToken(service.TokenClaims{UserID: "123"})
+	require.NoError(t, err)
+	_, err = after.ValidateToken(newToken)
+	assert.NoError(t, err)
+
+	// once the old key is dropped its tokens are rejected
+	dropped, err := NewManager([]Key{newKey}, ManagerOpts{SigningKeyID: newKey.ID, TimeNow: fixedTime(now)})
+	require.NoError(t, err)
+	_, err = dropped.ValidateToken(oldToken)
+	assert.ErrorIs(t, err, service.ErrInvalidToken)
+
+	var set struct {
+		Keys []map[string]string `json:"keys"`
+	}
+	assert.NoError(t, json.Unmarshal(after.JWKS(), &set))
+	assert.Len(t, set.Keys, 2)
+	assert.Equal(t, "2025-02", set.Keys[0]["kid"])
+	assert.Equal(t, "RSA", set.Keys[0]["kty"])
+	assert.Equal(t, "RS256", set.Keys[0]["alg"])
+	assert.Equal(t, "AQAB", set.Keys[0]["e"])
+	assert.Equal(t, "2025-01", set.Keys[1]["kid"])
+	assert.Equal(t, "OKP", set.Keys[1]["kty"])
+	assert.Equal(t, "Ed25519", set.Keys[1]["crv"])
+	assert.NotContains(t, string(after.JWKS()), `"d"`)
+}
+
+func TestNewManager_Invalid(t *testing.T) {
+	key := newEd25519Key(t, "ed-1")
+
+	_, err := NewManager([]Key{key, key}, ManagerOpts{SigningKeyID: key.ID})
+	assert.ErrorContains(t, err, "key id ed-1 is used more than once")
+
+	_, err = NewManager([]Key{key}, ManagerOpts{SigningKeyID: "ed-2"})
+	assert.ErrorContains(t, err, "signing key ed-2 is not one of the loaded keys")
+
+	_, err = NewManager([]Key{publicOnly(key)}, ManagerOpts{SigningKeyID: key.ID})
+	assert.ErrorContains(t, err, "signing key ed-1 has no private key")
+}
+
+func TestParseKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	pkcs8RSA, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	require.NoError(t, err)
+	pkcs8Ed, err := x509.MarshalPKCS8PrivateKey(edPriv)
+	require.NoError(t, err)
+	pkixEd, err := x509.MarshalPKIXPublicKey(edPub)
+	require.NoError(t, err)
+
+	encode := func(blockType string, der []byte) []byte {
+		return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der})
+	}
+
+	tests := []struct {
+		name      string
+		pem       []byte
+		algorithm string
+		canSign   bool
+	}{
+		{name: "PKCS#8 RSA private key", pem: encode("PRIVATE KEY", pkcs8RSA), algorithm: AlgorithmRS256, canSign: true},
+		{name: "PKCS#1 RSA private key", pem: encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaKey)), algorithm: AlgorithmRS256, canSign: true},
+		{name: "PKCS#8 Ed25519 private key", pem: encode("PRIVATE KEY", pkcs8Ed), algorithm: AlgorithmEdDSA, canSign: true},
+		{name: "PKIX Ed25519 public key", pem: encode("PUBLIC KEY", pkixEd), algorithm: AlgorithmEdDSA, canSign: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := ParseKey("k", tt.pem)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.algorithm, key.Algorithm)
+			assert.Equal(t, tt.canSign, key.CanSign())
+		})
+	}
+
+	_, err = ParseKey("k", []byte("not pem"))
+	assert.ErrorContains(t, err, "not PEM encoded")
+
+	_, err = ParseKey("k", encode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(weakKey)))
+	assert.ErrorContains(t, err, "RSA keys must be at least 2048 bits")
+
+	_, err = ParseKey("k", encode("CERTIFICATE", []byte("x")))
+	assert.ErrorContains(t, err, "unsupported PEM type")
+}
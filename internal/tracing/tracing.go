@@ -0,0 +1,33 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans started by the service itself, rather than by the
+// libraries it uses.
+const instrumentationName = "github.com/EFG"
+
+// Propagator reads and writes trace context in the W3C traceparent and tracestate headers, along
+// with any baggage.
+var Propagator propagation.TextMapPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// Start starts a span called name as a child of the span in ctx. The returned function ends it and
+// marks it failed when *err is set, so it is deferred with the address of a named error result.
+// Spans are only recorded once a provider is set up, until then starting one costs next to nothing.
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, func(err *error)) {
+	ctx, span := otel.Tracer(instrumentationName).Start(ctx, name, opts...)
+
+	return ctx, func(err *error) {
+		if err != nil && *err != nil {
+			span.RecordError(*err)
+			span.SetStatus(codes.Error, (*err).Error())
+		}
+		span.End()
+	}
+}
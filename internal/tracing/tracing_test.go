@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+func TestStart(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	ctx, endParent := Start(context.Background(), "service.CreateUser")
+	_, endChild := Start(ctx, "postgres.CreateUser")
+	err := errors.New("duplicate email")
+	endChild(&err)
+	endParent(new(error))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+
+	child, parent := spans[0], spans[1]
+	assert.Equal(t, "postgres.CreateUser", child.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), child.Parent().SpanID())
+	assert.Equal(t, codes.Error, child.Status().Code)
+	assert.Equal(t, "duplicate email", child.Status().Description)
+	assert.Len(t, child.Events(), 1)
+
+	assert.Equal(t, "service.CreateUser", parent.Name())
+	assert.Equal(t, codes.Unset, parent.Status().Code)
+	assert.Empty(t, parent.Events())
+}
+
+func TestNewProvider(t *testing.T) {
+	var out bytes.Buffer
+	exporter, err := NewWriterExporter(&out)
+	assert.NoError(t, err)
+
+	provider, err := NewProvider(exporter, "user-service", 1)
+	assert.NoError(t, err)
+	t.Cleanup(func() { otel.SetTracerProvider(noop.NewTracerProvider()) })
+
+	_, end := Start(context.Background(), "service.CreateUser")
+	end(nil)
+
+	assert.NoError(t, provider.Shutdown(context.Background()))
+	assert.Contains(t, out.String(), `"Name":"service.CreateUser"`)
+	assert.Contains(t, out.String(), `"Value":"user-service"`)
+}